@@ -2,11 +2,26 @@ package api
 
 const LUA_MINSTACK = 20
 const LUAI_MAXSTACK = 1000000
+const LUAI_MAXCALLS = 20000 // nested calls also nest on the Go stack
+const LUAI_MAXCCALLS = 200  // nested calls of Go functions, which may call back into Lua
 const LUA_REGISTRYINDEX = -LUAI_MAXSTACK - 1000
 const LUA_RIDX_MAINTHREAD int64 = 1
 const LUA_RIDX_GLOBALS int64 = 2
 const LUA_MULTRET = -1
 
+/* language versions, see LuaState.Version */
+const (
+	LUA_VERSION_51 = 501 // Lua 5.3 with the 5.1 compatibility libraries
+	LUA_VERSION_53 = 503
+	LUA_VERSION_54 = 504
+)
+
+/* predefined references, see AuxLib.Ref */
+const (
+	LUA_NOREF  = -2
+	LUA_REFNIL = -1
+)
+
 const (
 	LUA_MAXINTEGER = 1<<63 - 1
 	LUA_MININTEGER = -1 << 63
@@ -51,6 +66,19 @@ const (
 	LUA_OPLE        // <=
 )
 
+/* garbage-collection options */
+const (
+	LUA_GCSTOP       = 0
+	LUA_GCRESTART    = 1
+	LUA_GCCOLLECT    = 2
+	LUA_GCCOUNT      = 3
+	LUA_GCCOUNTB     = 4
+	LUA_GCSTEP       = 5
+	LUA_GCSETPAUSE   = 6
+	LUA_GCSETSTEPMUL = 7
+	LUA_GCISRUNNING  = 9
+)
+
 /* thread status */
 const (
 	LUA_OK = iota
@@ -2,6 +2,9 @@ package api
 
 type FuncReg map[string]GoFunction
 
+// GoFuncReg maps names to ordinary Go funcs, see AuxLib.RegisterFunc
+type GoFuncReg map[string]interface{}
+
 // auxiliary library
 type AuxLib interface {
 	/* Error-report functions */
@@ -19,6 +22,7 @@ type AuxLib interface {
 	OptInteger(arg int, d int64) int64
 	OptNumber(arg int, d float64) float64
 	OptString(arg int, d string) string
+	CheckOption(arg int, def string, lst []string) int
 
 	/* Load functions */
 	DoFile(filename string) bool
@@ -31,6 +35,7 @@ type AuxLib interface {
 	TypeName2(idx int) string
 	ToString2(idx int) string
 	Len2(idx int) int64
+	Gsub(s, p, r string) string
 	GetSubTable(idx int, fname string) bool
 	GetMetafield(obj int, e string) LuaType
 	CallMeta(obj int, e string) bool
@@ -39,4 +44,26 @@ type AuxLib interface {
 	NewLib(l FuncReg)
 	NewLibTable(l FuncReg)
 	SetFuncs(l FuncReg, nup int)
+
+	/* Userdata types */
+	NewMetatable(tname string) bool
+	SetMetatable2(tname string)
+	GetMetatable2(tname string) LuaType
+	TestUdata(ud int, tname string) interface{}
+	CheckUdata(ud int, tname string) interface{}
+
+	/* Debug */
+	Where(level int)
+	Traceback(l1 LuaState, msg string, level int)
+
+	/* References */
+	Ref(t int) int
+	Unref(t, ref int)
+
+	/* Go values and funcs */
+	Encode(v interface{}) error
+	Decode(idx int, v interface{}) error
+	PushGoFunc(fn interface{})
+	RegisterFunc(name string, fn interface{})
+	SetGoFuncs(l GoFuncReg)
 }
@@ -0,0 +1,62 @@
+package api
+
+// Buffer - luaL_Buffer, it builds a string piece by piece in Go memory
+// rather than on the stack, PushResult pushes it.
+type Buffer struct {
+	ls  LuaState
+	buf []byte
+}
+
+// NewBuffer - luaL_buffinit
+func NewBuffer(ls LuaState) *Buffer {
+	return &Buffer{ls: ls}
+}
+
+// NewBufferSize - luaL_buffinitsize
+func NewBufferSize(ls LuaState, sz int) *Buffer {
+	return &Buffer{ls: ls, buf: make([]byte, 0, sz)}
+}
+
+// AddChar - luaL_addchar
+func (b *Buffer) AddChar(c byte) {
+	b.buf = append(b.buf, c)
+}
+
+// AddString - luaL_addstring
+func (b *Buffer) AddString(s string) {
+	b.buf = append(b.buf, s...)
+}
+
+// AddBytes - luaL_addlstring
+func (b *Buffer) AddBytes(p []byte) {
+	b.buf = append(b.buf, p...)
+}
+
+// AddValue - luaL_addvalue, it pops the string or number at the top of the
+// stack and adds it.
+func (b *Buffer) AddValue() {
+	s, _ := b.ls.ToStringX(-1)
+	b.buf = append(b.buf, s...)
+	b.ls.Pop(1)
+}
+
+// Len - luaL_bufflen
+func (b *Buffer) Len() int {
+	return len(b.buf)
+}
+
+// Sub - luaL_buffsub, it removes the last n bytes.
+func (b *Buffer) Sub(n int) {
+	b.buf = b.buf[:len(b.buf)-n]
+}
+
+// String returns the contents of the buffer.
+func (b *Buffer) String() string {
+	return string(b.buf)
+}
+
+// PushResult - luaL_pushresult, the buffer is emptied.
+func (b *Buffer) PushResult() {
+	b.ls.PushString(string(b.buf))
+	b.buf = b.buf[:0]
+}
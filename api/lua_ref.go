@@ -0,0 +1,70 @@
+package api
+
+import "fmt"
+
+// LuaRef holds a Lua value in the registry, so that Go code can keep a
+// function or table between calls without storing it in a global. The
+// value is not collected until Release is called.
+type LuaRef struct {
+	ls  LuaState
+	ref int
+}
+
+// NewLuaRef pops the value at the top of the stack of ls and returns a
+// reference to it.
+func NewLuaRef(ls LuaState) *LuaRef {
+	return &LuaRef{ls, ls.Ref(LUA_REGISTRYINDEX)}
+}
+
+// Ref returns the reference in the registry, LUA_NOREF once released.
+func (r *LuaRef) Ref() int {
+	return r.ref
+}
+
+// Push pushes the referenced value, nil once released.
+func (r *LuaRef) Push() {
+	if r.ref == LUA_NOREF {
+		r.ls.PushNil()
+	} else {
+		r.ls.RawGetI(LUA_REGISTRYINDEX, int64(r.ref))
+	}
+}
+
+// Call calls the referenced value in protected mode with args pushed as by
+// BasicAPI.PushGoValue, and returns its results converted as by
+// BasicAPI.ToGoValue into interface{} values.
+func (r *LuaRef) Call(args ...interface{}) ([]interface{}, error) {
+	ls := r.ls
+	top := ls.GetTop()
+	defer ls.SetTop(top)
+
+	if !ls.CheckStack(len(args) + 1) {
+		return nil, fmt.Errorf("too many arguments")
+	}
+	r.Push()
+	for _, arg := range args {
+		ls.PushGoValue(arg)
+	}
+	if ls.PCall(len(args), LUA_MULTRET, 0) != LUA_OK {
+		if msg, ok := ls.ToStringX(-1); ok {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return nil, fmt.Errorf("(error object is a %s value)", ls.TypeName(ls.Type(-1)))
+	}
+
+	results := make([]interface{}, ls.GetTop()-top)
+	for i := range results {
+		if err := ls.ToGoValue(top+i+1, &results[i]); err != nil {
+			return nil, fmt.Errorf("result #%d: %s", i+1, err)
+		}
+	}
+	return results, nil
+}
+
+// Release frees the reference, it does nothing if already released.
+func (r *LuaRef) Release() {
+	if r.ref != LUA_NOREF {
+		r.ls.Unref(LUA_REGISTRYINDEX, r.ref)
+		r.ref = LUA_NOREF
+	}
+}
@@ -1,5 +1,7 @@
 package api
 
+import "io"
+
 // LuaType lua type
 type LuaType = int
 
@@ -52,6 +54,8 @@ type BasicAPI interface {
 	IsThread(idx int) bool
 	IsFunction(idx int) bool
 	IsGoFunction(idx int) bool
+	IsUserdata(idx int) bool
+	IsLightUserdata(idx int) bool
 	ToBoolean(idx int) bool
 	ToInteger(idx int) int64
 	ToIntegerX(idx int) (int64, bool)
@@ -61,7 +65,9 @@ type BasicAPI interface {
 	ToStringX(idx int) (string, bool)
 	ToGoFunction(idx int) GoFunction
 	ToThread(idx int) LuaState
+	ToUserdata(idx int) interface{}
 	ToPointer(idx int) interface{}
+	ToGoValue(idx int, target interface{}) error
 	RawLen(idx int) uint
 
 	/* push functions (Go -> stack) */
@@ -74,7 +80,9 @@ type BasicAPI interface {
 	PushGoFunction(f GoFunction)
 	PushGoClosure(f GoFunction, n int)
 	PushGlobalTable()
+	PushLightUserdata(p interface{})
 	PushThread() bool
+	PushGoValue(v interface{})
 
 	/* Comparison and arithmetic function */
 	Arith(op ArithOp)
@@ -89,7 +97,10 @@ type BasicAPI interface {
 	GetI(idx int, i int64) LuaType
 	RawGet(idx int) LuaType
 	RawGetI(idx int, i int64) LuaType
+	RawGetP(idx int, p interface{}) LuaType
+	NewUserdata(v interface{})
 	GetMetatable(idx int) bool
+	GetUserValue(idx int) LuaType
 	GetGlobal(name string) LuaType
 
 	/* set functions (stack -> Lua) */
@@ -98,14 +109,18 @@ type BasicAPI interface {
 	SetI(idx int, i int64)
 	RawSet(idx int)
 	RawSetI(idx int, i int64)
+	RawSetP(idx int, p interface{})
 	SetMetatable(idx int)
+	SetUserValue(idx int)
 	SetGlobal(name string)
 	Register(name string, f GoFunction)
 
 	/* 'load' and 'call' functions (load and run Lua code) */
 	Load(chunk []byte, chunkName, mode string) int
+	LoadReader(r io.Reader, chunkName, mode string) int
 	Call(nArgs, nResults int)
 	PCall(nArgs, nResults, msgh int) int
+	Dump(strip bool) []byte
 
 	/* miscellaneous functions */
 	Len(idx int)
@@ -113,6 +128,9 @@ type BasicAPI interface {
 	Next(idx int) bool
 	Error() int
 	StringToNumber(s string) bool
+	GC(what, data int) int
+	ToClose(idx int)
+	Warning(msg string, toCont bool)
 
 	/* coroutine functions */
 	NewThread() LuaState
@@ -120,5 +138,24 @@ type BasicAPI interface {
 	Yield(nResults int) int
 	Status() int
 	IsYieldable() bool
+	CloseThread(from LuaState) int
 	GetStack() bool // debug
+
+	/* debug functions */
+	GetFunction(level int) bool
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetUpvalue(funcIdx, n int) (string, bool)
+	UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int)
+
+	/* standard streams */
+	SetStdin(r io.Reader)
+	SetStdout(w io.Writer)
+	SetStderr(w io.Writer)
+	Stdin() io.Reader
+	Stdout() io.Writer
+	Stderr() io.Writer
+
+	/* state manipulation */
+	Version() int
+	Close()
 }
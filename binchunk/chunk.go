@@ -91,10 +91,16 @@ func IsBinaryChunk(data []byte) bool {
 		string(data[:4]) == LuaSignature
 }
 
-// Undump ...
-func Undump(data []byte) *ProtoType {
-	reader := &Reader{data}
-	reader.CheckHeader()
-	reader.ReadByte()
+// Undump reads the main function of the binary chunk data, it returns an
+// error describing why if data is not a chunk it can read.
+func Undump(data []byte) (*ProtoType, error) {
+	reader := NewReader(data)
+	if err := reader.CheckHeader(); err != nil {
+		return nil, err
+	}
+	if len(reader.data) == 0 {
+		return nil, formatError("truncated precompiled chunk")
+	}
+	reader.data = reader.data[1:] // size of the upvalues of the main function
 	return reader.ReadProto("")
 }
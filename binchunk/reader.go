@@ -2,209 +2,273 @@ package binchunk
 
 import (
 	"encoding/binary"
+	"fmt"
 	"math"
 )
 
-// Reader binary chunk reader
+// Reader binary chunk reader, it reads the chunks of any luac 5.3 whose
+// header describes ints, size_t, lua_Integer and lua_Number of 4 or 8
+// bytes in either byte order, converting them to the in-memory ProtoType.
 type Reader struct {
-	data []byte
+	data       []byte
+	order      binary.ByteOrder
+	intSize    int
+	sizetSize  int
+	luaIntSize int
+	luaNumSize int
 }
 
-// ReadByte read byte
-func (imp *Reader) ReadByte() byte {
-	b := imp.data[0]
-	imp.data = imp.data[1:]
-	return b
+// formatError is raised by the reads and returned by CheckHeader and
+// ReadProto
+type formatError string
+
+func (e formatError) Error() string {
+	return string(e)
+}
+
+// NewReader returns a reader of the binary chunk data.
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+func (imp *Reader) errorf(format string, a ...interface{}) {
+	panic(formatError(fmt.Sprintf(format, a...)))
+}
+
+func (imp *Reader) recover(err *error) {
+	if r := recover(); r != nil {
+		fe, ok := r.(formatError)
+		if !ok {
+			panic(r)
+		}
+		*err = fe
+	}
 }
 
-// ReadBytes read bytes
-func (imp *Reader) ReadBytes(n uint) []byte {
+func (imp *Reader) readByte() byte {
+	return imp.readBytes(1)[0]
+}
+
+func (imp *Reader) readBytes(n uint64) []byte {
+	if n > uint64(len(imp.data)) {
+		imp.errorf("truncated precompiled chunk")
+	}
 	bytes := imp.data[:n]
 	imp.data = imp.data[n:]
 	return bytes
 }
 
-// ReadUint32 read uint32
-func (imp *Reader) ReadUint32() uint32 {
-	n := binary.LittleEndian.Uint32(imp.data)
-	imp.data = imp.data[4:]
-	return n
+// readUint reads an unsigned integer of size bytes.
+func (imp *Reader) readUint(size int) uint64 {
+	bytes := imp.readBytes(uint64(size))
+	if size == 4 {
+		return uint64(imp.order.Uint32(bytes))
+	}
+	return imp.order.Uint64(bytes)
 }
 
-// ReadUint64 read uint64
-func (imp *Reader) ReadUint64() uint64 {
-	n := binary.LittleEndian.Uint64(imp.data)
-	imp.data = imp.data[8:]
-	return n
+// readInt reads a C int.
+func (imp *Reader) readInt() uint32 {
+	n := imp.readUint(imp.intSize)
+	if n > math.MaxUint32 {
+		imp.errorf("integer %d too large", n)
+	}
+	return uint32(n)
+}
+
+// readCount reads the number of the elements of a list, each taking one
+// byte at least.
+func (imp *Reader) readCount() int {
+	n := imp.readInt()
+	if uint64(n) > uint64(len(imp.data)) {
+		imp.errorf("truncated precompiled chunk")
+	}
+	return int(n)
 }
 
-// ReadLuaInteger read lua integer
-func (imp *Reader) ReadLuaInteger() int64 {
-	return int64(imp.ReadUint64())
+func (imp *Reader) readLuaInteger() int64 {
+	n := imp.readUint(imp.luaIntSize)
+	if imp.luaIntSize == 4 {
+		return int64(int32(n))
+	}
+	return int64(n)
 }
 
-// ReadLuaNumber read lua number
-func (imp *Reader) ReadLuaNumber() float64 {
-	return math.Float64frombits(imp.ReadUint64())
+func (imp *Reader) readLuaNumber() float64 {
+	n := imp.readUint(imp.luaNumSize)
+	if imp.luaNumSize == 4 {
+		return float64(math.Float32frombits(uint32(n)))
+	}
+	return math.Float64frombits(n)
 }
 
-// ReadString read string
-func (imp *Reader) ReadString() string {
-	size := uint(imp.ReadByte())
-	if size <= 0 {
+func (imp *Reader) readString() string {
+	size := uint64(imp.readByte())
+	if size == 0 {
 		return ""
 	}
 	if size == 0xFF {
-		size = uint(imp.ReadUint64())
+		size = imp.readUint(imp.sizetSize)
 	}
-	bytes := imp.ReadBytes(size - 1)
-	return string(bytes)
+	return string(imp.readBytes(size - 1))
 }
 
-// CheckHeader check header
-func (imp *Reader) CheckHeader() {
-	if string(imp.ReadBytes(4)) != LuaSignature {
-		panic("not a precompiled chunk!")
-	}
-	if imp.ReadByte() != LuacVersion {
-		panic("version mismatch!")
+// CheckHeader checks the header of the chunk and sets the sizes and the
+// byte order of the reads from it.
+func (imp *Reader) CheckHeader() (err error) {
+	defer imp.recover(&err)
+	if string(imp.readBytes(4)) != LuaSignature {
+		imp.errorf("not a precompiled chunk")
 	}
-	if imp.ReadByte() != LuacFormat {
-		panic("format mismatch!")
+	if v := imp.readByte(); v != LuacVersion {
+		imp.errorf("version mismatch, %d.%d chunk", v>>4, v&0xF)
 	}
-	if string(imp.ReadBytes(6)) != LuacData {
-		panic("corrupted!")
+	if imp.readByte() != LuacFormat {
+		imp.errorf("format mismatch")
 	}
-	if imp.ReadByte() != CIntSize {
-		panic("int size mismatch!")
+	if string(imp.readBytes(6)) != LuacData {
+		imp.errorf("corrupted precompiled chunk")
 	}
-	if imp.ReadByte() != CSizetSize {
-		panic("size_t size mismatch!")
+	imp.intSize = imp.readSize("int")
+	imp.sizetSize = imp.readSize("size_t")
+	if n := imp.readByte(); n != InstructionSize {
+		imp.errorf("unsupported Instruction size %d", n)
 	}
-	if imp.ReadByte() != InstructionSize {
-		panic("instruction size mismatch!")
-	}
-	if imp.ReadByte() != LuaIntSize {
-		panic("lua_Integer size mismatch!")
+	imp.luaIntSize = imp.readSize("lua_Integer")
+	imp.luaNumSize = imp.readSize("lua_Number")
+
+	bytes := imp.readBytes(uint64(imp.luaIntSize))
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		imp.order = order
+		if imp.luaIntSize == 4 && order.Uint32(bytes) == LuacInt ||
+			imp.luaIntSize == 8 && order.Uint64(bytes) == LuacInt {
+			break
+		}
+		imp.order = nil
 	}
-	if imp.ReadByte() != LuaNumberSize {
-		panic("lua_Number size mismatch!")
+	if imp.order == nil {
+		imp.errorf("endianness mismatch")
 	}
-	if imp.ReadLuaInteger() != LuacInt {
-		panic("endianness mismatch!")
+	if imp.readLuaNumber() != LuacNum {
+		imp.errorf("float format mismatch")
 	}
-	if imp.ReadLuaNumber() != LuacNum {
-		panic("float format mismatch!")
+	return nil
+}
+
+// readSize reads the size of the C type name, 4 or 8.
+func (imp *Reader) readSize(name string) int {
+	n := int(imp.readByte())
+	if n != 4 && n != 8 {
+		imp.errorf("unsupported %s size %d", name, n)
 	}
+	return n
+}
+
+// ReadProto reads a function prototype, after the header.
+func (imp *Reader) ReadProto(parentSource string) (proto *ProtoType, err error) {
+	defer imp.recover(&err)
+	return imp.readProto(parentSource), nil
 }
 
-// ReadProto read proto
-func (imp *Reader) ReadProto(parentSource string) *ProtoType {
-	source := imp.ReadString()
+func (imp *Reader) readProto(parentSource string) *ProtoType {
+	source := imp.readString()
 	if source == "" {
 		source = parentSource
 	}
 	return &ProtoType{
 		Source:          source,
-		LineDefined:     imp.ReadUint32(),
-		LastLineDefined: imp.ReadUint32(),
-		NumParams:       imp.ReadByte(),
-		IsVararg:        imp.ReadByte(),
-		MaxStackSize:    imp.ReadByte(),
-		Code:            imp.ReadCode(),
-		Constants:       imp.ReadConstants(),
-		Upvalues:        imp.ReadUpvalues(),
-		Protos:          imp.ReadProtos(source),
-		LineInfo:        imp.ReadLineInfo(),
-		LocVars:         imp.ReadLocVars(),
-		UpvalueNames:    imp.ReadUpvalueNames(),
-	}
-}
-
-// ReadCode read code
-func (imp *Reader) ReadCode() []uint32 {
-	code := make([]uint32, imp.ReadUint32())
+		LineDefined:     imp.readInt(),
+		LastLineDefined: imp.readInt(),
+		NumParams:       imp.readByte(),
+		IsVararg:        imp.readByte(),
+		MaxStackSize:    imp.readByte(),
+		Code:            imp.readCode(),
+		Constants:       imp.readConstants(),
+		Upvalues:        imp.readUpvalues(),
+		Protos:          imp.readProtos(source),
+		LineInfo:        imp.readLineInfo(),
+		LocVars:         imp.readLocVars(),
+		UpvalueNames:    imp.readUpvalueNames(),
+	}
+}
+
+func (imp *Reader) readCode() []uint32 {
+	code := make([]uint32, imp.readCount())
 	for i := range code {
-		code[i] = imp.ReadUint32()
+		code[i] = uint32(imp.readUint(InstructionSize))
 	}
 	return code
 }
 
-// ReadConstants read constants
-func (imp *Reader) ReadConstants() []interface{} {
-	constants := make([]interface{}, imp.ReadUint32())
+func (imp *Reader) readConstants() []interface{} {
+	constants := make([]interface{}, imp.readCount())
 	for i := range constants {
-		constants[i] = imp.ReadConstant()
+		constants[i] = imp.readConstant()
 	}
 	return constants
 }
 
-// ReadConstant read constant
-func (imp *Reader) ReadConstant() interface{} {
-	switch imp.ReadByte() {
+func (imp *Reader) readConstant() interface{} {
+	switch tag := imp.readByte(); tag {
 	case TAG_NIL:
 		return nil
 	case TAG_BOOLEAN:
-		return imp.ReadByte() != 0
+		return imp.readByte() != 0
 	case TAG_INTEGER:
-		return imp.ReadLuaInteger()
+		return imp.readLuaInteger()
 	case TAG_NUMBER:
-		return imp.ReadLuaNumber()
+		return imp.readLuaNumber()
 	case TAG_SHORT_STR, TAG_LONG_STR:
-		return imp.ReadString()
+		return imp.readString()
 	default:
-		panic("corrupted") // todo
+		imp.errorf("bad constant tag %#x", tag)
+		return nil
 	}
 }
 
-// ReadUpvalues read upvalues
-func (imp *Reader) ReadUpvalues() []Upvalue {
-	upvalues := make([]Upvalue, imp.ReadUint32())
+func (imp *Reader) readUpvalues() []Upvalue {
+	upvalues := make([]Upvalue, imp.readCount())
 	for i := range upvalues {
 		upvalues[i] = Upvalue{
-			Instack: imp.ReadByte(),
-			Idx:     imp.ReadByte(),
+			Instack: imp.readByte(),
+			Idx:     imp.readByte(),
 		}
 	}
 	return upvalues
 }
 
-// ReadProtos read protos
-func (imp *Reader) ReadProtos(parentSource string) []*ProtoType {
-	protos := make([]*ProtoType, imp.ReadUint32())
+func (imp *Reader) readProtos(parentSource string) []*ProtoType {
+	protos := make([]*ProtoType, imp.readCount())
 	for i := range protos {
-		protos[i] = imp.ReadProto(parentSource)
+		protos[i] = imp.readProto(parentSource)
 	}
 	return protos
 }
 
-// ReadLineInfo read line info
-func (imp *Reader) ReadLineInfo() []uint32 {
-	lineInfo := make([]uint32, imp.ReadUint32())
+func (imp *Reader) readLineInfo() []uint32 {
+	lineInfo := make([]uint32, imp.readCount())
 	for i := range lineInfo {
-		lineInfo[i] = imp.ReadUint32()
+		lineInfo[i] = imp.readInt()
 	}
 	return lineInfo
 }
 
-// ReadLocVars read local vars
-func (imp *Reader) ReadLocVars() []LocVar {
-	locVars := make([]LocVar, imp.ReadUint32())
+func (imp *Reader) readLocVars() []LocVar {
+	locVars := make([]LocVar, imp.readCount())
 	for i := range locVars {
 		locVars[i] = LocVar{
-			VarName: imp.ReadString(),
-			StartPC: imp.ReadUint32(),
-			EndPC:   imp.ReadUint32(),
+			VarName: imp.readString(),
+			StartPC: imp.readInt(),
+			EndPC:   imp.readInt(),
 		}
 	}
 	return locVars
 }
 
-// ReadUpvalueNames read upvalues name
-func (imp *Reader) ReadUpvalueNames() []string {
-	names := make([]string, imp.ReadUint32())
+func (imp *Reader) readUpvalueNames() []string {
+	names := make([]string, imp.readCount())
 	for i := range names {
-		names[i] = imp.ReadString()
+		names[i] = imp.readString()
 	}
 	return names
 }
@@ -0,0 +1,143 @@
+package binchunk_test
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/binchunk"
+	"github.com/iglev/glua/compiler"
+	"github.com/iglev/glua/state"
+)
+
+// foreignDump dumps proto stripped, as a luac with the byte order and the
+// sizes of size_t, lua_Integer and lua_Number given would.
+func foreignDump(proto *binchunk.ProtoType, order binary.ByteOrder, sizet, luaInt, luaNum int) []byte {
+	var buf []byte
+	putUint := func(n uint64, size int) {
+		b := make([]byte, size)
+		if size == 4 {
+			order.PutUint32(b, uint32(n))
+		} else {
+			order.PutUint64(b, n)
+		}
+		buf = append(buf, b...)
+	}
+	number := func(f float64) {
+		if luaNum == 4 {
+			putUint(uint64(math.Float32bits(float32(f))), 4)
+		} else {
+			putUint(math.Float64bits(f), 8)
+		}
+	}
+	str := func(s string) {
+		if len(s)+1 < 0xFF {
+			buf = append(buf, byte(len(s)+1))
+		} else {
+			buf = append(buf, 0xFF)
+			putUint(uint64(len(s)+1), sizet)
+		}
+		buf = append(buf, s...)
+	}
+	var fn func(p *binchunk.ProtoType)
+	fn = func(p *binchunk.ProtoType) {
+		buf = append(buf, 0)
+		putUint(uint64(p.LineDefined), 4)
+		putUint(uint64(p.LastLineDefined), 4)
+		buf = append(buf, p.NumParams, p.IsVararg, p.MaxStackSize)
+		putUint(uint64(len(p.Code)), 4)
+		for _, i := range p.Code {
+			putUint(uint64(i), 4)
+		}
+		putUint(uint64(len(p.Constants)), 4)
+		for _, k := range p.Constants {
+			switch k := k.(type) {
+			case int64:
+				buf = append(buf, binchunk.TAG_INTEGER)
+				putUint(uint64(k), luaInt)
+			case float64:
+				buf = append(buf, binchunk.TAG_NUMBER)
+				number(k)
+			case string:
+				buf = append(buf, binchunk.TAG_LONG_STR)
+				str(k)
+			}
+		}
+		putUint(uint64(len(p.Upvalues)), 4)
+		for _, uv := range p.Upvalues {
+			buf = append(buf, uv.Instack, uv.Idx)
+		}
+		putUint(uint64(len(p.Protos)), 4)
+		for _, sub := range p.Protos {
+			fn(sub)
+		}
+		putUint(0, 4)
+		putUint(0, 4)
+		putUint(0, 4)
+	}
+	buf = append(buf, binchunk.LuaSignature...)
+	buf = append(buf, binchunk.LuacVersion, binchunk.LuacFormat)
+	buf = append(buf, binchunk.LuacData...)
+	buf = append(buf, 4, byte(sizet), 4, byte(luaInt), byte(luaNum))
+	putUint(binchunk.LuacInt, luaInt)
+	number(binchunk.LuacNum)
+	buf = append(buf, byte(len(proto.Upvalues)))
+	fn(proto)
+	return buf
+}
+
+// TestForeignChunks chunks of other byte orders and type sizes
+func TestForeignChunks(t *testing.T) {
+	src := `local s = string.rep("x", 300)
+		local function f(a) return a * -7 + 1.5 end
+		return f(2) .. #s .. s:sub(1, 1)`
+	proto, err := compiler.Compile(src, "=src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := state.New()
+	ls.OpenLibs()
+	for _, f := range []struct {
+		order                 binary.ByteOrder
+		sizet, luaInt, luaNum int
+	}{
+		{binary.LittleEndian, 8, 8, 8},
+		{binary.BigEndian, 8, 8, 8},
+		{binary.LittleEndian, 4, 4, 4},
+		{binary.BigEndian, 4, 8, 4},
+		{binary.BigEndian, 4, 4, 8},
+	} {
+		chunk := foreignDump(proto, f.order, f.sizet, f.luaInt, f.luaNum)
+		if ls.Load(chunk, "=src", "b") != api.LUA_OK {
+			t.Fatal(f, ls.ToString(-1))
+		}
+		ls.Call(0, 1)
+		if s := ls.ToString(-1); s != "-12.5300x" {
+			t.Error(f, s)
+		}
+		ls.Pop(1)
+	}
+
+	chunk := foreignDump(proto, binary.BigEndian, 4, 4, 4)
+	for _, c := range []struct {
+		modify func(chunk []byte) []byte
+		msg    string
+	}{
+		{func(c []byte) []byte { c[4] = 0x54; return c }, "version mismatch, 5.4 chunk"},
+		{func(c []byte) []byte { c[13] = 2; return c }, "unsupported size_t size 2"},
+		{func(c []byte) []byte { c[17] = 0x12; return c }, "endianness mismatch"},
+		{func(c []byte) []byte { c[21] = 0; return c }, "float format mismatch"},
+		{func(c []byte) []byte { return c[:40] }, "truncated precompiled chunk"},
+	} {
+		modified := c.modify(append([]byte{}, chunk...))
+		if _, err := binchunk.Undump(modified); err == nil || err.Error() != c.msg {
+			t.Errorf("%s: %v", c.msg, err)
+		}
+		if ls.Load(modified, "=src", "") != api.LUA_ERRSYNTAX ||
+			ls.ToString(-1) != "src: bad binary format ("+c.msg+")" {
+			t.Error(ls.ToString(-1))
+		}
+		ls.Pop(1)
+	}
+}
@@ -0,0 +1,387 @@
+package binchunk
+
+import (
+	"fmt"
+	"math"
+)
+
+// opcodes of the instructions, as numbered by the vm package
+const (
+	opMove = iota
+	opLoadK
+	opLoadKx
+	opLoadBool
+	opLoadNil
+	opGetUpval
+	opGetTabUp
+	opGetTable
+	opSetTabUp
+	opSetUpval
+	opSetTable
+	opNewTable
+	opSelf
+	opAdd
+	opSub
+	opMul
+	opMod
+	opPow
+	opDiv
+	opIDiv
+	opBAnd
+	opBOr
+	opBXor
+	opShl
+	opShr
+	opUnm
+	opBNot
+	opNot
+	opLen
+	opConcat
+	opJmp
+	opEq
+	opLt
+	opLe
+	opTest
+	opTestSet
+	opCall
+	opTailCall
+	opReturn
+	opForLoop
+	opForPrep
+	opTForCall
+	opTForLoop
+	opSetList
+	opClosure
+	opVararg
+	opExtraArg
+	opTBC
+)
+
+const maxArgSBx = 1<<18/2 - 1
+
+// Verify checks that the instructions of proto and of its nested
+// prototypes only use the registers, constants, upvalues and prototypes
+// they have and only jump inside their code, so that the VM can run them
+// without indexing out of range. It returns the first problem found -
+// the precompiled chunks are not checked since Lua 5.2, but they may come
+// from anywhere.
+func Verify(proto *ProtoType) error {
+	return verify(proto, nil)
+}
+
+type verifier struct {
+	proto  *ProtoType
+	parent *ProtoType
+	pc     int
+}
+
+func verify(proto, parent *ProtoType) error {
+	if proto == nil {
+		return fmt.Errorf("missing function")
+	}
+	v := &verifier{proto: proto, parent: parent}
+	if err := v.check(); err != nil {
+		return err
+	}
+	for _, p := range proto.Protos {
+		if err := verify(p, proto); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) errorf(format string, a ...interface{}) error {
+	where := "main function"
+	if v.parent != nil {
+		where = fmt.Sprintf("function at line %d", v.proto.LineDefined)
+	}
+	return fmt.Errorf("%s: %s", where, fmt.Sprintf(format, a...))
+}
+
+func (v *verifier) check() error {
+	p := v.proto
+	if len(p.Code) == 0 {
+		return v.errorf("no code")
+	}
+	if int(p.NumParams) > int(p.MaxStackSize) {
+		return v.errorf("%d parameters for %d registers", p.NumParams, p.MaxStackSize)
+	}
+	if len(p.LineInfo) != 0 && len(p.LineInfo) != len(p.Code) {
+		return v.errorf("%d lines for %d instructions", len(p.LineInfo), len(p.Code))
+	}
+	if len(p.UpvalueNames) > len(p.Upvalues) {
+		return v.errorf("%d upvalue names for %d upvalues", len(p.UpvalueNames), len(p.Upvalues))
+	}
+	for i, uv := range p.Upvalues {
+		if v.parent == nil {
+			continue // the upvalues of a main function are set by load
+		}
+		if uv.Instack == 1 && uv.Idx >= v.parent.MaxStackSize ||
+			uv.Instack != 1 && int(uv.Idx) >= len(v.parent.Upvalues) {
+			return v.errorf("upvalue %d out of range", i)
+		}
+	}
+	for v.pc = 0; v.pc < len(p.Code); v.pc++ {
+		if err := v.checkInstruction(); err != nil {
+			return v.errorf("instruction %d: %v", v.pc+1, err)
+		}
+	}
+	switch last := p.Code[len(p.Code)-1]; last & 0x3F {
+	case opReturn, opJmp:
+	default:
+		return v.errorf("code does not end with a return")
+	}
+	return nil
+}
+
+func (v *verifier) checkInstruction() error {
+	p := v.proto
+	i := p.Code[v.pc]
+	op := int(i & 0x3F)
+	a := int(i >> 6 & 0xFF)
+	c := int(i >> 14 & 0x1FF)
+	b := int(i >> 23 & 0x1FF)
+	bx := int(i >> 14)
+	sBx := bx - maxArgSBx
+
+	switch op {
+	case opMove, opUnm, opBNot, opNot, opLen:
+		return v.regs(a, a, b, b)
+	case opLoadK:
+		return firstError(v.reg(a), v.constant(bx))
+	case opLoadKx:
+		if err := v.reg(a); err != nil {
+			return err
+		}
+		return v.extraArg(func(ax int) error { return v.constant(ax) })
+	case opLoadBool:
+		if c != 0 {
+			return firstError(v.reg(a), v.target(v.pc+2))
+		}
+		return v.reg(a)
+	case opLoadNil:
+		return v.regs(a, a+b)
+	case opGetUpval:
+		return firstError(v.reg(a), v.upvalue(b))
+	case opGetTabUp:
+		return firstError(v.reg(a), v.upvalue(b), v.rk(c))
+	case opGetTable:
+		return firstError(v.regs(a, a, b, b), v.rk(c))
+	case opSetTabUp:
+		return firstError(v.upvalue(a), v.rk(b), v.rk(c))
+	case opSetUpval:
+		return firstError(v.reg(a), v.upvalue(b))
+	case opSetTable:
+		return firstError(v.reg(a), v.rk(b), v.rk(c))
+	case opNewTable:
+		// each item of a constructor takes an instruction at least
+		if max := 2*len(p.Code) + 8; sizeHint(b) > max || sizeHint(c) > max {
+			return fmt.Errorf("table size hint too large")
+		}
+		return v.reg(a)
+	case opSelf:
+		return firstError(v.regs(a, a+1, b, b), v.rk(c))
+	case opAdd, opSub, opMul, opMod, opPow, opDiv, opIDiv,
+		opBAnd, opBOr, opBXor, opShl, opShr:
+		return firstError(v.reg(a), v.rk(b), v.rk(c))
+	case opConcat:
+		if b > c {
+			return fmt.Errorf("empty concatenation")
+		}
+		return v.regs(a, a, b, c)
+	case opJmp:
+		if a > 0 {
+			if err := v.reg(a - 1); err != nil {
+				return err
+			}
+		}
+		return v.target(v.pc + 1 + sBx)
+	case opEq, opLt, opLe:
+		return firstError(v.rk(b), v.rk(c), v.test())
+	case opTest:
+		return firstError(v.reg(a), v.test())
+	case opTestSet:
+		return firstError(v.regs(a, a, b, b), v.test())
+	case opCall, opTailCall:
+		if err := v.args(a, b); err != nil {
+			return err
+		}
+		if op == opCall && c > 1 {
+			return v.regs(a, a+c-2)
+		}
+		return nil
+	case opReturn:
+		if b == 0 {
+			return firstError(v.reg(a), v.open())
+		} else if b > 1 {
+			return v.regs(a, a+b-2)
+		}
+		return nil
+	case opForLoop, opForPrep:
+		return firstError(v.regs(a, a+3), v.target(v.pc+1+sBx))
+	case opTForCall:
+		return v.regs(a, a+2+c)
+	case opTForLoop:
+		return firstError(v.regs(a, a+1), v.target(v.pc+1+sBx))
+	case opSetList:
+		if b == 0 {
+			if err := v.args(a, 0); err != nil {
+				return err
+			}
+		} else if err := v.regs(a, a+b); err != nil {
+			return err
+		}
+		if c == 0 {
+			return v.extraArg(func(int) error { return nil })
+		}
+		return nil
+	case opClosure:
+		if bx >= len(p.Protos) {
+			return fmt.Errorf("function %d out of range", bx)
+		}
+		return v.reg(a)
+	case opVararg:
+		if b > 1 {
+			return v.regs(a, a+b-2)
+		}
+		return v.reg(a)
+	case opExtraArg:
+		if v.pc > 0 {
+			switch prev := p.Code[v.pc-1]; prev & 0x3F {
+			case opLoadKx:
+				return nil
+			case opSetList:
+				if prev>>14&0x1FF == 0 {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("unexpected EXTRAARG")
+	case opTBC:
+		return v.reg(a)
+	default:
+		return fmt.Errorf("invalid opcode %d", op)
+	}
+}
+
+// sizeHint decodes the "floating point byte" x of a NEWTABLE - luaO_fb2int
+func sizeHint(x int) int {
+	if x < 8 {
+		return x
+	}
+	if e := x>>3 - 1; e < 27 {
+		return (x&7 + 8) << uint(e)
+	}
+	return math.MaxInt32
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) reg(r int) error {
+	if r >= int(v.proto.MaxStackSize) {
+		return fmt.Errorf("register %d out of range", r)
+	}
+	return nil
+}
+
+// regs checks the registers of the ranges [from, to], given in pairs.
+func (v *verifier) regs(bounds ...int) error {
+	for i := 0; i < len(bounds); i += 2 {
+		if bounds[i] > bounds[i+1] {
+			continue
+		}
+		if err := firstError(v.reg(bounds[i]), v.reg(bounds[i+1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) constant(idx int) error {
+	if idx >= len(v.proto.Constants) {
+		return fmt.Errorf("constant %d out of range", idx)
+	}
+	return nil
+}
+
+func (v *verifier) rk(rk int) error {
+	if rk > 0xFF {
+		return v.constant(rk & 0xFF)
+	}
+	return v.reg(rk)
+}
+
+func (v *verifier) upvalue(idx int) error {
+	if idx >= len(v.proto.Upvalues) {
+		return fmt.Errorf("upvalue %d out of range", idx)
+	}
+	return nil
+}
+
+// target checks the instruction executed after a jump to pc.
+func (v *verifier) target(pc int) error {
+	code := v.proto.Code
+	if pc < 0 || pc >= len(code) {
+		return fmt.Errorf("jump to %d out of range", pc+1)
+	}
+	if code[pc]&0x3F == opExtraArg {
+		return fmt.Errorf("jump to EXTRAARG")
+	}
+	return nil
+}
+
+// test checks the jump following a test instruction.
+func (v *verifier) test() error {
+	code := v.proto.Code
+	if v.pc+1 >= len(code) || code[v.pc+1]&0x3F != opJmp {
+		return fmt.Errorf("test without jump")
+	}
+	return v.target(v.pc + 2)
+}
+
+// extraArg checks the EXTRAARG following the instruction with f.
+func (v *verifier) extraArg(f func(ax int) error) error {
+	code := v.proto.Code
+	if v.pc+1 >= len(code) || code[v.pc+1]&0x3F != opExtraArg {
+		return fmt.Errorf("missing EXTRAARG")
+	}
+	return f(int(code[v.pc+1] >> 6))
+}
+
+// args checks the registers R(A), ..., R(A+B-1) of a call, or that the
+// values above R(A) were left on the stack if B is 0.
+func (v *verifier) args(a, b int) error {
+	if b == 0 {
+		return firstError(v.reg(a), v.open())
+	}
+	return v.regs(a, a+b-1)
+}
+
+// open checks that the previous instruction leaves its results on the
+// stack, for an instruction taking all of them.
+func (v *verifier) open() error {
+	if v.pc > 0 {
+		prev := v.proto.Code[v.pc-1]
+		switch prev & 0x3F {
+		case opCall:
+			if prev>>14&0x1FF == 0 {
+				return nil
+			}
+		case opVararg:
+			if prev>>23&0x1FF == 0 {
+				return nil
+			}
+		case opTailCall:
+			return nil
+		}
+	}
+	return fmt.Errorf("no open results")
+}
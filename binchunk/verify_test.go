@@ -0,0 +1,77 @@
+package binchunk_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/binchunk"
+	"github.com/iglev/glua/compiler"
+	"github.com/iglev/glua/state"
+)
+
+// TestVerify corrupted instructions are rejected by load
+func TestVerify(t *testing.T) {
+	src := "local t = {}\nfor i = 1, 3 do t[i] = function() return i end end\nreturn t[2]()"
+	corrupt := []struct {
+		msg    string
+		modify func(p *binchunk.ProtoType)
+	}{
+		{"constant 9 out of range", func(p *binchunk.ProtoType) {
+			p.Code[2] = p.Code[2]&^(0x3FFFF<<14) | 9<<14 // LOADK
+		}},
+		{"register 200 out of range", func(p *binchunk.ProtoType) {
+			p.Code[0] = p.Code[0]&^(0xFF<<6) | 200<<6
+		}},
+		{"jump to 101 out of range", func(p *binchunk.ProtoType) {
+			p.Code[4] = p.Code[4]&^(0x3FFFF<<14) | (131071+95)<<14 // FORPREP
+		}},
+		{"function 0 out of range", func(p *binchunk.ProtoType) {
+			p.Protos = nil
+		}},
+		{"upvalue 0 out of range", func(p *binchunk.ProtoType) {
+			p.Protos[0].Upvalues[0].Idx = 100
+		}},
+		{"lines for", func(p *binchunk.ProtoType) {
+			p.LineInfo = p.LineInfo[1:]
+		}},
+		{"table size hint too large", func(p *binchunk.ProtoType) {
+			p.Code[0] |= 0x1FF<<23 | 0x1FF<<14 // NEWTABLE
+		}},
+		{"invalid opcode 63", func(p *binchunk.ProtoType) {
+			p.Code[1] |= 0x3F
+		}},
+	}
+	ls := state.New()
+	for _, c := range corrupt {
+		proto, err := compiler.Compile(src, "=src")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := binchunk.Verify(proto); err != nil {
+			t.Fatal(err)
+		}
+		c.modify(proto)
+		if ls.Load(binchunk.Dump(proto, false), "=src", "b") != api.LUA_ERRSYNTAX ||
+			!strings.HasPrefix(ls.ToString(-1), "src: bad binary format (") ||
+			!strings.Contains(ls.ToString(-1), c.msg) {
+			t.Errorf("%s: %s", c.msg, ls.ToString(-1))
+		}
+		ls.Pop(1)
+	}
+
+	proto, _ := compiler.Compile(src, "=src")
+	chunk := binchunk.Dump(proto, false)
+	if ls.Load(chunk[:len(chunk)-10], "=src", "b") != api.LUA_ERRSYNTAX ||
+		ls.ToString(-1) != "src: bad binary format (truncated precompiled chunk)" {
+		t.Error(ls.ToString(-1))
+	}
+	ls.Pop(1)
+	if ls.Load(chunk, "=src", "b") != api.LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.Call(0, 1)
+	if ls.ToInteger(-1) != 2 {
+		t.Error(ls.ToInteger(-1))
+	}
+}
@@ -0,0 +1,161 @@
+package binchunk
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Dump returns the binary chunk of the main function proto, without debug
+// information if strip is set - luaU_dump
+func Dump(proto *ProtoType, strip bool) []byte {
+	w := &writer{strip: strip}
+	w.writeHeader()
+	w.writeByte(byte(len(proto.Upvalues)))
+	w.writeProto(proto, "")
+	return w.data
+}
+
+// writer binary chunk writer, the reverse of Reader
+type writer struct {
+	data  []byte
+	strip bool
+}
+
+func (w *writer) writeByte(b byte) {
+	w.data = append(w.data, b)
+}
+
+func (w *writer) writeUint32(n uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], n)
+	w.data = append(w.data, buf[:]...)
+}
+
+func (w *writer) writeUint64(n uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], n)
+	w.data = append(w.data, buf[:]...)
+}
+
+func (w *writer) writeLuaInteger(n int64) {
+	w.writeUint64(uint64(n))
+}
+
+func (w *writer) writeLuaNumber(n float64) {
+	w.writeUint64(math.Float64bits(n))
+}
+
+// writeString writes s with its size plus one, 0 stands for no string.
+func (w *writer) writeString(s string, isNil bool) {
+	if isNil {
+		w.writeByte(0)
+		return
+	}
+	if size := len(s) + 1; size < 0xFF {
+		w.writeByte(byte(size))
+	} else {
+		w.writeByte(0xFF)
+		w.writeUint64(uint64(size))
+	}
+	w.data = append(w.data, s...)
+}
+
+func (w *writer) writeHeader() {
+	w.data = append(w.data, LuaSignature...)
+	w.writeByte(LuacVersion)
+	w.writeByte(LuacFormat)
+	w.data = append(w.data, LuacData...)
+	w.writeByte(CIntSize)
+	w.writeByte(CSizetSize)
+	w.writeByte(InstructionSize)
+	w.writeByte(LuaIntSize)
+	w.writeByte(LuaNumberSize)
+	w.writeLuaInteger(LuacInt)
+	w.writeLuaNumber(LuacNum)
+}
+
+func (w *writer) writeProto(proto *ProtoType, parentSource string) {
+	/* the source of nested functions is that of their parent */
+	w.writeString(proto.Source, w.strip || proto.Source == parentSource)
+	w.writeUint32(proto.LineDefined)
+	w.writeUint32(proto.LastLineDefined)
+	w.writeByte(proto.NumParams)
+	w.writeByte(proto.IsVararg)
+	w.writeByte(proto.MaxStackSize)
+
+	w.writeUint32(uint32(len(proto.Code)))
+	for _, inst := range proto.Code {
+		w.writeUint32(inst)
+	}
+
+	w.writeUint32(uint32(len(proto.Constants)))
+	for _, k := range proto.Constants {
+		w.writeConstant(k)
+	}
+
+	w.writeUint32(uint32(len(proto.Upvalues)))
+	for _, uv := range proto.Upvalues {
+		w.writeByte(uv.Instack)
+		w.writeByte(uv.Idx)
+	}
+
+	w.writeUint32(uint32(len(proto.Protos)))
+	for _, p := range proto.Protos {
+		w.writeProto(p, proto.Source)
+	}
+
+	w.writeDebug(proto)
+}
+
+func (w *writer) writeConstant(k interface{}) {
+	switch x := k.(type) {
+	case nil:
+		w.writeByte(TAG_NIL)
+	case bool:
+		w.writeByte(TAG_BOOLEAN)
+		if x {
+			w.writeByte(1)
+		} else {
+			w.writeByte(0)
+		}
+	case int64:
+		w.writeByte(TAG_INTEGER)
+		w.writeLuaInteger(x)
+	case float64:
+		w.writeByte(TAG_NUMBER)
+		w.writeLuaNumber(x)
+	case string:
+		if len(x) <= 40 { /* LUAI_MAXSHORTLEN */
+			w.writeByte(TAG_SHORT_STR)
+		} else {
+			w.writeByte(TAG_LONG_STR)
+		}
+		w.writeString(x, false)
+	default:
+		panic("unknown constant type!")
+	}
+}
+
+func (w *writer) writeDebug(proto *ProtoType) {
+	if w.strip {
+		w.writeUint32(0) /* line info */
+		w.writeUint32(0) /* local vars */
+		w.writeUint32(0) /* upvalue names */
+		return
+	}
+
+	w.writeUint32(uint32(len(proto.LineInfo)))
+	for _, line := range proto.LineInfo {
+		w.writeUint32(line)
+	}
+	w.writeUint32(uint32(len(proto.LocVars)))
+	for _, locVar := range proto.LocVars {
+		w.writeString(locVar.VarName, false)
+		w.writeUint32(locVar.StartPC)
+		w.writeUint32(locVar.EndPC)
+	}
+	w.writeUint32(uint32(len(proto.UpvalueNames)))
+	for _, name := range proto.UpvalueNames {
+		w.writeString(name, false)
+	}
+}
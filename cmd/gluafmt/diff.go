@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3 // lines of context around the changes
+
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// diff returns the unified diff between the old and the new contents of the
+// file name.
+func diff(name string, old, new []byte) string {
+	script := diffLines(lines(old), lines(new))
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s.orig\n+++ %s\n", name, name)
+	oldLine, newLine := 1, 1 // next lines of the files
+	for i := 0; i < len(script); {
+		if script[i].op == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+		// a hunk from the context before the change at i to the context
+		// after the last change less than 2*diffContext lines after it
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end, same := i, 0
+		for ; end < len(script) && same <= 2*diffContext; end++ {
+			if script[end].op == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+		}
+		if same > diffContext {
+			end -= same - diffContext
+		}
+		oldStart, newStart := oldLine-(i-start), newLine-(i-start)
+		oldLen, newLen := 0, 0
+		for _, l := range script[start:end] {
+			if l.op != '+' {
+				oldLen++
+			}
+			if l.op != '-' {
+				newLen++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldLen), hunkRange(newStart, newLen))
+		for _, l := range script[start:end] {
+			b.WriteByte(l.op)
+			b.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		oldLine += oldLen - (i - start)
+		newLine += newLen - (i - start)
+		i = end
+	}
+	return b.String()
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		start-- // the line before the empty range
+	}
+	if n == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
+
+// diffLines returns the lines of a and b as an edit script turning a into b,
+// from their longest common subsequence.
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffLine{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	var middle []diffLine
+	if len(a)*len(b) > 1<<22 {
+		// too large for the table, replace all the lines
+		for _, l := range a {
+			middle = append(middle, diffLine{'-', l})
+		}
+		for _, l := range b {
+			middle = append(middle, diffLine{'+', l})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// a[i:] and b[j:]
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				middle = append(middle, diffLine{' ', a[i]})
+				i++
+				j++
+			case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+				middle = append(middle, diffLine{'-', a[i]})
+				i++
+			default:
+				middle = append(middle, diffLine{'+', b[j]})
+				j++
+			}
+		}
+	}
+	return append(append(prefix, middle...), suffix...)
+}
+
+// lines splits b into lines, keeping their newlines.
+func lines(b []byte) []string {
+	s := string(b)
+	var ls []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n') + 1
+		if i == 0 {
+			i = len(s)
+		}
+		ls = append(ls, s[:i])
+		s = s[i:]
+	}
+	return ls
+}
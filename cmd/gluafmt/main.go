@@ -0,0 +1,162 @@
+// Command gluafmt formats Lua source files.
+//
+// Usage:
+//
+//	gluafmt [flags] [path ...]
+//
+// Without paths it formats the standard input. Directories are walked for
+// .lua files. By default the formatted sources are written to the standard
+// output.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/compiler/printer"
+)
+
+var (
+	write         = flag.Bool("w", false, "write the result to the source files instead of the standard output")
+	doDiff        = flag.Bool("d", false, "print diffs instead of the formatted sources")
+	list          = flag.Bool("l", false, "list the files whose formatting differs")
+	indent        = flag.String("indent", "\t", "indentation of one level")
+	quote         = flag.String("quote", "", "quote of the short strings: \" or ', empty to keep them")
+	trailingComma = flag.Bool("trailing-comma", false, "end multi-line table constructors with a comma")
+	parens        = flag.String("parens", "keep", "parentheses to write: keep, minimal or explicit")
+	version       = flag.String("version", "5.4", "language version of the sources: 5.1, 5.3 or 5.4")
+)
+
+var cfg printer.Config
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gluafmt [flags] [path ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := configure(); err != nil {
+		fmt.Fprintln(os.Stderr, "gluafmt:", err)
+		os.Exit(2)
+	}
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "gluafmt: cannot use -w with the standard input")
+			os.Exit(2)
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err == nil {
+			_, err = process("<stdin>", src)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && (filepath.Ext(path) == ".lua" || isArg(path)) {
+				err = processFile(path, info)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// isArg reports whether path was given on the command line, such files are
+// formatted whatever their extension.
+func isArg(path string) bool {
+	for _, arg := range flag.Args() {
+		if path == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func configure() error {
+	cfg.Indent = *indent
+	cfg.TrailingComma = *trailingComma
+	switch *quote {
+	case "":
+	case `"`, "'":
+		cfg.Quote = (*quote)[0]
+	default:
+		return fmt.Errorf("invalid quote %q", *quote)
+	}
+	switch *parens {
+	case "keep":
+		cfg.Parens = printer.ParensKeep
+	case "minimal":
+		cfg.Parens = printer.ParensMinimal
+	case "explicit":
+		cfg.Parens = printer.ParensExplicit
+	default:
+		return fmt.Errorf("invalid parens mode %q", *parens)
+	}
+	switch *version {
+	case "5.1":
+		cfg.Version = api.LUA_VERSION_51
+	case "5.3":
+		cfg.Version = api.LUA_VERSION_53
+	case "5.4":
+		cfg.Version = api.LUA_VERSION_54
+	default:
+		return fmt.Errorf("invalid version %q", *version)
+	}
+	return nil
+}
+
+func processFile(path string, info os.FileInfo) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	res, err := process(path, src)
+	if err != nil {
+		return err
+	}
+	if *write && !bytes.Equal(src, res) {
+		return ioutil.WriteFile(path, res, info.Mode().Perm())
+	}
+	return nil
+}
+
+// process formats src and prints the result as the flags ask.
+func process(name string, src []byte) ([]byte, error) {
+	res, err := cfg.Format(src, name)
+	if err != nil {
+		return nil, err
+	}
+	changed := !bytes.Equal(src, res)
+	if *list && changed {
+		fmt.Println(name)
+	}
+	if *doDiff && changed {
+		fmt.Print(diff(name, src, res))
+	}
+	if !*list && !*doDiff && !*write {
+		os.Stdout.Write(res)
+	}
+	return res, nil
+}
@@ -0,0 +1,103 @@
+// Command glualint checks Lua source files for likely mistakes.
+//
+// Usage:
+//
+//	glualint [flags] [path ...]
+//
+// Without paths it checks the standard input. Directories are walked for
+// .lua files. It exits with status 1 if it reports anything.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/compiler/lint"
+)
+
+var (
+	jsonOutput = flag.Bool("json", false, "print the diagnostics as a JSON array")
+	globals    = flag.String("globals", "", "comma-separated globals allowed besides the standard ones")
+	disable    = flag.String("disable", "", "comma-separated checks not to run")
+	version    = flag.String("version", "5.3", "language version of the sources: 5.1, 5.3 or 5.4")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: glualint [flags] [path ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	cfg := &lint.Config{Disabled: map[string]bool{}}
+	if *globals != "" {
+		cfg.Globals = strings.Split(*globals, ",")
+	}
+	if *disable != "" {
+		for _, check := range strings.Split(*disable, ",") {
+			cfg.Disabled[check] = true
+		}
+	}
+	switch *version {
+	case "5.1":
+		cfg.Version = api.LUA_VERSION_51
+	case "5.3":
+		cfg.Version = api.LUA_VERSION_53
+	case "5.4":
+		cfg.Version = api.LUA_VERSION_54
+	default:
+		fmt.Fprintf(os.Stderr, "glualint: invalid version %q\n", *version)
+		os.Exit(2)
+	}
+
+	diags := []*lint.Diagnostic{}
+	failed := false
+	check := func(name string, src []byte) {
+		ds, _ := cfg.CheckSource(src, name)
+		diags = append(diags, ds...)
+	}
+	if flag.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		check("<stdin>", src)
+	}
+	for _, path := range flag.Args() {
+		err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && (name == path || filepath.Ext(name) == ".lua") {
+				var src []byte
+				if src, err = ioutil.ReadFile(name); err == nil {
+					check(name, src)
+				}
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+
+	if *jsonOutput {
+		out, _ := json.MarshalIndent(diags, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+	if failed || len(diags) > 0 {
+		os.Exit(1)
+	}
+}
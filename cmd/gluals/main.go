@@ -0,0 +1,74 @@
+// Command gluals is a Language Server Protocol server for Lua, talking to
+// the editor over its standard input and output.
+//
+// Usage:
+//
+//	gluals [flags]
+//
+// The -stubs files describe the Go libraries the host registers, for
+// completion and hover, as documented by lsp.Stub.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/lsp"
+)
+
+var (
+	version = flag.String("version", "5.3", "language version of the sources: 5.1, 5.3 or 5.4")
+	stubs   = flag.String("stubs", "", "comma-separated JSON files of stubs of the host libraries")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gluals [flags]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	opts := lsp.Options{Stubs: map[string]*lsp.Stub{}}
+	switch *version {
+	case "5.1":
+		opts.Version = api.LUA_VERSION_51
+	case "5.3":
+		opts.Version = api.LUA_VERSION_53
+	case "5.4":
+		opts.Version = api.LUA_VERSION_54
+	default:
+		fmt.Fprintf(os.Stderr, "gluals: invalid version %q\n", *version)
+		os.Exit(2)
+	}
+	if *stubs != "" {
+		for _, name := range strings.Split(*stubs, ",") {
+			if err := loadStubs(name, opts.Stubs); err != nil {
+				fmt.Fprintf(os.Stderr, "gluals: %v\n", err)
+				os.Exit(2)
+			}
+		}
+	}
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout, opts).Serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "gluals: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func loadStubs(name string, stubs map[string]*lsp.Stub) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	loaded, err := lsp.LoadStubs(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for global, stub := range loaded {
+		stubs[global] = stub
+	}
+	return nil
+}
@@ -11,31 +11,61 @@ var ::=  Name | prefixexp ‘[’ exp ‘]’ | prefixexp ‘.’ Name
 functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
 */
 
-type Exp interface{}
+type Exp interface {
+	Node
+	expNode()
+}
 
-type NilExp struct{ Line int }    // nil
-type TrueExp struct{ Line int }   // true
-type FalseExp struct{ Line int }  // false
-type VarargExp struct{ Line int } // ...
+// nil
+type NilExp struct {
+	Span
+	Line int
+}
+
+// true
+type TrueExp struct {
+	Span
+	Line int
+}
+
+// false
+type FalseExp struct {
+	Span
+	Line int
+}
+
+// ...
+type VarargExp struct {
+	Span
+	Line int
+}
 
 // Numeral
+// Raw is the literal as written, "" if the node was not read from source.
 type IntegerExp struct {
+	Span
 	Line int
 	Val  int64
+	Raw  string
 }
 type FloatExp struct {
+	Span
 	Line int
 	Val  float64
+	Raw  string
 }
 
 // LiteralString
 type StringExp struct {
+	Span
 	Line int
 	Str  string
+	Raw  string // the literal as written with its quotes, or ""
 }
 
 // unop exp
 type UnopExp struct {
+	Span
 	Line int // line of operator
 	Op   int // operator
 	Exp  Exp
@@ -43,6 +73,7 @@ type UnopExp struct {
 
 // exp1 op exp2
 type BinopExp struct {
+	Span
 	Line int // line of operator
 	Op   int // operator
 	Exp1 Exp
@@ -50,6 +81,7 @@ type BinopExp struct {
 }
 
 type ConcatExp struct {
+	Span
 	Line int // line of last ..
 	Exps []Exp
 }
@@ -59,6 +91,7 @@ type ConcatExp struct {
 // field ::= ‘[’ exp ‘]’ ‘=’ exp | Name ‘=’ exp | exp
 // fieldsep ::= ‘,’ | ‘;’
 type TableConstructorExp struct {
+	Span
 	Line     int // line of `{` ?
 	LastLine int // line of `}`
 	KeyExps  []Exp
@@ -70,9 +103,11 @@ type TableConstructorExp struct {
 // parlist ::= namelist [‘,’ ‘...’] | ‘...’
 // namelist ::= Name {‘,’ Name}
 type FuncDefExp struct {
+	Span
 	Line     int
 	LastLine int // line of `end`
 	ParList  []string
+	Params   []*NameExp // the names of ParList, without an implicit self
 	IsVararg bool
 	Block    *Block
 }
@@ -87,24 +122,45 @@ prefixexp ::= Name |
 */
 
 type NameExp struct {
+	Span
 	Line int
 	Name string
 }
 
 type ParensExp struct {
+	Span
 	Exp Exp
 }
 
 type TableAccessExp struct {
+	Span
 	LastLine  int // line of `]` ?
 	PrefixExp Exp
 	KeyExp    Exp
 }
 
 type FuncCallExp struct {
+	Span
 	Line      int // line of `(` ?
 	LastLine  int // line of ')'
 	PrefixExp Exp
 	NameExp   *StringExp
 	Args      []Exp
 }
+
+func (*NilExp) expNode()              {}
+func (*TrueExp) expNode()             {}
+func (*FalseExp) expNode()            {}
+func (*VarargExp) expNode()           {}
+func (*IntegerExp) expNode()          {}
+func (*FloatExp) expNode()            {}
+func (*StringExp) expNode()           {}
+func (*UnopExp) expNode()             {}
+func (*BinopExp) expNode()            {}
+func (*ConcatExp) expNode()           {}
+func (*TableConstructorExp) expNode() {}
+func (*FuncDefExp) expNode()          {}
+func (*NameExp) expNode()             {}
+func (*ParensExp) expNode()           {}
+func (*TableAccessExp) expNode()      {}
+func (*FuncCallExp) expNode()         {}
@@ -0,0 +1,61 @@
+package ast
+
+// Pos is a position in the source of a chunk. Lines and columns start at 1,
+// columns count bytes.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// Node is implemented by all the nodes of the AST.
+type Node interface {
+	Pos() Pos            // position of the first byte of the node
+	End() Pos            // position just after the last byte of the node
+	Comments() *Comments // comments attached to the node, or nil
+}
+
+// Span holds the positions and the comments of a node, every node embeds
+// one.
+type Span struct {
+	StartPos Pos
+	EndPos   Pos
+	Trivia   *Comments // nil unless the parser keeps comments
+}
+
+func (s *Span) Pos() Pos {
+	return s.StartPos
+}
+
+func (s *Span) End() Pos {
+	return s.EndPos
+}
+
+func (s *Span) Comments() *Comments {
+	return s.Trivia
+}
+
+// SetSpan sets the positions of the node.
+func (s *Span) SetSpan(start, end Pos) {
+	s.StartPos, s.EndPos = start, end
+}
+
+func (s *Span) SetComments(comments *Comments) {
+	s.Trivia = comments
+}
+
+// Comment is a comment of the source, kept as trivia.
+type Comment struct {
+	StartPos Pos
+	EndPos   Pos
+	Text     string // including the leading "--"
+}
+
+// Comments are the comments attached to a node. The parser attaches them
+// to statements, to the values of table constructors and to blocks:
+// Leading holds the comments before the node and Trailing the ones after it
+// on its last line. For a Block, Leading holds the comments before its
+// return statement and Trailing the ones at its end.
+type Comments struct {
+	Leading  []*Comment
+	Trailing []*Comment
+}
@@ -17,38 +17,71 @@ stat ::=  ‘;’ |
 	 local function Name funcbody |
 	 local namelist [‘=’ explist]
 */
-type Stat interface{}
+type Stat interface {
+	Node
+	statNode()
+}
+
+// ‘;’
+type EmptyStat struct {
+	Span
+}
+
+// break
+type BreakStat struct {
+	Span
+	Line int
+}
 
-type EmptyStat struct{}              // ‘;’
-type BreakStat struct{ Line int }    // break
-type LabelStat struct{ Name string } // ‘::’ Name ‘::’
-type GotoStat struct{ Name string }  // goto Name
-type DoStat struct{ Block *Block }   // do block end
-type FuncCallStat = FuncCallExp      // functioncall
+// ‘::’ Name ‘::’
+type LabelStat struct {
+	Span
+	Name string
+}
+
+// goto Name
+type GotoStat struct {
+	Span
+	Name string
+}
+
+// do block end
+type DoStat struct {
+	Span
+	Block *Block
+}
+
+// functioncall
+type FuncCallStat = FuncCallExp
 
 // if exp then block {elseif exp then block} [else block] end
 type IfStat struct {
+	Span
 	Exps   []Exp
 	Blocks []*Block
 }
 
 // while exp do block end
 type WhileStat struct {
+	Span
 	Exp   Exp
 	Block *Block
 }
 
 // repeat block until exp
 type RepeatStat struct {
+	Span
 	Block *Block
 	Exp   Exp
 }
 
 // for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
 type ForNumStat struct {
+	Span
 	LineOfFor int
 	LineOfDo  int
 	VarName   string
+	Var       *NameExp // VarName
 	InitExp   Exp
 	LimitExp  Exp
 	StepExp   Exp
@@ -59,8 +92,10 @@ type ForNumStat struct {
 // namelist ::= Name {‘,’ Name}
 // explist ::= exp {‘,’ exp}
 type ForInStat struct {
+	Span
 	LineOfDo int
 	NameList []string
+	Names    []*NameExp // the names of NameList
 	ExpList  []Exp
 	Block    *Block
 }
@@ -69,6 +104,7 @@ type ForInStat struct {
 // varlist ::= var {‘,’ var}
 // var ::=  Name | prefixexp ‘[’ exp ‘]’ | prefixexp ‘.’ Name
 type AssignStat struct {
+	Span
 	LastLine int
 	VarList  []Exp
 	ExpList  []Exp
@@ -77,14 +113,36 @@ type AssignStat struct {
 // local namelist [‘=’ explist]
 // namelist ::= Name {‘,’ Name}
 // explist ::= exp {‘,’ exp}
+// attnamelist ::=  Name attrib {‘,’ Name attrib} (Lua 5.4)
+// attrib ::= [‘<’ Name ‘>’]
 type LocalVarDeclStat struct {
-	LastLine int
-	NameList []string
-	ExpList  []Exp
+	Span
+	LastLine   int
+	NameList   []string
+	Names      []*NameExp // the names of NameList
+	ExpList    []Exp
+	AttribList []string // "const", "close" or "", nil before Lua 5.4
 }
 
 // local function Name funcbody
 type LocalFuncDefStat struct {
-	Name string
-	Exp  *FuncDefExp
+	Span
+	Name    string
+	NameExp *NameExp // Name
+	Exp     *FuncDefExp
 }
+
+func (*EmptyStat) statNode()        {}
+func (*BreakStat) statNode()        {}
+func (*LabelStat) statNode()        {}
+func (*GotoStat) statNode()         {}
+func (*DoStat) statNode()           {}
+func (*FuncCallExp) statNode()      {}
+func (*IfStat) statNode()           {}
+func (*WhileStat) statNode()        {}
+func (*RepeatStat) statNode()       {}
+func (*ForNumStat) statNode()       {}
+func (*ForInStat) statNode()        {}
+func (*AssignStat) statNode()       {}
+func (*LocalVarDeclStat) statNode() {}
+func (*LocalFuncDefStat) statNode() {}
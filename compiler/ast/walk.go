@@ -0,0 +1,90 @@
+package ast
+
+// Inspect traverses the AST in depth-first order: it calls f(node) and, if
+// f returns true, visits the children of node, then calls f(nil). Nil
+// children, as left by a parse with errors, are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *Block:
+		for _, stat := range n.Stats {
+			Inspect(stat, f)
+		}
+		inspectExps(n.RetExps, f)
+	case *DoStat:
+		Inspect(n.Block, f)
+	case *WhileStat:
+		Inspect(n.Exp, f)
+		Inspect(n.Block, f)
+	case *RepeatStat:
+		Inspect(n.Block, f)
+		Inspect(n.Exp, f)
+	case *IfStat:
+		for i, exp := range n.Exps {
+			Inspect(exp, f)
+			Inspect(n.Blocks[i], f)
+		}
+	case *ForNumStat:
+		Inspect(n.Var, f)
+		Inspect(n.InitExp, f)
+		Inspect(n.LimitExp, f)
+		Inspect(n.StepExp, f)
+		Inspect(n.Block, f)
+	case *ForInStat:
+		for _, name := range n.Names {
+			Inspect(name, f)
+		}
+		inspectExps(n.ExpList, f)
+		Inspect(n.Block, f)
+	case *AssignStat:
+		inspectExps(n.VarList, f)
+		inspectExps(n.ExpList, f)
+	case *LocalVarDeclStat:
+		for _, name := range n.Names {
+			Inspect(name, f)
+		}
+		inspectExps(n.ExpList, f)
+	case *LocalFuncDefStat:
+		Inspect(n.NameExp, f)
+		Inspect(n.Exp, f)
+	case *UnopExp:
+		Inspect(n.Exp, f)
+	case *BinopExp:
+		Inspect(n.Exp1, f)
+		Inspect(n.Exp2, f)
+	case *ConcatExp:
+		inspectExps(n.Exps, f)
+	case *TableConstructorExp:
+		for i, val := range n.ValExps {
+			if key := n.KeyExps[i]; key != nil {
+				Inspect(key, f)
+			}
+			Inspect(val, f)
+		}
+	case *FuncDefExp:
+		for _, param := range n.Params {
+			Inspect(param, f)
+		}
+		Inspect(n.Block, f)
+	case *ParensExp:
+		Inspect(n.Exp, f)
+	case *TableAccessExp:
+		Inspect(n.PrefixExp, f)
+		Inspect(n.KeyExp, f)
+	case *FuncCallExp:
+		Inspect(n.PrefixExp, f)
+		if n.NameExp != nil {
+			Inspect(n.NameExp, f)
+		}
+		inspectExps(n.Args, f)
+	}
+	f(nil)
+}
+
+func inspectExps(exps []Exp, f func(Node) bool) {
+	for _, exp := range exps {
+		Inspect(exp, f)
+	}
+}
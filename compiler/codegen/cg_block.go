@@ -26,7 +26,7 @@ func cgRetStat(fi *funcInfo, exps []ast.Exp) {
 				return
 			}
 		}
-		if fcExp, ok := exps[0].(*ast.FuncCallExp); ok {
+		if fcExp, ok := exps[0].(*ast.FuncCallExp); ok && !fi.hasTBCLocVars() {
 			r := fi.allocReg()
 			cgTailCallExp(fi, fcExp, r)
 			fi.freeReg()
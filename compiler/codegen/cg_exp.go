@@ -10,16 +10,22 @@ import (
 func cgExp(fi *funcInfo, node ast.Exp, a, n int) {
 	switch exp := node.(type) {
 	case *ast.NilExp:
+		fi.setLine(exp.Line)
 		fi.emitLoadNil(a, n)
 	case *ast.FalseExp:
+		fi.setLine(exp.Line)
 		fi.emitLoadBool(a, 0, 0)
 	case *ast.TrueExp:
+		fi.setLine(exp.Line)
 		fi.emitLoadBool(a, 1, 0)
 	case *ast.IntegerExp:
+		fi.setLine(exp.Line)
 		fi.emitLoadK(a, exp.Val)
 	case *ast.FloatExp:
+		fi.setLine(exp.Line)
 		fi.emitLoadK(a, exp.Val)
 	case *ast.StringExp:
+		fi.setLine(exp.Line)
 		fi.emitLoadK(a, exp.Str)
 	case *ast.ParensExp:
 		cgExp(fi, exp.Exp, a, 1)
@@ -45,8 +51,9 @@ func cgExp(fi *funcInfo, node ast.Exp, a, n int) {
 }
 
 func cgVarargExp(fi *funcInfo, node *ast.VarargExp, a, n int) {
+	fi.setLine(node.Line)
 	if !fi.isVararg {
-		panic("cannot use '...' outside a vararg function")
+		fi.error("cannot use '...' outside a vararg function")
 	}
 	fi.emitVararg(a, n)
 }
@@ -62,6 +69,7 @@ func cgFuncDefExp(fi *funcInfo, node *ast.FuncDefExp, a int) {
 
 	cgBlock(subFI, node.Block)
 	subFI.exitScope()
+	subFI.setLine(node.Block.LastLine)
 	subFI.emitReturn(0, 0)
 
 	bx := len(fi.subFuncs) - 1
@@ -100,7 +108,7 @@ func cgTableConstructorExp(fi *funcInfo, node *ast.TableConstructorExp, a int) {
 					n = 50
 				}
 				fi.freeRegs(n)
-				c := (arrIdx-1)/50 + 1 // todo: c > 0xFF
+				c := (arrIdx-1)/50 + 1
 				if i == nExps-1 && multRet {
 					fi.emitSetList(a, 0, c)
 				} else {
@@ -125,6 +133,7 @@ func cgTableConstructorExp(fi *funcInfo, node *ast.TableConstructorExp, a int) {
 func cgUnopExp(fi *funcInfo, node *ast.UnopExp, a int) {
 	b := fi.allocReg()
 	cgExp(fi, node.Exp, b, 1)
+	fi.setLine(node.Line)
 	fi.emitUnaryOp(node.Op, a, b)
 	fi.freeReg()
 }
@@ -153,6 +162,7 @@ func cgBinopExp(fi *funcInfo, node *ast.BinopExp, a int) {
 		cgExp(fi, node.Exp1, b, 1)
 		c := fi.allocReg()
 		cgExp(fi, node.Exp2, c, 1)
+		fi.setLine(node.Line)
 		fi.emitBinaryOp(node.Op, a, b, c)
 		fi.freeRegs(2)
 	}
@@ -168,11 +178,13 @@ func cgConcatExp(fi *funcInfo, node *ast.ConcatExp, a int) {
 	c := fi.usedRegs - 1
 	b := c - len(node.Exps) + 1
 	fi.freeRegs(c - b + 1)
+	fi.setLine(node.Line)
 	fi.emitABC(vm.OP_CONCAT, a, b, c)
 }
 
 // r[a] := name
 func cgNameExp(fi *funcInfo, node *ast.NameExp, a int) {
+	fi.setLine(node.Line)
 	if r := fi.slotOfLocVar(node.Name); r >= 0 {
 		fi.emitMove(a, r)
 	} else if idx := fi.indexOfUpval(node.Name); idx >= 0 {
@@ -192,6 +204,7 @@ func cgTableAccessExp(fi *funcInfo, node *ast.TableAccessExp, a int) {
 	cgExp(fi, node.PrefixExp, b, 1)
 	c := fi.allocReg()
 	cgExp(fi, node.KeyExp, c, 1)
+	fi.setLine(node.LastLine)
 	fi.emitGetTable(a, b, c)
 	fi.freeRegs(2)
 }
@@ -199,12 +212,14 @@ func cgTableAccessExp(fi *funcInfo, node *ast.TableAccessExp, a int) {
 // r[a] := f(args)
 func cgFuncCallExp(fi *funcInfo, node *ast.FuncCallExp, a, n int) {
 	nArgs := prepFuncCall(fi, node, a)
+	fi.setLine(node.Line)
 	fi.emitCall(a, nArgs, n)
 }
 
 // return f(args)
 func cgTailCallExp(fi *funcInfo, node *ast.FuncCallExp, a int) {
 	nArgs := prepFuncCall(fi, node, a)
+	fi.setLine(node.Line)
 	fi.emitTailCall(a, nArgs)
 }
 
@@ -214,7 +229,13 @@ func prepFuncCall(fi *funcInfo, node *ast.FuncCallExp, a int) int {
 
 	cgExp(fi, node.PrefixExp, a, 1)
 	if node.NameExp != nil {
-		c := 0x100 + fi.indexOfConstant(node.NameExp.Str)
+		fi.allocReg() // self
+		c := fi.rkOfConstant(node.NameExp.Str)
+		if c < 0 {
+			c = fi.allocReg()
+			fi.emitLoadK(c, node.NameExp.Str)
+			fi.freeReg()
+		}
 		fi.emitSelf(a, a, c)
 	}
 	for i, arg := range node.Args {
@@ -229,6 +250,7 @@ func prepFuncCall(fi *funcInfo, node *ast.FuncCallExp, a int) int {
 	fi.freeRegs(nArgs)
 
 	if node.NameExp != nil {
+		fi.freeReg()
 		nArgs++
 	}
 	if lastArgIsVarargOrFuncCall {
@@ -27,7 +27,7 @@ func cgStat(fi *funcInfo, node ast.Stat) {
 	case *ast.LocalFuncDefStat:
 		cgLocalFuncDefStat(fi, stat)
 	case *ast.LabelStat, *ast.GotoStat:
-		panic("label and goto statements are not supported!")
+		fi.error("label and goto statements are not supported")
 	}
 }
 
@@ -43,7 +43,8 @@ func cgFuncCallStat(fi *funcInfo, node *ast.FuncCallStat) {
 }
 
 func cgBreakStat(fi *funcInfo, node *ast.BreakStat) {
-	pc := fi.emitJmp(0, 0)
+	fi.setLine(node.Line)
+	pc := fi.emitJmp(fi.getBreakJmpArgA(), 0)
 	fi.addBreakJmp(pc)
 }
 
@@ -156,9 +157,11 @@ func cgForNumStat(fi *funcInfo, node *ast.ForNumStat) {
 	fi.addLocVar(node.VarName)
 
 	a := fi.usedRegs - 4
+	fi.setLine(node.LineOfDo)
 	pcForPrep := fi.emitForPrep(a, 0)
 	cgBlock(fi, node.Block)
 	fi.closeOpenUpvals()
+	fi.setLine(node.LineOfFor)
 	pcForLoop := fi.emitForLoop(a, 0)
 
 	fi.fixSbx(pcForPrep, pcForLoop-pcForPrep-1)
@@ -184,6 +187,7 @@ func cgForInStat(fi *funcInfo, node *ast.ForInStat) {
 	fi.fixSbx(pcJmpToTFC, fi.pc()-pcJmpToTFC)
 
 	rGenerator := fi.slotOfLocVar("(for generator)")
+	fi.setLine(node.LineOfDo)
 	fi.emitTForCall(rGenerator, len(node.NameList))
 	fi.emitTForLoop(rGenerator+2, pcJmpToTFC-fi.pc()-1)
 
@@ -231,8 +235,17 @@ func cgLocalVarDeclStat(fi *funcInfo, node *ast.LocalVarDeclStat) {
 	}
 
 	fi.usedRegs = oldRegs
-	for _, name := range node.NameList {
-		fi.addLocVar(name)
+	for i, name := range node.NameList {
+		if node.Names != nil {
+			fi.setLine(node.Names[i].Line)
+		}
+		a := fi.addLocVar(name)
+		if node.AttribList != nil && node.AttribList[i] != "" {
+			fi.locNames[name].attrib = node.AttribList[i]
+			if node.AttribList[i] == "close" {
+				fi.emitTBC(a)
+			}
+		}
 	}
 }
 
@@ -254,11 +267,16 @@ func cgAssignStat(fi *funcInfo, node *ast.AssignStat) {
 			cgExp(fi, taExp.KeyExp, kRegs[i], 1)
 		} else {
 			name := exp.(*ast.NameExp).Name
+			if fi.isReadOnly(name) {
+				fi.setLine(exp.(*ast.NameExp).Line)
+				fi.error("attempt to assign to const variable '%s'", name)
+			}
 			if fi.slotOfLocVar(name) < 0 && fi.indexOfUpval(name) < 0 {
 				// global var
 				kRegs[i] = -1
-				if fi.indexOfConstant(name) > 0xFF {
+				if fi.rkOfConstant(name) < 0 {
 					kRegs[i] = fi.allocReg()
+					fi.emitLoadK(kRegs[i], name)
 				}
 			}
 		}
@@ -296,6 +314,7 @@ func cgAssignStat(fi *funcInfo, node *ast.AssignStat) {
 		}
 	}
 
+	fi.setLine(node.LastLine)
 	for i, exp := range node.VarList {
 		if nameExp, ok := exp.(*ast.NameExp); ok {
 			varName := nameExp.Name
@@ -305,7 +324,7 @@ func cgAssignStat(fi *funcInfo, node *ast.AssignStat) {
 				fi.emitSetUpval(vRegs[i], b)
 			} else if a := fi.slotOfLocVar("_ENV"); a >= 0 {
 				if kRegs[i] < 0 {
-					b := 0x100 + fi.indexOfConstant(varName)
+					b := fi.rkOfConstant(varName)
 					fi.emitSetTable(a, b, vRegs[i])
 				} else {
 					fi.emitSetTable(a, kRegs[i], vRegs[i])
@@ -313,7 +332,7 @@ func cgAssignStat(fi *funcInfo, node *ast.AssignStat) {
 			} else { // global var
 				a := fi.indexOfUpval("_ENV")
 				if kRegs[i] < 0 {
-					b := 0x100 + fi.indexOfConstant(varName)
+					b := fi.rkOfConstant(varName)
 					fi.emitSetTabUp(a, b, vRegs[i])
 				} else {
 					fi.emitSetTabUp(a, kRegs[i], vRegs[i])
@@ -0,0 +1,61 @@
+package codegen_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/state"
+)
+
+// TestCodegenLimits constants, list items and registers past the operand limits
+func TestCodegenLimits(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("local t = {")
+	for i := 1; i <= 30000; i++ {
+		fmt.Fprintf(&b, "%d, ", i)
+	}
+	b.WriteString("'last', ...}\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&b, "g%d = %d\n", i, i)
+	}
+	b.WriteString(`local obj = {}
+		function obj:method299(x) return self, x end
+		assert(#t == 30003 and t[12751] == 12751 and t[30000] == 30000 and t[30001] == "last")
+		assert(t[30003] == "v2" and g299 == 299)
+		assert(select(2, obj:method299(7)) == 7)
+		return t[30002]`)
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.Load([]byte(b.String()), "=src", "t") != api.LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	chunk := ls.Dump(false)
+	if ls.Load(chunk, "=src", "b") != api.LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.PushString("v1")
+	ls.PushString("v2")
+	if ls.PCall(2, 1, 0) != api.LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	if s := ls.ToString(-1); s != "v1" {
+		t.Error(s)
+	}
+	ls.SetTop(0)
+
+	locals := "local x0" + strings.Repeat(", x", 200)
+	args := "print(" + strings.Repeat("1, ", 300) + "1)"
+	for _, c := range []struct{ src, msg string }{
+		{locals, "src:1: too many local variables (limit is 200) in main function"},
+		{"local t = {}\n\nfunction t.f()\n" + locals + "\nend", "src:4: too many local variables (limit is 200) in function at line 3"},
+		{"\n" + args, "src:2: main function has more than 255 registers"},
+		{"local function f()\n" + args + "\nend", "src:2: function at line 1 has more than 255 registers"},
+	} {
+		if ls.Load([]byte(c.src), "=src", "t") != api.LUA_ERRSYNTAX || ls.ToString(-1) != c.msg {
+			t.Errorf("%s: %s", c.msg, ls.ToString(-1))
+		}
+		ls.Pop(1)
+	}
+}
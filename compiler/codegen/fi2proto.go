@@ -4,15 +4,17 @@ import "github.com/iglev/glua/binchunk"
 
 func toProto(fi *funcInfo) *binchunk.ProtoType {
 	proto := &binchunk.ProtoType{
-		NumParams:    byte(fi.numParams),
-		MaxStackSize: byte(fi.maxRegs),
-		Code:         fi.insts,
-		Constants:    getConstants(fi),
-		Upvalues:     getUpvalues(fi),
-		Protos:       toProtos(fi.subFuncs),
-		LineInfo:     []uint32{},          // debug
-		LocVars:      []binchunk.LocVar{}, // debug
-		UpvalueNames: []string{},          // debug
+		LineDefined:     uint32(fi.lineDefined),
+		LastLineDefined: uint32(fi.lastLineDefined),
+		NumParams:       byte(fi.numParams),
+		MaxStackSize:    byte(fi.maxRegs),
+		Code:            fi.insts,
+		Constants:       getConstants(fi),
+		Upvalues:        getUpvalues(fi),
+		Protos:          toProtos(fi.subFuncs),
+		LineInfo:        fi.lineNums,         // debug
+		LocVars:         []binchunk.LocVar{}, // debug
+		UpvalueNames:    getUpvalueNames(fi), // debug
 	}
 
 	if proto.MaxStackSize < 2 {
@@ -41,6 +43,14 @@ func getConstants(fi *funcInfo) []interface{} {
 	return consts
 }
 
+func getUpvalueNames(fi *funcInfo) []string {
+	names := make([]string, len(fi.upvalues))
+	for name, uv := range fi.upvalues {
+		names[uv.index] = name
+	}
+	return names
+}
+
 func getUpvalues(fi *funcInfo) []binchunk.Upvalue {
 	upvals := make([]binchunk.Upvalue, len(fi.upvalues))
 	for _, uv := range fi.upvalues {
@@ -1,6 +1,8 @@
 package codegen
 
 import (
+	"fmt"
+
 	"github.com/iglev/glua/compiler/ast"
 	"github.com/iglev/glua/compiler/lexer"
 	"github.com/iglev/glua/vm"
@@ -25,6 +27,7 @@ type upvalInfo struct {
 	locVarSlot int
 	upvalIndex int
 	index      int
+	readOnly   bool // refers to a <const> or <close> variable
 }
 
 type locVarInfo struct {
@@ -33,6 +36,7 @@ type locVarInfo struct {
 	scopeLv  int
 	slot     int
 	captured bool
+	attrib   string // "const", "close" or ""
 }
 
 type funcInfo struct {
@@ -40,6 +44,7 @@ type funcInfo struct {
 	subFuncs  []*funcInfo
 	usedRegs  int
 	maxRegs   int
+	nActVars  int // number of the local variables in scope
 	scopeLv   int
 	locVars   []*locVarInfo
 	locNames  map[string]*locVarInfo
@@ -47,8 +52,13 @@ type funcInfo struct {
 	constants map[interface{}]int
 	breaks    [][]int
 	insts     []uint32
+	lineNums  []uint32
+	line      int // line of the code being generated
 	numParams int
 	isVararg  bool
+	/* debug */
+	lineDefined     int
+	lastLineDefined int
 }
 
 func newFuncInfo(parent *funcInfo, fd *ast.FuncDefExp) *funcInfo {
@@ -61,8 +71,13 @@ func newFuncInfo(parent *funcInfo, fd *ast.FuncDefExp) *funcInfo {
 		constants: map[interface{}]int{},
 		breaks:    make([][]int, 1),
 		insts:     make([]uint32, 0, 8),
+		lineNums:  make([]uint32, 0, 8),
+		line:      fd.Line,
 		numParams: len(fd.ParList),
 		isVararg:  fd.IsVararg,
+
+		lineDefined:     fd.Line,
+		lastLineDefined: fd.LastLine,
 	}
 }
 
@@ -78,12 +93,41 @@ func (fi *funcInfo) indexOfConstant(k interface{}) int {
 	return idx
 }
 
+// rkOfConstant returns the RK operand of the constant k, -1 if its index
+// is too large for an RK operand.
+func (fi *funcInfo) rkOfConstant(k interface{}) int {
+	if idx := fi.indexOfConstant(k); idx <= vm.MAXINDEXRK {
+		return 0x100 + idx
+	}
+	return -1
+}
+
+// error raises a *lexer.SyntaxError at the line of the code being
+// generated, the chunk name is set by the compiler.
+func (fi *funcInfo) error(f string, a ...interface{}) {
+	panic(&lexer.SyntaxError{Line: fi.line, Msg: fmt.Sprintf(f, a...)})
+}
+
+// where names the function for the errors, as the reference
+// implementation does.
+func (fi *funcInfo) where() string {
+	if fi.lineDefined == 0 {
+		return "main function"
+	}
+	return fmt.Sprintf("function at line %d", fi.lineDefined)
+}
+
 /* registers */
 
+const (
+	maxRegisters = 255 // MAXREGS
+	maxVars      = 200 // MAXVARS
+)
+
 func (fi *funcInfo) allocReg() int {
 	fi.usedRegs++
-	if fi.usedRegs >= 255 {
-		panic("function or expression needs too many registers")
+	if fi.usedRegs >= maxRegisters {
+		fi.error("%s has more than %d registers", fi.where(), maxRegisters)
 	}
 	if fi.usedRegs > fi.maxRegs {
 		fi.maxRegs = fi.usedRegs
@@ -132,11 +176,8 @@ func (fi *funcInfo) exitScope() {
 	pendingBreakJmps := fi.breaks[len(fi.breaks)-1]
 	fi.breaks = fi.breaks[:len(fi.breaks)-1]
 
-	a := fi.getJmpArgA()
 	for _, pc := range pendingBreakJmps {
-		sBx := fi.pc() - pc
-		i := (sBx+vm.MAXARG_sBx)<<14 | a<<6 | vm.OP_JMP
-		fi.insts[pc] = uint32(i)
+		fi.fixSbx(pc, fi.pc()-pc)
 	}
 
 	fi.scopeLv--
@@ -149,6 +190,7 @@ func (fi *funcInfo) exitScope() {
 
 func (fi *funcInfo) removeLocVar(locVar *locVarInfo) {
 	fi.freeReg()
+	fi.nActVars--
 	if locVar.prev == nil {
 		delete(fi.locNames, locVar.name)
 	} else if locVar.prev.scopeLv == locVar.scopeLv {
@@ -159,6 +201,10 @@ func (fi *funcInfo) removeLocVar(locVar *locVarInfo) {
 }
 
 func (fi *funcInfo) addLocVar(name string) int {
+	if fi.nActVars >= maxVars {
+		fi.error("too many local variables (limit is %d) in %s", maxVars, fi.where())
+	}
+	fi.nActVars++
 	newVar := &locVarInfo{
 		name:    name,
 		prev:    fi.locNames[name],
@@ -179,6 +225,41 @@ func (fi *funcInfo) slotOfLocVar(name string) int {
 	return -1
 }
 
+// isReadOnly tells whether name is a <const> or <close> variable, local or
+// upvalue.
+func (fi *funcInfo) isReadOnly(name string) bool {
+	if locVar, found := fi.locNames[name]; found {
+		return locVar.attrib != ""
+	}
+	if fi.indexOfUpval(name) >= 0 {
+		return fi.upvalues[name].readOnly
+	}
+	return false
+}
+
+// hasTBCLocVars tells whether a to-be-closed variable is in scope.
+func (fi *funcInfo) hasTBCLocVars() bool {
+	for _, locVar := range fi.locNames {
+		for v := locVar; v != nil; v = v.prev {
+			if v.attrib == "close" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getBreakJmpArgA returns the A of a break, which closes the variables of
+// the scopes it leaves.
+func (fi *funcInfo) getBreakJmpArgA() int {
+	for i := fi.scopeLv; i >= 0; i-- {
+		if fi.breaks[i] != nil { // breakable
+			return fi.jmpArgAFrom(i)
+		}
+	}
+	return 0
+}
+
 func (fi *funcInfo) addBreakJmp(pc int) {
 	for i := fi.scopeLv; i >= 0; i-- {
 		if fi.breaks[i] != nil { // breakable
@@ -187,7 +268,7 @@ func (fi *funcInfo) addBreakJmp(pc int) {
 		}
 	}
 
-	panic("<break> at line ? not inside a loop!")
+	fi.error("<break> at line %d not inside a loop", fi.line)
 }
 
 /* upvalues */
@@ -199,13 +280,14 @@ func (fi *funcInfo) indexOfUpval(name string) int {
 	if fi.parent != nil {
 		if locVar, found := fi.parent.locNames[name]; found {
 			idx := len(fi.upvalues)
-			fi.upvalues[name] = upvalInfo{locVar.slot, -1, idx}
+			fi.upvalues[name] = upvalInfo{locVar.slot, -1, idx, locVar.attrib != ""}
 			locVar.captured = true
 			return idx
 		}
 		if uvIdx := fi.parent.indexOfUpval(name); uvIdx >= 0 {
 			idx := len(fi.upvalues)
-			fi.upvalues[name] = upvalInfo{-1, uvIdx, idx}
+			readOnly := fi.parent.upvalues[name].readOnly
+			fi.upvalues[name] = upvalInfo{-1, uvIdx, idx, readOnly}
 			return idx
 		}
 	}
@@ -220,17 +302,21 @@ func (fi *funcInfo) closeOpenUpvals() {
 }
 
 func (fi *funcInfo) getJmpArgA() int {
+	return fi.jmpArgAFrom(fi.scopeLv)
+}
+
+// jmpArgAFrom returns the A of a jump leaving the scopes from level scopeLv
+// up, which closes their captured and to-be-closed variables.
+func (fi *funcInfo) jmpArgAFrom(scopeLv int) int {
 	hasCapturedLocVars := false
 	minSlotOfLocVars := fi.maxRegs
 	for _, locVar := range fi.locNames {
-		if locVar.scopeLv == fi.scopeLv {
-			for v := locVar; v != nil && v.scopeLv == fi.scopeLv; v = v.prev {
-				if v.captured {
-					hasCapturedLocVars = true
-				}
-				if v.slot < minSlotOfLocVars && v.name[0] != '(' {
-					minSlotOfLocVars = v.slot
-				}
+		for v := locVar; v != nil && v.scopeLv >= scopeLv; v = v.prev {
+			if v.captured || v.attrib == "close" {
+				hasCapturedLocVars = true
+			}
+			if v.slot < minSlotOfLocVars && v.name[0] != '(' {
+				minSlotOfLocVars = v.slot
 			}
 		}
 	}
@@ -243,6 +329,14 @@ func (fi *funcInfo) getJmpArgA() int {
 
 /* code */
 
+// setLine sets the line recorded for the following instructions, lines
+// unknown to the parser (0) are ignored.
+func (fi *funcInfo) setLine(line int) {
+	if line > 0 {
+		fi.line = line
+	}
+}
+
 func (fi *funcInfo) pc() int {
 	return len(fi.insts) - 1
 }
@@ -257,21 +351,25 @@ func (fi *funcInfo) fixSbx(pc, sBx int) {
 func (fi *funcInfo) emitABC(opcode, a, b, c int) {
 	i := b<<23 | c<<14 | a<<6 | opcode
 	fi.insts = append(fi.insts, uint32(i))
+	fi.lineNums = append(fi.lineNums, uint32(fi.line))
 }
 
 func (fi *funcInfo) emitABx(opcode, a, bx int) {
 	i := bx<<14 | a<<6 | opcode
 	fi.insts = append(fi.insts, uint32(i))
+	fi.lineNums = append(fi.lineNums, uint32(fi.line))
 }
 
 func (fi *funcInfo) emitAsBx(opcode, a, b int) {
 	i := (b+vm.MAXARG_sBx)<<14 | a<<6 | opcode
 	fi.insts = append(fi.insts, uint32(i))
+	fi.lineNums = append(fi.lineNums, uint32(fi.line))
 }
 
 func (fi *funcInfo) emitAx(opcode, ax int) {
 	i := ax<<6 | opcode
 	fi.insts = append(fi.insts, uint32(i))
+	fi.lineNums = append(fi.lineNums, uint32(fi.line))
 }
 
 // r[a] = r[b]
@@ -310,6 +408,11 @@ func (fi *funcInfo) emitClosure(a, bx int) {
 	fi.emitABx(vm.OP_CLOSURE, a, bx)
 }
 
+// mark r[a] to be closed
+func (fi *funcInfo) emitTBC(a int) {
+	fi.emitABC(vm.OP_TBC, a, 0, 0)
+}
+
 // r[a] = {}
 func (fi *funcInfo) emitNewTable(a, nArr, nRec int) {
 	fi.emitABC(vm.OP_NEWTABLE,
@@ -318,7 +421,12 @@ func (fi *funcInfo) emitNewTable(a, nArr, nRec int) {
 
 // r[a][(c-1)*FPF+i] := r[a+i], 1 <= i <= b
 func (fi *funcInfo) emitSetList(a, b, c int) {
-	fi.emitABC(vm.OP_SETLIST, a, b, c)
+	if c <= vm.MAXARG_C {
+		fi.emitABC(vm.OP_SETLIST, a, b, c)
+	} else {
+		fi.emitABC(vm.OP_SETLIST, a, b, 0)
+		fi.emitAx(vm.OP_EXTRAARG, c)
+	}
 }
 
 // r[a] := r[b][rk(c)]
@@ -1,12 +1,61 @@
 package compiler
 
 import (
+	"io"
+
+	"github.com/iglev/glua/api"
 	"github.com/iglev/glua/binchunk"
+	"github.com/iglev/glua/compiler/ast"
 	"github.com/iglev/glua/compiler/codegen"
+	"github.com/iglev/glua/compiler/lexer"
 	"github.com/iglev/glua/compiler/parser"
 )
 
-func Compile(chunk, chunkName string) *binchunk.ProtoType {
-	ast := parser.Parse(chunk, chunkName)
-	return codegen.GenProto(ast)
+// Compile compiles chunk as Lua 5.3 code. The error is a
+// *lexer.SyntaxError.
+func Compile(chunk, chunkName string) (*binchunk.ProtoType, error) {
+	return CompileVersion(chunk, chunkName, api.LUA_VERSION_53)
+}
+
+// CompileVersion compiles chunk as code of the given language version,
+// such as api.LUA_VERSION_54.
+func CompileVersion(chunk, chunkName string, version int) (*binchunk.ProtoType, error) {
+	block, err := parser.ParseVersion(chunk, chunkName, version)
+	if err != nil {
+		return nil, err
+	}
+	return generate(block, chunkName)
+}
+
+// CompileReader is CompileVersion reading the chunk from r as it goes. If
+// r fails, the error is a *lexer.ReadError.
+func CompileReader(r io.Reader, chunkName string, version int) (*binchunk.ProtoType, error) {
+	block, err := parser.ParseReader(r, chunkName, version, 0)
+	if err != nil {
+		return nil, err
+	}
+	return generate(block, chunkName)
+}
+
+func generate(block *ast.Block, chunkName string) (proto *binchunk.ProtoType, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*lexer.SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			se.Source = chunkName
+			proto, err = nil, se
+		}
+	}()
+	proto = codegen.GenProto(block)
+	setSource(proto, chunkName)
+	return proto, nil
+}
+
+func setSource(proto *binchunk.ProtoType, chunkName string) {
+	proto.Source = chunkName
+	for _, f := range proto.Protos {
+		setSource(f, chunkName)
+	}
 }
@@ -0,0 +1,31 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError is an error in the source of a chunk, raised by the lexer,
+// the parser or the code generator.
+type SyntaxError struct {
+	Source string // chunk name
+	Line   int
+	Column int    // column of Token in bytes, starting at 1, 0 if unknown
+	Token  string // the offending token, "" if unknown
+	Msg    string // message without the position, as in "unfinished string"
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Source, e.Line, e.Msg)
+}
+
+// ErrorList is the list of the syntax errors of a chunk, in source order.
+type ErrorList []*SyntaxError
+
+func (list ErrorList) Error() string {
+	msgs := make([]string, len(list))
+	for i, e := range list {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
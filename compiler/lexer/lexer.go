@@ -1,51 +1,200 @@
 package lexer
 
 import (
-	"bytes"
 	"fmt"
-	"regexp"
-	"strconv"
-	"strings"
+	"io"
+	"sort"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/number"
 )
 
-//var reSpaces = regexp.MustCompile(`^\s+`)
-var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
-var reIdentifier = regexp.MustCompile(`^[_\d\w]+`)
-var reNumber = regexp.MustCompile(`^0[xX][0-9a-fA-F]*(\.[0-9a-fA-F]*)?([pP][+\-]?[0-9]+)?|^[0-9]*(\.[0-9]*)?([eE][+\-]?[0-9]+)?`)
-var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)
-var reOpeningLongBracket = regexp.MustCompile(`^\[=*\[`)
+// minRead is the size of the reads of a lexer reading from an io.Reader.
+const minRead = 4096
 
-var reDecEscapeSeq = regexp.MustCompile(`^\\[0-9]{1,3}`)
-var reHexEscapeSeq = regexp.MustCompile(`^\\x[0-9a-fA-F]{2}`)
-var reUnicodeEscapeSeq = regexp.MustCompile(`^\\u\{[0-9a-fA-F]+\}`)
+// eoz is returned by peek at the end of the source.
+const eoz = -1
 
 type Lexer struct {
-	chunk         string // source code
-	chunkName     string // source name
-	line          int    // current line number
-	nextToken     string
-	nextTokenKind int
-	nextTokenLine int
+	chunkName      string // source name
+	line           int    // current line number
+	nextToken      string
+	nextTokenKind  int
+	nextTokenLine  int
+	nextTokenStart int
+	nextTokenEnd   int
+	version        int // language version, see SetVersion
+
+	// The lexer scans buf, the source from offset base on. When it reads
+	// from r, it drops the input before the last token returned as it
+	// needs more.
+	r    io.Reader // nil once read to its end
+	buf  []byte
+	base int // offset of buf[0] in the source
+	pos  int // position of the scanner in buf
+	keep int // offset of the first byte to keep in buf
+
+	tokenStart   int // offset of the current token in the source
+	tokenEnd     int // offset of its end, -1 while scanning it
+	recovering   bool
+	errors       ErrorList
+	keepComments bool
+	keepSyntax   bool
+	comments     []Comment // comments not taken yet
+	lineStarts   []int     // offsets of the lines scanned so far
+	sbuf         []byte    // string being scanned, as in Lua error messages
+}
+
+// Comment is a comment kept by the lexer, see SetKeepComments.
+type Comment struct {
+	Text  string // including the leading "--"
+	Start int    // offset of the comment in the source
+	End   int    // offset just after it
+}
+
+// ReadError is raised by a lexer reading from an io.Reader when the read
+// fails.
+type ReadError struct {
+	Err error
+}
+
+func (e *ReadError) Error() string {
+	return e.Err.Error()
 }
 
 func NewLexer(chunk, chunkName string) *Lexer {
-	return &Lexer{chunk, chunkName, 1, "", 0, 0}
+	return newLexer(nil, []byte(chunk), chunkName)
+}
+
+// NewReaderLexer returns a lexer scanning the source read from r as it
+// goes. A failed read raises a *ReadError.
+func NewReaderLexer(r io.Reader, chunkName string) *Lexer {
+	return newLexer(r, nil, chunkName)
+}
+
+func newLexer(r io.Reader, buf []byte, chunkName string) *Lexer {
+	return &Lexer{chunkName: chunkName, line: 1, version: api.LUA_VERSION_53,
+		r: r, buf: buf, lineStarts: []int{0}}
+}
+
+// SetVersion selects the language version of the chunk, such as
+// api.LUA_VERSION_54.
+func (lex *Lexer) SetVersion(v int) {
+	lex.version = v
+}
+
+func (lex *Lexer) Version() int {
+	return lex.version
+}
+
+// Error raises a syntax error at the current token.
+func (lex *Lexer) Error(f string, a ...interface{}) {
+	lex.error(f, a...)
+}
+
+// SetRecovering tells the parser to record the syntax errors it meets with
+// AddError and go on with the next statement, instead of stopping at the
+// first one.
+func (lex *Lexer) SetRecovering(recovering bool) {
+	lex.recovering = recovering
+}
+
+func (lex *Lexer) Recovering() bool {
+	return lex.recovering
+}
+
+func (lex *Lexer) AddError(err *SyntaxError) {
+	lex.errors = append(lex.errors, err)
+}
+
+// Errors returns the syntax errors recorded with AddError.
+func (lex *Lexer) Errors() ErrorList {
+	return lex.errors
+}
+
+// SetKeepComments makes the lexer keep the comments it skips, see
+// Comments.
+func (lex *Lexer) SetKeepComments(keep bool) {
+	lex.keepComments = keep
+}
+
+func (lex *Lexer) KeepComments() bool {
+	return lex.keepComments
+}
+
+// SetKeepSyntax tells the parser to keep the expressions as written, see
+// parser.KeepSyntax.
+func (lex *Lexer) SetKeepSyntax(keep bool) {
+	lex.keepSyntax = keep
+}
+
+func (lex *Lexer) KeepSyntax() bool {
+	return lex.keepSyntax
+}
+
+// Comments returns the kept comments not taken yet by TakeComments, in
+// source order. They are all before the look ahead token if there is one.
+func (lex *Lexer) Comments() []Comment {
+	return lex.comments
+}
+
+// TakeComments removes and returns the first n comments of Comments.
+func (lex *Lexer) TakeComments(n int) []Comment {
+	comments := lex.comments[:n:n]
+	lex.comments = lex.comments[n:]
+	return comments
 }
 
 func (lex *Lexer) Line() int {
 	return lex.line
 }
 
+// TokenStart returns the offset in the source of the last token returned
+// by NextToken.
+func (lex *Lexer) TokenStart() int {
+	return lex.tokenStart
+}
+
+// TokenEnd returns the offset just after the last token returned by
+// NextToken.
+func (lex *Lexer) TokenEnd() int {
+	return lex.tokenEnd
+}
+
+// TokenText returns the last token returned by NextToken as written in the
+// source, such as a string literal with its quotes.
+func (lex *Lexer) TokenText() string {
+	return lex.text(lex.tokenStart, lex.tokenEnd)
+}
+
+// LookAheadStart returns the offset in the source of the next token.
+func (lex *Lexer) LookAheadStart() int {
+	lex.LookAhead()
+	return lex.nextTokenStart
+}
+
+// Position returns the line and the column, in bytes, of an offset in the
+// source scanned so far.
+func (lex *Lexer) Position(offset int) (line, column int) {
+	line = sort.Search(len(lex.lineStarts), func(i int) bool {
+		return lex.lineStarts[i] > offset
+	})
+	return line, offset - lex.lineStarts[line-1] + 1
+}
+
 func (lex *Lexer) LookAhead() int {
 	if lex.nextTokenLine > 0 {
 		return lex.nextTokenKind
 	}
 	currentLine := lex.line
+	start, end := lex.tokenStart, lex.tokenEnd
 	line, kind, token := lex.NextToken()
 	lex.line = currentLine
 	lex.nextTokenLine = line
 	lex.nextTokenKind = kind
 	lex.nextToken = token
+	lex.nextTokenStart, lex.nextTokenEnd = lex.tokenStart, lex.tokenEnd
+	lex.tokenStart, lex.tokenEnd = start, end
 	return kind
 }
 
@@ -67,352 +216,559 @@ func (lex *Lexer) NextToken() (line, kind int, token string) {
 		kind = lex.nextTokenKind
 		token = lex.nextToken
 		lex.line = lex.nextTokenLine
+		lex.tokenStart, lex.tokenEnd = lex.nextTokenStart, lex.nextTokenEnd
 		lex.nextTokenLine = 0
 		return
 	}
 
+	if lex.recovering {
+		for {
+			if line, kind, token, ok := lex.tryScanToken(); ok {
+				return line, kind, token
+			}
+		}
+	}
+	return lex.scanToken()
+}
+
+// tryScanToken records the syntax error raised while scanning the next
+// token, if any, and reports whether a token was scanned.
+func (lex *Lexer) tryScanToken() (line, kind int, token string, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, isSyntaxError := r.(*SyntaxError)
+			if !isSyntaxError {
+				panic(r)
+			}
+			lex.AddError(err)
+		}
+	}()
+	line, kind, token = lex.scanToken()
+	return line, kind, token, true
+}
+
+func (lex *Lexer) scanToken() (line, kind int, token string) {
+	lex.keep = lex.tokenStart // the text of the last token returned
 	lex.skipWhiteSpaces()
-	if len(lex.chunk) == 0 {
+	lex.tokenStart, lex.tokenEnd = lex.offset(), -1
+	line, kind, token = lex.scanTokenKind()
+	lex.tokenEnd = lex.offset()
+	return
+}
+
+func (lex *Lexer) scanTokenKind() (line, kind int, token string) {
+	c := lex.peek(0)
+	if c == eoz {
 		return lex.line, TOKEN_EOF, "EOF"
 	}
 
-	switch lex.chunk[0] {
+	switch c {
 	case ';':
-		lex.next(1)
-		return lex.line, TOKEN_SEP_SEMI, ";"
+		return lex.op(1, TOKEN_SEP_SEMI, ";")
 	case ',':
-		lex.next(1)
-		return lex.line, TOKEN_SEP_COMMA, ","
+		return lex.op(1, TOKEN_SEP_COMMA, ",")
 	case '(':
-		lex.next(1)
-		return lex.line, TOKEN_SEP_LPAREN, "("
+		return lex.op(1, TOKEN_SEP_LPAREN, "(")
 	case ')':
-		lex.next(1)
-		return lex.line, TOKEN_SEP_RPAREN, ")"
+		return lex.op(1, TOKEN_SEP_RPAREN, ")")
 	case ']':
-		lex.next(1)
-		return lex.line, TOKEN_SEP_RBRACK, "]"
+		return lex.op(1, TOKEN_SEP_RBRACK, "]")
 	case '{':
-		lex.next(1)
-		return lex.line, TOKEN_SEP_LCURLY, "{"
+		return lex.op(1, TOKEN_SEP_LCURLY, "{")
 	case '}':
-		lex.next(1)
-		return lex.line, TOKEN_SEP_RCURLY, "}"
+		return lex.op(1, TOKEN_SEP_RCURLY, "}")
 	case '+':
-		lex.next(1)
-		return lex.line, TOKEN_OP_ADD, "+"
+		return lex.op(1, TOKEN_OP_ADD, "+")
 	case '-':
-		lex.next(1)
-		return lex.line, TOKEN_OP_MINUS, "-"
+		return lex.op(1, TOKEN_OP_MINUS, "-")
 	case '*':
-		lex.next(1)
-		return lex.line, TOKEN_OP_MUL, "*"
+		return lex.op(1, TOKEN_OP_MUL, "*")
 	case '^':
-		lex.next(1)
-		return lex.line, TOKEN_OP_POW, "^"
+		return lex.op(1, TOKEN_OP_POW, "^")
 	case '%':
-		lex.next(1)
-		return lex.line, TOKEN_OP_MOD, "%"
+		return lex.op(1, TOKEN_OP_MOD, "%")
 	case '&':
-		lex.next(1)
-		return lex.line, TOKEN_OP_BAND, "&"
+		return lex.op(1, TOKEN_OP_BAND, "&")
 	case '|':
-		lex.next(1)
-		return lex.line, TOKEN_OP_BOR, "|"
+		return lex.op(1, TOKEN_OP_BOR, "|")
 	case '#':
-		lex.next(1)
-		return lex.line, TOKEN_OP_LEN, "#"
+		return lex.op(1, TOKEN_OP_LEN, "#")
 	case ':':
-		if lex.test("::") {
-			lex.next(2)
-			return lex.line, TOKEN_SEP_LABEL, "::"
-		} else {
-			lex.next(1)
-			return lex.line, TOKEN_SEP_COLON, ":"
+		if lex.peek(1) == ':' {
+			return lex.op(2, TOKEN_SEP_LABEL, "::")
 		}
+		return lex.op(1, TOKEN_SEP_COLON, ":")
 	case '/':
-		if lex.test("//") {
-			lex.next(2)
-			return lex.line, TOKEN_OP_IDIV, "//"
-		} else {
-			lex.next(1)
-			return lex.line, TOKEN_OP_DIV, "/"
+		if lex.peek(1) == '/' {
+			return lex.op(2, TOKEN_OP_IDIV, "//")
 		}
+		return lex.op(1, TOKEN_OP_DIV, "/")
 	case '~':
-		if lex.test("~=") {
-			lex.next(2)
-			return lex.line, TOKEN_OP_NE, "~="
-		} else {
-			lex.next(1)
-			return lex.line, TOKEN_OP_WAVE, "~"
+		if lex.peek(1) == '=' {
+			return lex.op(2, TOKEN_OP_NE, "~=")
 		}
+		return lex.op(1, TOKEN_OP_WAVE, "~")
 	case '=':
-		if lex.test("==") {
-			lex.next(2)
-			return lex.line, TOKEN_OP_EQ, "=="
-		} else {
-			lex.next(1)
-			return lex.line, TOKEN_OP_ASSIGN, "="
+		if lex.peek(1) == '=' {
+			return lex.op(2, TOKEN_OP_EQ, "==")
 		}
+		return lex.op(1, TOKEN_OP_ASSIGN, "=")
 	case '<':
-		if lex.test("<<") {
-			lex.next(2)
-			return lex.line, TOKEN_OP_SHL, "<<"
-		} else if lex.test("<=") {
-			lex.next(2)
-			return lex.line, TOKEN_OP_LE, "<="
-		} else {
-			lex.next(1)
-			return lex.line, TOKEN_OP_LT, "<"
+		switch lex.peek(1) {
+		case '<':
+			return lex.op(2, TOKEN_OP_SHL, "<<")
+		case '=':
+			return lex.op(2, TOKEN_OP_LE, "<=")
 		}
+		return lex.op(1, TOKEN_OP_LT, "<")
 	case '>':
-		if lex.test(">>") {
-			lex.next(2)
-			return lex.line, TOKEN_OP_SHR, ">>"
-		} else if lex.test(">=") {
-			lex.next(2)
-			return lex.line, TOKEN_OP_GE, ">="
-		} else {
-			lex.next(1)
-			return lex.line, TOKEN_OP_GT, ">"
+		switch lex.peek(1) {
+		case '>':
+			return lex.op(2, TOKEN_OP_SHR, ">>")
+		case '=':
+			return lex.op(2, TOKEN_OP_GE, ">=")
 		}
+		return lex.op(1, TOKEN_OP_GT, ">")
 	case '.':
-		if lex.test("...") {
-			lex.next(3)
-			return lex.line, TOKEN_VARARG, "..."
-		} else if lex.test("..") {
-			lex.next(2)
-			return lex.line, TOKEN_OP_CONCAT, ".."
-		} else if len(lex.chunk) == 1 || !isDigit(lex.chunk[1]) {
-			lex.next(1)
-			return lex.line, TOKEN_SEP_DOT, "."
+		if lex.peek(1) == '.' {
+			if lex.peek(2) == '.' {
+				return lex.op(3, TOKEN_VARARG, "...")
+			}
+			return lex.op(2, TOKEN_OP_CONCAT, "..")
+		}
+		if c := lex.peek(1); c == eoz || !isDigit(byte(c)) {
+			return lex.op(1, TOKEN_SEP_DOT, ".")
 		}
+		return lex.line, TOKEN_NUMBER, lex.scanNumber()
 	case '[':
-		if lex.test("[[") || lex.test("[=") {
-			return lex.line, TOKEN_STRING, lex.scanLongString()
-		} else {
-			lex.next(1)
-			return lex.line, TOKEN_SEP_LBRACK, "["
+		if sep := lex.skipSep(); sep >= 0 {
+			return lex.line, TOKEN_STRING, lex.scanLongString(sep, true)
+		} else if sep != -1 {
+			lex.error("invalid long string delimiter near '%s'", lex.text(lex.tokenStart, lex.offset()))
 		}
+		return lex.line, TOKEN_SEP_LBRACK, "["
 	case '\'', '"':
 		return lex.line, TOKEN_STRING, lex.scanShortString()
 	}
 
-	c := lex.chunk[0]
-	if c == '.' || isDigit(c) {
-		token := lex.scanNumber()
-		return lex.line, TOKEN_NUMBER, token
+	if isDigit(byte(c)) {
+		return lex.line, TOKEN_NUMBER, lex.scanNumber()
 	}
-	if c == '_' || isLetter(c) {
+	if c == '_' || isLetter(byte(c)) {
 		token := lex.scanIdentifier()
 		if kind, found := keywords[token]; found {
 			return lex.line, kind, token // keyword
-		} else {
-			return lex.line, TOKEN_IDENTIFIER, token
 		}
+		return lex.line, TOKEN_IDENTIFIER, token
 	}
 
-	lex.error("unexpected symbol near %q", c)
+	lex.pos++
+	if c >= ' ' && c < 0x7F {
+		lex.error("unexpected symbol near '%c'", c)
+	}
+	lex.error("unexpected symbol near '<\\%d>'", c)
 	return
 }
 
-func (lex *Lexer) next(n int) {
-	lex.chunk = lex.chunk[n:]
+// op scans an operator or a separator of n bytes.
+func (lex *Lexer) op(n, kind int, token string) (int, int, string) {
+	lex.pos += n
+	return lex.line, kind, token
+}
+
+// peek returns the byte i bytes after the scanner, eoz past the end of the
+// source.
+func (lex *Lexer) peek(i int) int {
+	if p := lex.pos + i; p < len(lex.buf) {
+		return int(lex.buf[p])
+	}
+	for lex.r != nil {
+		lex.fill()
+		if p := lex.pos + i; p < len(lex.buf) {
+			return int(lex.buf[p])
+		}
+	}
+	return eoz
+}
+
+// fill reads more of the source into buf.
+func (lex *Lexer) fill() {
+	if drop := lex.keep - lex.base; drop > 0 && drop >= len(lex.buf)/2 {
+		n := copy(lex.buf, lex.buf[drop:])
+		lex.buf = lex.buf[:n]
+		lex.base += drop
+		lex.pos -= drop
+	}
+	if cap(lex.buf)-len(lex.buf) < minRead {
+		buf := make([]byte, len(lex.buf), 2*cap(lex.buf)+minRead)
+		copy(buf, lex.buf)
+		lex.buf = buf
+	}
+	n, err := lex.r.Read(lex.buf[len(lex.buf):cap(lex.buf)])
+	lex.buf = lex.buf[:len(lex.buf)+n]
+	if err == io.EOF {
+		lex.r = nil
+	} else if err != nil {
+		lex.r = nil
+		panic(&ReadError{err})
+	}
+}
+
+// offset returns the offset of the scanner in the source.
+func (lex *Lexer) offset() int {
+	return lex.base + lex.pos
 }
 
-func (lex *Lexer) test(s string) bool {
-	return strings.HasPrefix(lex.chunk, s)
+// text returns the source from offset start to offset end.
+func (lex *Lexer) text(start, end int) string {
+	return string(lex.buf[start-lex.base : end-lex.base])
 }
 
+// error raises a *SyntaxError at the current token. The lexer is always
+// past the bad input by then, so that a recovering parser goes on.
 func (lex *Lexer) error(f string, a ...interface{}) {
-	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d: %s", lex.chunkName, lex.line, err)
-	panic(err)
+	panic(lex.newError(f, a...))
+}
+
+func (lex *Lexer) newError(f string, a ...interface{}) *SyntaxError {
+	end := lex.tokenEnd
+	if end < 0 {
+		end = lex.offset()
+	}
+	_, column := lex.Position(lex.tokenStart)
+	return &SyntaxError{
+		Source: lex.chunkName,
+		Line:   lex.line,
+		Column: column,
+		Token:  lex.text(lex.tokenStart, end),
+		Msg:    fmt.Sprintf(f, a...),
+	}
+}
+
+// newLine skips a line break, "\n", "\r", "\r\n" or "\n\r".
+func (lex *Lexer) newLine() {
+	c := lex.peek(0)
+	lex.pos++
+	if next := lex.peek(0); next != c && (next == '\n' || next == '\r') {
+		lex.pos++
+	}
+	lex.line++
+	lex.lineStarts = append(lex.lineStarts, lex.offset())
 }
 
 func (lex *Lexer) skipWhiteSpaces() {
-	for len(lex.chunk) > 0 {
-		if lex.test("--") {
+	if lex.offset() == 0 && lex.peek(0) == '#' {
+		// skip the first line, as "#!/usr/bin/env lua"
+		for c := lex.peek(0); c != eoz && !isNewLine(byte(c)); c = lex.peek(0) {
+			lex.pos++
+		}
+	}
+	for {
+		switch c := lex.peek(0); c {
+		case '\n', '\r':
+			lex.newLine()
+		case ' ', '\t', '\v', '\f':
+			lex.pos++
+		case '-':
+			if lex.peek(1) != '-' {
+				return
+			}
 			lex.skipComment()
-		} else if lex.test("\r\n") || lex.test("\n\r") {
-			lex.next(2)
-			lex.line += 1
-		} else if isNewLine(lex.chunk[0]) {
-			lex.next(1)
-			lex.line += 1
-		} else if isWhiteSpace(lex.chunk[0]) {
-			lex.next(1)
-		} else {
-			break
+		default:
+			return
 		}
 	}
 }
 
 func (lex *Lexer) skipComment() {
-	lex.next(2) // skip --
-
-	// long comment ?
-	if lex.test("[") {
-		if reOpeningLongBracket.FindString(lex.chunk) != "" {
-			lex.scanLongString()
-			return
+	start := lex.offset()
+	lex.tokenStart, lex.tokenEnd = start, -1
+	lex.pos += 2 // skip --
+
+	sep := -1
+	if lex.peek(0) == '[' {
+		pos := lex.pos
+		if sep = lex.skipSep(); sep < 0 {
+			lex.pos = pos
+		}
+	}
+	if sep >= 0 {
+		lex.scanLongString(sep, false) // long comment
+	} else {
+		for c := lex.peek(0); c != eoz && !isNewLine(byte(c)); c = lex.peek(0) {
+			lex.pos++ // short comment
 		}
 	}
 
-	// short comment
-	for len(lex.chunk) > 0 && !isNewLine(lex.chunk[0]) {
-		lex.next(1)
+	if lex.keepComments {
+		end := lex.offset()
+		lex.comments = append(lex.comments, Comment{lex.text(start, end), start, end})
 	}
 }
 
 func (lex *Lexer) scanIdentifier() string {
-	return lex.scan(reIdentifier)
+	for c := lex.peek(0); c == '_' || c != eoz && (isLetter(byte(c)) || isDigit(byte(c))); c = lex.peek(0) {
+		lex.pos++
+	}
+	return lex.text(lex.tokenStart, lex.offset())
 }
 
+// scanNumber scans a numeral as Lua does: the digits, the dots and the
+// exponents, then one more letter if the numeral touches one, and checks
+// the result - read_numeral.
 func (lex *Lexer) scanNumber() string {
-	return lex.scan(reNumber)
+	expo1, expo2 := 'e', 'E'
+	if lex.peek(0) == '0' && (lex.peek(1) == 'x' || lex.peek(1) == 'X') {
+		expo1, expo2 = 'p', 'P'
+		lex.pos += 2
+	}
+	for {
+		c := lex.peek(0)
+		if c == int(expo1) || c == int(expo2) {
+			lex.pos++
+			if c := lex.peek(0); c == '+' || c == '-' {
+				lex.pos++
+			}
+		} else if c != eoz && (isHexDigit(byte(c)) || c == '.') {
+			lex.pos++
+		} else {
+			break
+		}
+	}
+	if c := lex.peek(0); c == '_' || c != eoz && isLetter(byte(c)) {
+		lex.pos++ // force an error
+	}
+	token := lex.text(lex.tokenStart, lex.offset())
+	if _, ok := number.ParseInteger(token); !ok {
+		if _, ok := number.ParseFloat(token); !ok {
+			lex.error("malformed number near '%s'", token)
+		}
+	}
+	return token
 }
 
-func (lex *Lexer) scan(re *regexp.Regexp) string {
-	if token := re.FindString(lex.chunk); token != "" {
-		lex.next(len(token))
-		return token
+// skipSep skips the opening or the closing bracket of a long string, as
+// "[==[" or "]==]", and returns the number of '='. It stops after the '='
+// and returns -1-n if the second bracket is missing after n of them, past
+// the first bracket if n is 0.
+func (lex *Lexer) skipSep() int {
+	bracket := lex.peek(0)
+	lex.pos++
+	n := 0
+	for lex.peek(0) == '=' {
+		lex.pos++
+		n++
+	}
+	if lex.peek(0) == bracket {
+		lex.pos++
+		return n
 	}
-	panic("unreachable!")
+	return -1 - n
 }
 
-func (lex *Lexer) scanLongString() string {
-	openingLongBracket := reOpeningLongBracket.FindString(lex.chunk)
-	if openingLongBracket == "" {
-		lex.error("invalid long string delimiter near '%s'",
-			lex.chunk[0:2])
+// scanLongString scans a long string or comment, past its opening bracket
+// with sep '='.
+func (lex *Lexer) scanLongString(sep int, isString bool) string {
+	line := lex.line
+	lex.sbuf = lex.sbuf[:0]
+	if c := lex.peek(0); c == '\n' || c == '\r' {
+		lex.newLine() // skip the first line break
 	}
-
-	closingLongBracket := strings.Replace(openingLongBracket, "[", "]", -1)
-	closingLongBracketIdx := strings.Index(lex.chunk, closingLongBracket)
-	if closingLongBracketIdx < 0 {
-		lex.error("unfinished long string or comment")
+	for {
+		switch c := lex.peek(0); c {
+		case eoz:
+			what := "comment"
+			if isString {
+				what = "string"
+			}
+			lex.error("unfinished long %s (starting at line %d) near <eof>", what, line)
+		case ']':
+			pos := lex.pos
+			if lex.skipSep() == sep {
+				return string(lex.sbuf)
+			}
+			lex.pos = pos + 1
+			lex.sbuf = append(lex.sbuf, ']')
+		case '\n', '\r':
+			lex.newLine()
+			lex.sbuf = append(lex.sbuf, '\n')
+		default:
+			lex.pos++
+			if isString {
+				lex.sbuf = append(lex.sbuf, byte(c))
+			}
+		}
 	}
+}
 
-	str := lex.chunk[len(openingLongBracket):closingLongBracketIdx]
-	lex.next(closingLongBracketIdx + len(closingLongBracket))
-
-	str = reNewLine.ReplaceAllString(str, "\n")
-	lex.line += strings.Count(str, "\n")
-	if len(str) > 0 && str[0] == '\n' {
-		str = str[1:]
+// scanShortString scans a quoted string. Its escape sequences are decoded
+// into sbuf, which holds the string read so far, quote included, for the
+// error messages - read_string.
+func (lex *Lexer) scanShortString() string {
+	quote := lex.peek(0)
+	lex.pos++
+	lex.sbuf = append(lex.sbuf[:0], byte(quote))
+	for {
+		c := lex.peek(0)
+		switch c {
+		case quote:
+			lex.pos++
+			return string(lex.sbuf[1:])
+		case eoz:
+			lex.error("unfinished string near <eof>")
+		case '\n', '\r':
+			str := string(lex.sbuf[1:])
+			if !lex.recovering {
+				lex.error("unfinished string near '%s'", lex.sbuf)
+			}
+			// keep the string, so that the statement around it still parses
+			lex.AddError(lex.newError("unfinished string near '%s'", lex.sbuf))
+			return str
+		case '\\':
+			lex.scanEscape()
+		default:
+			lex.pos++
+			lex.sbuf = append(lex.sbuf, byte(c))
+		}
 	}
+}
 
-	return str
+// scanEscape decodes the escape sequence at the scanner into sbuf.
+func (lex *Lexer) scanEscape() {
+	lex.pos++
+	lex.sbuf = append(lex.sbuf, '\\') // for the error messages
+	esc := lex.sbuf[:len(lex.sbuf)-1]
+	c := lex.peek(0)
+	switch c {
+	case 'a':
+		c = '\a'
+	case 'b':
+		c = '\b'
+	case 'f':
+		c = '\f'
+	case 'n':
+		c = '\n'
+	case 'r':
+		c = '\r'
+	case 't':
+		c = '\t'
+	case 'v':
+		c = '\v'
+	case '\\', '"', '\'':
+	case '\n', '\r':
+		lex.newLine()
+		lex.sbuf = append(esc, '\n')
+		return
+	case eoz:
+		return // unfinished string
+	case 'x':
+		lex.saveNext()
+		d := lex.hexDigit()<<4 | lex.hexDigit()
+		lex.sbuf = append(esc, byte(d))
+		return
+	case 'u':
+		lex.utf8Escape(esc)
+		return
+	case 'z':
+		lex.pos++
+		lex.sbuf = esc
+		for c := lex.peek(0); c != eoz && isWhiteSpace(byte(c)); c = lex.peek(0) {
+			if isNewLine(byte(c)) {
+				lex.newLine()
+			} else {
+				lex.pos++
+			}
+		}
+		return
+	default:
+		lex.escCheck(isDigit(byte(c)), "invalid escape sequence")
+		d := 0
+		for i := 0; i < 3; i++ {
+			c := lex.peek(0)
+			if c == eoz || !isDigit(byte(c)) {
+				break
+			}
+			d = d*10 + c - '0'
+			lex.saveNext()
+		}
+		lex.escCheck(d <= 0xFF, "decimal escape too large")
+		lex.sbuf = append(esc, byte(d))
+		return
+	}
+	lex.pos++
+	lex.sbuf = append(esc, byte(c))
 }
 
-func (lex *Lexer) scanShortString() string {
-	if str := reShortStr.FindString(lex.chunk); str != "" {
-		lex.next(len(str))
-		str = str[1 : len(str)-1]
-		if strings.Index(str, `\`) >= 0 {
-			lex.line += len(reNewLine.FindAllString(str, -1))
-			str = lex.escape(str)
+// utf8Escape decodes \u{XXX}, the scanner being on the 'u'.
+func (lex *Lexer) utf8Escape(esc []byte) {
+	max := 0x10FFFF
+	if lex.version >= api.LUA_VERSION_54 {
+		max = 0x7FFFFFFF
+	}
+	lex.saveNext()
+	lex.escCheck(lex.peek(0) == '{', "missing '{'")
+	lex.saveNext()
+	r := lex.hexDigit()
+	for {
+		c := lex.peek(0)
+		if c == eoz || !isHexDigit(byte(c)) {
+			break
 		}
-		return str
+		r = r<<4 | hexValue(byte(c))
+		lex.escCheck(r <= max, "UTF-8 value too large")
+		lex.saveNext()
 	}
-	lex.error("unfinished string")
-	return ""
+	lex.escCheck(lex.peek(0) == '}', "missing '}'")
+	lex.pos++
+	lex.sbuf = appendUTF8(esc, uint32(r))
 }
 
-func (lex *Lexer) escape(str string) string {
-	var buf bytes.Buffer
+// hexDigit reads a hexadecimal digit of an escape sequence.
+func (lex *Lexer) hexDigit() int {
+	c := lex.peek(0)
+	lex.escCheck(c != eoz && isHexDigit(byte(c)), "hexadecimal digit expected")
+	lex.saveNext()
+	return hexValue(byte(c))
+}
 
-	for len(str) > 0 {
-		if str[0] != '\\' {
-			buf.WriteByte(str[0])
-			str = str[1:]
-			continue
-		}
+func (lex *Lexer) saveNext() {
+	lex.sbuf = append(lex.sbuf, byte(lex.peek(0)))
+	lex.pos++
+}
 
-		if len(str) == 1 {
-			lex.error("unfinished string")
-		}
+// escCheck raises the error msg near the string read so far and the
+// current byte, unless ok.
+func (lex *Lexer) escCheck(ok bool, msg string) {
+	if ok {
+		return
+	}
+	if lex.peek(0) != eoz {
+		lex.saveNext()
+	}
+	lex.error("%s near '%s'", msg, lex.sbuf)
+}
 
-		switch str[1] {
-		case 'a':
-			buf.WriteByte('\a')
-			str = str[2:]
-			continue
-		case 'b':
-			buf.WriteByte('\b')
-			str = str[2:]
-			continue
-		case 'f':
-			buf.WriteByte('\f')
-			str = str[2:]
-			continue
-		case 'n', '\n':
-			buf.WriteByte('\n')
-			str = str[2:]
-			continue
-		case 'r':
-			buf.WriteByte('\r')
-			str = str[2:]
-			continue
-		case 't':
-			buf.WriteByte('\t')
-			str = str[2:]
-			continue
-		case 'v':
-			buf.WriteByte('\v')
-			str = str[2:]
-			continue
-		case '"':
-			buf.WriteByte('"')
-			str = str[2:]
-			continue
-		case '\'':
-			buf.WriteByte('\'')
-			str = str[2:]
-			continue
-		case '\\':
-			buf.WriteByte('\\')
-			str = str[2:]
-			continue
-		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': // \ddd
-			if found := reDecEscapeSeq.FindString(str); found != "" {
-				d, _ := strconv.ParseInt(found[1:], 10, 32)
-				if d <= 0xFF {
-					buf.WriteByte(byte(d))
-					str = str[len(found):]
-					continue
-				}
-				lex.error("decimal escape too large near '%s'", found)
-			}
-		case 'x': // \xXX
-			if found := reHexEscapeSeq.FindString(str); found != "" {
-				d, _ := strconv.ParseInt(found[2:], 16, 32)
-				buf.WriteByte(byte(d))
-				str = str[len(found):]
-				continue
-			}
-		case 'u': // \u{XXX}
-			if found := reUnicodeEscapeSeq.FindString(str); found != "" {
-				d, err := strconv.ParseInt(found[3:len(found)-1], 16, 32)
-				if err == nil && d <= 0x10FFFF {
-					buf.WriteRune(rune(d))
-					str = str[len(found):]
-					continue
-				}
-				lex.error("UTF-8 value too large near '%s'", found)
-			}
-		case 'z':
-			str = str[2:]
-			for len(str) > 0 && isWhiteSpace(str[0]) { // todo
-				str = str[1:]
-			}
-			continue
+// appendUTF8 appends the UTF-8 sequence of x, surrogates and values up to
+// 0x7FFFFFFF (6 bytes) included - luaO_utf8esc
+func appendUTF8(buf []byte, x uint32) []byte {
+	if x < 0x80 {
+		return append(buf, byte(x))
+	}
+	var seq [6]byte
+	n := len(seq)
+	mfb := uint32(0x3f) // maximum that fits in first byte
+	for {
+		n--
+		seq[n] = byte(0x80 | x&0x3f)
+		x >>= 6
+		mfb >>= 1
+		if x <= mfb {
+			break
 		}
-		lex.error("invalid escape sequence near '\\%c'", str[1])
 	}
-
-	return buf.String()
+	n--
+	seq[n] = byte(^mfb<<1 | x)
+	return append(buf, seq[n:]...)
 }
 
 func isWhiteSpace(c byte) bool {
@@ -431,6 +787,17 @@ func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
 
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	if isDigit(c) {
+		return int(c - '0')
+	}
+	return int(c|0x20-'a') + 10
+}
+
 func isLetter(c byte) bool {
 	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
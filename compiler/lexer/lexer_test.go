@@ -0,0 +1,67 @@
+package lexer_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/iglev/glua/compiler"
+	"github.com/iglev/glua/compiler/lexer"
+)
+
+// TestLexerErrors malformed tokens and reading from an io.Reader
+func TestLexerErrors(t *testing.T) {
+	for _, c := range []struct{ src, err string }{
+		{"x = 3x", "t:1: malformed number near '3x'"},
+		{"x = 0x1p", "t:1: malformed number near '0x1p'"},
+		{"x = 1..2", "t:1: malformed number near '1..2'"},
+		{"x = 'abc", "t:1: unfinished string near <eof>"},
+		{"x = \"a\\n\\q\"", "t:1: invalid escape sequence near '\"a\n\\q'"},
+		{"x = '\\300'", "t:1: decimal escape too large near ''\\300''"},
+		{"x = '\\xg'", "t:1: hexadecimal digit expected near ''\\xg'"},
+		{"x = '\\u{110000}'", "t:1: UTF-8 value too large near ''\\u{110000'"},
+		{"x = [==[\nabc]=]", "t:2: unfinished long string (starting at line 1) near <eof>"},
+		{"x = [=a", "t:1: invalid long string delimiter near '[='"},
+		{"x = caf\xc3\xa9", "t:1: unexpected symbol near '<\\195>'"},
+		{"x = 1 @", "t:1: unexpected symbol near '@'"},
+	} {
+		_, err := compiler.Compile(c.src, "t")
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%q: %v", c.src, err)
+		}
+	}
+	_, err := compiler.Compile("x = 1\n  y = 'a\\qb'", "t")
+	if se := err.(*lexer.SyntaxError); se.Line != 2 || se.Column != 7 || se.Token != "'a\\q" {
+		t.Errorf("%#v", se)
+	}
+
+	src := "#!/usr/bin/env lua\nlocal s = 'a\\z\n  b\\65\\x43\\u{20AC}' --[[\n]] return [[\nx]], 0x10, 1e2, .5, s"
+	var kinds []string
+	lex := lexer.NewReaderLexer(iotest.OneByteReader(strings.NewReader(src)), "t")
+	for {
+		line, kind, token := lex.NextToken()
+		if kind == lexer.TOKEN_EOF {
+			break
+		}
+		kinds = append(kinds, fmt.Sprintf("%d:%s", line, token))
+	}
+	want := "2:local 2:s 2:= 3:abAC\u20ac 4:return 5:x 5:, 5:0x10 5:, 5:1e2 5:, 5:.5 5:, 5:s"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Errorf("got %s", got)
+	}
+	if line, column := lex.Position(len(src) - 1); line != 5 || column != 21 {
+		t.Errorf("%d:%d", line, column)
+	}
+	lex = lexer.NewReaderLexer(iotest.TimeoutReader(strings.NewReader(strings.Repeat("x ", 5000))), "t")
+	func() {
+		defer func() {
+			if _, ok := recover().(*lexer.ReadError); !ok {
+				t.Error("no read error")
+			}
+		}()
+		for {
+			lex.NextToken()
+		}
+	}()
+}
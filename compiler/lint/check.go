@@ -0,0 +1,502 @@
+package lint
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/iglev/glua/compiler/ast"
+)
+
+type linter struct {
+	cfg        *Config
+	source     string
+	std        *stdlib
+	allowed    map[string]bool // globals allowed by the Config
+	setGlobals map[string]bool // globals the chunk assigns
+	globalRefs []*ast.NameExp  // global reads, checked once all assignments are known
+	fi         *funcInfo
+	scopeEnd   ast.Pos     // end of the scope of the variables being declared
+	res        *Resolution // the resolution being built, or nil
+	diags      []*Diagnostic
+}
+
+func newLinter(cfg *Config, chunkName string) *linter {
+	l := &linter{
+		cfg:        cfg,
+		source:     chunkName,
+		std:        newStdlib(cfg.version()),
+		allowed:    map[string]bool{},
+		setGlobals: map[string]bool{},
+	}
+	for _, name := range cfg.Globals {
+		l.allowed[name] = true
+	}
+	return l
+}
+
+func (l *linter) report(check string, node ast.Node, f string, a ...interface{}) {
+	if l.cfg.Disabled[check] {
+		return
+	}
+	start, end := node.Pos(), node.End()
+	l.diags = append(l.diags, &Diagnostic{
+		Source:    l.source,
+		Line:      start.Line,
+		Column:    start.Column,
+		EndLine:   end.Line,
+		EndColumn: end.Column,
+		Check:     check,
+		Message:   fmt.Sprintf(f, a...),
+	})
+}
+
+func (l *linter) checkChunk(block *ast.Block) {
+	l.fi = newFuncInfo(nil)
+	l.scopeEnd = ast.Pos{Line: math.MaxInt32}
+	l.checkFuncBody(block)
+	for _, name := range l.globalRefs {
+		if !l.isKnownGlobal(name.Name) && !l.setGlobals[name.Name] {
+			l.report(UndefinedGlobal, name, "undefined global '%s'", name.Name)
+		}
+	}
+}
+
+func (l *linter) isKnownGlobal(name string) bool {
+	return l.std.globals[name] || l.allowed[name]
+}
+
+// hasLocalEnv tells whether a local _ENV is in scope, the globals then
+// being fields of a table unknown to the linter.
+func (l *linter) hasLocalEnv() bool {
+	return l.fi.resolve("_ENV") != nil
+}
+
+/* functions */
+
+func (l *linter) checkFuncDefExp(fd *ast.FuncDefExp) {
+	l.fi = newFuncInfo(l.fi)
+	scopeEnd := l.scopeEnd
+	l.scopeEnd = fd.End()
+	if len(fd.ParList) == len(fd.Params)+1 { // method with an implicit self
+		l.fi.addLocVar("self", "parameter", nil)
+	}
+	for _, param := range fd.Params {
+		l.declare(param, "parameter", param.End())
+	}
+	l.checkFuncBody(fd.Block)
+	l.scopeEnd = scopeEnd
+	l.fi = l.fi.parent
+}
+
+// checkFuncBody checks the block of the function being checked, and the
+// variables and gotos of the function once it is done.
+func (l *linter) checkFuncBody(block *ast.Block) {
+	l.checkBlockStats(block)
+	for _, g := range l.fi.gotos {
+		l.checkGoto(g)
+	}
+	for _, locVar := range l.fi.locVars {
+		l.checkUsage(locVar)
+	}
+}
+
+func (l *linter) checkUsage(v *locVarInfo) {
+	if v.read || v.decl == nil || strings.HasPrefix(v.name, "_") || v.attrib == "close" {
+		return
+	}
+	switch {
+	case v.setInClosure:
+		l.report(UnusedUpvalue, v.decl, "upvalue '%s' is set but never accessed", v.name)
+	case v.set:
+		l.report(UnusedLocal, v.decl, "%s '%s' is set but never accessed", v.kind, v.name)
+	case v.kind == "parameter":
+		l.report(UnusedParameter, v.decl, "unused parameter '%s'", v.name)
+	default:
+		l.report(UnusedLocal, v.decl, "unused %s '%s'", v.kind, v.name)
+	}
+}
+
+// declare adds a local variable visible from start, reporting the local it
+// shadows.
+func (l *linter) declare(name *ast.NameExp, kind string, start ast.Pos) *locVarInfo {
+	if prev := l.fi.resolve(name.Name); prev != nil && prev.decl != nil &&
+		!strings.HasPrefix(name.Name, "_") {
+		l.report(ShadowedLocal, name, "%s '%s' shadows the %s at line %d",
+			kind, name.Name, prev.kind, prev.decl.Pos().Line)
+	}
+	locVar := l.fi.addLocVar(name.Name, kind, name)
+	if l.res != nil {
+		locVar.v = l.res.addVariable(name, kind, start, l.scopeEnd)
+	}
+	return locVar
+}
+
+// refer records that name refers to locVar, or to a global if locVar is
+// nil.
+func (l *linter) refer(name *ast.NameExp, locVar *locVarInfo) {
+	if l.res == nil {
+		return
+	}
+	if locVar == nil {
+		l.res.Globals = append(l.res.Globals, name)
+	} else if v := locVar.v; v != nil {
+		v.Refs = append(v.Refs, name)
+		l.res.Locals[name] = v
+	}
+}
+
+/* blocks */
+
+// checkBlock checks block in a new scope ending at scopeEnd.
+func (l *linter) checkBlock(block *ast.Block, scopeEnd ast.Pos) {
+	saved := l.scopeEnd
+	l.scopeEnd = scopeEnd
+	l.fi.enterScope()
+	l.checkBlockStats(block)
+	l.fi.exitScope()
+	l.scopeEnd = saved
+}
+
+// checkBlockStats checks the statements of block in the current scope.
+func (l *linter) checkBlockStats(block *ast.Block) {
+	fi := l.fi
+	fi.blocks = append(fi.blocks, blockInfo{block: block})
+	dead, reported := false, false
+	for i, stat := range block.Stats {
+		fi.blocks[len(fi.blocks)-1].index = i
+		switch stat.(type) {
+		case *ast.LabelStat:
+			dead, reported = false, false // reachable by a goto
+		case *ast.EmptyStat:
+		default:
+			if dead && !reported {
+				l.report(Unreachable, stat, "unreachable code")
+				reported = true
+			}
+		}
+		l.checkStat(stat)
+		if terminates(stat) {
+			dead = true
+		}
+	}
+	if dead && !reported && len(block.RetExps) > 0 {
+		l.report(Unreachable, block.RetExps[0], "unreachable code")
+	}
+	l.checkExps(block.RetExps)
+	fi.blocks = fi.blocks[:len(fi.blocks)-1]
+}
+
+// terminates tells whether the statements after stat in its block are
+// unreachable, unless by a goto.
+func terminates(stat ast.Stat) bool {
+	switch stat := stat.(type) {
+	case *ast.BreakStat, *ast.GotoStat:
+		return true
+	case *ast.DoStat:
+		return blockTerminates(stat.Block)
+	case *ast.IfStat:
+		last := stat.Exps[len(stat.Exps)-1]
+		if _, ok := last.(*ast.TrueExp); !ok {
+			return false // no else
+		}
+		for _, block := range stat.Blocks {
+			if !blockTerminates(block) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// blockTerminates tells whether the end of block is unreachable.
+func blockTerminates(block *ast.Block) bool {
+	dead := false
+	for _, stat := range block.Stats {
+		if _, ok := stat.(*ast.LabelStat); ok {
+			dead = false
+		} else if terminates(stat) {
+			dead = true
+		}
+	}
+	return dead || block.RetExps != nil
+}
+
+/* gotos */
+
+// checkGoto reports a goto jumping forward into the scope of a local of
+// the block of its label.
+func (l *linter) checkGoto(g gotoInfo) {
+	for i := len(g.blocks) - 1; i >= 0; i-- {
+		b := g.blocks[i]
+		stats := b.block.Stats
+		for j, stat := range stats {
+			label, ok := stat.(*ast.LabelStat)
+			if !ok || label.Name != g.stat.Name {
+				continue
+			}
+			if j <= b.index || atBlockEnd(b.block, j) {
+				return
+			}
+			for _, stat := range stats[b.index+1 : j] {
+				if name := declaredLocal(stat); name != "" {
+					l.report(GotoIntoScope, g.stat,
+						"<goto %s> jumps into the scope of local '%s'", label.Name, name)
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+// atBlockEnd tells whether only void statements follow the statement at
+// index i of block, a label there being out of the scope of its locals.
+func atBlockEnd(block *ast.Block, i int) bool {
+	if block.RetExps != nil {
+		return false
+	}
+	for _, stat := range block.Stats[i+1:] {
+		switch stat.(type) {
+		case *ast.LabelStat, *ast.EmptyStat:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// declaredLocal returns the name of the first local stat declares, "" if
+// none.
+func declaredLocal(stat ast.Stat) string {
+	switch stat := stat.(type) {
+	case *ast.LocalVarDeclStat:
+		return stat.NameList[0]
+	case *ast.LocalFuncDefStat:
+		return stat.Name
+	}
+	return ""
+}
+
+/* statements */
+
+func (l *linter) checkStat(stat ast.Stat) {
+	switch stat := stat.(type) {
+	case *ast.FuncCallStat:
+		l.checkFuncCallExp(stat)
+	case *ast.GotoStat:
+		blocks := make([]blockInfo, len(l.fi.blocks))
+		copy(blocks, l.fi.blocks)
+		l.fi.gotos = append(l.fi.gotos, gotoInfo{stat, blocks})
+	case *ast.DoStat:
+		l.checkBlock(stat.Block, stat.End())
+	case *ast.WhileStat:
+		l.checkExp(stat.Exp)
+		l.checkBlock(stat.Block, stat.End())
+	case *ast.RepeatStat:
+		saved := l.scopeEnd
+		l.scopeEnd = stat.End()
+		l.fi.enterScope()
+		l.checkBlockStats(stat.Block)
+		l.checkExp(stat.Exp)
+		l.fi.exitScope()
+		l.scopeEnd = saved
+	case *ast.IfStat:
+		for i, exp := range stat.Exps {
+			l.checkExp(exp)
+			end := stat.End()
+			if i < len(stat.Exps)-1 {
+				end = stat.Exps[i+1].Pos()
+			}
+			l.checkBlock(stat.Blocks[i], end)
+		}
+	case *ast.ForNumStat:
+		l.checkExp(stat.InitExp)
+		l.checkExp(stat.LimitExp)
+		l.checkExp(stat.StepExp)
+		saved := l.scopeEnd
+		l.scopeEnd = stat.End()
+		l.fi.enterScope()
+		l.declare(stat.Var, "loop variable", stat.Block.Pos())
+		l.checkBlockStats(stat.Block)
+		l.fi.exitScope()
+		l.scopeEnd = saved
+	case *ast.ForInStat:
+		l.checkExps(stat.ExpList)
+		saved := l.scopeEnd
+		l.scopeEnd = stat.End()
+		l.fi.enterScope()
+		for _, name := range stat.Names {
+			l.declare(name, "loop variable", stat.Block.Pos())
+		}
+		l.checkBlockStats(stat.Block)
+		l.fi.exitScope()
+		l.scopeEnd = saved
+	case *ast.LocalVarDeclStat:
+		l.checkExps(stat.ExpList)
+		for i, name := range stat.Names {
+			locVar := l.declare(name, "local", stat.End())
+			if i < len(stat.AttribList) {
+				locVar.attrib = stat.AttribList[i]
+			}
+		}
+	case *ast.LocalFuncDefStat:
+		l.declare(stat.NameExp, "function", stat.NameExp.End())
+		l.checkFuncDefExp(stat.Exp)
+	case *ast.AssignStat:
+		l.checkExps(stat.ExpList)
+		for _, exp := range stat.VarList {
+			l.checkAssignment(exp)
+		}
+	}
+}
+
+func (l *linter) checkAssignment(exp ast.Exp) {
+	switch exp := exp.(type) {
+	case *ast.NameExp:
+		locVar := l.fi.resolve(exp.Name)
+		l.refer(exp, locVar)
+		if locVar != nil {
+			locVar.set = true
+			if locVar.fi != l.fi {
+				locVar.setInClosure = true
+			}
+		} else if !l.hasLocalEnv() {
+			if l.std.globals[exp.Name] {
+				l.report(ReadOnlyGlobal, exp, "setting read-only global '%s'", exp.Name)
+			} else if !l.allowed[exp.Name] {
+				l.report(UndefinedGlobal, exp, "setting undefined global '%s'", exp.Name)
+			}
+			l.setGlobals[exp.Name] = true
+		}
+	case *ast.TableAccessExp:
+		if lib := l.stdLibrary(exp.PrefixExp); lib != "" {
+			if key, ok := exp.KeyExp.(*ast.StringExp); ok {
+				l.report(ReadOnlyGlobal, exp, "setting read-only field '%s' of global '%s'", key.Str, lib)
+			} else {
+				l.report(ReadOnlyGlobal, exp, "setting a read-only field of global '%s'", lib)
+			}
+		}
+		l.checkExp(exp.PrefixExp)
+		l.checkExp(exp.KeyExp)
+	default:
+		l.checkExp(exp)
+	}
+}
+
+// stdLibrary returns the name of the standard library exp is the global
+// of, "" if none.
+func (l *linter) stdLibrary(exp ast.Exp) string {
+	if name, ok := exp.(*ast.NameExp); ok && libraries[name.Name] && l.std.globals[name.Name] &&
+		l.fi.resolve(name.Name) == nil && !l.hasLocalEnv() {
+		return name.Name
+	}
+	return ""
+}
+
+/* expressions */
+
+func (l *linter) checkExps(exps []ast.Exp) {
+	for _, exp := range exps {
+		l.checkExp(exp)
+	}
+}
+
+func (l *linter) checkExp(exp ast.Exp) {
+	switch exp := exp.(type) {
+	case *ast.NameExp:
+		locVar := l.fi.resolve(exp.Name)
+		l.refer(exp, locVar)
+		if locVar != nil {
+			locVar.read = true
+		} else if !l.hasLocalEnv() {
+			l.globalRefs = append(l.globalRefs, exp)
+		}
+	case *ast.ParensExp:
+		l.checkExp(exp.Exp)
+	case *ast.UnopExp:
+		l.checkExp(exp.Exp)
+	case *ast.BinopExp:
+		l.checkExp(exp.Exp1)
+		l.checkExp(exp.Exp2)
+	case *ast.ConcatExp:
+		l.checkExps(exp.Exps)
+	case *ast.TableConstructorExp:
+		for i, val := range exp.ValExps {
+			if key := exp.KeyExps[i]; key != nil {
+				l.checkExp(key)
+			}
+			l.checkExp(val)
+		}
+	case *ast.FuncDefExp:
+		l.checkFuncDefExp(exp)
+	case *ast.TableAccessExp:
+		l.checkExp(exp.PrefixExp)
+		l.checkExp(exp.KeyExp)
+	case *ast.FuncCallExp:
+		l.checkFuncCallExp(exp)
+	}
+}
+
+func (l *linter) checkFuncCallExp(exp *ast.FuncCallExp) {
+	if exp.NameExp == nil {
+		if name := l.stdFuncName(exp.PrefixExp); name != "" {
+			l.checkArgCount(exp, name, l.std.funcs[name])
+		}
+	}
+	l.checkExp(exp.PrefixExp)
+	l.checkExps(exp.Args)
+}
+
+// stdFuncName returns the name of the standard function exp is, "" if
+// none.
+func (l *linter) stdFuncName(exp ast.Exp) string {
+	name := ""
+	switch exp := exp.(type) {
+	case *ast.NameExp:
+		if l.fi.resolve(exp.Name) != nil || l.hasLocalEnv() {
+			return ""
+		}
+		name = exp.Name
+	case *ast.TableAccessExp:
+		key, ok := exp.KeyExp.(*ast.StringExp)
+		lib := l.stdLibrary(exp.PrefixExp)
+		if !ok || lib == "" {
+			return ""
+		}
+		name = lib + "." + key.Str
+	}
+	if _, found := l.std.funcs[name]; !found {
+		return ""
+	}
+	return name
+}
+
+func (l *linter) checkArgCount(exp *ast.FuncCallExp, name string, count argCount) {
+	n, multi := len(exp.Args), false
+	if n > 0 {
+		switch exp.Args[n-1].(type) {
+		case *ast.FuncCallExp, *ast.VarargExp:
+			n, multi = n-1, true // the last argument may be no value or several
+		}
+	}
+	if (n >= count.min || multi) && (count.max < 0 || n <= count.max) {
+		return
+	}
+	expected, last := fmt.Sprintf("%d to %d", count.min, count.max), count.max
+	if count.max < 0 {
+		expected, last = fmt.Sprintf("at least %d", count.min), count.min
+	} else if count.min == count.max {
+		expected = fmt.Sprint(count.min)
+	}
+	s := "s"
+	if last == 1 {
+		s = ""
+	}
+	got := fmt.Sprint(n)
+	if multi {
+		got = "at least " + got
+	}
+	l.report(ArgumentCount, exp, "'%s' expects %s argument%s, got %s", name, expected, s, got)
+}
@@ -0,0 +1,102 @@
+// Package lint checks Lua chunks for likely mistakes: undefined globals,
+// unused variables, shadowed locals, unreachable code, assignments to the
+// standard library, wrong argument counts to standard functions and gotos
+// into the scope of a local.
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/compiler/ast"
+	"github.com/iglev/glua/compiler/lexer"
+	"github.com/iglev/glua/compiler/parser"
+)
+
+// names of the checks
+const (
+	UndefinedGlobal = "undefined-global"
+	UnusedLocal     = "unused-local"
+	UnusedParameter = "unused-parameter"
+	UnusedUpvalue   = "unused-upvalue"
+	ShadowedLocal   = "shadowed-local"
+	Unreachable     = "unreachable-code"
+	ReadOnlyGlobal  = "read-only-global"
+	ArgumentCount   = "argument-count"
+	GotoIntoScope   = "goto-into-scope"
+	Syntax          = "syntax" // the syntax errors reported by CheckSource
+)
+
+// Diagnostic is a problem found by a check.
+type Diagnostic struct {
+	Source    string `json:"source"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+	Check     string `json:"check"`
+	Message   string `json:"message"`
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", d.Source, d.Line, d.Column, d.Message, d.Check)
+}
+
+// Config configures the checks. The zero Config checks Lua 5.3 chunks
+// with no globals but the standard ones.
+type Config struct {
+	Globals  []string        // globals defined by the host, allowed besides the standard ones
+	Version  int             // language version, api.LUA_VERSION_53 if 0
+	Disabled map[string]bool // names of the checks not to run
+}
+
+func (cfg *Config) version() int {
+	if cfg.Version == 0 {
+		return api.LUA_VERSION_53
+	}
+	return cfg.Version
+}
+
+// Check checks the chunk parsed as block and returns the diagnostics
+// sorted by position.
+func (cfg *Config) Check(block *ast.Block, chunkName string) []*Diagnostic {
+	l := newLinter(cfg, chunkName)
+	l.checkChunk(block)
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		a, b := l.diags[i], l.diags[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.diags
+}
+
+// CheckSource parses and checks src. If src has syntax errors, it is not
+// checked: CheckSource returns a Syntax diagnostic for each error and the
+// error, a lexer.ErrorList.
+func (cfg *Config) CheckSource(src []byte, chunkName string) ([]*Diagnostic, error) {
+	block, err := parser.ParseMode(string(src), chunkName, cfg.version(), parser.AllErrors)
+	if err != nil {
+		var diags []*Diagnostic
+		for _, e := range err.(lexer.ErrorList) {
+			diags = append(diags, &Diagnostic{
+				Source:    chunkName,
+				Line:      e.Line,
+				Column:    e.Column,
+				EndLine:   e.Line,
+				EndColumn: e.Column + len(e.Token),
+				Check:     Syntax,
+				Message:   e.Msg,
+			})
+		}
+		return diags, err
+	}
+	return cfg.Check(block, chunkName), nil
+}
+
+// CheckSource checks src with the zero Config.
+func CheckSource(src []byte, chunkName string) ([]*Diagnostic, error) {
+	return (&Config{}).CheckSource(src, chunkName)
+}
@@ -0,0 +1,73 @@
+package lint_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/iglev/glua/compiler/lint"
+)
+
+// TestLint the checks and their positions
+func TestLint(t *testing.T) {
+	src := `local unused = 1
+print(undefined, host)
+function helper(a, _b, c) return c end
+local function f(x)
+  local x = x + 1
+  if x then return x else return 0 end
+  print("dead")
+end
+print, math.pi = nil, 3
+print(string.rep("x"), select(), table.insert({}, ...))
+local up
+local function g() up = 1 end
+for i = 1, 3 do g(); break; print(i) end
+do
+  goto skip
+  local y = 1
+  ::skip::
+  print(y, f)
+end
+do goto done; local z = 1; ::done:: end
+`
+	cfg := &lint.Config{Globals: []string{"host"}}
+	diags, err := cfg.CheckSource([]byte(src), "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range diags {
+		got = append(got, fmt.Sprintf("%d:%d %s", d.Line, d.Column, d.Check))
+	}
+	want := []string{
+		"1:7 unused-local",
+		"2:7 undefined-global",
+		"3:10 undefined-global",
+		"3:17 unused-parameter",
+		"5:9 shadowed-local",
+		"7:3 unreachable-code",
+		"9:1 read-only-global",
+		"9:8 read-only-global",
+		"10:7 argument-count",
+		"10:24 argument-count",
+		"11:7 unused-upvalue",
+		"13:29 unreachable-code",
+		"15:3 goto-into-scope",
+		"16:3 unreachable-code",
+		"20:15 unreachable-code",
+		"20:21 unused-local",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s", strings.Join(got, "\n"))
+	}
+	if diags[1].Message != "undefined global 'undefined'" ||
+		diags[8].Message != "'string.rep' expects 2 to 3 arguments, got 1" {
+		t.Errorf("%v\n%v", diags[1], diags[8])
+	}
+
+	diags, err = lint.CheckSource([]byte("x = = 1"), "t")
+	if err == nil || len(diags) != 1 || diags[0].Check != lint.Syntax || diags[0].Column != 5 {
+		t.Errorf("%v %v", diags, err)
+	}
+}
@@ -0,0 +1,67 @@
+package lint
+
+import "github.com/iglev/glua/compiler/ast"
+
+// Variable is a local variable of a chunk.
+type Variable struct {
+	Name string
+	Kind string         // "local", "parameter", "loop variable" or "function"
+	Decl *ast.NameExp   // the name declaring the variable
+	Refs []*ast.NameExp // the names referring to the variable
+
+	// the variable is visible from ScopeStart to ScopeEnd
+	ScopeStart ast.Pos
+	ScopeEnd   ast.Pos
+}
+
+// Resolution tells what the names of a chunk refer to.
+type Resolution struct {
+	Variables []*Variable                // in declaration order
+	Locals    map[*ast.NameExp]*Variable // the declarations and references of the Variables
+	Globals   []*ast.NameExp             // the names referring to globals
+}
+
+// Resolve resolves the names of the chunk parsed as block. The implicit
+// self parameters of the methods are not resolved.
+func Resolve(block *ast.Block) *Resolution {
+	l := newLinter(&Config{}, "")
+	l.res = &Resolution{Locals: map[*ast.NameExp]*Variable{}}
+	l.checkChunk(block)
+	return l.res
+}
+
+func (res *Resolution) addVariable(name *ast.NameExp, kind string, start, end ast.Pos) *Variable {
+	v := &Variable{
+		Name:       name.Name,
+		Kind:       kind,
+		Decl:       name,
+		ScopeStart: start,
+		ScopeEnd:   end,
+	}
+	res.Variables = append(res.Variables, v)
+	res.Locals[name] = v
+	return v
+}
+
+// VisibleAt returns the variables visible at pos, without the ones they
+// shadow.
+func (res *Resolution) VisibleAt(pos ast.Pos) []*Variable {
+	var visible []*Variable
+	index := map[string]int{}
+	for _, v := range res.Variables {
+		if before(pos, v.ScopeStart) || before(v.ScopeEnd, pos) {
+			continue
+		}
+		if i, found := index[v.Name]; found {
+			visible[i] = v
+		} else {
+			index[v.Name] = len(visible)
+			visible = append(visible, v)
+		}
+	}
+	return visible
+}
+
+func before(a, b ast.Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
@@ -0,0 +1,97 @@
+package lint
+
+import "github.com/iglev/glua/compiler/ast"
+
+type locVarInfo struct {
+	prev         *locVarInfo
+	name         string
+	kind         string // "local", "parameter", "loop variable" or "function"
+	scopeLv      int
+	fi           *funcInfo
+	decl         *ast.NameExp // nil for an implicit self
+	attrib       string       // "const", "close" or ""
+	read         bool
+	set          bool      // assigned after its declaration
+	setInClosure bool      // assigned by a nested function
+	v            *Variable // the variable resolved, or nil
+}
+
+// blockInfo is a block being checked, and the index of its statement being
+// checked.
+type blockInfo struct {
+	block *ast.Block
+	index int
+}
+
+type gotoInfo struct {
+	stat   *ast.GotoStat
+	blocks []blockInfo // the blocks enclosing the goto
+}
+
+type funcInfo struct {
+	parent   *funcInfo
+	scopeLv  int
+	locVars  []*locVarInfo
+	locNames map[string]*locVarInfo
+	blocks   []blockInfo
+	gotos    []gotoInfo
+}
+
+func newFuncInfo(parent *funcInfo) *funcInfo {
+	return &funcInfo{
+		parent:   parent,
+		locNames: map[string]*locVarInfo{},
+	}
+}
+
+/* lexical scope */
+
+func (fi *funcInfo) enterScope() {
+	fi.scopeLv++
+}
+
+func (fi *funcInfo) exitScope() {
+	fi.scopeLv--
+	for _, locVar := range fi.locNames {
+		if locVar.scopeLv > fi.scopeLv { // out of scope
+			fi.removeLocVar(locVar)
+		}
+	}
+}
+
+func (fi *funcInfo) removeLocVar(locVar *locVarInfo) {
+	if locVar.prev == nil {
+		delete(fi.locNames, locVar.name)
+	} else if locVar.prev.scopeLv == locVar.scopeLv {
+		fi.removeLocVar(locVar.prev)
+	} else {
+		fi.locNames[locVar.name] = locVar.prev
+	}
+}
+
+func (fi *funcInfo) addLocVar(name, kind string, decl *ast.NameExp) *locVarInfo {
+	newVar := &locVarInfo{
+		name:    name,
+		kind:    kind,
+		prev:    fi.locNames[name],
+		scopeLv: fi.scopeLv,
+		fi:      fi,
+		decl:    decl,
+	}
+
+	fi.locVars = append(fi.locVars, newVar)
+	fi.locNames[name] = newVar
+
+	return newVar
+}
+
+// resolve returns the local variable of fi or of an enclosing function
+// named name, nil for a global.
+func (fi *funcInfo) resolve(name string) *locVarInfo {
+	for f := fi; f != nil; f = f.parent {
+		if locVar, found := f.locNames[name]; found {
+			return locVar
+		}
+	}
+	return nil
+}
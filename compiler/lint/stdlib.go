@@ -0,0 +1,228 @@
+package lint
+
+import (
+	"strings"
+
+	"github.com/iglev/glua/api"
+)
+
+// libraries are the standard globals holding tables of functions, their
+// fields are read-only.
+var libraries = map[string]bool{
+	"bit": true, "bit32": true, "coroutine": true, "io": true, "math": true,
+	"os": true, "string": true, "table": true, "utf8": true,
+}
+
+// stdValues are the standard globals and fields which are not functions,
+// with the versions having them.
+var stdValues = []struct {
+	name    string
+	version int // api.LUA_VERSION_51 or api.LUA_VERSION_54 if only in it, 0 in all
+}{
+	{"_G", 0}, {"_VERSION", 0},
+	{"bit", api.LUA_VERSION_51}, {"bit32", api.LUA_VERSION_51},
+	{"coroutine", 0}, {"io", 0}, {"math", 0}, {"os", 0}, {"package", 0},
+	{"string", 0}, {"table", 0}, {"utf8", 0},
+	{"io.stdin", 0}, {"io.stdout", 0}, {"io.stderr", 0},
+	{"math.huge", 0}, {"math.maxinteger", 0}, {"math.mininteger", 0}, {"math.pi", 0},
+	{"package.config", 0}, {"package.cpath", 0}, {"package.loaded", 0},
+	{"package.path", 0}, {"package.preload", 0}, {"package.searchers", 0},
+	{"utf8.charpattern", 0},
+}
+
+// stdFuncs are the standard functions with the number of arguments they
+// take.
+var stdFuncs = []struct {
+	name     string
+	min, max int // max is -1 for the variadic functions
+	version  int // api.LUA_VERSION_51 or api.LUA_VERSION_54 if only in it, 0 in all
+}{
+	{"assert", 1, -1, 0},
+	{"collectgarbage", 0, 2, 0},
+	{"dofile", 0, 1, 0},
+	{"error", 0, 2, 0},
+	{"getmetatable", 1, 1, 0},
+	{"ipairs", 1, 1, 0},
+	{"load", 1, 4, 0},
+	{"loadfile", 0, 3, 0},
+	{"next", 1, 2, 0},
+	{"pairs", 1, 1, 0},
+	{"pcall", 1, -1, 0},
+	{"print", 0, -1, 0},
+	{"rawequal", 2, 2, 0},
+	{"rawget", 2, 2, 0},
+	{"rawlen", 1, 1, 0},
+	{"rawset", 3, 3, 0},
+	{"require", 1, 1, 0},
+	{"select", 1, -1, 0},
+	{"setmetatable", 2, 2, 0},
+	{"tonumber", 1, 2, 0},
+	{"tostring", 1, 1, 0},
+	{"type", 1, 1, 0},
+	{"xpcall", 2, -1, 0},
+	{"warn", 1, -1, api.LUA_VERSION_54},
+	{"getfenv", 0, 1, api.LUA_VERSION_51},
+	{"loadstring", 1, 2, api.LUA_VERSION_51},
+	{"module", 1, -1, api.LUA_VERSION_51},
+	{"setfenv", 2, 2, api.LUA_VERSION_51},
+	{"unpack", 1, 3, api.LUA_VERSION_51},
+
+	{"bit.arshift", 2, 2, api.LUA_VERSION_51},
+	{"bit.band", 1, -1, api.LUA_VERSION_51},
+	{"bit.bnot", 1, 1, api.LUA_VERSION_51},
+	{"bit.bor", 1, -1, api.LUA_VERSION_51},
+	{"bit.bswap", 1, 1, api.LUA_VERSION_51},
+	{"bit.bxor", 1, -1, api.LUA_VERSION_51},
+	{"bit.lshift", 2, 2, api.LUA_VERSION_51},
+	{"bit.rol", 2, 2, api.LUA_VERSION_51},
+	{"bit.ror", 2, 2, api.LUA_VERSION_51},
+	{"bit.rshift", 2, 2, api.LUA_VERSION_51},
+	{"bit.tobit", 1, 1, api.LUA_VERSION_51},
+	{"bit.tohex", 1, 2, api.LUA_VERSION_51},
+
+	{"bit32.arshift", 2, 2, api.LUA_VERSION_51},
+	{"bit32.band", 0, -1, api.LUA_VERSION_51},
+	{"bit32.bnot", 1, 1, api.LUA_VERSION_51},
+	{"bit32.bor", 0, -1, api.LUA_VERSION_51},
+	{"bit32.btest", 0, -1, api.LUA_VERSION_51},
+	{"bit32.bxor", 0, -1, api.LUA_VERSION_51},
+	{"bit32.extract", 2, 3, api.LUA_VERSION_51},
+	{"bit32.lrotate", 2, 2, api.LUA_VERSION_51},
+	{"bit32.lshift", 2, 2, api.LUA_VERSION_51},
+	{"bit32.replace", 3, 4, api.LUA_VERSION_51},
+	{"bit32.rrotate", 2, 2, api.LUA_VERSION_51},
+	{"bit32.rshift", 2, 2, api.LUA_VERSION_51},
+
+	{"coroutine.close", 1, 1, api.LUA_VERSION_54},
+	{"coroutine.create", 1, 1, 0},
+	{"coroutine.isyieldable", 0, 1, 0},
+	{"coroutine.resume", 1, -1, 0},
+	{"coroutine.running", 0, 0, 0},
+	{"coroutine.status", 1, 1, 0},
+	{"coroutine.wrap", 1, 1, 0},
+	{"coroutine.yield", 0, -1, 0},
+
+	{"io.close", 0, 1, 0},
+	{"io.flush", 0, 0, 0},
+	{"io.input", 0, 1, 0},
+	{"io.lines", 0, -1, 0},
+	{"io.open", 1, 2, 0},
+	{"io.output", 0, 1, 0},
+	{"io.popen", 1, 2, 0},
+	{"io.read", 0, -1, 0},
+	{"io.tmpfile", 0, 0, 0},
+	{"io.type", 1, 1, 0},
+	{"io.write", 0, -1, 0},
+
+	{"math.abs", 1, 1, 0},
+	{"math.acos", 1, 1, 0},
+	{"math.asin", 1, 1, 0},
+	{"math.atan", 1, 2, 0},
+	{"math.ceil", 1, 1, 0},
+	{"math.cos", 1, 1, 0},
+	{"math.deg", 1, 1, 0},
+	{"math.exp", 1, 1, 0},
+	{"math.floor", 1, 1, 0},
+	{"math.fmod", 2, 2, 0},
+	{"math.frexp", 1, 1, api.LUA_VERSION_51},
+	{"math.ldexp", 2, 2, api.LUA_VERSION_51},
+	{"math.log", 1, 2, 0},
+	{"math.max", 1, -1, 0},
+	{"math.min", 1, -1, 0},
+	{"math.modf", 1, 1, 0},
+	{"math.pow", 2, 2, api.LUA_VERSION_51},
+	{"math.rad", 1, 1, 0},
+	{"math.random", 0, 2, 0},
+	{"math.randomseed", 0, 2, 0},
+	{"math.sin", 1, 1, 0},
+	{"math.sqrt", 1, 1, 0},
+	{"math.tan", 1, 1, 0},
+	{"math.tointeger", 1, 1, 0},
+	{"math.type", 1, 1, 0},
+	{"math.ult", 2, 2, 0},
+
+	{"os.clock", 0, 0, 0},
+	{"os.date", 0, 2, 0},
+	{"os.difftime", 1, 2, 0},
+	{"os.execute", 0, 1, 0},
+	{"os.exit", 0, 2, 0},
+	{"os.getenv", 1, 1, 0},
+	{"os.remove", 1, 1, 0},
+	{"os.rename", 2, 2, 0},
+	{"os.setlocale", 0, 2, 0},
+	{"os.time", 0, 1, 0},
+	{"os.tmpname", 0, 0, 0},
+
+	{"package.searchpath", 2, 4, 0},
+	{"package.seeall", 1, 1, api.LUA_VERSION_51},
+
+	{"string.byte", 1, 3, 0},
+	{"string.char", 0, -1, 0},
+	{"string.dump", 1, 2, 0},
+	{"string.find", 2, 4, 0},
+	{"string.format", 1, -1, 0},
+	{"string.gfind", 2, 2, api.LUA_VERSION_51},
+	{"string.gmatch", 2, 3, 0},
+	{"string.gsub", 3, 4, 0},
+	{"string.len", 1, 1, 0},
+	{"string.lower", 1, 1, 0},
+	{"string.match", 2, 3, 0},
+	{"string.pack", 1, -1, 0},
+	{"string.packsize", 1, 1, 0},
+	{"string.rep", 2, 3, 0},
+	{"string.reverse", 1, 1, 0},
+	{"string.sub", 2, 3, 0},
+	{"string.unpack", 2, 3, 0},
+	{"string.upper", 1, 1, 0},
+
+	{"table.concat", 1, 4, 0},
+	{"table.getn", 1, 1, api.LUA_VERSION_51},
+	{"table.insert", 2, 3, 0},
+	{"table.maxn", 1, 1, api.LUA_VERSION_51},
+	{"table.move", 4, 5, 0},
+	{"table.pack", 0, -1, 0},
+	{"table.remove", 1, 2, 0},
+	{"table.sort", 1, 2, 0},
+	{"table.unpack", 1, 3, 0},
+
+	{"utf8.char", 0, -1, 0},
+	{"utf8.codepoint", 1, 4, 0},
+	{"utf8.codes", 1, 2, 0},
+	{"utf8.len", 1, 4, 0},
+	{"utf8.offset", 2, 3, 0},
+}
+
+// argCount is the number of arguments a standard function takes.
+type argCount struct {
+	min, max int
+}
+
+// stdlib describes the standard library of a language version.
+type stdlib struct {
+	globals map[string]bool     // the standard globals
+	funcs   map[string]argCount // the standard functions, as "print" or "string.format"
+}
+
+func newStdlib(version int) *stdlib {
+	std := &stdlib{globals: map[string]bool{}, funcs: map[string]argCount{}}
+	has := func(v int) bool {
+		return v == 0 || v == version || v == api.LUA_VERSION_54 && version > v
+	}
+	add := func(name string) {
+		if !strings.Contains(name, ".") {
+			std.globals[name] = true
+		}
+	}
+	for _, v := range stdValues {
+		if has(v.version) {
+			add(v.name)
+		}
+	}
+	for _, f := range stdFuncs {
+		if has(f.version) {
+			add(f.name)
+			std.funcs[f.name] = argCount{f.min, f.max}
+		}
+	}
+	return std
+}
@@ -8,22 +8,30 @@ import (
 	"github.com/iglev/glua/number"
 )
 
+// optimize returns f(exp) unless the parser keeps the syntax.
+func optimize(lex *lexer.Lexer, exp *ast.BinopExp, f func(*ast.BinopExp) ast.Exp) ast.Exp {
+	if lex.KeepSyntax() {
+		return exp
+	}
+	return f(exp)
+}
+
 func optimizeLogicalOr(exp *ast.BinopExp) ast.Exp {
 	if isTrue(exp.Exp1) {
-		return exp.Exp1 // true or x => true
+		return replace(exp, exp.Exp1) // true or x => true
 	}
 	if isFalse(exp.Exp1) && !isVarargOrFuncCall(exp.Exp2) {
-		return exp.Exp2 // false or x => x
+		return replace(exp, exp.Exp2) // false or x => x
 	}
 	return exp
 }
 
 func optimizeLogicalAnd(exp *ast.BinopExp) ast.Exp {
 	if isFalse(exp.Exp1) {
-		return exp.Exp1 // false and x => false
+		return replace(exp, exp.Exp1) // false and x => false
 	}
 	if isTrue(exp.Exp1) && !isVarargOrFuncCall(exp.Exp2) {
-		return exp.Exp2 // true and x => x
+		return replace(exp, exp.Exp2) // true and x => x
 	}
 	return exp
 }
@@ -33,15 +41,15 @@ func optimizeBitwiseBinaryOp(exp *ast.BinopExp) ast.Exp {
 		if j, ok := castToInt(exp.Exp2); ok {
 			switch exp.Op {
 			case lexer.TOKEN_OP_BAND:
-				return &ast.IntegerExp{exp.Line, i & j}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: i & j}
 			case lexer.TOKEN_OP_BOR:
-				return &ast.IntegerExp{exp.Line, i | j}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: i | j}
 			case lexer.TOKEN_OP_BXOR:
-				return &ast.IntegerExp{exp.Line, i ^ j}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: i ^ j}
 			case lexer.TOKEN_OP_SHL:
-				return &ast.IntegerExp{exp.Line, number.ShiftLeft(i, j)}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: number.ShiftLeft(i, j)}
 			case lexer.TOKEN_OP_SHR:
-				return &ast.IntegerExp{exp.Line, number.ShiftRight(i, j)}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: number.ShiftRight(i, j)}
 			}
 		}
 	}
@@ -53,18 +61,18 @@ func optimizeArithBinaryOp(exp *ast.BinopExp) ast.Exp {
 		if y, ok := exp.Exp2.(*ast.IntegerExp); ok {
 			switch exp.Op {
 			case lexer.TOKEN_OP_ADD:
-				return &ast.IntegerExp{exp.Line, x.Val + y.Val}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x.Val + y.Val}
 			case lexer.TOKEN_OP_SUB:
-				return &ast.IntegerExp{exp.Line, x.Val - y.Val}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x.Val - y.Val}
 			case lexer.TOKEN_OP_MUL:
-				return &ast.IntegerExp{exp.Line, x.Val * y.Val}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x.Val * y.Val}
 			case lexer.TOKEN_OP_IDIV:
 				if y.Val != 0 {
-					return &ast.IntegerExp{exp.Line, number.IFloorDiv(x.Val, y.Val)}
+					return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: number.IFloorDiv(x.Val, y.Val)}
 				}
 			case lexer.TOKEN_OP_MOD:
 				if y.Val != 0 {
-					return &ast.IntegerExp{exp.Line, number.IMod(x.Val, y.Val)}
+					return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: number.IMod(x.Val, y.Val)}
 				}
 			}
 		}
@@ -73,25 +81,25 @@ func optimizeArithBinaryOp(exp *ast.BinopExp) ast.Exp {
 		if g, ok := castToFloat(exp.Exp2); ok {
 			switch exp.Op {
 			case lexer.TOKEN_OP_ADD:
-				return &ast.FloatExp{exp.Line, f + g}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: f + g}
 			case lexer.TOKEN_OP_SUB:
-				return &ast.FloatExp{exp.Line, f - g}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: f - g}
 			case lexer.TOKEN_OP_MUL:
-				return &ast.FloatExp{exp.Line, f * g}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: f * g}
 			case lexer.TOKEN_OP_DIV:
 				if g != 0 {
-					return &ast.FloatExp{exp.Line, f / g}
+					return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: f / g}
 				}
 			case lexer.TOKEN_OP_IDIV:
 				if g != 0 {
-					return &ast.FloatExp{exp.Line, number.FFloorDiv(f, g)}
+					return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: number.FFloorDiv(f, g)}
 				}
 			case lexer.TOKEN_OP_MOD:
 				if g != 0 {
-					return &ast.FloatExp{exp.Line, number.FMod(f, g)}
+					return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: number.FMod(f, g)}
 				}
 			case lexer.TOKEN_OP_POW:
-				return &ast.FloatExp{exp.Line, math.Pow(f, g)}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: math.Pow(f, g)}
 			}
 		}
 	}
@@ -124,12 +132,12 @@ func optimizeUnaryOp(exp *ast.UnopExp) ast.Exp {
 func optimizeUnm(exp *ast.UnopExp) ast.Exp {
 	switch x := exp.Exp.(type) { // number?
 	case *ast.IntegerExp:
-		x.Val = -x.Val
-		return x
+		x.Val, x.Raw = -x.Val, ""
+		return replace(exp, x)
 	case *ast.FloatExp:
 		if x.Val != 0 {
-			x.Val = -x.Val
-			return x
+			x.Val, x.Raw = -x.Val, ""
+			return replace(exp, x)
 		}
 	}
 	return exp
@@ -138,9 +146,9 @@ func optimizeUnm(exp *ast.UnopExp) ast.Exp {
 func optimizeNot(exp *ast.UnopExp) ast.Exp {
 	switch exp.Exp.(type) {
 	case *ast.NilExp, *ast.FalseExp: // false
-		return &ast.TrueExp{exp.Line}
+		return &ast.TrueExp{Span: exp.Span, Line: exp.Line}
 	case *ast.TrueExp, *ast.IntegerExp, *ast.FloatExp, *ast.StringExp: // true
-		return &ast.FalseExp{exp.Line}
+		return &ast.FalseExp{Span: exp.Span, Line: exp.Line}
 	default:
 		return exp
 	}
@@ -149,16 +157,22 @@ func optimizeNot(exp *ast.UnopExp) ast.Exp {
 func optimizeBnot(exp *ast.UnopExp) ast.Exp {
 	switch x := exp.Exp.(type) { // number?
 	case *ast.IntegerExp:
-		x.Val = ^x.Val
-		return x
+		x.Val, x.Raw = ^x.Val, ""
+		return replace(exp, x)
 	case *ast.FloatExp:
 		if i, ok := number.FloatToInteger(x.Val); ok {
-			return &ast.IntegerExp{x.Line, ^i}
+			return &ast.IntegerExp{Span: exp.Span, Line: x.Line, Val: ^i}
 		}
 	}
 	return exp
 }
 
+// replace returns exp in place of the node old, covering its source.
+func replace(old, exp ast.Exp) ast.Exp {
+	exp.(spanner).SetSpan(old.Pos(), old.End())
+	return exp
+}
+
 func isFalse(exp ast.Exp) bool {
 	switch exp.(type) {
 	case *ast.FalseExp, *ast.NilExp:
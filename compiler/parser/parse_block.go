@@ -6,25 +6,64 @@ import (
 )
 
 // block ::= {stat} [retstat]
-func parseBlock(lexer *lexer.Lexer) *ast.Block {
-	return &ast.Block{
-		Stats:    parseStats(lexer),
-		RetExps:  parseRetExps(lexer),
-		LastLine: lexer.Line(),
+func parseBlock(lex *lexer.Lexer) *ast.Block {
+	start := lex.LookAheadStart()
+	block := &ast.Block{Stats: parseStats(lex)}
+	var leading []*ast.Comment
+	if lex.LookAhead() == lexer.TOKEN_KW_RETURN {
+		leading = takeComments(lex, len(lex.Comments()))
 	}
+	block.RetExps = parseRetExps(lex)
+	block.LastLine = lex.Line()
+	setSpan(lex, block, start)
+	if lex.KeepComments() {
+		lex.LookAhead() // skip the comments before the block end
+		trailing := takeComments(lex, len(lex.Comments()))
+		if leading != nil || trailing != nil {
+			block.Trivia = &ast.Comments{Leading: leading, Trailing: trailing}
+		}
+	}
+	return block
 }
 
-func parseStats(lexer *lexer.Lexer) []ast.Stat {
+func parseStats(lex *lexer.Lexer) []ast.Stat {
 	stats := make([]ast.Stat, 0, 8)
-	for !_isReturnOrBlockEnd(lexer.LookAhead()) {
-		stat := parseStat(lexer)
-		if _, ok := stat.(*ast.EmptyStat); !ok {
-			stats = append(stats, stat)
+	for !_isReturnOrBlockEnd(lex.LookAhead()) {
+		leading := takeLeadingComments(lex)
+		var stat ast.Stat
+		tryParse(lex, func() {
+			stat = parseStat(lex)
+		})
+		if stat == nil {
+			continue
 		}
+		if _, ok := stat.(*ast.EmptyStat); ok && leading == nil {
+			// keep the comments after ‘;’ with the statement before it
+			if n := len(stats); n > 0 && lex.KeepComments() {
+				_attachTrailingComments(lex, stats[n-1], stat.End().Line)
+			}
+			continue
+		}
+		attachComments(lex, stat, leading)
+		stats = append(stats, stat)
 	}
 	return stats
 }
 
+// _attachTrailingComments attaches to stat the comments after the ‘;’
+// ending it on line.
+func _attachTrailingComments(lex *lexer.Lexer, stat ast.Stat, line int) {
+	trailing := takeTrailingComments(lex, line)
+	if trailing == nil {
+		return
+	}
+	if comments := stat.Comments(); comments != nil {
+		comments.Trailing = append(comments.Trailing, trailing...)
+	} else {
+		stat.(spanner).SetComments(&ast.Comments{Trailing: trailing})
+	}
+}
+
 func _isReturnOrBlockEnd(tokenKind int) bool {
 	switch tokenKind {
 	case lexer.TOKEN_KW_RETURN, lexer.TOKEN_EOF, lexer.TOKEN_KW_END,
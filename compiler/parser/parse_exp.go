@@ -47,8 +47,8 @@ func parseExp12(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp11(lex)
 	for lex.LookAhead() == lexer.TOKEN_OP_OR {
 		line, op, _ := lex.NextToken()
-		lor := &ast.BinopExp{line, op, exp, parseExp11(lex)}
-		exp = optimizeLogicalOr(lor)
+		lor := _newBinopExp(lex, line, op, exp, parseExp11(lex))
+		exp = optimize(lex, lor, optimizeLogicalOr)
 	}
 	return exp
 }
@@ -58,8 +58,8 @@ func parseExp11(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp10(lex)
 	for lex.LookAhead() == lexer.TOKEN_OP_AND {
 		line, op, _ := lex.NextToken()
-		land := &ast.BinopExp{line, op, exp, parseExp10(lex)}
-		exp = optimizeLogicalAnd(land)
+		land := _newBinopExp(lex, line, op, exp, parseExp10(lex))
+		exp = optimize(lex, land, optimizeLogicalAnd)
 	}
 	return exp
 }
@@ -72,12 +72,11 @@ func parseExp10(lex *lexer.Lexer) ast.Exp {
 		case lexer.TOKEN_OP_LT, lexer.TOKEN_OP_GT, lexer.TOKEN_OP_NE,
 			lexer.TOKEN_OP_LE, lexer.TOKEN_OP_GE, lexer.TOKEN_OP_EQ:
 			line, op, _ := lex.NextToken()
-			exp = &ast.BinopExp{line, op, exp, parseExp9(lex)}
+			exp = _newBinopExp(lex, line, op, exp, parseExp9(lex))
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // x | y
@@ -85,8 +84,8 @@ func parseExp9(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp8(lex)
 	for lex.LookAhead() == lexer.TOKEN_OP_BOR {
 		line, op, _ := lex.NextToken()
-		bor := &ast.BinopExp{line, op, exp, parseExp8(lex)}
-		exp = optimizeBitwiseBinaryOp(bor)
+		bor := _newBinopExp(lex, line, op, exp, parseExp8(lex))
+		exp = optimize(lex, bor, optimizeBitwiseBinaryOp)
 	}
 	return exp
 }
@@ -96,8 +95,8 @@ func parseExp8(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp7(lex)
 	for lex.LookAhead() == lexer.TOKEN_OP_BXOR {
 		line, op, _ := lex.NextToken()
-		bxor := &ast.BinopExp{line, op, exp, parseExp7(lex)}
-		exp = optimizeBitwiseBinaryOp(bxor)
+		bxor := _newBinopExp(lex, line, op, exp, parseExp7(lex))
+		exp = optimize(lex, bxor, optimizeBitwiseBinaryOp)
 	}
 	return exp
 }
@@ -107,8 +106,8 @@ func parseExp7(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp6(lex)
 	for lex.LookAhead() == lexer.TOKEN_OP_BAND {
 		line, op, _ := lex.NextToken()
-		band := &ast.BinopExp{line, op, exp, parseExp6(lex)}
-		exp = optimizeBitwiseBinaryOp(band)
+		band := _newBinopExp(lex, line, op, exp, parseExp6(lex))
+		exp = optimize(lex, band, optimizeBitwiseBinaryOp)
 	}
 	return exp
 }
@@ -120,13 +119,12 @@ func parseExp6(lex *lexer.Lexer) ast.Exp {
 		switch lex.LookAhead() {
 		case lexer.TOKEN_OP_SHL, lexer.TOKEN_OP_SHR:
 			line, op, _ := lex.NextToken()
-			shx := &ast.BinopExp{line, op, exp, parseExp5(lex)}
-			exp = optimizeBitwiseBinaryOp(shx)
+			shx := _newBinopExp(lex, line, op, exp, parseExp5(lex))
+			exp = optimize(lex, shx, optimizeBitwiseBinaryOp)
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // a .. b
@@ -142,7 +140,9 @@ func parseExp5(lex *lexer.Lexer) ast.Exp {
 		line, _, _ = lex.NextToken()
 		exps = append(exps, parseExp4(lex))
 	}
-	return &ast.ConcatExp{line, exps}
+	concat := &ast.ConcatExp{Line: line, Exps: exps}
+	concat.SetSpan(exp.Pos(), endPos(lex))
+	return concat
 }
 
 // x +/- y
@@ -152,13 +152,12 @@ func parseExp4(lex *lexer.Lexer) ast.Exp {
 		switch lex.LookAhead() {
 		case lexer.TOKEN_OP_ADD, lexer.TOKEN_OP_SUB:
 			line, op, _ := lex.NextToken()
-			arith := &ast.BinopExp{line, op, exp, parseExp3(lex)}
-			exp = optimizeArithBinaryOp(arith)
+			arith := _newBinopExp(lex, line, op, exp, parseExp3(lex))
+			exp = optimize(lex, arith, optimizeArithBinaryOp)
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // *, %, /, //
@@ -168,13 +167,12 @@ func parseExp3(lex *lexer.Lexer) ast.Exp {
 		switch lex.LookAhead() {
 		case lexer.TOKEN_OP_MUL, lexer.TOKEN_OP_MOD, lexer.TOKEN_OP_DIV, lexer.TOKEN_OP_IDIV:
 			line, op, _ := lex.NextToken()
-			arith := &ast.BinopExp{line, op, exp, parseExp2(lex)}
-			exp = optimizeArithBinaryOp(arith)
+			arith := _newBinopExp(lex, line, op, exp, parseExp2(lex))
+			exp = optimize(lex, arith, optimizeArithBinaryOp)
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // unary
@@ -182,7 +180,12 @@ func parseExp2(lex *lexer.Lexer) ast.Exp {
 	switch lex.LookAhead() {
 	case lexer.TOKEN_OP_UNM, lexer.TOKEN_OP_BNOT, lexer.TOKEN_OP_LEN, lexer.TOKEN_OP_NOT:
 		line, op, _ := lex.NextToken()
-		exp := &ast.UnopExp{line, op, parseExp2(lex)}
+		start := lex.TokenStart()
+		exp := &ast.UnopExp{Line: line, Op: op, Exp: parseExp2(lex)}
+		setSpan(lex, exp, start)
+		if lex.KeepSyntax() {
+			return exp
+		}
 		return optimizeUnaryOp(exp)
 	}
 	return parseExp1(lex)
@@ -193,66 +196,97 @@ func parseExp1(lex *lexer.Lexer) ast.Exp { // pow is right associative
 	exp := parseExp0(lex)
 	if lex.LookAhead() == lexer.TOKEN_OP_POW {
 		line, op, _ := lex.NextToken()
-		exp = &ast.BinopExp{line, op, exp, parseExp2(lex)}
+		exp = _newBinopExp(lex, line, op, exp, parseExp2(lex))
+	}
+	if lex.KeepSyntax() {
+		return exp
 	}
 	return optimizePow(exp)
 }
 
+// _newBinopExp returns exp1 op exp2, exp2 being the last expression read.
+func _newBinopExp(lex *lexer.Lexer, line, op int, exp1, exp2 ast.Exp) *ast.BinopExp {
+	exp := &ast.BinopExp{Line: line, Op: op, Exp1: exp1, Exp2: exp2}
+	exp.SetSpan(exp1.Pos(), endPos(lex))
+	return exp
+}
+
 func parseExp0(lex *lexer.Lexer) ast.Exp {
+	var exp interface {
+		ast.Exp
+		spanner
+	}
 	switch lex.LookAhead() {
 	case lexer.TOKEN_VARARG: // ...
 		line, _, _ := lex.NextToken()
-		return &ast.VarargExp{line}
+		exp = &ast.VarargExp{Line: line}
 	case lexer.TOKEN_KW_NIL: // nil
 		line, _, _ := lex.NextToken()
-		return &ast.NilExp{line}
+		exp = &ast.NilExp{Line: line}
 	case lexer.TOKEN_KW_TRUE: // true
 		line, _, _ := lex.NextToken()
-		return &ast.TrueExp{line}
+		exp = &ast.TrueExp{Line: line}
 	case lexer.TOKEN_KW_FALSE: // false
 		line, _, _ := lex.NextToken()
-		return &ast.FalseExp{line}
+		exp = &ast.FalseExp{Line: line}
 	case lexer.TOKEN_STRING: // LiteralString
 		line, _, token := lex.NextToken()
-		return &ast.StringExp{line, token}
+		exp = &ast.StringExp{Line: line, Str: token, Raw: lex.TokenText()}
 	case lexer.TOKEN_NUMBER: // Numeral
 		return parseNumberExp(lex)
 	case lexer.TOKEN_SEP_LCURLY: // tableconstructor
 		return parseTableConstructorExp(lex)
 	case lexer.TOKEN_KW_FUNCTION: // functiondef
 		lex.NextToken()
-		return parseFuncDefExp(lex)
+		return parseFuncDefExp(lex, lex.TokenStart())
 	default: // prefixexp
 		return parsePrefixExp(lex)
 	}
+	setSpan(lex, exp, lex.TokenStart())
+	return exp
 }
 
 func parseNumberExp(lex *lexer.Lexer) ast.Exp {
 	line, _, token := lex.NextToken()
+	var exp interface {
+		ast.Exp
+		spanner
+	}
 	if i, ok := number.ParseInteger(token); ok {
-		return &ast.IntegerExp{line, i}
+		exp = &ast.IntegerExp{Line: line, Val: i, Raw: token}
 	} else if f, ok := number.ParseFloat(token); ok {
-		return &ast.FloatExp{line, f}
-	} else { // todo
-		panic("not a number: " + token)
+		exp = &ast.FloatExp{Line: line, Val: f, Raw: token}
+	} else {
+		lex.Error("malformed number near '%s'", token)
 	}
+	setSpan(lex, exp, lex.TokenStart())
+	return exp
 }
 
 // functiondef ::= function funcbody
 // funcbody ::= ‘(’ [parlist] ‘)’ block end
-func parseFuncDefExp(lex *lexer.Lexer) *ast.FuncDefExp {
+func parseFuncDefExp(lex *lexer.Lexer, start int) *ast.FuncDefExp {
 	line := lex.Line()                                     // function
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_LPAREN)            // (
-	parList, isVararg := _parseParList(lex)                // [parlist]
+	params, isVararg := _parseParList(lex)                 // [parlist]
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_RPAREN)            // )
 	block := parseBlock(lex)                               // block
 	lastLine, _ := lex.NextTokenOfKind(lexer.TOKEN_KW_END) // end
-	return &ast.FuncDefExp{line, lastLine, parList, isVararg, block}
+	exp := &ast.FuncDefExp{
+		Line:     line,
+		LastLine: lastLine,
+		ParList:  _names(params),
+		Params:   params,
+		IsVararg: isVararg,
+		Block:    block,
+	}
+	setSpan(lex, exp, start)
+	return exp
 }
 
 // [parlist]
 // parlist ::= namelist [‘,’ ‘...’] | ‘...’
-func _parseParList(lex *lexer.Lexer) (names []string, isVararg bool) {
+func _parseParList(lex *lexer.Lexer) (names []*ast.NameExp, isVararg bool) {
 	switch lex.LookAhead() {
 	case lexer.TOKEN_SEP_RPAREN:
 		return nil, false
@@ -261,13 +295,11 @@ func _parseParList(lex *lexer.Lexer) (names []string, isVararg bool) {
 		return nil, true
 	}
 
-	_, name := lex.NextIdentifier()
-	names = append(names, name)
+	names = append(names, _parseName(lex))
 	for lex.LookAhead() == lexer.TOKEN_SEP_COMMA {
 		lex.NextToken()
 		if lex.LookAhead() == lexer.TOKEN_IDENTIFIER {
-			_, name := lex.NextIdentifier()
-			names = append(names, name)
+			names = append(names, _parseName(lex))
 		} else {
 			lex.NextTokenOfKind(lexer.TOKEN_VARARG)
 			isVararg = true
@@ -280,29 +312,37 @@ func _parseParList(lex *lexer.Lexer) (names []string, isVararg bool) {
 // tableconstructor ::= ‘{’ [fieldlist] ‘}’
 func parseTableConstructorExp(lex *lexer.Lexer) *ast.TableConstructorExp {
 	line := lex.Line()
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_LCURLY) // {
 	keyExps, valExps := _parseFieldList(lex)    // [fieldlist]
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_RCURLY) // }
 	lastLine := lex.Line()
-	return &ast.TableConstructorExp{line, lastLine, keyExps, valExps}
+	exp := &ast.TableConstructorExp{
+		Line:     line,
+		LastLine: lastLine,
+		KeyExps:  keyExps,
+		ValExps:  valExps,
+	}
+	setSpan(lex, exp, start)
+	return exp
 }
 
 // fieldlist ::= field {fieldsep field} [fieldsep]
+// The comments around a field are attached to its value.
 func _parseFieldList(lex *lexer.Lexer) (ks, vs []ast.Exp) {
-	if lex.LookAhead() != lexer.TOKEN_SEP_RCURLY {
+	for lex.LookAhead() != lexer.TOKEN_SEP_RCURLY {
+		leading := takeLeadingComments(lex)
 		k, v := _parseField(lex)
 		ks = append(ks, k)
 		vs = append(vs, v)
 
-		for _isFieldSep(lex.LookAhead()) {
+		hasSep := _isFieldSep(lex.LookAhead())
+		if hasSep {
 			lex.NextToken()
-			if lex.LookAhead() != lexer.TOKEN_SEP_RCURLY {
-				k, v := _parseField(lex)
-				ks = append(ks, k)
-				vs = append(vs, v)
-			} else {
-				break
-			}
+		}
+		attachComments(lex, v, leading)
+		if !hasSep {
+			break
 		}
 	}
 	return
@@ -329,7 +369,7 @@ func _parseField(lex *lexer.Lexer) (k, v ast.Exp) {
 		if lex.LookAhead() == lexer.TOKEN_OP_ASSIGN {
 			// Name ‘=’ exp => ‘[’ LiteralString ‘]’ = exp
 			lex.NextToken()
-			k = &ast.StringExp{nameExp.Line, nameExp.Name}
+			k = &ast.StringExp{Span: nameExp.Span, Line: nameExp.Line, Str: nameExp.Name}
 			v = parseExp(lex)
 			return
 		}
@@ -17,67 +17,86 @@ prefixexp ::= Name
 	| prefixexp [‘:’ Name] args
 */
 func parsePrefixExp(lex *lexer.Lexer) ast.Exp {
+	start := lex.LookAheadStart()
 	var exp ast.Exp
 	if lex.LookAhead() == lexer.TOKEN_IDENTIFIER {
-		line, name := lex.NextIdentifier() // Name
-		exp = &ast.NameExp{line, name}
+		exp = _parseName(lex) // Name
 	} else { // ‘(’ exp ‘)’
 		exp = parseParensExp(lex)
 	}
-	return _finishPrefixExp(lex, exp)
+	return _finishPrefixExp(lex, exp, start)
 }
 
 func parseParensExp(lex *lexer.Lexer) ast.Exp {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_LPAREN) // (
 	exp := parseExp(lex)                        // exp
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_RPAREN) // )
 
 	switch exp.(type) {
 	case *ast.VarargExp, *ast.FuncCallExp, *ast.NameExp, *ast.TableAccessExp:
-		return &ast.ParensExp{exp}
+		parens := &ast.ParensExp{Exp: exp}
+		setSpan(lex, parens, start)
+		return parens
 	}
 
-	// no need to keep parens
+	if lex.KeepSyntax() {
+		parens := &ast.ParensExp{Exp: exp}
+		setSpan(lex, parens, start)
+		return parens
+	}
+
+	// no need to keep parens, the expression covers them
+	setSpan(lex, exp.(spanner), start)
 	return exp
 }
 
-func _finishPrefixExp(lex *lexer.Lexer, exp ast.Exp) ast.Exp {
+func _finishPrefixExp(lex *lexer.Lexer, exp ast.Exp, start int) ast.Exp {
 	for {
 		switch lex.LookAhead() {
 		case lexer.TOKEN_SEP_LBRACK: // prefixexp ‘[’ exp ‘]’
 			lex.NextToken()                             // ‘[’
 			keyExp := parseExp(lex)                     // exp
 			lex.NextTokenOfKind(lexer.TOKEN_SEP_RBRACK) // ‘]’
-			exp = &ast.TableAccessExp{lex.Line(), exp, keyExp}
+			taExp := &ast.TableAccessExp{LastLine: lex.Line(), PrefixExp: exp, KeyExp: keyExp}
+			setSpan(lex, taExp, start)
+			exp = taExp
 		case lexer.TOKEN_SEP_DOT: // prefixexp ‘.’ Name
-			lex.NextToken()                    // ‘.’
-			line, name := lex.NextIdentifier() // Name
-			keyExp := &ast.StringExp{line, name}
-			exp = &ast.TableAccessExp{line, exp, keyExp}
+			lex.NextToken() // ‘.’
+			exp = _finishFieldExp(lex, exp, start)
 		case lexer.TOKEN_SEP_COLON, // prefixexp ‘:’ Name args
 			lexer.TOKEN_SEP_LPAREN, lexer.TOKEN_SEP_LCURLY, lexer.TOKEN_STRING: // prefixexp args
-			exp = _finishFuncCallExp(lex, exp)
+			exp = _finishFuncCallExp(lex, exp, start)
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
-func _finishFuncCallExp(lex *lexer.Lexer, prefixExp ast.Exp) *ast.FuncCallExp {
+func _finishFuncCallExp(lex *lexer.Lexer, prefixExp ast.Exp, start int) *ast.FuncCallExp {
 	nameExp := _parseNameExp(lex)
 	line := lex.Line() // todo
 	args := _parseArgs(lex)
 	lastLine := lex.Line()
-	return &ast.FuncCallExp{line, lastLine, prefixExp, nameExp, args}
+	exp := &ast.FuncCallExp{
+		Line:      line,
+		LastLine:  lastLine,
+		PrefixExp: prefixExp,
+		NameExp:   nameExp,
+		Args:      args,
+	}
+	setSpan(lex, exp, start)
+	return exp
 }
 
 func _parseNameExp(lex *lexer.Lexer) *ast.StringExp {
 	if lex.LookAhead() == lexer.TOKEN_SEP_COLON {
 		lex.NextToken()
 		line, name := lex.NextIdentifier()
-		return &ast.StringExp{line, name}
+		exp := &ast.StringExp{Line: line, Str: name}
+		setSpan(lex, exp, lex.TokenStart())
+		return exp
 	}
 	return nil
 }
@@ -95,7 +114,9 @@ func _parseArgs(lex *lexer.Lexer) (args []ast.Exp) {
 		args = []ast.Exp{parseTableConstructorExp(lex)}
 	default: // LiteralString
 		line, str := lex.NextTokenOfKind(lexer.TOKEN_STRING)
-		args = []ast.Exp{&ast.StringExp{line, str}}
+		exp := &ast.StringExp{Line: line, Str: str, Raw: lex.TokenText()}
+		setSpan(lex, exp, lex.TokenStart())
+		args = []ast.Exp{exp}
 	}
 	return
 }
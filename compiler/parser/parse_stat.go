@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"github.com/iglev/glua/api"
 	"github.com/iglev/glua/compiler/ast"
 	"github.com/iglev/glua/compiler/lexer"
 )
@@ -9,6 +10,7 @@ var _statEmpty = &ast.EmptyStat{}
 
 /*
 stat ::=  ‘;’
+
 	| break
 	| ‘::’ Name ‘::’
 	| goto Name
@@ -56,55 +58,74 @@ func parseStat(lex *lexer.Lexer) ast.Stat {
 // ;
 func parseEmptyStat(lex *lexer.Lexer) *ast.EmptyStat {
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_SEMI)
-	return _statEmpty
+	stat := &ast.EmptyStat{}
+	setSpan(lex, stat, lex.TokenStart())
+	return stat
 }
 
 // break
 func parseBreakStat(lex *lexer.Lexer) *ast.BreakStat {
 	lex.NextTokenOfKind(lexer.TOKEN_KW_BREAK)
-	return &ast.BreakStat{lex.Line()}
+	stat := &ast.BreakStat{Line: lex.Line()}
+	setSpan(lex, stat, lex.TokenStart())
+	return stat
 }
 
 // ‘::’ Name ‘::’
 func parseLabelStat(lex *lexer.Lexer) *ast.LabelStat {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_LABEL) // ::
 	_, name := lex.NextIdentifier()            // name
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_LABEL) // ::
-	return &ast.LabelStat{name}
+	stat := &ast.LabelStat{Name: name}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // goto Name
 func parseGotoStat(lex *lexer.Lexer) *ast.GotoStat {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_KW_GOTO) // goto
 	_, name := lex.NextIdentifier()          // name
-	return &ast.GotoStat{name}
+	stat := &ast.GotoStat{Name: name}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // do block end
 func parseDoStat(lex *lexer.Lexer) *ast.DoStat {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_KW_DO)  // do
 	block := parseBlock(lex)                // block
 	lex.NextTokenOfKind(lexer.TOKEN_KW_END) // end
-	return &ast.DoStat{block}
+	stat := &ast.DoStat{Block: block}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // while exp do block end
 func parseWhileStat(lex *lexer.Lexer) *ast.WhileStat {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_KW_WHILE) // while
 	exp := parseExp(lex)                      // exp
 	lex.NextTokenOfKind(lexer.TOKEN_KW_DO)    // do
 	block := parseBlock(lex)                  // block
 	lex.NextTokenOfKind(lexer.TOKEN_KW_END)   // end
-	return &ast.WhileStat{exp, block}
+	stat := &ast.WhileStat{Exp: exp, Block: block}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // repeat block until exp
 func parseRepeatStat(lex *lexer.Lexer) *ast.RepeatStat {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_KW_REPEAT) // repeat
 	block := parseBlock(lex)                   // block
 	lex.NextTokenOfKind(lexer.TOKEN_KW_UNTIL)  // until
 	exp := parseExp(lex)                       // exp
-	return &ast.RepeatStat{block, exp}
+	stat := &ast.RepeatStat{Block: block, Exp: exp}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // if exp then block {elseif exp then block} [else block] end
@@ -112,6 +133,7 @@ func parseIfStat(lex *lexer.Lexer) *ast.IfStat {
 	exps := make([]ast.Exp, 0, 4)
 	blocks := make([]*ast.Block, 0, 4)
 
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_KW_IF)   // if
 	exps = append(exps, parseExp(lex))       // exp
 	lex.NextTokenOfKind(lexer.TOKEN_KW_THEN) // then
@@ -124,31 +146,42 @@ func parseIfStat(lex *lexer.Lexer) *ast.IfStat {
 		blocks = append(blocks, parseBlock(lex)) // block
 	}
 
-	// else block => elseif true then block
+	// else block => elseif true then block, true having an empty span
 	if lex.LookAhead() == lexer.TOKEN_KW_ELSE {
-		lex.NextToken()                               // else
-		exps = append(exps, &ast.TrueExp{lex.Line()}) //
-		blocks = append(blocks, parseBlock(lex))      // block
+		lex.NextToken() // else
+		exp := &ast.TrueExp{Line: lex.Line()}
+		setSpan(lex, exp, lex.TokenEnd())
+		exps = append(exps, exp)                 //
+		blocks = append(blocks, parseBlock(lex)) // block
 	}
 
 	lex.NextTokenOfKind(lexer.TOKEN_KW_END) // end
-	return &ast.IfStat{exps, blocks}
+	stat := &ast.IfStat{Exps: exps, Blocks: blocks}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
 // for namelist in explist do block end
 func parseForStat(lex *lexer.Lexer) ast.Stat {
+	start := lex.LookAheadStart()
 	lineOfFor, _ := lex.NextTokenOfKind(lexer.TOKEN_KW_FOR)
-	_, name := lex.NextIdentifier()
+	name := _parseName(lex)
+	var stat interface {
+		ast.Stat
+		spanner
+	}
 	if lex.LookAhead() == lexer.TOKEN_OP_ASSIGN {
-		return _finishForNumStat(lex, lineOfFor, name)
+		stat = _finishForNumStat(lex, lineOfFor, name)
 	} else {
-		return _finishForInStat(lex, name)
+		stat = _finishForInStat(lex, name)
 	}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
-func _finishForNumStat(lex *lexer.Lexer, lineOfFor int, varName string) *ast.ForNumStat {
+func _finishForNumStat(lex *lexer.Lexer, lineOfFor int, varName *ast.NameExp) *ast.ForNumStat {
 	lex.NextTokenOfKind(lexer.TOKEN_OP_ASSIGN) // for name =
 	initExp := parseExp(lex)                   // exp
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_COMMA) // ,
@@ -159,37 +192,68 @@ func _finishForNumStat(lex *lexer.Lexer, lineOfFor int, varName string) *ast.For
 		lex.NextToken()         // ,
 		stepExp = parseExp(lex) // exp
 	} else {
-		stepExp = &ast.IntegerExp{lex.Line(), 1}
+		step := &ast.IntegerExp{Line: lex.Line(), Val: 1}
+		setSpan(lex, step, lex.TokenEnd())
+		stepExp = step
 	}
 
 	lineOfDo, _ := lex.NextTokenOfKind(lexer.TOKEN_KW_DO) // do
 	block := parseBlock(lex)                              // block
 	lex.NextTokenOfKind(lexer.TOKEN_KW_END)               // end
 
-	return &ast.ForNumStat{lineOfFor, lineOfDo,
-		varName, initExp, limitExp, stepExp, block}
+	return &ast.ForNumStat{
+		LineOfFor: lineOfFor,
+		LineOfDo:  lineOfDo,
+		VarName:   varName.Name,
+		Var:       varName,
+		InitExp:   initExp,
+		LimitExp:  limitExp,
+		StepExp:   stepExp,
+		Block:     block,
+	}
 }
 
 // for namelist in explist do block end
 // namelist ::= Name {‘,’ Name}
 // explist ::= exp {‘,’ exp}
-func _finishForInStat(lex *lexer.Lexer, name0 string) *ast.ForInStat {
-	nameList := _finishNameList(lex, name0)               // for namelist
+func _finishForInStat(lex *lexer.Lexer, name0 *ast.NameExp) *ast.ForInStat {
+	names := _finishNameList(lex, name0)                  // for namelist
 	lex.NextTokenOfKind(lexer.TOKEN_KW_IN)                // in
 	expList := parseExpList(lex)                          // explist
 	lineOfDo, _ := lex.NextTokenOfKind(lexer.TOKEN_KW_DO) // do
 	block := parseBlock(lex)                              // block
 	lex.NextTokenOfKind(lexer.TOKEN_KW_END)               // end
-	return &ast.ForInStat{lineOfDo, nameList, expList, block}
+	return &ast.ForInStat{
+		LineOfDo: lineOfDo,
+		NameList: _names(names),
+		Names:    names,
+		ExpList:  expList,
+		Block:    block,
+	}
 }
 
 // namelist ::= Name {‘,’ Name}
-func _finishNameList(lex *lexer.Lexer, name0 string) []string {
-	names := []string{name0}
+func _finishNameList(lex *lexer.Lexer, name0 *ast.NameExp) []*ast.NameExp {
+	names := []*ast.NameExp{name0}
 	for lex.LookAhead() == lexer.TOKEN_SEP_COMMA {
-		lex.NextToken()                 // ,
-		_, name := lex.NextIdentifier() // Name
-		names = append(names, name)
+		lex.NextToken()                        // ,
+		names = append(names, _parseName(lex)) // Name
+	}
+	return names
+}
+
+// Name
+func _parseName(lex *lexer.Lexer) *ast.NameExp {
+	line, name := lex.NextIdentifier()
+	exp := &ast.NameExp{Line: line, Name: name}
+	setSpan(lex, exp, lex.TokenStart())
+	return exp
+}
+
+func _names(exps []*ast.NameExp) []string {
+	names := make([]string, len(exps))
+	for i, exp := range exps {
+		names[i] = exp.Name
 	}
 	return names
 }
@@ -197,12 +261,19 @@ func _finishNameList(lex *lexer.Lexer, name0 string) []string {
 // local function Name funcbody
 // local namelist [‘=’ explist]
 func parseLocalAssignOrFuncDefStat(lex *lexer.Lexer) ast.Stat {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_KW_LOCAL)
+	var stat interface {
+		ast.Stat
+		spanner
+	}
 	if lex.LookAhead() == lexer.TOKEN_KW_FUNCTION {
-		return _finishLocalFuncDefStat(lex)
+		stat = _finishLocalFuncDefStat(lex)
 	} else {
-		return _finishLocalVarDeclStat(lex)
+		stat = _finishLocalVarDeclStat(lex)
 	}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 /*
@@ -221,43 +292,94 @@ not to `local f = function () body end`
 */
 // local function Name funcbody
 func _finishLocalFuncDefStat(lex *lexer.Lexer) *ast.LocalFuncDefStat {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_KW_FUNCTION) // local function
-	_, name := lex.NextIdentifier()              // name
-	fdExp := parseFuncDefExp(lex)                // funcbody
-	return &ast.LocalFuncDefStat{name, fdExp}
+	name := _parseName(lex)                      // name
+	fdExp := parseFuncDefExp(lex, start)         // funcbody
+	return &ast.LocalFuncDefStat{Name: name.Name, NameExp: name, Exp: fdExp}
 }
 
 // local namelist [‘=’ explist]
+// local attnamelist [‘=’ explist] (Lua 5.4)
 func _finishLocalVarDeclStat(lex *lexer.Lexer) *ast.LocalVarDeclStat {
-	_, name0 := lex.NextIdentifier()        // local Name
-	nameList := _finishNameList(lex, name0) // { , Name }
+	var names []*ast.NameExp
+	var attribList []string
+	if lex.Version() >= api.LUA_VERSION_54 {
+		names, attribList = _finishAttNameList(lex)
+	} else {
+		name0 := _parseName(lex)            // local Name
+		names = _finishNameList(lex, name0) // { , Name }
+	}
 	var expList []ast.Exp = nil
 	if lex.LookAhead() == lexer.TOKEN_OP_ASSIGN {
 		lex.NextToken()             // ==
 		expList = parseExpList(lex) // explist
 	}
 	lastLine := lex.Line()
-	return &ast.LocalVarDeclStat{lastLine, nameList, expList}
+	return &ast.LocalVarDeclStat{
+		LastLine:   lastLine,
+		NameList:   _names(names),
+		Names:      names,
+		ExpList:    expList,
+		AttribList: attribList,
+	}
+}
+
+// attnamelist ::=  Name attrib {‘,’ Name attrib}
+func _finishAttNameList(lex *lexer.Lexer) (names []*ast.NameExp, attribs []string) {
+	nClose := 0
+	for {
+		name := _parseName(lex)     // Name
+		attrib := _parseAttrib(lex) // attrib
+		if attrib == "close" {
+			if nClose++; nClose > 1 {
+				lex.Error("multiple to-be-closed variables in local list")
+			}
+		}
+		names = append(names, name)
+		attribs = append(attribs, attrib)
+		if lex.LookAhead() != lexer.TOKEN_SEP_COMMA {
+			return
+		}
+		lex.NextToken() // ,
+	}
+}
+
+// attrib ::= [‘<’ Name ‘>’]
+func _parseAttrib(lex *lexer.Lexer) string {
+	if lex.LookAhead() != lexer.TOKEN_OP_LT {
+		return ""
+	}
+	lex.NextToken()                   // <
+	_, attrib := lex.NextIdentifier() // Name
+	lex.NextTokenOfKind(lexer.TOKEN_OP_GT)
+	if attrib != "const" && attrib != "close" {
+		lex.Error("unknown attribute '%s'", attrib)
+	}
+	return attrib
 }
 
 // varlist ‘=’ explist
 // functioncall
 func parseAssignOrFuncCallStat(lex *lexer.Lexer) ast.Stat {
+	start := lex.LookAheadStart()
 	prefixExp := parsePrefixExp(lex)
 	if fc, ok := prefixExp.(*ast.FuncCallExp); ok {
 		return fc
 	} else {
-		return parseAssignStat(lex, prefixExp)
+		return parseAssignStat(lex, prefixExp, start)
 	}
 }
 
 // varlist ‘=’ explist |
-func parseAssignStat(lex *lexer.Lexer, var0 ast.Exp) *ast.AssignStat {
+func parseAssignStat(lex *lexer.Lexer, var0 ast.Exp, start int) *ast.AssignStat {
 	varList := _finishVarList(lex, var0)       // varlist
 	lex.NextTokenOfKind(lexer.TOKEN_OP_ASSIGN) // =
 	expList := parseExpList(lex)               // explist
 	lastLine := lex.Line()
-	return &ast.AssignStat{lastLine, varList, expList}
+	stat := &ast.AssignStat{LastLine: lastLine, VarList: varList, ExpList: expList}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // varlist ::= var {‘,’ var}
@@ -287,40 +409,49 @@ func _checkVar(lex *lexer.Lexer, exp ast.Exp) ast.Exp {
 // parlist ::= namelist [‘,’ ‘...’] | ‘...’
 // namelist ::= Name {‘,’ Name}
 func parseFuncDefStat(lex *lexer.Lexer) *ast.AssignStat {
+	start := lex.LookAheadStart()
 	lex.NextTokenOfKind(lexer.TOKEN_KW_FUNCTION) // function
 	fnExp, hasColon := _parseFuncName(lex)       // funcname
-	fdExp := parseFuncDefExp(lex)                // funcbody
+	fdExp := parseFuncDefExp(lex, start)         // funcbody
 	if hasColon {                                // insert self
 		fdExp.ParList = append(fdExp.ParList, "")
 		copy(fdExp.ParList[1:], fdExp.ParList)
 		fdExp.ParList[0] = "self"
 	}
 
-	return &ast.AssignStat{
+	stat := &ast.AssignStat{
 		LastLine: fdExp.Line,
 		VarList:  []ast.Exp{fnExp},
 		ExpList:  []ast.Exp{fdExp},
 	}
+	setSpan(lex, stat, start)
+	return stat
 }
 
 // funcname ::= Name {‘.’ Name} [‘:’ Name]
 func _parseFuncName(lex *lexer.Lexer) (exp ast.Exp, hasColon bool) {
-	line, name := lex.NextIdentifier()
-	exp = &ast.NameExp{line, name}
+	start := lex.LookAheadStart()
+	exp = _parseName(lex)
 
 	for lex.LookAhead() == lexer.TOKEN_SEP_DOT {
 		lex.NextToken()
-		line, name := lex.NextIdentifier()
-		idx := &ast.StringExp{line, name}
-		exp = &ast.TableAccessExp{line, exp, idx}
+		exp = _finishFieldExp(lex, exp, start)
 	}
 	if lex.LookAhead() == lexer.TOKEN_SEP_COLON {
 		lex.NextToken()
-		line, name := lex.NextIdentifier()
-		idx := &ast.StringExp{line, name}
-		exp = &ast.TableAccessExp{line, exp, idx}
+		exp = _finishFieldExp(lex, exp, start)
 		hasColon = true
 	}
 
 	return
 }
+
+// prefixexp ‘.’ Name, after the ‘.’
+func _finishFieldExp(lex *lexer.Lexer, prefixExp ast.Exp, start int) *ast.TableAccessExp {
+	line, name := lex.NextIdentifier()
+	keyExp := &ast.StringExp{Line: line, Str: name}
+	setSpan(lex, keyExp, lex.TokenStart())
+	exp := &ast.TableAccessExp{LastLine: line, PrefixExp: prefixExp, KeyExp: keyExp}
+	setSpan(lex, exp, start)
+	return exp
+}
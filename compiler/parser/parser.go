@@ -1,15 +1,232 @@
 package parser
 
 import (
+	"io"
+
+	"github.com/iglev/glua/api"
 	"github.com/iglev/glua/compiler/ast"
 	"github.com/iglev/glua/compiler/lexer"
 )
 
 /* recursive descent parser */
 
-func Parse(chunk, chunkName string) *ast.Block {
-	lex := lexer.NewLexer(chunk, chunkName)
-	block := parseBlock(lex)
+// Mode is a set of flags controlling the parser.
+type Mode uint
+
+const (
+	// AllErrors makes the parser go on after a syntax error and report
+	// all of them as a lexer.ErrorList, along with the partial AST.
+	AllErrors Mode = 1 << iota
+	// ParseComments makes the parser attach the comments to the nodes.
+	ParseComments
+	// KeepSyntax makes the parser keep the expressions as written for
+	// tools such as printers: constant expressions are not folded and the
+	// parentheses are all kept as ParensExp.
+	KeepSyntax
+)
+
+func Parse(chunk, chunkName string) (*ast.Block, error) {
+	return ParseVersion(chunk, chunkName, api.LUA_VERSION_53)
+}
+
+// ParseVersion parses chunk as code of the given language version. The
+// error is a *lexer.SyntaxError.
+func ParseVersion(chunk, chunkName string, version int) (*ast.Block, error) {
+	return ParseMode(chunk, chunkName, version, 0)
+}
+
+// ParseMode is ParseVersion with the parser flags of mode.
+func ParseMode(chunk, chunkName string, version int, mode Mode) (*ast.Block, error) {
+	return parse(lexer.NewLexer(chunk, chunkName), version, mode)
+}
+
+// ParseReader is ParseMode reading the chunk from r as it goes. If r
+// fails, the error is a *lexer.ReadError.
+func ParseReader(r io.Reader, chunkName string, version int, mode Mode) (*ast.Block, error) {
+	return parse(lexer.NewReaderLexer(r, chunkName), version, mode)
+}
+
+func parse(lex *lexer.Lexer, version int, mode Mode) (block *ast.Block, err error) {
+	lex.SetVersion(version)
+	lex.SetRecovering(mode&AllErrors != 0)
+	lex.SetKeepComments(mode&ParseComments != 0)
+	lex.SetKeepSyntax(mode&KeepSyntax != 0)
+	defer func() {
+		if r := recover(); r != nil {
+			if re, ok := r.(*lexer.ReadError); ok {
+				block, err = nil, re
+				return
+			}
+			block, err = nil, toSyntaxError(r)
+		}
+	}()
+	if lex.Recovering() {
+		block = parseChunkRecovering(lex)
+		if errs := lex.Errors(); len(errs) > 0 {
+			err = errs
+		}
+		return
+	}
+	block = parseBlock(lex)
+	lex.NextTokenOfKind(lexer.TOKEN_EOF)
+	return
+}
+
+// parseChunkRecovering parses the main block like parseBlock, going on
+// after the tokens left over by unbalanced block ends such as a stray 'end'.
+func parseChunkRecovering(lex *lexer.Lexer) *ast.Block {
+	var block *ast.Block
+	for {
+		tryParse(lex, func() {
+			more := parseBlock(lex)
+			if block == nil {
+				block = more
+				return
+			}
+			block.Stats = append(block.Stats, more.Stats...)
+			block.RetExps = more.RetExps
+			block.LastLine = more.LastLine
+			block.EndPos = more.EndPos
+			if more.Trivia != nil {
+				block.Trivia = more.Trivia
+			}
+		})
+		if block == nil {
+			block = &ast.Block{Stats: []ast.Stat{}}
+		}
+		if lex.LookAhead() == lexer.TOKEN_EOF {
+			return block
+		}
+		skipToken(lex)
+	}
+}
+
+// skipToken records the syntax error of the token where parseBlock stopped
+// and skips it.
+func skipToken(lex *lexer.Lexer) {
+	defer func() {
+		lex.AddError(toSyntaxError(recover()))
+	}()
 	lex.NextTokenOfKind(lexer.TOKEN_EOF)
-	return block
+}
+
+// tryParse calls parse and, in recovering mode, records the syntax error
+// it raises and skips the tokens up to the start of the next statement.
+func tryParse(lex *lexer.Lexer, parse func()) {
+	if !lex.Recovering() {
+		parse()
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			lex.AddError(toSyntaxError(r))
+			for !_isStatStart(lex.LookAhead()) {
+				lex.NextToken()
+			}
+		}
+	}()
+	parse()
+}
+
+func _isStatStart(tokenKind int) bool {
+	switch tokenKind {
+	case lexer.TOKEN_SEP_SEMI, lexer.TOKEN_SEP_LABEL, lexer.TOKEN_KW_BREAK,
+		lexer.TOKEN_KW_GOTO, lexer.TOKEN_KW_DO, lexer.TOKEN_KW_WHILE,
+		lexer.TOKEN_KW_REPEAT, lexer.TOKEN_KW_IF, lexer.TOKEN_KW_FOR,
+		lexer.TOKEN_KW_FUNCTION, lexer.TOKEN_KW_LOCAL:
+		return true
+	}
+	return _isReturnOrBlockEnd(tokenKind)
+}
+
+// toSyntaxError returns the *lexer.SyntaxError raised by the lexer and
+// panics again with any other value.
+func toSyntaxError(r interface{}) *lexer.SyntaxError {
+	if err, ok := r.(*lexer.SyntaxError); ok {
+		return err
+	}
+	panic(r)
+}
+
+/* positions and comments */
+
+// spanner is implemented by all the nodes through ast.Span.
+type spanner interface {
+	ast.Node
+	SetSpan(start, end ast.Pos)
+	SetComments(comments *ast.Comments)
+}
+
+func toPos(lex *lexer.Lexer, offset int) ast.Pos {
+	line, column := lex.Position(offset)
+	return ast.Pos{Line: line, Column: column}
+}
+
+// endPos returns the position just after the last token read.
+func endPos(lex *lexer.Lexer) ast.Pos {
+	return toPos(lex, lex.TokenEnd())
+}
+
+// setSpan sets the positions of node, which starts with the token at
+// offset start and ends with the last token read.
+func setSpan(lex *lexer.Lexer, node spanner, start int) {
+	end := lex.TokenEnd()
+	if end < start { // empty
+		end = start
+	}
+	node.SetSpan(toPos(lex, start), toPos(lex, end))
+}
+
+// takeLeadingComments takes the kept comments before the look ahead token.
+func takeLeadingComments(lex *lexer.Lexer) []*ast.Comment {
+	if !lex.KeepComments() {
+		return nil
+	}
+	lex.LookAhead()
+	return takeComments(lex, len(lex.Comments()))
+}
+
+// attachComments attaches to node the leading comments taken before it and
+// the kept comments after it on its last line.
+func attachComments(lex *lexer.Lexer, node ast.Node, leading []*ast.Comment) {
+	if !lex.KeepComments() {
+		return
+	}
+	trailing := takeTrailingComments(lex, node.End().Line)
+	if leading != nil || trailing != nil {
+		node.(spanner).SetComments(&ast.Comments{
+			Leading:  leading,
+			Trailing: trailing,
+		})
+	}
+}
+
+// takeTrailingComments takes the kept comments starting on the given line,
+// which is the last line of the previous node.
+func takeTrailingComments(lex *lexer.Lexer, line int) []*ast.Comment {
+	lex.LookAhead() // skip the comments before the next token
+	n := 0
+	for _, c := range lex.Comments() {
+		if l, _ := lex.Position(c.Start); l != line {
+			break
+		}
+		n++
+	}
+	return takeComments(lex, n)
+}
+
+// takeComments takes the first n comments kept by the lexer.
+func takeComments(lex *lexer.Lexer, n int) []*ast.Comment {
+	if n == 0 {
+		return nil
+	}
+	comments := make([]*ast.Comment, n)
+	for i, c := range lex.TakeComments(n) {
+		comments[i] = &ast.Comment{
+			StartPos: toPos(lex, c.Start),
+			EndPos:   toPos(lex, c.End),
+			Text:     c.Text,
+		}
+	}
+	return comments
 }
@@ -0,0 +1,94 @@
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/compiler"
+	"github.com/iglev/glua/compiler/ast"
+	"github.com/iglev/glua/compiler/lexer"
+	"github.com/iglev/glua/compiler/parser"
+)
+
+// TestSyntaxErrors positions of syntax errors and recovery after them
+func TestSyntaxErrors(t *testing.T) {
+	_, err := compiler.Compile("local x = 1\nx = = 2", "t")
+	se, ok := err.(*lexer.SyntaxError)
+	if !ok || se.Line != 2 || se.Column != 5 || se.Token != "=" {
+		t.Fatalf("%#v", err)
+	}
+	if err.Error() != "t:2: syntax error near '='" {
+		t.Fatal(err)
+	}
+	if _, err = compiler.Compile("x = 'abc\ny = 1", "t"); err == nil ||
+		err.(*lexer.SyntaxError).Msg != "unfinished string near ''abc'" {
+		t.Fatal(err)
+	}
+	if _, err = compiler.Compile("while true do end break", "t"); err == nil ||
+		err.Error() != "t:1: <break> at line 1 not inside a loop" {
+		t.Fatal(err)
+	}
+
+	src := "x = = 1\nlocal y = 'a\nif z then\n  w = )\nend\nend\nprint(x)"
+	block, err := parser.ParseMode(src, "t", api.LUA_VERSION_53, parser.AllErrors)
+	errs, ok := err.(lexer.ErrorList)
+	if !ok || len(errs) != 4 || block == nil {
+		t.Fatalf("%v", err)
+	}
+	for i, line := range []int{1, 2, 4, 6} {
+		if errs[i].Line != line {
+			t.Fatalf("%d: %v", i, errs[i])
+		}
+	}
+	if _, ok := block.Stats[len(block.Stats)-1].(*ast.FuncCallStat); !ok {
+		t.Fatalf("%#v", block.Stats)
+	}
+}
+
+// TestASTPositions node positions and comments
+func TestASTPositions(t *testing.T) {
+	src := "-- head\nlocal t = {\n  a = 1, -- one\n  b = (x),\n}\nprint(t.a, -3) -- call\ngoto done\n::done::\n"
+	block, err := parser.ParseMode(src, "t", api.LUA_VERSION_53, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := func(n ast.Node) [4]int {
+		return [4]int{n.Pos().Line, n.Pos().Column, n.End().Line, n.End().Column}
+	}
+	local := block.Stats[0].(*ast.LocalVarDeclStat)
+	table := local.ExpList[0].(*ast.TableConstructorExp)
+	call := block.Stats[1].(*ast.FuncCallStat)
+	for _, c := range []struct {
+		node ast.Node
+		want [4]int
+	}{
+		{block, [4]int{2, 1, 8, 9}},
+		{local, [4]int{2, 1, 5, 2}},
+		{local.Names[0], [4]int{2, 7, 2, 8}},
+		{table, [4]int{2, 11, 5, 2}},
+		{table.KeyExps[0], [4]int{3, 3, 3, 4}},
+		{table.ValExps[1], [4]int{4, 7, 4, 10}},
+		{call, [4]int{6, 1, 6, 15}},
+		{call.Args[0], [4]int{6, 7, 6, 10}},
+		{call.Args[1], [4]int{6, 12, 6, 14}},
+		{block.Stats[2], [4]int{7, 1, 7, 10}},
+		{block.Stats[3], [4]int{8, 1, 8, 9}},
+	} {
+		if got := pos(c.node); got != c.want {
+			t.Errorf("%T: %v, want %v", c.node, got, c.want)
+		}
+	}
+	if cs := local.Comments(); cs == nil || len(cs.Leading) != 1 || cs.Leading[0].Text != "-- head" {
+		t.Errorf("%+v", cs)
+	}
+	if cs := table.ValExps[0].Comments(); cs == nil || cs.Trailing[0].Text != "-- one" ||
+		cs.Trailing[0].StartPos != (ast.Pos{Line: 3, Column: 10}) {
+		t.Errorf("%+v", cs)
+	}
+	if cs := call.Comments(); cs == nil || cs.Trailing[0].Text != "-- call" {
+		t.Errorf("%+v", cs)
+	}
+	if block, _ := parser.Parse(src, "t"); block.Stats[0].Comments() != nil {
+		t.Error("comments kept")
+	}
+}
@@ -0,0 +1,363 @@
+package printer
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/iglev/glua/compiler/ast"
+	"github.com/iglev/glua/compiler/lexer"
+)
+
+// operator precedences, higher binds tighter
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precBor
+	precBxor
+	precBand
+	precShift
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precPow
+	precAtom
+)
+
+var binops = map[int]struct {
+	text string
+	prec int
+}{
+	lexer.TOKEN_OP_OR:   {"or", precOr},
+	lexer.TOKEN_OP_AND:  {"and", precAnd},
+	lexer.TOKEN_OP_LT:   {"<", precCompare},
+	lexer.TOKEN_OP_LE:   {"<=", precCompare},
+	lexer.TOKEN_OP_GT:   {">", precCompare},
+	lexer.TOKEN_OP_GE:   {">=", precCompare},
+	lexer.TOKEN_OP_EQ:   {"==", precCompare},
+	lexer.TOKEN_OP_NE:   {"~=", precCompare},
+	lexer.TOKEN_OP_BOR:  {"|", precBor},
+	lexer.TOKEN_OP_BXOR: {"~", precBxor},
+	lexer.TOKEN_OP_BAND: {"&", precBand},
+	lexer.TOKEN_OP_SHL:  {"<<", precShift},
+	lexer.TOKEN_OP_SHR:  {">>", precShift},
+	lexer.TOKEN_OP_ADD:  {"+", precAdd},
+	lexer.TOKEN_OP_SUB:  {"-", precAdd},
+	lexer.TOKEN_OP_MUL:  {"*", precMul},
+	lexer.TOKEN_OP_DIV:  {"/", precMul},
+	lexer.TOKEN_OP_IDIV: {"//", precMul},
+	lexer.TOKEN_OP_MOD:  {"%", precMul},
+	lexer.TOKEN_OP_POW:  {"^", precPow},
+}
+
+var unops = map[int]string{
+	lexer.TOKEN_OP_UNM:  "-",
+	lexer.TOKEN_OP_NOT:  "not ",
+	lexer.TOKEN_OP_LEN:  "#",
+	lexer.TOKEN_OP_BNOT: "~",
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "goto": true,
+	"if": true, "in": true, "local": true, "nil": true, "not": true,
+	"or": true, "repeat": true, "return": true, "then": true, "true": true,
+	"until": true, "while": true,
+}
+
+// isName reports whether s can be written as a name.
+func isName(s string) bool {
+	if s == "" || keywords[s] {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// unparen returns exp without the parentheses the printer drops.
+func (p *printer) unparen(exp ast.Exp) ast.Exp {
+	if p.Parens == ParensKeep {
+		return exp
+	}
+	for {
+		parens, ok := exp.(*ast.ParensExp)
+		if !ok {
+			return exp
+		}
+		switch parens.Exp.(type) {
+		case *ast.VarargExp, *ast.FuncCallExp:
+			return exp // the parentheses truncate the values to one
+		}
+		exp = parens.Exp
+	}
+}
+
+// prec returns the precedence of exp as printed.
+func (p *printer) prec(exp ast.Exp) int {
+	switch exp := p.unparen(exp).(type) {
+	case *ast.BinopExp:
+		return binops[exp.Op].prec
+	case *ast.ConcatExp:
+		return precConcat
+	case *ast.UnopExp:
+		return precUnary
+	case *ast.IntegerExp:
+		if exp.Raw == "" && exp.Val < 0 {
+			return precUnary
+		}
+	case *ast.FloatExp:
+		if exp.Raw == "" {
+			if math.IsInf(exp.Val, 0) || math.IsNaN(exp.Val) {
+				return precMul
+			} else if math.Signbit(exp.Val) {
+				return precUnary
+			}
+		}
+	}
+	return precAtom
+}
+
+func (p *printer) expList(exps []ast.Exp) {
+	for i, exp := range exps {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.exp(exp)
+	}
+}
+
+func (p *printer) exp(exp ast.Exp) {
+	switch exp := p.unparen(exp).(type) {
+	case *ast.NilExp:
+		p.print("nil")
+	case *ast.TrueExp:
+		p.print("true")
+	case *ast.FalseExp:
+		p.print("false")
+	case *ast.VarargExp:
+		p.print("...")
+	case *ast.IntegerExp:
+		p.print(formatInteger(exp))
+	case *ast.FloatExp:
+		p.print(formatFloat(exp))
+	case *ast.StringExp:
+		p.print(p.quote(exp))
+	case *ast.NameExp:
+		p.print(exp.Name)
+	case *ast.ParensExp:
+		p.print("(")
+		p.exp(exp.Exp)
+		p.print(")")
+	case *ast.UnopExp:
+		p.print(unops[exp.Op])
+		if exp.Op == lexer.TOKEN_OP_UNM && p.startsWithMinus(exp.Exp) {
+			p.print(" ") // not a comment
+		}
+		p.operand(exp.Exp, precUnary, false)
+	case *ast.BinopExp:
+		op := binops[exp.Op]
+		if exp.Op == lexer.TOKEN_OP_POW {
+			p.operand(exp.Exp1, op.prec, true)
+			p.print(" ^ ")
+			if _, ok := p.unparen(exp.Exp2).(*ast.UnopExp); ok {
+				p.exp(exp.Exp2)
+			} else {
+				p.operand(exp.Exp2, op.prec, false)
+			}
+		} else {
+			p.operand(exp.Exp1, op.prec, false)
+			p.print(" " + op.text + " ")
+			p.operand(exp.Exp2, op.prec, true)
+		}
+	case *ast.ConcatExp:
+		for i, e := range exp.Exps {
+			if i > 0 {
+				p.print(" .. ")
+			}
+			p.operand(e, precConcat, true)
+		}
+	case *ast.TableConstructorExp:
+		p.table(exp)
+	case *ast.FuncDefExp:
+		p.print("function")
+		p.funcBody(exp, false)
+	case *ast.TableAccessExp:
+		p.prefixExp(exp.PrefixExp)
+		if key, ok := exp.KeyExp.(*ast.StringExp); ok && isName(key.Str) {
+			p.print("." + key.Str)
+		} else {
+			p.print("[")
+			p.exp(exp.KeyExp)
+			p.print("]")
+		}
+	case *ast.FuncCallExp:
+		p.prefixExp(exp.PrefixExp)
+		if exp.NameExp != nil {
+			p.print(":" + exp.NameExp.Str)
+		}
+		p.args(exp)
+	}
+}
+
+// operand prints exp as an operand of an operator with the precedence prec,
+// in parentheses if it binds less tightly, or as tightly and at the side
+// the operator does not associate to.
+func (p *printer) operand(exp ast.Exp, prec int, sameNeedsParens bool) {
+	eprec := p.prec(exp)
+	parens := eprec < prec || eprec == prec && sameNeedsParens
+	if p.Parens == ParensExplicit && eprec < precUnary && eprec != prec {
+		parens = true
+	}
+	if parens {
+		p.print("(")
+		p.exp(exp)
+		p.print(")")
+	} else {
+		p.exp(exp)
+	}
+}
+
+// prefixExp prints exp as the prefix of a call or an index.
+func (p *printer) prefixExp(exp ast.Exp) {
+	switch p.unparen(exp).(type) {
+	case *ast.NameExp, *ast.TableAccessExp, *ast.FuncCallExp, *ast.ParensExp:
+		p.exp(exp)
+	default:
+		p.print("(")
+		p.exp(exp)
+		p.print(")")
+	}
+}
+
+// startsWithMinus reports whether exp is printed starting with a minus.
+func (p *printer) startsWithMinus(exp ast.Exp) bool {
+	switch exp := p.unparen(exp).(type) {
+	case *ast.UnopExp:
+		return exp.Op == lexer.TOKEN_OP_UNM
+	case *ast.IntegerExp, *ast.FloatExp:
+		return p.prec(exp) == precUnary
+	}
+	return false
+}
+
+// args prints the arguments of a call, without parentheses if the source
+// had none.
+func (p *printer) args(call *ast.FuncCallExp) {
+	if len(call.Args) == 1 {
+		arg := call.Args[0]
+		switch arg.(type) {
+		case *ast.StringExp, *ast.TableConstructorExp:
+			if arg.End() == call.End() && !isSynthesized(arg) {
+				p.print(" ")
+				p.exp(arg)
+				return
+			}
+		}
+	}
+	p.print("(")
+	p.expList(call.Args)
+	p.print(")")
+}
+
+func (p *printer) table(t *ast.TableConstructorExp) {
+	if len(t.ValExps) == 0 {
+		p.print("{}")
+		return
+	}
+	multiline := t.End().Line > t.Pos().Line
+	for _, val := range t.ValExps {
+		if val.Comments() != nil {
+			multiline = true
+		}
+	}
+	if !multiline {
+		p.print("{")
+		for i, val := range t.ValExps {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.field(t.KeyExps[i], val)
+		}
+		p.print("}")
+		return
+	}
+
+	p.print("{")
+	p.level++
+	p.lastLine = 0
+	for i, val := range t.ValExps {
+		c := val.Comments()
+		if c != nil {
+			p.comments(c.Leading)
+		}
+		start := val.Pos()
+		if key := t.KeyExps[i]; key != nil {
+			start = key.Pos()
+		}
+		p.newline(start.Line)
+		p.field(t.KeyExps[i], val)
+		if i < len(t.ValExps)-1 || p.TrailingComma {
+			p.print(",")
+		}
+		p.lastLine = val.End().Line
+		if c != nil {
+			p.trailingComments(c.Trailing)
+		}
+	}
+	p.level--
+	p.lastLine = 0
+	p.newline(0)
+	p.print("}")
+}
+
+func (p *printer) field(key, val ast.Exp) {
+	if key != nil {
+		if s, ok := key.(*ast.StringExp); ok && isName(s.Str) {
+			p.print(s.Str)
+		} else {
+			p.print("[")
+			p.exp(key)
+			p.print("]")
+		}
+		p.print(" = ")
+	}
+	p.exp(val)
+}
+
+func formatInteger(exp *ast.IntegerExp) string {
+	if exp.Raw != "" {
+		return exp.Raw
+	}
+	if exp.Val == math.MinInt64 {
+		return "(-9223372036854775807 - 1)" // 9223372036854775808 is a float
+	}
+	return strconv.FormatInt(exp.Val, 10)
+}
+
+func formatFloat(exp *ast.FloatExp) string {
+	if exp.Raw != "" {
+		return exp.Raw
+	}
+	f := exp.Val
+	switch {
+	case math.IsInf(f, 1):
+		return "1 / 0"
+	case math.IsInf(f, -1):
+		return "-1 / 0"
+	case math.IsNaN(f):
+		return "0 / 0"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+// Package printer prints an AST back as Lua source code.
+package printer
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/compiler/ast"
+	"github.com/iglev/glua/compiler/parser"
+)
+
+// ParensMode selects which parentheses the printer writes.
+type ParensMode int
+
+const (
+	// ParensKeep keeps the parentheses of the AST, as parsed with
+	// parser.KeepSyntax, and adds the ones the precedences require.
+	ParensKeep ParensMode = iota
+	// ParensMinimal writes only the parentheses that change the meaning.
+	ParensMinimal
+	// ParensExplicit also parenthesizes the operands of a binary operator
+	// with another precedence, as in a + (b * c) and (a and b) or c.
+	ParensExplicit
+)
+
+// Config controls the output of the printer. The zero Config indents with
+// tabs, keeps the quotes and the parentheses of the source and writes no
+// trailing comma.
+type Config struct {
+	Indent        string // one level of indentation, "\t" if empty
+	Quote         byte   // quote of the short strings, '"' or '\'', 0 to keep them
+	TrailingComma bool   // end the last field of multi-line tables with a comma
+	Parens        ParensMode
+	Version       int // language version of the sources, Lua 5.4 if 0
+}
+
+// Fprint prints node, a *ast.Block, an ast.Stat or an ast.Exp, to w. A
+// block ends with a newline.
+func (cfg *Config) Fprint(w io.Writer, node ast.Node) error {
+	p := &printer{Config: *cfg}
+	if p.Indent == "" {
+		p.Indent = "\t"
+	}
+	switch node := node.(type) {
+	case *ast.Block:
+		p.blockItems(node)
+		if p.buf.Len() > 0 {
+			p.buf.WriteByte('\n')
+		}
+	case ast.Stat:
+		p.stat(node)
+	case ast.Exp:
+		p.exp(node)
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// Format parses src keeping its comments and syntax, and prints it back.
+// A first line starting with '#', as "#!/usr/bin/env lua", is kept as is.
+func (cfg *Config) Format(src []byte, chunkName string) ([]byte, error) {
+	version := cfg.Version
+	if version == 0 {
+		version = api.LUA_VERSION_54
+	}
+	mode := parser.ParseComments | parser.KeepSyntax
+	block, err := parser.ParseMode(string(src), chunkName, version, mode)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if len(src) > 0 && src[0] == '#' { // skipped by the lexer
+		line := src
+		if i := bytes.IndexAny(src, "\r\n"); i >= 0 {
+			line = src[:i]
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := cfg.Fprint(&buf, block); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fprint prints node to w with the zero Config.
+func Fprint(w io.Writer, node ast.Node) error {
+	return (&Config{}).Fprint(w, node)
+}
+
+// Format formats src with the zero Config.
+func Format(src []byte, chunkName string) ([]byte, error) {
+	return (&Config{}).Format(src, chunkName)
+}
+
+type printer struct {
+	Config
+	buf      bytes.Buffer
+	level    int // indentation level
+	lastLine int // source line of what was printed last, 0 if unknown
+}
+
+func (p *printer) print(s string) {
+	p.buf.WriteString(s)
+}
+
+// newline starts a new indented line for something at the given source
+// line, keeping one blank line if there was any in the source.
+func (p *printer) newline(line int) {
+	if p.buf.Len() > 0 {
+		if p.lastLine > 0 && line > p.lastLine+1 {
+			p.buf.WriteByte('\n')
+		}
+		p.buf.WriteByte('\n')
+	}
+	for i := 0; i < p.level; i++ {
+		p.buf.WriteString(p.Indent)
+	}
+}
+
+// comments prints comments on their own lines.
+func (p *printer) comments(comments []*ast.Comment) {
+	for _, c := range comments {
+		p.newline(c.StartPos.Line)
+		p.print(c.Text)
+		p.lastLine = c.EndPos.Line
+	}
+}
+
+// trailingComments prints comments at the end of the current line.
+func (p *printer) trailingComments(comments []*ast.Comment) {
+	for _, c := range comments {
+		p.print(" ")
+		p.print(c.Text)
+		p.lastLine = c.EndPos.Line
+	}
+}
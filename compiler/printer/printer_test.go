@@ -0,0 +1,100 @@
+package printer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iglev/glua/compiler/ast"
+	"github.com/iglev/glua/compiler/parser"
+	"github.com/iglev/glua/compiler/printer"
+)
+
+// TestPrinter formatting with the configurations and round trips
+func TestPrinter(t *testing.T) {
+	src := `-- head
+local a,b<const> = 1, 'it\'s' -- one
+
+function M.a:b(x, ...) return -(-x), (...), 2^-3, (a+b)*c, a-(b-c) end
+local t = { 1, x=2, ["y z"]=3;
+  -- field
+  n = {}, -- last
+}
+if a then f"s" elseif b then f{} else for i=1,2 do end end
+`
+	want := `-- head
+local a, b <const> = 1, 'it\'s' -- one
+
+function M.a:b(x, ...)
+	return -(-x), (...), 2 ^ -3, (a + b) * c, a - (b - c)
+end
+local t = {
+	1,
+	x = 2,
+	["y z"] = 3,
+	-- field
+	n = {} -- last
+}
+if a then
+	f "s"
+elseif b then
+	f {}
+else
+	for i = 1, 2 do
+	end
+end
+`
+	for _, c := range []struct {
+		cfg  printer.Config
+		want string
+	}{
+		{printer.Config{}, want},
+		{printer.Config{Indent: "  ", Quote: '"', TrailingComma: true, Parens: printer.ParensMinimal},
+			strings.NewReplacer("\t", "  ", `'it\'s'`, `"it's"`, "-(-x)", "- -x", "(...)", "(...)",
+				"n = {} --", "n = {}, --").Replace(want)},
+	} {
+		got, err := c.cfg.Format([]byte(src), "t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != c.want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, c.want)
+		}
+		if again, _ := c.cfg.Format(got, "t"); string(again) != string(got) {
+			t.Errorf("not idempotent:\n%s", again)
+		}
+	}
+
+	cfg := printer.Config{Parens: printer.ParensExplicit}
+	if got, _ := cfg.Format([]byte("x = a + b * c == d or e and f .. g"), "t"); string(got) !=
+		"x = ((a + (b * c)) == d) or (e and (f .. g))\n" {
+		t.Errorf("%s", got)
+	}
+	block, _ := parser.Parse("x = -(2^63) .. 1/0 .. 'a\\0b' .. -(1 - 2)", "t")
+	var b strings.Builder
+	printer.Fprint(&b, block)
+	if got := b.String(); got != "x = -9.223372036854776e+18 .. 1 / 0 .. 'a\\0b' .. 1\n" {
+		t.Errorf("%s", got)
+	}
+	b.Reset()
+	printer.Fprint(&b, &ast.StringExp{Str: "a\x00\"\n\u00e9\xff"})
+	if got := b.String(); got != "\"a\\000\\\"\\n\u00e9\\255\"" {
+		t.Errorf("%s", got)
+	}
+}
+
+// TestShebang the first line of a script starting with '#' is kept
+func TestShebang(t *testing.T) {
+	for _, c := range []struct{ src, want string }{
+		{"#!/usr/bin/env lua\nprint( 1 )\n", "#!/usr/bin/env lua\nprint(1)\n"},
+		{"#!/usr/bin/env  lua -e x=1\r\nlocal  x=1", "#!/usr/bin/env  lua -e x=1\nlocal x = 1\n"},
+		{"# comment", "# comment\n"},
+	} {
+		got, err := printer.Format([]byte(c.src), "t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != c.want {
+			t.Errorf("%q: got %q", c.src, got)
+		}
+	}
+}
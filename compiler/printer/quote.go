@@ -0,0 +1,100 @@
+package printer
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/iglev/glua/compiler/ast"
+)
+
+// quote returns the literal of s. The literal as written is kept unless it
+// is a short string to requote and requoting adds no escapes.
+func (p *printer) quote(s *ast.StringExp) string {
+	raw := s.Raw
+	if raw == "" {
+		q := p.Quote
+		if q == 0 {
+			q = '"'
+		}
+		return quoteString(s.Str, q)
+	}
+	old, q := raw[0], p.Quote
+	if old == '[' || q == 0 || old == q ||
+		strings.Count(s.Str, string(q)) > strings.Count(s.Str, string(old)) {
+		return raw
+	}
+	return requote(raw, q)
+}
+
+// requote converts the short string literal raw to the quote q, keeping its
+// escape sequences.
+func requote(raw string, q byte) string {
+	old := raw[0]
+	body := raw[1 : len(raw)-1]
+	var b strings.Builder
+	b.WriteByte(q)
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\\' && i+1 < len(body):
+			i++
+			if body[i] != old {
+				b.WriteByte(c)
+			}
+			b.WriteByte(body[i])
+		case c == q:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(q)
+	return b.String()
+}
+
+// quoteString returns s as a short string literal with the quote q.
+func quoteString(s string, q byte) string {
+	var b strings.Builder
+	b.WriteByte(q)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case q, '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if c >= utf8.RuneSelf {
+				r, size := utf8.DecodeRuneInString(s[i:])
+				if r != utf8.RuneError || size > 1 {
+					b.WriteString(s[i : i+size])
+					i += size
+					continue
+				}
+			}
+			if c < ' ' || c >= 0x7f {
+				fmt.Fprintf(&b, "\\%03d", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+		i++
+	}
+	b.WriteByte(q)
+	return b.String()
+}
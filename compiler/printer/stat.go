@@ -0,0 +1,221 @@
+package printer
+
+import (
+	"strings"
+
+	"github.com/iglev/glua/compiler/ast"
+)
+
+// blockItems prints the statements, the return statement and the comments
+// of b at the current level.
+func (p *printer) blockItems(b *ast.Block) {
+	for _, stat := range b.Stats {
+		p.stat(stat)
+	}
+	var leading, trailing []*ast.Comment
+	if c := b.Comments(); c != nil {
+		leading, trailing = c.Leading, c.Trailing
+	}
+	if b.RetExps != nil {
+		p.comments(leading)
+		line := b.End().Line
+		if len(b.RetExps) > 0 {
+			line = b.RetExps[0].Pos().Line
+		}
+		p.newline(line)
+		p.print("return")
+		if len(b.RetExps) > 0 {
+			p.print(" ")
+			p.expList(b.RetExps)
+		}
+		p.lastLine = b.End().Line
+	} else {
+		trailing = append(leading, trailing...)
+	}
+	p.comments(trailing)
+}
+
+// body prints b one level deeper, and starts the line of the keyword closing
+// it.
+func (p *printer) body(b *ast.Block) {
+	p.level++
+	p.lastLine = 0
+	p.blockItems(b)
+	p.level--
+	p.lastLine = 0
+	p.newline(0)
+}
+
+func (p *printer) stat(stat ast.Stat) {
+	c := stat.Comments()
+	if c != nil {
+		p.comments(c.Leading)
+	}
+	p.newline(stat.Pos().Line)
+	switch s := stat.(type) {
+	case *ast.EmptyStat:
+		p.print(";")
+	case *ast.BreakStat:
+		p.print("break")
+	case *ast.LabelStat:
+		p.print("::" + s.Name + "::")
+	case *ast.GotoStat:
+		p.print("goto " + s.Name)
+	case *ast.DoStat:
+		p.print("do")
+		p.body(s.Block)
+		p.print("end")
+	case *ast.FuncCallStat:
+		p.exp(s)
+	case *ast.WhileStat:
+		p.print("while ")
+		p.exp(s.Exp)
+		p.print(" do")
+		p.body(s.Block)
+		p.print("end")
+	case *ast.RepeatStat:
+		p.print("repeat")
+		p.body(s.Block)
+		p.print("until ")
+		p.exp(s.Exp)
+	case *ast.IfStat:
+		p.ifStat(s)
+	case *ast.ForNumStat:
+		p.print("for " + s.VarName + " = ")
+		p.exp(s.InitExp)
+		p.print(", ")
+		p.exp(s.LimitExp)
+		if !isDefaultStep(s.StepExp) {
+			p.print(", ")
+			p.exp(s.StepExp)
+		}
+		p.print(" do")
+		p.body(s.Block)
+		p.print("end")
+	case *ast.ForInStat:
+		p.print("for " + strings.Join(s.NameList, ", ") + " in ")
+		p.expList(s.ExpList)
+		p.print(" do")
+		p.body(s.Block)
+		p.print("end")
+	case *ast.LocalVarDeclStat:
+		p.print("local ")
+		for i, name := range s.NameList {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.print(name)
+			if i < len(s.AttribList) && s.AttribList[i] != "" {
+				p.print(" <" + s.AttribList[i] + ">")
+			}
+		}
+		if len(s.ExpList) > 0 {
+			p.print(" = ")
+			p.expList(s.ExpList)
+		}
+	case *ast.LocalFuncDefStat:
+		p.print("local function " + s.Name)
+		p.funcBody(s.Exp, false)
+	case *ast.AssignStat:
+		p.assignStat(s)
+	}
+	p.lastLine = stat.End().Line
+	if c != nil {
+		p.trailingComments(c.Trailing)
+	}
+}
+
+func (p *printer) ifStat(s *ast.IfStat) {
+	for i, exp := range s.Exps {
+		if i > 0 && i == len(s.Exps)-1 && isSynthesized(exp) {
+			if _, ok := exp.(*ast.TrueExp); ok {
+				p.print("else")
+				p.body(s.Blocks[i])
+				break
+			}
+		}
+		if i == 0 {
+			p.print("if ")
+		} else {
+			p.print("elseif ")
+		}
+		p.exp(exp)
+		p.print(" then")
+		p.body(s.Blocks[i])
+	}
+	p.print("end")
+}
+
+// assignStat prints s as a function statement if it was written as one.
+func (p *printer) assignStat(s *ast.AssignStat) {
+	if len(s.VarList) == 1 && len(s.ExpList) == 1 {
+		if f, ok := s.ExpList[0].(*ast.FuncDefExp); ok && f.Pos() == s.Pos() {
+			if name, ok := funcName(s.VarList[0]); ok {
+				method := isMethod(f) && strings.Contains(name, ".")
+				if method {
+					i := strings.LastIndexByte(name, '.')
+					name = name[:i] + ":" + name[i+1:]
+				}
+				p.print("function " + name)
+				p.funcBody(f, method)
+				return
+			}
+		}
+	}
+	p.expList(s.VarList)
+	p.print(" = ")
+	p.expList(s.ExpList)
+}
+
+// funcName returns the name of a function statement assigning to exp, a
+// name followed by fields.
+func funcName(exp ast.Exp) (string, bool) {
+	switch exp := exp.(type) {
+	case *ast.NameExp:
+		return exp.Name, true
+	case *ast.TableAccessExp:
+		if key, ok := exp.KeyExp.(*ast.StringExp); ok && isName(key.Str) {
+			if prefix, ok := funcName(exp.PrefixExp); ok {
+				return prefix + "." + key.Str, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isMethod reports whether f was defined with the colon syntax, the parser
+// leaving its implicit self out of Params.
+func isMethod(f *ast.FuncDefExp) bool {
+	return len(f.ParList) == len(f.Params)+1 && f.ParList[0] == "self"
+}
+
+// isDefaultStep reports whether exp is the step the parser adds to the
+// numeric for loops written without one.
+func isDefaultStep(exp ast.Exp) bool {
+	i, ok := exp.(*ast.IntegerExp)
+	return ok && i.Val == 1 && isSynthesized(exp)
+}
+
+// isSynthesized reports whether exp was added by the parser rather than
+// written in the source, such nodes have an empty span.
+func isSynthesized(exp ast.Exp) bool {
+	return exp.Pos() == exp.End()
+}
+
+func (p *printer) funcBody(f *ast.FuncDefExp, method bool) {
+	params := f.ParList
+	if method {
+		params = params[1:]
+	}
+	if f.IsVararg {
+		params = append(params[:len(params):len(params)], "...")
+	}
+	p.print("(" + strings.Join(params, ", ") + ")")
+	b := f.Block
+	if len(b.Stats) == 0 && b.RetExps == nil && b.Comments() == nil {
+		p.print(" end")
+		return
+	}
+	p.body(b)
+	p.print("end")
+}
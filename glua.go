@@ -0,0 +1,318 @@
+// Package glua embeds a Lua 5.3 interpreter, or Lua 5.4 with WithVersion,
+// in Go programs.
+//
+//	L := glua.NewState(glua.WithStdout(&buf))
+//	defer L.Close()
+//	if err := L.DoString(`function add(a, b) return a + b end`); err != nil {
+//		...
+//	}
+//	res, err := L.Call("add", 1, 2) // []interface{}{int64(3)}
+//
+// Go values cross into Lua as by api.BasicAPI.PushGoValue, and come back
+// as by ToGoValue into an interface{}. For anything else, LuaState gives
+// the underlying state.
+package glua
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/state"
+	"github.com/iglev/glua/stdlib"
+)
+
+// State is a Lua state, it must be used by one goroutine at a time.
+type State struct {
+	ls api.LuaState
+}
+
+// Option configures a State made by NewState.
+type Option func(*options)
+
+type options struct {
+	libs          []string // nil for all
+	stdin         io.Reader
+	stdout        io.Writer
+	stderr        io.Writer
+	path          string
+	maxCalls      int
+	maxStack      int
+	deterministic bool
+	version       int
+}
+
+// WithLibs opens only the given standard libraries ("_G" for the basic
+// library, "string", "table"...) instead of all of them. The names of
+// stdlib.Compat51Libs such as "bit32" are accepted too.
+func WithLibs(names ...string) Option {
+	return func(o *options) { o.libs = append([]string{}, names...) }
+}
+
+// WithStdin makes io.read and io.stdin read from r.
+func WithStdin(r io.Reader) Option {
+	return func(o *options) { o.stdin = r }
+}
+
+// WithStdout sends the output of print, io.write and io.stdout to w.
+func WithStdout(w io.Writer) Option {
+	return func(o *options) { o.stdout = w }
+}
+
+// WithStderr sends the output of io.stderr and error reports to w.
+func WithStderr(w io.Writer) Option {
+	return func(o *options) { o.stderr = w }
+}
+
+// WithPath sets package.path, where require looks for Lua modules, to the
+// given templates such as "./lib/?.lua".
+func WithPath(templates ...string) Option {
+	return func(o *options) { o.path = strings.Join(templates, ";") }
+}
+
+// WithMaxCalls limits the depth of nested calls.
+func WithMaxCalls(n int) Option {
+	return func(o *options) { o.maxCalls = n }
+}
+
+// WithMaxStack limits the number of stack slots of each thread.
+func WithMaxStack(n int) Option {
+	return func(o *options) { o.maxStack = n }
+}
+
+// WithDeterministic makes tables traverse in a deterministic order.
+func WithDeterministic() Option {
+	return func(o *options) { o.deterministic = true }
+}
+
+// WithVersion selects the language version, api.LUA_VERSION_53 by default
+// or api.LUA_VERSION_54. api.LUA_VERSION_51 is Lua 5.3 with the libraries
+// of stdlib.Compat51Libs also open, for scripts written for Lua 5.1.
+func WithVersion(v int) Option {
+	return func(o *options) { o.version = v }
+}
+
+// NewState returns a new state with the standard libraries open.
+func NewState(opts ...Option) *State {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ls := state.New()
+	if o.maxCalls > 0 {
+		ls.SetMaxCalls(o.maxCalls)
+	}
+	if o.maxStack > 0 {
+		ls.SetMaxStack(o.maxStack)
+	}
+	ls.SetDeterministic(o.deterministic)
+	if o.version != 0 {
+		ls.SetVersion(o.version)
+	}
+	if o.stdin != nil {
+		ls.SetStdin(o.stdin)
+	}
+	if o.stdout != nil {
+		ls.SetStdout(o.stdout)
+	}
+	if o.stderr != nil {
+		ls.SetStderr(o.stderr)
+	}
+
+	libs := o.libs
+	if libs == nil {
+		libs = libNames(stdlib.Libs)
+		if o.version == api.LUA_VERSION_51 {
+			libs = append(libs, libNames(stdlib.Compat51Libs)...)
+		}
+	}
+	for _, name := range libs {
+		openf, ok := stdlib.Libs[name]
+		if !ok {
+			openf, ok = stdlib.Compat51Libs[name]
+		}
+		if ok {
+			ls.RequireF(name, openf, true)
+			ls.Pop(1)
+		}
+	}
+	if o.path != "" && ls.GetGlobal("package") == api.LUA_TTABLE {
+		ls.PushString(o.path)
+		ls.SetField(-2, "path")
+	}
+	ls.SetTop(0)
+	return &State{ls}
+}
+
+func libNames(libs map[string]api.GoFunction) []string {
+	names := make([]string, 0, len(libs))
+	for name := range libs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LuaState returns the underlying state.
+func (s *State) LuaState() api.LuaState {
+	return s.ls
+}
+
+// Close calls the pending finalizers and releases the state, which must
+// not be used afterwards.
+func (s *State) Close() {
+	s.ls.Close()
+}
+
+// DoString runs the chunk code.
+func (s *State) DoString(code string) error {
+	return s.do([]byte(code), code)
+}
+
+// DoFile runs the chunk in the file filename.
+func (s *State) DoFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return s.do(data, "@"+filename)
+}
+
+// do loads and runs a chunk in protected mode, so that syntax errors are
+// reported as well.
+func (s *State) do(chunk []byte, chunkName string) error {
+	ls := s.ls
+	top := ls.GetTop()
+	defer ls.SetTop(top)
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		if ls.Load(chunk, chunkName, "bt") != api.LUA_OK {
+			return ls.Error()
+		}
+		ls.Call(0, 0)
+		return 0
+	})
+	return s.pcall(0, 0)
+}
+
+// Call calls the global function fnName, which may be a path such as
+// "string.format", with args converted as by SetGlobal, and returns its
+// results converted as by GetGlobal.
+func (s *State) Call(fnName string, args ...interface{}) ([]interface{}, error) {
+	ls := s.ls
+	top := ls.GetTop()
+	defer ls.SetTop(top)
+
+	parts := strings.Split(fnName, ".")
+	ls.GetGlobal(parts[0])
+	for _, part := range parts[1:] {
+		if !ls.IsTable(-1) {
+			return nil, fmt.Errorf("glua: %s is not a function", fnName)
+		}
+		ls.GetField(-1, part)
+		ls.Remove(-2)
+	}
+	if ls.IsNil(-1) {
+		return nil, fmt.Errorf("glua: %s is not a function", fnName)
+	}
+	ls.CheckStack2(len(args), "too many arguments")
+	for _, arg := range args {
+		s.push(arg)
+	}
+	if err := s.pcall(len(args), api.LUA_MULTRET); err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, ls.GetTop()-top)
+	for i := range results {
+		if err := ls.ToGoValue(top+i+1, &results[i]); err != nil {
+			return nil, fmt.Errorf("glua: result #%d: %s", i+1, err)
+		}
+	}
+	return results, nil
+}
+
+// GetGlobal returns the value of the global name converted as by
+// api.BasicAPI.ToGoValue into an interface{}: tables are copied into
+// slices and maps, functions become func(...interface{}) []interface{}.
+func (s *State) GetGlobal(name string) (interface{}, error) {
+	ls := s.ls
+	ls.GetGlobal(name)
+	defer ls.Pop(1)
+	var v interface{}
+	if err := ls.ToGoValue(-1, &v); err != nil {
+		return nil, fmt.Errorf("glua: %s: %s", name, err)
+	}
+	return v, nil
+}
+
+// SetGlobal sets the global name to v converted as by
+// api.BasicAPI.PushGoValue, except that funcs become Lua functions as by
+// api.AuxLib.PushGoFunc.
+func (s *State) SetGlobal(name string, v interface{}) {
+	if reflect.ValueOf(v).Kind() == reflect.Func {
+		s.ls.RegisterFunc(name, v)
+		return
+	}
+	s.push(v)
+	s.ls.SetGlobal(name)
+}
+
+func (s *State) push(v interface{}) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Func && !rv.IsNil() {
+		s.ls.PushGoFunc(v)
+	} else {
+		s.ls.PushGoValue(v)
+	}
+}
+
+// pcall calls a function in protected mode, returning the error it raises.
+func (s *State) pcall(nArgs, nResults int) error {
+	if s.ls.PCall(nArgs, nResults, 0) != api.LUA_OK {
+		return s.popError()
+	}
+	return nil
+}
+
+// Error is a Lua error returned by a State.
+type Error struct {
+	Value   interface{} // the error object, converted as by GetGlobal
+	Message string      // the error message, or a description of Value
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// popError pops the error object at the top of the stack, the message is
+// made as by the standalone interpreter.
+func (s *State) popError() error {
+	ls := s.ls
+	defer ls.Pop(1)
+	e := &Error{}
+	ls.ToGoValue(-1, &e.Value)
+	if ls.Type(-1) == api.LUA_TSTRING || ls.Type(-1) == api.LUA_TNUMBER {
+		e.Message = ls.ToString(-1)
+		e.Value = e.Message
+		return e
+	}
+
+	e.Message = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(-1))
+	if ls.GetMetafield(-1, "__tostring") != api.LUA_TNIL {
+		ls.Pop(1)
+		ls.PushGoFunction(func(ls api.LuaState) int {
+			ls.ToString2(1)
+			return 1
+		})
+		ls.PushValue(-2)
+		if ls.PCall(1, 1, 0) == api.LUA_OK {
+			e.Message = ls.ToString(-1)
+		}
+		ls.Pop(1)
+	}
+	return e
+}
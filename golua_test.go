@@ -1,8 +1,17 @@
 package glua
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/iglev/glua/api"
 	"github.com/iglev/glua/state"
 )
 
@@ -416,3 +425,1024 @@ func TestStdlib(t *testing.T) {
 	ls.LoadFile("test.lua")
 	ls.Call(0, -1)
 }
+
+func doString(t *testing.T, chunk string) {
+	t.Helper()
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.DoString(chunk) {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+// TestCallFrames calls sharing one value stack
+func TestCallFrames(t *testing.T) {
+	doString(t, `
+		local function sum(n) if n == 0 then return 0 end return n + sum(n-1) end
+		assert(sum(3000) == 4501500)
+
+		local function va(a, ...) return select('#', ...), a, ... end
+		local n, a, b, c = va(1, 2, 3)
+		assert(n == 2 and a == 1 and b == 2 and c == 3)
+
+		-- open upvalues must follow their slots when the stack grows
+		local v = 0
+		local function get() return v end
+		local function rec(n) if n == 0 then v = v + 1 return get() end return rec(n-1) end
+		assert(rec(2000) == 1 and get() == 1)
+
+		local fs = {}
+		for i = 1, 3 do fs[i] = function() i = i + 10 return i end end
+		assert(fs[1]() == 11 and fs[1]() == 21 and fs[2]() == 12)
+
+		-- every frame closes its own upvalues and leaves the outer ones open
+		local function nest(n, outer)
+			local a, b = n, n * 2
+			local function both() return a + b end
+			local function set(x) a = x end
+			if n == 0 then set(100); outer(); return both end
+			local inner = nest(n - 1, function() set(100); outer() end)
+			assert(both() == 100 + b and inner() == 100 + b - 2, n)
+			return both
+		end
+		assert(nest(500, function() end)() == 100 + 1000)
+
+		local ok, err = pcall(function(x) local y = x .. nil end, "a")
+		assert(not ok)
+	`)
+}
+
+// TestMethodCall the object of a method call is kept apart from the arguments
+func TestMethodCall(t *testing.T) {
+	doString(t, `
+		local o = {v = 5}
+		function o:m(x, y) return self.v, x, y end
+		local v, x, y = o:m(1, 2)
+		assert(v == 5 and x == 1 and y == 2)
+		v, x = o:m((function() return 7 end)())
+		assert(v == 5 and x == 7)
+		assert(("x"):rep(3, ",") == "x,x,x")
+		local t = {o = o}
+		assert(select("#", t.o:m(o:m(3))) == 3 and t.o:m(o:m(3)) == 5)
+	`)
+}
+
+// TestStackOverflow runaway recursion raises a catchable error
+func TestStackOverflow(t *testing.T) {
+	doString(t, `
+		local function f() return 1 + f() end
+		local ok, err = pcall(f)
+		assert(not ok and err:find("stack overflow"))
+		assert(err:find("stack traceback:"))
+
+		local function g(...) return g(1, ...) end
+		ok, err = pcall(g)
+		assert(not ok and err:find("stack overflow"))
+
+		local a, b = setmetatable({}, {}), {}
+		setmetatable(b, {__index = a})
+		getmetatable(a).__index = b
+		ok, err = pcall(function() return a.x end)
+		assert(not ok and err:find("loop"))
+
+		assert(select('#', pcall(error)) == 2)
+
+		-- Lua -> Go -> Lua nesting is limited much lower
+		local depth = 0
+		local function h()
+			depth = depth + 1
+			local ok, err = pcall(h)
+			if not ok then error(err, 0) end
+		end
+		ok, err = pcall(h)
+		assert(not ok and err:find("C stack overflow") and depth < 250, depth)
+	`)
+
+	// the error and the traceback tell the lines of the frames
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.DoString(`
+		local f = load("local function f()\n  return 1 + f()\nend\nreturn f()", "=src")
+		local ok, err = pcall(f)
+		assert(err:sub(1, 21) == "src:2: stack overflow", err)
+		assert(err:find("\n\tsrc:2: in function <src:1>\n", 1, true), err)
+		assert(err:find("\n\tsrc:4: in main chunk", 1, true), err)
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+
+	ls = state.New()
+	ls.OpenLibs()
+	ls.SetMaxCalls(100)
+	if ls.DoString(`local function f(n) if n > 0 then f(n-1) end end f(200)`) {
+		if msg := ls.ToString(-1); !strings.Contains(msg, "stack overflow") {
+			t.Fatal(msg)
+		}
+	} else {
+		t.Fatal("call depth is not limited")
+	}
+}
+
+// TestTable array/hash parts, borders and traversal
+func TestTable(t *testing.T) {
+	doString(t, `
+		local t = {}
+		for i = 1, 100 do t[i] = i end
+		assert(#t == 100)
+		t[100] = nil
+		assert(#t == 99)
+
+		local u = {}
+		u[3] = 3; u[2] = 2; u[1] = 1 -- integer keys in the hash part
+		assert(#u == 3)
+
+		local keys = {1.5, true, false, "x", {}, print, 2^53, -1, 0}
+		local m = {}
+		for i, k in ipairs(keys) do m[k] = i end
+		for i, k in ipairs(keys) do assert(m[k] == i) end
+		assert(m[9007199254740992] == 7)
+
+		-- fields may be cleared during traversal
+		for i = 1, 1000 do m["k" .. i] = i; m[i] = i end
+		local n = 0
+		for k in pairs(m) do n = n + 1; m[k] = nil end
+		assert(n == 2000 + #keys and next(m) == nil)
+
+		assert(not pcall(next, {}, "missing"))
+
+		-- strided integer keys spread over the hash part
+		local start, s = os.clock(), {}
+		for i = 1, 40000 do s[i * 65536] = i end
+		for i = 1, 40000 do assert(s[i * 65536] == i) end
+		assert(s[65536 * 40001] == nil and os.clock() - start < 2)
+	`)
+
+	// size hints are only preallocated up to a bound
+	ls := state.New()
+	ls.CreateTable(1<<30, 1<<30)
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		ls.CreateTable(1<<40, 0)
+		return 1
+	})
+	if ls.PCall(0, 1, 0) != api.LUA_ERRRUN || ls.ToString(-1) != "table overflow" {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+// TestDeterministic tables traverse in insertion order
+func TestDeterministic(t *testing.T) {
+	ls := state.New()
+	ls.SetDeterministic(true)
+	ls.OpenLibs()
+	if ls.DoString(`
+		local t, keys = {"a", "b"}, {} -- array part comes first
+		for i = 1, 300 do
+			local k = i % 3 == 0 and {} or i % 3 == 1 and "k" .. i or i + 0.5
+			keys[#keys + 1] = k
+			t[k] = i
+		end
+		local i = 0
+		for k, v in pairs(t) do
+			i = i + 1
+			if i == 1 then assert(k == 1) elseif i == 2 then assert(k == 2)
+			else assert(k == keys[i - 2] and v == i - 2) end
+			t[k] = nil
+		end
+		assert(i == 302 and next(t) == nil)
+
+		-- cleared keys that are set again keep their place
+		local o = {}
+		o.x, o.y, o.z = 1, 2, 3
+		o.x = nil; o.w = 4; o.x = 5
+		local s = ""
+		for k in pairs(o) do s = s .. k end
+		assert(s == "xyzw", s)
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+
+	// the global table is ordered too, also when the mode is set after
+	// the libraries are opened, and for the threads created before
+	globals := `
+		for i = 1, 100 do _G["g" .. i] = i end
+		local i = 0
+		for k, v in pairs(_G) do
+			if type(k) == "string" and k:sub(1, 1) == "g" and tonumber(k:sub(2)) then
+				i = i + 1
+				assert(v == i, k)
+			end
+		end
+		assert(i == 100)
+
+		-- existing fields may be cleared during traversal
+		local n = 0
+		for k, v in pairs(_G) do
+			if type(k) == "string" and k:sub(1, 1) == "g" and tonumber(k:sub(2)) then
+				n = n + 1
+				_G[k] = nil
+			end
+		end
+		assert(n == 100 and g1 == nil and g100 == nil and print)
+	`
+	ls = state.New()
+	ls.SetDeterministic(true)
+	ls.OpenLibs()
+	if ls.DoString(globals) {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls = state.New()
+	ls.OpenLibs()
+	co := ls.NewThread()
+	ls.SetDeterministic(true)
+	if co.DoString(globals) {
+		t.Fatal(co.ToString(-1))
+	}
+}
+
+// TestGC weak tables and finalizers
+func TestGC(t *testing.T) {
+	doString(t, `
+		local strong = {}
+		local wv = setmetatable({}, {__mode = "v"})
+		local wk = setmetatable({}, {__mode = "k"})
+		local function fill()
+			wv.a, wv.b = {}, strong
+			wk[{}] = 1
+			local k = {}
+			wk[k] = {k} -- ephemeron: only its own value refers to the key
+			wk[strong] = 2
+		end
+		fill()
+		collectgarbage()
+		assert(wv.a == nil and wv.b == strong)
+		local n = 0
+		for k, v in pairs(wk) do n = n + 1 end
+		assert(n == 1 and wk[strong] == 2)
+
+		local log = {}
+		local function newObj(name)
+			setmetatable({name = name}, {__gc = function(o) log[#log + 1] = o.name end})
+		end
+		newObj("a"); newObj("b")
+		collectgarbage()
+		assert(#log == 2 and log[1] == "b" and log[2] == "a")
+		collectgarbage()
+		assert(#log == 2)
+
+		assert(type(collectgarbage("count")) == "number")
+		collectgarbage("stop")
+		assert(collectgarbage("isrunning") == false)
+		collectgarbage("restart")
+		assert(collectgarbage("isrunning") and collectgarbage("step"))
+		assert(not pcall(collectgarbage, "bogus"))
+	`)
+}
+
+type testPoint struct {
+	X, Y  int
+	Label string `lua:"label"`
+}
+
+func (p *testPoint) Move(dx, dy int) { p.X += dx; p.Y += dy }
+
+func (p testPoint) String() string { return p.Label }
+
+type testShape struct {
+	testPoint
+	Points []testPoint
+	Tags   map[string]float64
+	Area   func(scale float64) (float64, error)
+	hidden int
+}
+
+// TestGoValue Go values as proxies and back
+func TestGoValue(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	shape := &testShape{
+		testPoint: testPoint{1, 2, "s"},
+		Points:    []testPoint{{X: 1}, {X: 2}},
+		Tags:      map[string]float64{"w": 1.5},
+		Area: func(scale float64) (float64, error) {
+			if scale < 0 {
+				return 0, errors.New("negative scale")
+			}
+			return 10 * scale, nil
+		},
+	}
+	ls.PushGoValue(shape)
+	ls.SetGlobal("shape")
+	ls.PushGoValue(strings.ToUpper)
+	ls.SetGlobal("upper")
+	if ls.DoString(`
+		assert(shape.X == 1 and shape.label == "s" and shape.Label == "s")
+		shape:Move(2, 3.0)
+		assert(shape.X == 3 and shape.Y == 5)
+		shape.X = "7"
+		assert(not pcall(function() shape.X = 1.5 end))
+		assert(not pcall(function() return shape.hidden end))
+		assert(#shape.Points == 2 and shape.Points[2].X == 2 and shape.Points[3] == nil)
+		shape.Points[1].Y = 9
+		shape.Tags.h = 2
+		shape.Tags.w = nil
+		local n = 0
+		for k, v in pairs(shape.Tags) do n = n + 1 assert(k == "h" and v == 2) end
+		assert(n == 1 and #shape.Tags == 1)
+		assert(shape.Area(2) == 20)
+		local ok, err = pcall(shape.Area, -1)
+		assert(not ok and err == "negative scale")
+		assert(upper("abc") == "ABC")
+		assert(tostring(shape.Points[1]) == "" and shape.Points == shape.Points)
+		pt = {X = 4, label = "p"}
+		list = {1, 2.0, "3"}
+		any = {a = {1, 2}, b = true}
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+	if shape.X != 7 || shape.Points[0].Y != 9 || shape.Tags["h"] != 2 {
+		t.Fatalf("%+v", shape)
+	}
+
+	var pt testPoint
+	ls.GetGlobal("pt")
+	if err := ls.ToGoValue(-1, &pt); err != nil || pt.X != 4 || pt.Label != "p" {
+		t.Fatal(pt, err)
+	}
+	var list []int
+	ls.GetGlobal("list")
+	if err := ls.ToGoValue(-1, &list); err != nil || len(list) != 3 || list[2] != 3 {
+		t.Fatal(list, err)
+	}
+	var any interface{}
+	ls.GetGlobal("any")
+	if err := ls.ToGoValue(-1, &any); err != nil {
+		t.Fatal(err)
+	}
+	if m := any.(map[string]interface{}); m["b"] != true || m["a"].([]interface{})[1] != int64(2) {
+		t.Fatal(any)
+	}
+	var sp *testShape
+	ls.GetGlobal("shape")
+	if err := ls.ToGoValue(-1, &sp); err != nil || sp != shape {
+		t.Fatal(sp, err)
+	}
+	var f func(string) string
+	ls.GetGlobal("string")
+	ls.GetField(-1, "upper")
+	if err := ls.ToGoValue(-1, &f); err != nil || f("ab") != "AB" {
+		t.Fatal(err)
+	}
+	var u8 uint8
+	ls.PushInteger(300)
+	if err := ls.ToGoValue(-1, &u8); err == nil {
+		t.Fatal("overflow not detected")
+	}
+}
+
+// TestRegisterFunc ordinary Go funcs as Lua functions
+func TestRegisterFunc(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.RegisterFunc("add", func(a, b int) int { return a + b })
+	ls.RegisterFunc("join", func(sep string, parts ...string) string { return strings.Join(parts, sep) })
+	ls.RegisterFunc("div", func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	})
+	ls.RegisterFunc("opt", func(n *int) (bool, int) {
+		if n == nil {
+			return false, 0
+		}
+		return true, *n
+	})
+	ls.NewTable()
+	ls.SetGoFuncs(api.GoFuncReg{"pair": func() (string, int) { return "a", 1 }})
+	ls.SetGlobal("m")
+	if ls.DoString(`
+		assert(add(1, "2") == 3 and add(1, 2, 3) == 3)
+		assert(join("-", "a", "b", "c") == "a-b-c" and join(",") == "")
+		assert(div(1, 4) == 0.25)
+		local ok, err = pcall(div, 1, 0)
+		assert(not ok and err == "division by zero")
+		ok, err = pcall(add, 1, "x")
+		assert(err == "bad argument #2 to 'add' (integer expected, got string)", err)
+		ok, err = pcall(add, 1)
+		assert(err == "bad argument #2 to 'add' (integer expected, got no value)", err)
+		ok, err = pcall(add, 1.5, 1)
+		assert(err:find("#1") and err:find("no integer representation"))
+		local given, n = opt()
+		assert(not given and n == 0)
+		given, n = opt(5)
+		assert(given and n == 5)
+		local k, v = m.pair()
+		assert(k == "a" and v == 1)
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+type testServer struct {
+	Host string `lua:"host"`
+	Port int    `lua:"port"`
+}
+
+type testBase struct {
+	Name string `lua:"name"`
+}
+
+type testConfig struct {
+	testBase
+	Servers []testServer       `lua:"servers"`
+	Limits  map[string]float64 `lua:"limits,omitempty"`
+	Timeout time.Duration      `lua:"timeout"`
+	Debug   bool               `lua:"debug,omitempty"`
+	Next    *testConfig        `lua:"next,omitempty"`
+}
+
+// TestEncodeDecode marshalling between tables and Go values
+func TestEncodeDecode(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.DoString(`
+		config = {
+			name = "main",
+			servers = {{host = "a", port = 80}, {host = "b", port = "8080"}},
+			timeout = "1m30s",
+			unknown = true,
+		}
+		bad = {servers = {{port = 1}, {port = "x"}}}
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+	cfg := testConfig{Debug: true}
+	ls.GetGlobal("config")
+	if err := ls.Decode(-1, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "main" || len(cfg.Servers) != 2 || cfg.Servers[1].Port != 8080 ||
+		cfg.Timeout != 90*time.Second || !cfg.Debug {
+		t.Fatalf("%+v", cfg)
+	}
+	var bad testConfig
+	ls.GetGlobal("bad")
+	if err := ls.Decode(-1, &bad); err == nil || err.Error() != "servers[2].port: expected integer, got string" {
+		t.Fatal(err)
+	}
+
+	cfg.Debug = false
+	if err := ls.Encode(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	ls.SetGlobal("encoded")
+	if ls.DoString(`
+		local e = encoded
+		assert(e.name == "main" and e.timeout == "1m30s" and e.servers[2].host == "b")
+		assert(e.debug == nil and e.limits == nil and e.next == nil and e.testBase == nil)
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+	cfg.Next = &cfg
+	if err := ls.Encode(&cfg); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatal(err)
+	}
+	if ls.DoString(`cyclic = {} cyclic.next = cyclic`) {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.GetGlobal("cyclic")
+	if err := ls.Decode(-1, &cfg); err == nil || !strings.Contains(err.Error(), "cycles") {
+		t.Fatal(err)
+	}
+}
+
+// TestFacade the embedding API of the root package
+func TestFacade(t *testing.T) {
+	var out strings.Builder
+	L := NewState(WithStdout(&out), WithMaxCalls(500), WithPath("./?.lua"))
+	if err := L.DoString(`
+		print("hello", 1)
+		function add(a, b) return a + b end
+		function fail(v) error(v) end
+		function failObj() error(setmetatable({}, {__tostring = function() return "custom" end})) end
+		conf = {name = "x", list = {1, 2}}
+		assert(package.path == "./?.lua")
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello\t1\n" {
+		t.Fatalf("%q", out.String())
+	}
+	res, err := L.Call("add", 1, 2.5)
+	if err != nil || len(res) != 1 || res[0] != 3.5 {
+		t.Fatal(res, err)
+	}
+	if res, err := L.Call("string.rep", "ab", 2); err != nil || res[0] != "abab" {
+		t.Fatal(res, err)
+	}
+	if _, err := L.Call("fail", "boom"); err == nil || err.Error() != "boom" {
+		t.Fatal(err)
+	}
+	if _, err := L.Call("failObj"); err == nil || err.Error() != "custom" {
+		t.Fatal(err)
+	}
+	if err := L.DoString(`local function f() return f() + 1 end f()`); err == nil ||
+		!strings.Contains(err.Error(), "stack overflow") {
+		t.Fatal(err)
+	}
+	if err := L.DoString(`x = = 1`); err == nil {
+		t.Fatal("syntax error not reported")
+	}
+	if err := L.DoFile("does-not-exist.lua"); err == nil {
+		t.Fatal("missing file not reported")
+	}
+
+	L.SetGlobal("double", func(n int) int { return 2 * n })
+	L.SetGlobal("limit", 10)
+	if err := L.DoString(`assert(double(limit) == 20 and type(double) == "function")`); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := L.GetGlobal("conf")
+	if m, ok := conf.(map[string]interface{}); err != nil || !ok || m["name"] != "x" {
+		t.Fatal(conf, err)
+	}
+	if err := L.DoString(`keep = setmetatable({}, {__gc = function() print("bye") end})`); err != nil {
+		t.Fatal(err)
+	}
+	L.Close()
+	if !strings.HasSuffix(out.String(), "bye\n") {
+		t.Fatalf("%q", out.String())
+	}
+
+	L = NewState(WithLibs("_G"))
+	defer L.Close()
+	if err := L.DoString(`assert(string == nil and print ~= nil)`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStreams io library on the streams of the state and on files
+func TestStreams(t *testing.T) {
+	var out, errOut strings.Builder
+	L := NewState(WithStdin(strings.NewReader("12 0x1p4 rest\nline 2\nlast")),
+		WithStdout(&out), WithStderr(&errOut))
+	defer L.Close()
+	if err := L.DoString(`
+		local a, b, s = io.read("n", "n", "l")
+		assert(a == 12 and b == 16.0 and s == " rest")
+		local lines = {}
+		for l in io.lines() do lines[#lines+1] = l end
+		assert(#lines == 2 and lines[1] == "line 2" and lines[2] == "last")
+		assert(io.read() == nil and io.read("a") == "")
+		print("p", 1)
+		io.write("w", 2, "\n")
+		assert(io.stdout:write("x") == io.stdout)
+		io.stderr:write("oops")
+		assert(io.type(io.stdout) == "file" and io.type(42) == nil)
+		local ok, msg = io.close(io.stdout)
+		assert(ok == nil and msg == "cannot close standard file")
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "p\t1\nw2\nx" || errOut.String() != "oops" {
+		t.Fatalf("%q %q", out.String(), errOut.String())
+	}
+
+	// streams set later are used by the existing handles
+	var out2 strings.Builder
+	L.LuaState().SetStdout(&out2)
+	L.LuaState().SetStdin(strings.NewReader("again\n"))
+	if err := L.DoString(`io.write(io.read("L"))`); err != nil {
+		t.Fatal(err)
+	}
+	if out2.String() != "again\n" {
+		t.Fatalf("%q", out2.String())
+	}
+
+	f, err := ioutil.TempFile("", "glua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	L.SetGlobal("fname", f.Name())
+	if err := L.DoString(`
+		local f = assert(io.open(fname, "w+"))
+		f:write("one\n", 2, "\nthree")
+		assert(f:seek("set") == 0)
+		assert(f:read("l") == "one" and f:read("n") == 2)
+		f:seek("set", 4)
+		f:write("X")
+		f:seek("set")
+		assert(f:read("a") == "one\nX\nthree")
+		f:close()
+		assert(io.type(f) == "closed file" and tostring(f) == "file (closed)")
+		local n = 0
+		for a, b in io.lines(fname, 1, "l") do n = n + 1 end
+		assert(n == 3)
+		assert(not pcall(f.read, f))
+		local ok, msg = io.open(fname .. "/none")
+		assert(ok == nil and msg:find("none"))
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRef registry references and LuaRef
+func TestRef(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.DoString(`function greet(name) return "hi " .. name, #name end`)
+
+	ls.GetGlobal("greet")
+	ref := api.NewLuaRef(ls)
+	ls.PushNil()
+	if r := ls.Ref(api.LUA_REGISTRYINDEX); r != api.LUA_REFNIL {
+		t.Fatal(r)
+	}
+	ls.DoString(`greet = nil collectgarbage()`)
+	if ls.GetTop() != 0 {
+		t.Fatal(ls.GetTop())
+	}
+
+	res, err := ref.Call("bob")
+	if err != nil || len(res) != 2 || res[0] != "hi bob" || res[1] != int64(3) {
+		t.Fatal(res, err)
+	}
+	if _, err := ref.Call(nil); err == nil || !strings.Contains(err.Error(), "concatenat") {
+		t.Fatal(err)
+	}
+
+	// released references are reused
+	r := ref.Ref()
+	ref.Release()
+	ref.Release()
+	ref.Push()
+	if !ls.IsNil(-1) {
+		t.Fatal("released reference still pushes a value")
+	}
+	ls.PushString("again")
+	if r2 := ls.Ref(api.LUA_REGISTRYINDEX); r2 != r {
+		t.Fatal(r, r2)
+	}
+	ls.RawGetI(api.LUA_REGISTRYINDEX, int64(r))
+	if ls.ToString(-1) != "again" {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+// TestCAPI userdata, light userdata, the auxiliary functions and to-be-closed slots
+func TestCAPI(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+
+	// userdata with a named metatable and a user value
+	type counter struct{ n int }
+	if !ls.NewMetatable("Counter") || ls.NewMetatable("Counter") {
+		t.Fatal("NewMetatable")
+	}
+	ls.SetTop(0)
+	c := &counter{}
+	ls.NewUserdata(c)
+	ls.SetMetatable2("Counter")
+	ls.NewTable()
+	ls.SetUserValue(1)
+	if ls.CheckUdata(1, "Counter") != c || ls.TestUdata(1, "Other") != nil ||
+		!ls.IsUserdata(1) || ls.GetUserValue(1) != api.LUA_TTABLE {
+		t.Fatal("userdata")
+	}
+	ls.SetTop(0)
+
+	// light userdata keys
+	key := new(int)
+	ls.NewTable()
+	ls.PushString("v")
+	ls.RawSetP(1, key)
+	ls.PushLightUserdata(key)
+	if ls.RawGetP(1, key) != api.LUA_TSTRING || !ls.IsLightUserdata(2) ||
+		ls.ToUserdata(2) != key || !ls.RawEqual(2, 2) {
+		t.Fatal("light userdata")
+	}
+	ls.PushLightUserdata(key)
+	if !ls.RawEqual(2, 4) {
+		t.Fatal("light userdata equality")
+	}
+	ls.SetTop(0)
+
+	// options, gsub, where and traceback
+	ls.Register("opt", func(ls api.LuaState) int {
+		ls.PushInteger(int64(ls.CheckOption(1, "b", []string{"a", "b"})))
+		ls.Where(1)
+		ls.Traceback(ls, "msg", 1)
+		return 3
+	})
+	if ls.DoString(`
+		local i, w, tb = opt()
+		assert(i == 1 and w:find(":2: $") and tb:find("^msg\nstack traceback:\n"))
+		assert(not pcall(opt, "c"))
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+	if ls.Gsub("a.b.c", ".", "/") != "a/b/c" {
+		t.Fatal("Gsub")
+	}
+	ls.SetTop(0)
+
+	// dump and load again
+	if ls.DoString(`
+		local f = function(a, ...) local t = {...} return a .. #t .. "` + strings.Repeat("x", 300) + `" end
+		local g = load(string.dump(f))
+		assert(g("n", 1, 2) == f("n", 1, 2))
+		assert(load(string.dump(f, true))("n") == f("n"))
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+
+	// to-be-closed slots
+	ls.DoString(`
+		closed = {}
+		local mt = {__close = function(v, err) closed[#closed+1] = v.name .. ":" .. tostring(err) end}
+		function tbc(name) return setmetatable({name = name}, mt) end
+	`)
+	ls.Register("useTBC", func(ls api.LuaState) int {
+		ls.GetGlobal("tbc")
+		ls.PushString("a")
+		ls.Call(1, 1)
+		ls.ToClose(-1)
+		ls.GetGlobal("tbc")
+		ls.PushString("b")
+		ls.Call(1, 1)
+		ls.ToClose(-1)
+		if ls.ToBoolean(1) {
+			ls.PushString("boom")
+			ls.Error()
+		}
+		return 0
+	})
+	if ls.DoString(`
+		useTBC(false)
+		assert(table.concat(closed, " ") == "b:nil a:nil")
+		closed = {}
+		assert(select(2, pcall(useTBC, true)) == "boom")
+		assert(table.concat(closed, " ") == "b:boom a:boom")
+	`) {
+		t.Fatal(ls.ToString(-1))
+	}
+
+	b := api.NewBuffer(ls)
+	b.AddString("ab")
+	b.AddChar('c')
+	ls.PushInteger(42)
+	b.AddValue()
+	b.PushResult()
+	if ls.ToString(-1) != "abc42" {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+// TestConcat concatenation, table.concat and string.rep
+func TestConcat(t *testing.T) {
+	doString(t, `
+		local parts = {}
+		for i = 1, 20000 do parts[i] = i end
+		local s = table.concat(parts, ",")
+		assert(#s == 108893 and s:sub(1, 6) == "1,2,3," and s:sub(-6) == ",20000")
+		assert(table.concat(parts, "-", 3, 5) == "3-4-5" and table.concat({}, "x") == "")
+		assert(not pcall(table.concat, {1, {}, 3}))
+		local mt = {__concat = function(a, b)
+			return (type(a) == "table" and "T" or a) .. (type(b) == "table" and "T" or b)
+		end}
+		local o = setmetatable({}, mt)
+		assert("a" .. 1 .. "b" .. o .. "c" .. 2.5 == "a1bTc2.5")
+		assert(o .. "x" .. "y" == "Txy" and "x" .. "y" .. o == "xyT")
+		assert(string.rep("ab", 3, ",") == "ab,ab,ab" and string.rep("x", 0) == "")
+		assert(not pcall(string.rep, "x", 1 << 40))
+		assert(string.format("%d%%%s", 5, "x") == "5%x")
+	`)
+}
+
+// TestLua54 the Lua 5.4 mode
+func TestLua54(t *testing.T) {
+	var errOut strings.Builder
+	L := NewState(WithVersion(api.LUA_VERSION_54), WithStderr(&errOut))
+	defer L.Close()
+	if err := L.DoString(`
+		assert(_VERSION == "Lua 5.4")
+		local log = {}
+		local function closer(name)
+			return setmetatable({}, {__close = function(_, err)
+				log[#log+1] = name .. ":" .. tostring(err)
+			end})
+		end
+		do
+			local a <close> = closer("a")
+			local b <const>, c <close> = 1, closer("c")
+			local d <close> = nil
+		end
+		assert(table.concat(log, ",") == "c:nil,a:nil")
+		log = {}
+		for i = 1, 3 do
+			local x <close> = closer("x" .. i)
+			if i == 2 then break end
+		end
+		assert(table.concat(log, ",") == "x1:nil,x2:nil")
+		log = {}
+		local function f() local y <close> = closer("y"); error("boom", 0) end
+		assert(not pcall(f) and log[1] == "y:boom")
+		assert(not load("local x <const> = 1; x = 2"))
+		assert(not load("local x <foo> = 1"))
+		assert(not pcall(function() local z <close> = 42 end))
+
+		local n = 0
+		for i = math.maxinteger - 2, math.maxinteger do n = n + 1 end
+		assert(n == 3)
+		n = 0
+		for i = math.mininteger, math.mininteger + 2, 1 do n = n + 1 end
+		assert(n == 3)
+		for i = 1, 0 do error("not skipped") end
+		n = 0
+		for i = 1, 2.9 do n = i end
+		assert(n == 2 and math.type(n) == "integer")
+		assert(not pcall(function() for i = 1, 10, 0 do end end))
+
+		log = {}
+		local co = coroutine.create(function()
+			local w <close> = closer("w")
+			coroutine.yield(1)
+		end)
+		assert(coroutine.resume(co))
+		assert(coroutine.close(co) and coroutine.status(co) == "dead")
+		assert(log[1] == "w:nil")
+		co = coroutine.create(function() error("bad", 0) end)
+		coroutine.resume(co)
+		local ok, err = coroutine.close(co)
+		assert(not ok and err == "bad")
+
+		assert("10" + 1 == 11 and "3" * "4" == 12 and -"2" == -2)
+		assert(not pcall(function() return "x" + 1 end))
+		local lt = setmetatable({}, {__lt = function() return true end})
+		assert(not pcall(function() return lt <= lt end))
+
+		warn("@on")
+		warn("hello ", "world")
+		math.randomseed(42)
+		local r1 = math.random(1, 100)
+		math.randomseed(42)
+		assert(math.random(1, 100) == r1 and math.random(0) ~= nil)
+		assert(utf8.len("\u{7FFFFFFF}") == nil and utf8.len("\u{7FFFFFFF}", 1, -1, true) == 1)
+		assert(utf8.char(0x7FFFFFFF) == "\u{7FFFFFFF}")
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if errOut.String() != "Lua warning: hello world\n" {
+		t.Fatalf("%q", errOut.String())
+	}
+
+	L53 := NewState()
+	defer L53.Close()
+	if err := L53.DoString(`
+		assert(_VERSION == "Lua 5.3" and warn == nil and coroutine.close == nil)
+		assert("10" + 1 == 11)
+		assert(not load("local x <const> = 1"))
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCompat51 the Lua 5.1 compatibility mode
+func TestCompat51(t *testing.T) {
+	L := NewState(WithVersion(api.LUA_VERSION_51))
+	defer L.Close()
+	if err := L.DoString(`
+		assert(_VERSION == "Lua 5.1")
+		local a, b, c = unpack({1, 2, 3})
+		assert(a == 1 and c == 3 and table.unpack == unpack)
+		assert(loadstring("return 1 + 1")() == 2)
+		assert(table.getn({1, 2, 3}) == 3 and table.maxn({1, 2, [10] = 3}) == 10)
+		assert(math.pow(2, 10) == 1024 and math.ldexp(0.5, 4) == 8)
+		local m, e = math.frexp(8)
+		assert(m == 0.5 and e == 4)
+		local words = {}
+		for w in string.gfind("one two", "[a-z]+") do words[#words+1] = w end
+		assert(#words == 2)
+
+		assert(bit32.band(0xFF, 0x0F) == 0x0F and bit32.bnot(0) == 0xFFFFFFFF)
+		assert(bit32.rshift(0x80000000, 31) == 1 and bit32.arshift(0x80000000, 31) == 0xFFFFFFFF)
+		assert(bit32.extract(0xF0, 4, 4) == 0xF and bit32.replace(0, 1, 31) == 0x80000000)
+		assert(bit32.lrotate(1, 33) == 2 and bit32.btest(1, 3))
+		assert(bit.tobit(0xFFFFFFFF) == -1 and bit.tohex(255) == "000000ff" and bit.tohex(-1, -4) == "FFFF")
+		assert(bit.bor(1, 2, 4) == 7 and bit.lshift(1, 31) == -2147483648)
+		assert(bit.rol(1, 1) == 2 and bit.ror(1, 1) == -2147483648 and bit.bswap(0x12345678) == 0x78563412)
+
+		local env = {print = print}
+		local function f() return x end
+		setfenv(f, env)
+		env.x = 42
+		assert(f() == 42 and getfenv(f) == env and x == nil)
+		local function g() return x end
+		assert(g() == nil and getfenv(0) == _G)
+		local function h()
+			setfenv(1, {y = 7})
+			return y
+		end
+		assert(h() == 7)
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := L.DoString(`
+		local chunk = loadstring([[
+			module("legacy.util", package.seeall)
+			function twice(x) return 2 * x end
+			assert(type(print) == "function")
+		]])
+		chunk()
+		assert(legacy.util.twice(4) == 8 and legacy.util._NAME == "legacy.util")
+		assert(legacy.util._PACKAGE == "legacy." and twice == nil)
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLoadSyntaxErrors syntax errors reported by load and LoadString
+func TestLoadSyntaxErrors(t *testing.T) {
+	ls := state.New()
+	if ls.LoadString("x = = 1") != api.LUA_ERRSYNTAX ||
+		ls.ToString(-1) != `[string "x = = 1"]:1: syntax error near '='` {
+		t.Fatal(ls.ToString(-1))
+	}
+	doString(t, `
+		local f, err = load("return 1 +", "=chunk")
+		assert(f == nil and err:find("^chunk:1:"))
+		assert(not load("return ...x", "=c") and load("return ...")() == nil)
+		assert(select(2, load("local function f() return ... end")):find("outside a vararg"))
+	`)
+}
+
+// TestLoadFile loadfile honours its mode argument
+func TestLoadFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "glua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("return 42")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	doString(t, fmt.Sprintf(`
+		local path = %q
+		assert(loadfile(path)() == 42)
+		assert(loadfile(path, "t")() == 42)
+		local f, err = loadfile(path, "b")
+		assert(f == nil and err == "attempt to load a text chunk (mode is 'b')", err)
+	`, f.Name()))
+}
+
+// TestLoadReader load from reader functions and io.Readers
+func TestLoadReader(t *testing.T) {
+	doString(t, `
+		local pieces = {"ret", "urn 4", "2 + ", "...", nil}
+		local i = 0
+		local f = assert(load(function() i = i + 1; return pieces[i] end))
+		assert(f(1) == 43 and i == 5)
+		local done
+		f = load(function() if not done then done = true; return "return" end end, "=r")
+		assert(f() == nil)
+		local f, err = load(function() return {} end)
+		assert(f == nil and err == "reader function must return a string")
+		f, err = load(function() error("broken", 0) end)
+		assert(f == nil and err == "broken")
+		f, err = load(function() return "x =" end)
+		assert(f == nil and err:sub(1, 10) == "(load):1: ", err)
+		f, err = load(string.dump(function() end), "d", "t")
+		assert(f == nil and err == "attempt to load a binary chunk (mode is 't')")
+		f, err = load("return 1", "c", "b")
+		assert(f == nil and err == "attempt to load a text chunk (mode is 'b')")
+		f, err = load("\27Lua", "x", "t")
+		assert(f == nil and err == "attempt to load a binary chunk (mode is 't')", err)
+		f, err = load("\27Lua", "=x")
+		assert(f == nil and err == "x: bad binary format (truncated precompiled chunk)", err)
+		assert(not pcall(load, {}))
+	`)
+
+	ls := state.New()
+	src := "local t = {}\nfor i = 1, 1000 do t[i] = i end\nreturn #t, ..."
+	if ls.LoadReader(iotest.OneByteReader(strings.NewReader(src)), "=src", "t") != api.LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.PushString("x")
+	ls.Call(1, 2)
+	if ls.ToInteger(-2) != 1000 || ls.ToString(-1) != "x" {
+		t.Fatal(ls.ToInteger(-2), ls.ToString(-1))
+	}
+	ls.Pop(2)
+	r := io.MultiReader(strings.NewReader("return 1 +"), iotest.TimeoutReader(strings.NewReader(" 1")))
+	if ls.LoadReader(r, "=src", "bt") != api.LUA_ERRRUN || ls.ToString(-1) != iotest.ErrTimeout.Error() {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.Pop(1)
+	if ls.LoadReader(strings.NewReader("return return"), "=src", "bt") != api.LUA_ERRSYNTAX ||
+		ls.ToString(-1) != "src:1: syntax error near 'return'" {
+		t.Fatal(ls.ToString(-1))
+	}
+}
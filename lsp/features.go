@@ -0,0 +1,404 @@
+package lsp
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/iglev/glua/compiler/ast"
+	"github.com/iglev/glua/compiler/lint"
+	"github.com/iglev/glua/compiler/printer"
+)
+
+// pathAt returns the nodes enclosing pos, from the chunk to the innermost
+// one. A name ending at pos encloses it, for the cursor just after it.
+func (d *document) pathAt(pos ast.Pos) []ast.Node {
+	if d.block == nil {
+		return nil
+	}
+	var path, stack []ast.Node
+	ast.Inspect(d.block, func(node ast.Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+		if node.Pos() != node.End() { // synthesized nodes have empty spans
+			if before(pos, node.Pos()) || before(node.End(), pos) {
+				return false
+			}
+			path = append(stack[:len(stack):len(stack)], node)
+		}
+		stack = append(stack, node)
+		return true
+	})
+	return path
+}
+
+func before(a, b ast.Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// variableAt returns the local variable named at p, nil if none.
+func (d *document) variableAt(p Position) *lint.Variable {
+	path := d.pathAt(d.pos(p))
+	if len(path) == 0 || d.res == nil {
+		return nil
+	}
+	if name, ok := path[len(path)-1].(*ast.NameExp); ok {
+		return d.res.Locals[name]
+	}
+	return nil
+}
+
+func (d *document) definition(p Position) []Location {
+	v := d.variableAt(p)
+	if v == nil {
+		return nil
+	}
+	return []Location{{d.uri, d.rangeOf(v.Decl)}}
+}
+
+func (d *document) references(p Position, includeDecl bool) []Location {
+	v := d.variableAt(p)
+	if v == nil {
+		return nil
+	}
+	locs := []Location{}
+	if includeDecl {
+		locs = append(locs, Location{d.uri, d.rangeOf(v.Decl)})
+	}
+	refs := append([]*ast.NameExp(nil), v.Refs...)
+	sort.Slice(refs, func(i, j int) bool { return before(refs[i].Pos(), refs[j].Pos()) })
+	for _, ref := range refs {
+		locs = append(locs, Location{d.uri, d.rangeOf(ref)})
+	}
+	return locs
+}
+
+// accessPath returns the name exp starts from and the names of the fields
+// it accesses, as ["a", "b", "c"] for a.b.c, nil if exp is not such an
+// access.
+func accessPath(exp ast.Exp) (*ast.NameExp, []string) {
+	switch exp := exp.(type) {
+	case *ast.NameExp:
+		return exp, []string{exp.Name}
+	case *ast.TableAccessExp:
+		if key, ok := exp.KeyExp.(*ast.StringExp); ok {
+			if name, path := accessPath(exp.PrefixExp); name != nil {
+				return name, append(path, key.Str)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// targetPath returns the names of the variable and the fields exp
+// accesses, if the variable is root, or a global if root is nil.
+func (d *document) targetPath(exp ast.Exp, root *lint.Variable) []string {
+	name, path := accessPath(exp)
+	if name == nil {
+		return nil
+	}
+	var v *lint.Variable
+	if d.res != nil {
+		v = d.res.Locals[name]
+	}
+	if v != root {
+		return nil
+	}
+	return path
+}
+
+func (d *document) hover(p Position, stubs map[string]*Stub) *Hover {
+	path := d.pathAt(d.pos(p))
+	if len(path) == 0 {
+		return nil
+	}
+	node := path[len(path)-1]
+	if name, ok := node.(*ast.NameExp); ok && d.res != nil {
+		if v := d.res.Locals[name]; v != nil {
+			r := d.rangeOf(name)
+			return &Hover{MarkupContent{"markdown", "```lua\n(" + v.Kind + ") " + v.Name + "\n```"}, &r}
+		}
+	}
+	// the key of a field access is hovered as the access
+	if len(path) > 1 {
+		if access, ok := path[len(path)-2].(*ast.TableAccessExp); ok && access.KeyExp == node {
+			node = access
+		}
+	}
+	exp, ok := node.(ast.Exp)
+	if !ok {
+		return nil
+	}
+	fields := d.targetPath(exp, nil)
+	stub := lookupStub(stubs, fields)
+	if stub == nil {
+		return nil
+	}
+	r := d.rangeOf(node)
+	return &Hover{MarkupContent{"markdown", stub.hoverText(strings.Join(fields, "."))}, &r}
+}
+
+var (
+	fieldPrefix = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*(?:\s*\.\s*[A-Za-z_][A-Za-z0-9_]*)*)\s*[.:]\s*[A-Za-z0-9_]*$`)
+	keywords    = []string{
+		"and", "break", "do", "else", "elseif", "end", "false", "for",
+		"function", "goto", "if", "in", "local", "nil", "not", "or",
+		"repeat", "return", "then", "true", "until", "while",
+	}
+)
+
+func (d *document) completion(p Position, stubs map[string]*Stub) []CompletionItem {
+	pos := d.pos(p)
+	prefix := d.line(p.Line)[:pos.Column-1]
+	items := []CompletionItem{}
+	seen := map[string]bool{}
+	add := func(item CompletionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+
+	var visible []*lint.Variable
+	if d.res != nil {
+		visible = d.res.VisibleAt(pos)
+	}
+	if m := fieldPrefix.FindStringSubmatch(prefix); m != nil {
+		var path []string
+		for _, name := range strings.Split(m[1], ".") {
+			path = append(path, strings.TrimSpace(name))
+		}
+		var root *lint.Variable
+		for _, v := range visible {
+			if v.Name == path[0] {
+				root = v
+			}
+		}
+		if root == nil {
+			if stub := lookupStub(stubs, path); stub != nil {
+				for name, field := range stub.Fields {
+					add(CompletionItem{Label: name, Kind: field.completionKind(), Detail: field.Signature, Documentation: field.Doc})
+				}
+			}
+		}
+		for name, kind := range d.fields(root, path) {
+			add(CompletionItem{Label: name, Kind: kind})
+		}
+		sortItems(items)
+		return items
+	}
+
+	for _, v := range visible {
+		add(CompletionItem{Label: v.Name, Kind: CompletionVariable, Detail: v.Kind})
+	}
+	for name, stub := range stubs {
+		add(CompletionItem{Label: name, Kind: stub.completionKind(), Detail: stub.Signature, Documentation: stub.Doc})
+	}
+	for name, kind := range d.fields(nil, nil) {
+		add(CompletionItem{Label: name, Kind: kind})
+	}
+	for _, kw := range keywords {
+		add(CompletionItem{Label: kw, Kind: CompletionKeyword})
+	}
+	sortItems(items)
+	return items
+}
+
+func sortItems(items []CompletionItem) {
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+}
+
+// fields returns the names, and their completion kinds, the document
+// assigns in the table at path, the fields of the global or of the local
+// variable root. The table constructors assigned to the path count. With
+// a nil path, fields returns the globals the document assigns.
+func (d *document) fields(root *lint.Variable, path []string) map[string]int {
+	fields := map[string]int{}
+	kindOf := func(exp ast.Exp) int {
+		if _, ok := exp.(*ast.FuncDefExp); ok {
+			return CompletionFunction
+		}
+		return CompletionField
+	}
+	addKeys := func(exp ast.Exp) {
+		if tc, ok := exp.(*ast.TableConstructorExp); ok {
+			for i, key := range tc.KeyExps {
+				if s, ok := key.(*ast.StringExp); ok && isName(s.Str) {
+					fields[s.Str] = kindOf(tc.ValExps[i])
+				}
+			}
+		}
+	}
+	// matches tells whether exp is the table at path followed by n fields.
+	matches := func(exp ast.Exp, n int) (string, bool) {
+		names := d.targetPath(exp, root)
+		if len(names) != len(path)+n {
+			return "", false
+		}
+		for i, name := range path {
+			if names[i] != name {
+				return "", false
+			}
+		}
+		if n == 0 {
+			return "", true
+		}
+		return names[len(names)-1], true
+	}
+
+	if d.block == nil {
+		return fields
+	}
+	ast.Inspect(d.block, func(node ast.Node) bool {
+		switch stat := node.(type) {
+		case *ast.AssignStat:
+			for i, v := range stat.VarList {
+				var val ast.Exp
+				if i < len(stat.ExpList) {
+					val = stat.ExpList[i]
+				}
+				if name, ok := matches(v, 1); ok {
+					fields[name] = kindOf(val)
+				} else if _, ok := matches(v, 0); ok && path != nil {
+					addKeys(val)
+				}
+			}
+		case *ast.LocalVarDeclStat:
+			if root != nil && len(path) == 1 {
+				for i, name := range stat.Names {
+					if d.res.Locals[name] == root && i < len(stat.ExpList) {
+						addKeys(stat.ExpList[i])
+					}
+				}
+			}
+		}
+		return true
+	})
+	return fields
+}
+
+func isName(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// symbols returns the functions of the document, nested as they are, and
+// the variables of the chunk.
+func (d *document) symbols() []DocumentSymbol {
+	if d.block == nil {
+		return []DocumentSymbol{}
+	}
+	return d.blockSymbols(d.block, true)
+}
+
+func (d *document) blockSymbols(block *ast.Block, chunk bool) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, stat := range block.Stats {
+		switch stat := stat.(type) {
+		case *ast.LocalFuncDefStat:
+			symbols = append(symbols, d.funcSymbol(stat.Name, SymbolFunction, stat, stat.NameExp, stat.Exp))
+		case *ast.LocalVarDeclStat:
+			for i, name := range stat.Names {
+				var val ast.Exp
+				if i < len(stat.ExpList) {
+					val = stat.ExpList[i]
+				}
+				if f, ok := val.(*ast.FuncDefExp); ok {
+					symbols = append(symbols, d.funcSymbol(name.Name, SymbolFunction, stat, name, f))
+				} else if chunk {
+					symbols = append(symbols, d.varSymbol(name.Name, stat, name))
+				}
+			}
+		case *ast.AssignStat:
+			for i, v := range stat.VarList {
+				var val ast.Exp
+				if i < len(stat.ExpList) {
+					val = stat.ExpList[i]
+				}
+				_, names := accessPath(v)
+				if names == nil {
+					continue
+				}
+				name := strings.Join(names, ".")
+				if f, ok := val.(*ast.FuncDefExp); ok {
+					kind := SymbolFunction
+					if f.Pos() == stat.Pos() && len(names) > 1 && len(f.ParList) == len(f.Params)+1 {
+						kind = SymbolMethod
+						name = strings.Join(names[:len(names)-1], ".") + ":" + names[len(names)-1]
+					}
+					symbols = append(symbols, d.funcSymbol(name, kind, stat, v, f))
+				} else if chunk && len(names) == 1 {
+					symbols = append(symbols, d.varSymbol(name, stat, v))
+				}
+			}
+		case *ast.DoStat:
+			symbols = append(symbols, d.blockSymbols(stat.Block, false)...)
+		case *ast.WhileStat:
+			symbols = append(symbols, d.blockSymbols(stat.Block, false)...)
+		case *ast.RepeatStat:
+			symbols = append(symbols, d.blockSymbols(stat.Block, false)...)
+		case *ast.IfStat:
+			for _, b := range stat.Blocks {
+				symbols = append(symbols, d.blockSymbols(b, false)...)
+			}
+		case *ast.ForNumStat:
+			symbols = append(symbols, d.blockSymbols(stat.Block, false)...)
+		case *ast.ForInStat:
+			symbols = append(symbols, d.blockSymbols(stat.Block, false)...)
+		}
+	}
+	return symbols
+}
+
+func (d *document) funcSymbol(name string, kind int, stat ast.Stat, sel ast.Node, f *ast.FuncDefExp) DocumentSymbol {
+	params := f.ParList
+	if kind == SymbolMethod {
+		params = params[1:]
+	}
+	if f.IsVararg {
+		params = append(params[:len(params):len(params)], "...")
+	}
+	return DocumentSymbol{
+		Name:           name,
+		Detail:         "function(" + strings.Join(params, ", ") + ")",
+		Kind:           kind,
+		Range:          d.rangeOf(stat),
+		SelectionRange: d.rangeOf(sel),
+		Children:       d.blockSymbols(f.Block, false),
+	}
+}
+
+func (d *document) varSymbol(name string, stat ast.Stat, sel ast.Node) DocumentSymbol {
+	return DocumentSymbol{
+		Name:           name,
+		Kind:           SymbolVariable,
+		Range:          d.rangeOf(stat),
+		SelectionRange: d.rangeOf(sel),
+	}
+}
+
+// format returns the edit replacing the document with its formatting, nil
+// if it has syntax errors.
+func (d *document) format(tabSize int, insertSpaces bool, version int) []TextEdit {
+	cfg := &printer.Config{Version: version}
+	if insertSpaces && tabSize > 0 {
+		cfg.Indent = strings.Repeat(" ", tabSize)
+	}
+	out, err := cfg.Format([]byte(d.text), d.uri)
+	if err != nil {
+		return nil
+	}
+	if bytes.Equal(out, []byte(d.text)) {
+		return []TextEdit{}
+	}
+	last := len(d.lines) - 1
+	end := Position{Line: last, Character: utf16Len(d.lines[last])}
+	return []TextEdit{{Range{Position{}, end}, string(out)}}
+}
@@ -0,0 +1,70 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// conn reads and writes JSON-RPC messages framed by a Content-Length header.
+type conn struct {
+	r  *bufio.Reader
+	w  io.Writer
+	mu sync.Mutex // serializes the writes
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+// read returns the content of the next message.
+func (c *conn) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break // end of the header
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid header line %q", line)
+		}
+		if strings.EqualFold(line[:i], "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length %q", line[i+1:])
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length")
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(c.r, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// write sends msg, marshaled to JSON.
+func (c *conn) write(msg interface{}) error {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(content)
+	return err
+}
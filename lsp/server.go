@@ -0,0 +1,346 @@
+// Package lsp implements a Language Server Protocol server for Lua,
+// reporting the syntax errors and the lint diagnostics of the documents
+// and providing go to definition, references, hover, completion, document
+// symbols and formatting.
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/compiler"
+	"github.com/iglev/glua/compiler/ast"
+	"github.com/iglev/glua/compiler/lexer"
+	"github.com/iglev/glua/compiler/lint"
+	"github.com/iglev/glua/compiler/parser"
+)
+
+// Options configures a Server.
+type Options struct {
+	Version int              // language version of the documents, api.LUA_VERSION_53 if 0
+	Stubs   map[string]*Stub // globals of the Go libraries of the host, see LoadStubs
+}
+
+// Server serves one client over a stream.
+type Server struct {
+	conn     *conn
+	version  int
+	stubs    map[string]*Stub // the standard library and Options.Stubs
+	docs     map[string]*document
+	shutdown bool
+}
+
+// NewServer returns a server reading the messages of the client from r and
+// writing its own to w.
+func NewServer(r io.Reader, w io.Writer, opts Options) *Server {
+	s := &Server{
+		conn:    newConn(r, w),
+		version: opts.Version,
+		docs:    map[string]*document{},
+	}
+	if s.version == 0 {
+		s.version = api.LUA_VERSION_53
+	}
+	s.stubs = stdlibStubsFor(s.version)
+	for name, stub := range opts.Stubs {
+		s.stubs[name] = stub
+	}
+	return s
+}
+
+// Serve handles the messages of the client until it sends the exit
+// notification or closes the stream. It returns an error if the stream
+// fails or if the client exits without shutting down the server.
+func (s *Server) Serve() error {
+	for {
+		content, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			s.reply(nil, nil, &responseError{codeParseError, err.Error()})
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.dispatch(&req)
+		if req.ID != nil {
+			if err := s.reply(req.ID, result, rerr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	resp := &response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = raw
+	}
+	return s.conn.write(resp)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return s.conn.write(struct {
+		JSONRPC string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params"`
+	}{"2.0", method, params})
+}
+
+// dispatch handles req and returns its result.
+func (s *Server) dispatch(req *request) (result interface{}, rerr *responseError) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, rerr = nil, &responseError{codeInternalError, fmt.Sprint(r)}
+		}
+	}()
+	decode := func(params interface{}) bool {
+		if err := json.Unmarshal(req.Params, params); err != nil {
+			rerr = &responseError{codeInvalidParams, err.Error()}
+			return false
+		}
+		return true
+	}
+
+	switch req.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized":
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if decode(&params) {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if decode(&params) && len(params.ContentChanges) > 0 {
+			changes := params.ContentChanges
+			s.update(params.TextDocument.URI, changes[len(changes)-1].Text)
+		}
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if decode(&params) {
+			delete(s.docs, params.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+				URI:         params.TextDocument.URI,
+				Diagnostics: []Diagnostic{},
+			})
+		}
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if decode(&params) {
+			if d := s.docs[params.TextDocument.URI]; d != nil {
+				return d.definition(params.Position), nil
+			}
+		}
+	case "textDocument/references":
+		var params ReferenceParams
+		if decode(&params) {
+			if d := s.docs[params.TextDocument.URI]; d != nil {
+				return d.references(params.Position, params.Context.IncludeDeclaration), nil
+			}
+		}
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if decode(&params) {
+			if d := s.docs[params.TextDocument.URI]; d != nil {
+				return d.hover(params.Position, s.stubs), nil
+			}
+		}
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if decode(&params) {
+			if d := s.docs[params.TextDocument.URI]; d != nil {
+				return d.completion(params.Position, s.stubs), nil
+			}
+		}
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if decode(&params) {
+			if d := s.docs[params.TextDocument.URI]; d != nil {
+				return d.symbols(), nil
+			}
+		}
+	case "textDocument/formatting":
+		var params DocumentFormattingParams
+		if decode(&params) {
+			if d := s.docs[params.TextDocument.URI]; d != nil {
+				return d.format(params.Options.TabSize, params.Options.InsertSpaces, s.version), nil
+			}
+		}
+	default:
+		if req.ID != nil {
+			return nil, &responseError{codeMethodNotFound, "method not found: " + req.Method}
+		}
+	}
+	return nil, rerr
+}
+
+func (s *Server) initialize() *InitializeResult {
+	res := &InitializeResult{}
+	caps := &res.Capabilities
+	caps.TextDocumentSync = 1 // full
+	caps.DefinitionProvider = true
+	caps.ReferencesProvider = true
+	caps.HoverProvider = true
+	caps.CompletionProvider.TriggerCharacters = []string{".", ":"}
+	caps.DocumentSymbolProvider = true
+	caps.DocumentFormattingProvider = true
+	res.ServerInfo.Name = "gluals"
+	return res
+}
+
+// document is an open document.
+type document struct {
+	uri   string
+	text  string
+	lines []string // without their line terminators
+	block *ast.Block
+	res   *lint.Resolution
+}
+
+// update parses the new text of the document uri and publishes its
+// diagnostics. The AST of a text with syntax errors is the partial one
+// recovered by the parser, or the last good one if it cannot be resolved.
+func (s *Server) update(uri, text string) {
+	d := &document{uri: uri, text: text, lines: splitLines(text)}
+	if old := s.docs[uri]; old != nil {
+		d.block, d.res = old.block, old.res
+	}
+	s.docs[uri] = d
+
+	block, err := parser.ParseMode(text, uri, s.version, parser.AllErrors)
+	if res := resolve(block); res != nil {
+		d.block, d.res = block, res
+	}
+
+	diags := []Diagnostic{}
+	if err != nil {
+		for _, e := range err.(lexer.ErrorList) {
+			start := ast.Pos{Line: e.Line, Column: e.Column}
+			end := ast.Pos{Line: e.Line, Column: e.Column + len(e.Token)}
+			diags = append(diags, d.diagnostic(start, end, SeverityError, lint.Syntax, e.Msg))
+		}
+	} else {
+		cfg := &lint.Config{Version: s.version}
+		for name := range s.stubs {
+			cfg.Globals = append(cfg.Globals, name)
+		}
+		if _, err := compiler.CompileVersion(text, uri, s.version); err != nil {
+			if e, ok := err.(*lexer.SyntaxError); ok {
+				start := ast.Pos{Line: e.Line, Column: 1}
+				end := ast.Pos{Line: e.Line, Column: len(d.line(e.Line-1)) + 1}
+				diags = append(diags, d.diagnostic(start, end, SeverityError, "", e.Msg))
+			}
+		}
+		for _, ld := range cfg.Check(block, uri) {
+			start := ast.Pos{Line: ld.Line, Column: ld.Column}
+			end := ast.Pos{Line: ld.EndLine, Column: ld.EndColumn}
+			diags = append(diags, d.diagnostic(start, end, SeverityWarning, ld.Check, ld.Message))
+		}
+		sort.SliceStable(diags, func(i, j int) bool {
+			a, b := diags[i].Range.Start, diags[j].Range.Start
+			return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diags,
+	})
+}
+
+// resolve resolves block, nil if it cannot.
+func resolve(block *ast.Block) (res *lint.Resolution) {
+	if block == nil {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			res = nil
+		}
+	}()
+	return lint.Resolve(block)
+}
+
+func (d *document) diagnostic(start, end ast.Pos, severity int, code, msg string) Diagnostic {
+	return Diagnostic{
+		Range:    Range{d.position(start), d.position(end)},
+		Severity: severity,
+		Code:     code,
+		Source:   "glua",
+		Message:  msg,
+	}
+}
+
+func splitLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// line returns the line i, counted from 0, "" if out of range.
+func (d *document) line(i int) string {
+	if i < 0 || i >= len(d.lines) {
+		return ""
+	}
+	return d.lines[i]
+}
+
+// position converts pos, with its column in bytes from 1, to a protocol
+// position.
+func (d *document) position(pos ast.Pos) Position {
+	line := d.line(pos.Line - 1)
+	n := pos.Column - 1
+	if n > len(line) {
+		n = len(line)
+	} else if n < 0 {
+		n = 0
+	}
+	return Position{Line: pos.Line - 1, Character: utf16Len(line[:n])}
+}
+
+// pos converts a protocol position to a position of the AST.
+func (d *document) pos(p Position) ast.Pos {
+	line := d.line(p.Line)
+	n, units := 0, 0
+	for n < len(line) && units < p.Character {
+		r, size := utf8.DecodeRuneInString(line[n:])
+		n += size
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return ast.Pos{Line: p.Line + 1, Column: n + 1}
+}
+
+func (d *document) rangeOf(node ast.Node) Range {
+	return Range{d.position(node.Pos()), d.position(node.End())}
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
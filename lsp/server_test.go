@@ -0,0 +1,201 @@
+package lsp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/iglev/glua/lsp"
+)
+
+// TestLSP a session with the server over pipes
+func TestLSP(t *testing.T) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	stubs, err := lsp.LoadStubs(strings.NewReader(`{"http": {"kind": "table", "fields": {
+		"get": {"kind": "function", "signature": "http.get (url)", "doc": "Fetches url."}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- lsp.NewServer(serverR, serverW, lsp.Options{Stubs: stubs}).Serve()
+		serverW.Close()
+	}()
+
+	in := bufio.NewReader(clientR)
+	id := 0
+	send := func(method string, params interface{}, notification bool) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if !notification {
+			id++
+			msg["id"] = id
+		}
+		content, _ := json.Marshal(msg)
+		fmt.Fprintf(clientW, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	}
+	receive := func(v interface{}) {
+		var length int
+		for {
+			line, err := in.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			fmt.Sscanf(line, "Content-Length: %d", &length)
+		}
+		content := make([]byte, length)
+		if _, err := io.ReadFull(in, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(content, v); err != nil {
+			t.Fatalf("%v: %s", err, content)
+		}
+	}
+	call := func(method string, params, result interface{}) {
+		send(method, params, false)
+		var resp struct {
+			ID     int
+			Result json.RawMessage
+			Error  *struct{ Message string }
+		}
+		receive(&resp)
+		if resp.ID != id || resp.Error != nil {
+			t.Fatalf("%s: %d %+v", method, resp.ID, resp.Error)
+		}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+	}
+	type diagnostics struct {
+		Params lsp.PublishDiagnosticsParams
+	}
+	doc := map[string]string{"uri": "file:///t.lua"}
+	pos := func(line, character int) map[string]interface{} {
+		return map[string]interface{}{
+			"textDocument": doc,
+			"position":     lsp.Position{Line: line, Character: character},
+			"context":      map[string]bool{"includeDeclaration": true},
+		}
+	}
+
+	var init lsp.InitializeResult
+	call("initialize", map[string]interface{}{}, &init)
+	if !init.Capabilities.HoverProvider || init.ServerInfo.Name != "gluals" {
+		t.Errorf("%+v", init)
+	}
+	send("initialized", map[string]interface{}{}, true)
+
+	src := `local M = {count = 0}
+function M.inc(n)
+  M.count = M.count + n
+  return string.rep("é", n), http.get("x")
+end
+local x = undefined
+`
+	send("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": doc["uri"], "languageId": "lua", "version": 1, "text": src},
+	}, true)
+	var diags diagnostics
+	receive(&diags)
+	var got []string
+	for _, d := range diags.Params.Diagnostics {
+		got = append(got, fmt.Sprintf("%d:%d %s", d.Range.Start.Line, d.Range.Start.Character, d.Code))
+	}
+	if strings.Join(got, " ") != "5:6 unused-local 5:10 undefined-global" {
+		t.Errorf("diagnostics: %v", got)
+	}
+
+	var locs []lsp.Location
+	call("textDocument/definition", pos(2, 13), &locs)
+	if len(locs) != 1 || locs[0].Range != (lsp.Range{Start: lsp.Position{Line: 0, Character: 6}, End: lsp.Position{Line: 0, Character: 7}}) {
+		t.Errorf("definition: %+v", locs)
+	}
+	call("textDocument/references", pos(0, 6), &locs)
+	if len(locs) != 4 || locs[3].Range.Start != (lsp.Position{Line: 2, Character: 12}) ||
+		locs[1].Range.Start != (lsp.Position{Line: 1, Character: 9}) {
+		t.Errorf("references: %+v", locs)
+	}
+
+	var hover lsp.Hover
+	call("textDocument/hover", pos(3, 18), &hover)
+	if !strings.Contains(hover.Contents.Value, "string.rep (s, n [, sep])") || hover.Range.Start.Character != 9 {
+		t.Errorf("hover: %+v", hover)
+	}
+	call("textDocument/hover", pos(3, 37), &hover)
+	if !strings.Contains(hover.Contents.Value, "http.get (url)\n```\n\nFetches url.") {
+		t.Errorf("hover: %+v", hover)
+	}
+	call("textDocument/hover", pos(1, 15), &hover)
+	if hover.Contents.Value != "```lua\n(parameter) n\n```" {
+		t.Errorf("hover: %+v", hover)
+	}
+
+	labels := func(items []lsp.CompletionItem) string {
+		var labels []string
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+		return strings.Join(labels, " ")
+	}
+	var items []lsp.CompletionItem
+	send("textDocument/didChange", map[string]interface{}{
+		"textDocument":   doc,
+		"contentChanges": []map[string]string{{"text": src + "http.\nstring.\nM.\n"}},
+	}, true)
+	receive(&diags)
+	if len(diags.Params.Diagnostics) == 0 || diags.Params.Diagnostics[0].Severity != lsp.SeverityError {
+		t.Errorf("diagnostics: %+v", diags.Params.Diagnostics)
+	}
+	call("textDocument/completion", pos(6, 5), &items)
+	if labels(items) != "get" {
+		t.Errorf("completion: %s", labels(items))
+	}
+	call("textDocument/completion", pos(7, 7), &items)
+	if !strings.Contains(labels(items), "format gmatch gsub len") {
+		t.Errorf("completion: %s", labels(items))
+	}
+	call("textDocument/completion", pos(8, 2), &items)
+	if labels(items) != "count inc" {
+		t.Errorf("completion: %s", labels(items))
+	}
+	call("textDocument/completion", pos(3, 2), &items)
+	if l := " " + labels(items) + " "; !strings.Contains(l, " M ") || !strings.Contains(l, " n ") ||
+		!strings.Contains(l, " http ") || !strings.Contains(l, " while") {
+		t.Errorf("completion: %s", l)
+	}
+
+	send("textDocument/didChange", map[string]interface{}{
+		"textDocument":   doc,
+		"contentChanges": []map[string]string{{"text": "local M = {}\nfunction M:f( a )\nlocal function g() end\nend\n"}},
+	}, true)
+	receive(&diags)
+	var symbols []lsp.DocumentSymbol
+	call("textDocument/documentSymbol", map[string]interface{}{"textDocument": doc}, &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "M" || symbols[1].Name != "M:f" ||
+		symbols[1].Kind != lsp.SymbolMethod || symbols[1].Detail != "function(a)" ||
+		len(symbols[1].Children) != 1 || symbols[1].Children[0].Name != "g" {
+		t.Errorf("symbols: %+v", symbols)
+	}
+	var edits []lsp.TextEdit
+	call("textDocument/formatting", map[string]interface{}{
+		"textDocument": doc,
+		"options":      map[string]interface{}{"tabSize": 2, "insertSpaces": true},
+	}, &edits)
+	if len(edits) != 1 || edits[0].NewText != "local M = {}\nfunction M:f(a)\n  local function g() end\nend\n" ||
+		edits[0].Range.End != (lsp.Position{Line: 4, Character: 0}) {
+		t.Errorf("formatting: %+v", edits)
+	}
+
+	var null interface{}
+	call("shutdown", nil, &null)
+	send("exit", nil, true)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,195 @@
+package lsp
+
+// stdlibStubs describes the standard library, versions 501 and 504 being
+// api.LUA_VERSION_51 and api.LUA_VERSION_54.
+const stdlibStubs = `{
+"_G": {"kind": "table", "doc": "The global environment."},
+"_VERSION": {"kind": "value", "doc": "The running Lua version, as \"Lua 5.3\"."},
+"assert": {"kind": "function", "signature": "assert (v [, message])", "doc": "Raises an error if v is false or nil, returns all its arguments otherwise."},
+"collectgarbage": {"kind": "function", "signature": "collectgarbage ([opt [, arg]])", "doc": "Controls the garbage collector."},
+"dofile": {"kind": "function", "signature": "dofile ([filename])", "doc": "Runs the file and returns its values."},
+"error": {"kind": "function", "signature": "error (message [, level])", "doc": "Raises message as an error."},
+"getmetatable": {"kind": "function", "signature": "getmetatable (object)", "doc": "Returns the metatable of object, or its __metatable field."},
+"ipairs": {"kind": "function", "signature": "ipairs (t)", "doc": "Returns an iterator over the pairs (1, t[1]), (2, t[2]), ... up to the first nil."},
+"load": {"kind": "function", "signature": "load (chunk [, chunkname [, mode [, env]]])", "doc": "Loads a chunk from a string or a function."},
+"loadfile": {"kind": "function", "signature": "loadfile ([filename [, mode [, env]]])", "doc": "Loads a chunk from a file."},
+"next": {"kind": "function", "signature": "next (table [, index])", "doc": "Returns the next key of the table and its value."},
+"pairs": {"kind": "function", "signature": "pairs (t)", "doc": "Returns an iterator over all the pairs of t."},
+"pcall": {"kind": "function", "signature": "pcall (f [, arg1, ...])", "doc": "Calls f in protected mode, returns a status and its results or the error."},
+"print": {"kind": "function", "signature": "print (...)", "doc": "Prints its arguments to the standard output."},
+"rawequal": {"kind": "function", "signature": "rawequal (v1, v2)", "doc": "Tells whether v1 equals v2 without invoking metamethods."},
+"rawget": {"kind": "function", "signature": "rawget (table, index)", "doc": "Gets table[index] without invoking metamethods."},
+"rawlen": {"kind": "function", "signature": "rawlen (v)", "doc": "Returns the length of v without invoking metamethods."},
+"rawset": {"kind": "function", "signature": "rawset (table, index, value)", "doc": "Sets table[index] without invoking metamethods."},
+"require": {"kind": "function", "signature": "require (modname)", "doc": "Loads the module modname."},
+"select": {"kind": "function", "signature": "select (index, ...)", "doc": "Returns the arguments after index, or their number if index is \"#\"."},
+"setmetatable": {"kind": "function", "signature": "setmetatable (table, metatable)", "doc": "Sets the metatable of table and returns table."},
+"tonumber": {"kind": "function", "signature": "tonumber (e [, base])", "doc": "Converts e to a number, or returns nil."},
+"tostring": {"kind": "function", "signature": "tostring (v)", "doc": "Converts v to a string."},
+"type": {"kind": "function", "signature": "type (v)", "doc": "Returns the type name of v."},
+"xpcall": {"kind": "function", "signature": "xpcall (f, msgh [, arg1, ...])", "doc": "Calls f in protected mode with the message handler msgh."},
+"warn": {"kind": "function", "signature": "warn (msg1, ...)", "doc": "Emits a warning made of the concatenation of its arguments.", "version": 504},
+"getfenv": {"kind": "function", "signature": "getfenv ([f])", "doc": "Returns the environment of a function.", "version": 501},
+"loadstring": {"kind": "function", "signature": "loadstring (string [, chunkname])", "doc": "Loads a chunk from a string.", "version": 501},
+"module": {"kind": "function", "signature": "module (name [, ...])", "doc": "Creates a module.", "version": 501},
+"setfenv": {"kind": "function", "signature": "setfenv (f, table)", "doc": "Sets the environment of a function.", "version": 501},
+"unpack": {"kind": "function", "signature": "unpack (list [, i [, j]])", "doc": "Returns the elements of list from i to j.", "version": 501},
+
+"bit": {"kind": "table", "doc": "LuaJIT bitwise operations.", "version": 501, "fields": {
+	"arshift": {"kind": "function", "signature": "bit.arshift (x, n)"},
+	"band": {"kind": "function", "signature": "bit.band (x1 [, x2, ...])"},
+	"bnot": {"kind": "function", "signature": "bit.bnot (x)"},
+	"bor": {"kind": "function", "signature": "bit.bor (x1 [, x2, ...])"},
+	"bswap": {"kind": "function", "signature": "bit.bswap (x)"},
+	"bxor": {"kind": "function", "signature": "bit.bxor (x1 [, x2, ...])"},
+	"lshift": {"kind": "function", "signature": "bit.lshift (x, n)"},
+	"rol": {"kind": "function", "signature": "bit.rol (x, n)"},
+	"ror": {"kind": "function", "signature": "bit.ror (x, n)"},
+	"rshift": {"kind": "function", "signature": "bit.rshift (x, n)"},
+	"tobit": {"kind": "function", "signature": "bit.tobit (x)"},
+	"tohex": {"kind": "function", "signature": "bit.tohex (x [, n])"}
+}},
+
+"bit32": {"kind": "table", "doc": "Lua 5.2 bitwise operations.", "version": 501, "fields": {
+	"arshift": {"kind": "function", "signature": "bit32.arshift (x, disp)"},
+	"band": {"kind": "function", "signature": "bit32.band (...)"},
+	"bnot": {"kind": "function", "signature": "bit32.bnot (x)"},
+	"bor": {"kind": "function", "signature": "bit32.bor (...)"},
+	"btest": {"kind": "function", "signature": "bit32.btest (...)"},
+	"bxor": {"kind": "function", "signature": "bit32.bxor (...)"},
+	"extract": {"kind": "function", "signature": "bit32.extract (n, field [, width])"},
+	"lrotate": {"kind": "function", "signature": "bit32.lrotate (x, disp)"},
+	"lshift": {"kind": "function", "signature": "bit32.lshift (x, disp)"},
+	"replace": {"kind": "function", "signature": "bit32.replace (n, v, field [, width])"},
+	"rrotate": {"kind": "function", "signature": "bit32.rrotate (x, disp)"},
+	"rshift": {"kind": "function", "signature": "bit32.rshift (x, disp)"}
+}},
+
+"coroutine": {"kind": "table", "doc": "Coroutine manipulation.", "fields": {
+	"close": {"kind": "function", "signature": "coroutine.close (co)", "doc": "Closes a suspended or dead coroutine.", "version": 504},
+	"create": {"kind": "function", "signature": "coroutine.create (f)", "doc": "Creates a coroutine running f."},
+	"isyieldable": {"kind": "function", "signature": "coroutine.isyieldable ()", "doc": "Tells whether the running coroutine can yield."},
+	"resume": {"kind": "function", "signature": "coroutine.resume (co [, val1, ...])", "doc": "Starts or continues the coroutine co."},
+	"running": {"kind": "function", "signature": "coroutine.running ()", "doc": "Returns the running coroutine and whether it is the main one."},
+	"status": {"kind": "function", "signature": "coroutine.status (co)", "doc": "Returns the status of co."},
+	"wrap": {"kind": "function", "signature": "coroutine.wrap (f)", "doc": "Creates a coroutine running f, returns a function resuming it."},
+	"yield": {"kind": "function", "signature": "coroutine.yield (...)", "doc": "Suspends the running coroutine."}
+}},
+
+"io": {"kind": "table", "doc": "Input and output.", "fields": {
+	"close": {"kind": "function", "signature": "io.close ([file])", "doc": "Closes file, or the default output file."},
+	"flush": {"kind": "function", "signature": "io.flush ()", "doc": "Flushes the default output file."},
+	"input": {"kind": "function", "signature": "io.input ([file])", "doc": "Sets or returns the default input file."},
+	"lines": {"kind": "function", "signature": "io.lines ([filename, ...])", "doc": "Returns an iterator over the lines of a file."},
+	"open": {"kind": "function", "signature": "io.open (filename [, mode])", "doc": "Opens a file."},
+	"output": {"kind": "function", "signature": "io.output ([file])", "doc": "Sets or returns the default output file."},
+	"popen": {"kind": "function", "signature": "io.popen (prog [, mode])", "doc": "Starts prog and returns a file connected to it."},
+	"read": {"kind": "function", "signature": "io.read (...)", "doc": "Reads the default input file."},
+	"stderr": {"kind": "value", "doc": "The standard error."},
+	"stdin": {"kind": "value", "doc": "The standard input."},
+	"stdout": {"kind": "value", "doc": "The standard output."},
+	"tmpfile": {"kind": "function", "signature": "io.tmpfile ()", "doc": "Returns a temporary file."},
+	"type": {"kind": "function", "signature": "io.type (obj)", "doc": "Tells whether obj is a file, a closed file or not a file."},
+	"write": {"kind": "function", "signature": "io.write (...)", "doc": "Writes to the default output file."}
+}},
+
+"math": {"kind": "table", "doc": "Mathematical functions.", "fields": {
+	"abs": {"kind": "function", "signature": "math.abs (x)"},
+	"acos": {"kind": "function", "signature": "math.acos (x)"},
+	"asin": {"kind": "function", "signature": "math.asin (x)"},
+	"atan": {"kind": "function", "signature": "math.atan (y [, x])"},
+	"ceil": {"kind": "function", "signature": "math.ceil (x)"},
+	"cos": {"kind": "function", "signature": "math.cos (x)"},
+	"deg": {"kind": "function", "signature": "math.deg (x)"},
+	"exp": {"kind": "function", "signature": "math.exp (x)"},
+	"floor": {"kind": "function", "signature": "math.floor (x)"},
+	"fmod": {"kind": "function", "signature": "math.fmod (x, y)"},
+	"frexp": {"kind": "function", "signature": "math.frexp (x)", "version": 501},
+	"huge": {"kind": "value", "doc": "A value greater than any other number."},
+	"ldexp": {"kind": "function", "signature": "math.ldexp (m, e)", "version": 501},
+	"log": {"kind": "function", "signature": "math.log (x [, base])"},
+	"max": {"kind": "function", "signature": "math.max (x, ...)"},
+	"maxinteger": {"kind": "value", "doc": "The maximum value of an integer."},
+	"min": {"kind": "function", "signature": "math.min (x, ...)"},
+	"mininteger": {"kind": "value", "doc": "The minimum value of an integer."},
+	"modf": {"kind": "function", "signature": "math.modf (x)"},
+	"pi": {"kind": "value", "doc": "The value of π."},
+	"pow": {"kind": "function", "signature": "math.pow (x, y)", "version": 501},
+	"rad": {"kind": "function", "signature": "math.rad (x)"},
+	"random": {"kind": "function", "signature": "math.random ([m [, n]])"},
+	"randomseed": {"kind": "function", "signature": "math.randomseed ([x [, y]])"},
+	"sin": {"kind": "function", "signature": "math.sin (x)"},
+	"sqrt": {"kind": "function", "signature": "math.sqrt (x)"},
+	"tan": {"kind": "function", "signature": "math.tan (x)"},
+	"tointeger": {"kind": "function", "signature": "math.tointeger (x)"},
+	"type": {"kind": "function", "signature": "math.type (x)"},
+	"ult": {"kind": "function", "signature": "math.ult (m, n)"}
+}},
+
+"os": {"kind": "table", "doc": "Operating system facilities.", "fields": {
+	"clock": {"kind": "function", "signature": "os.clock ()", "doc": "Returns the CPU time used by the program, in seconds."},
+	"date": {"kind": "function", "signature": "os.date ([format [, time]])", "doc": "Formats a date."},
+	"difftime": {"kind": "function", "signature": "os.difftime (t2, t1)", "doc": "Returns t2 - t1, in seconds."},
+	"execute": {"kind": "function", "signature": "os.execute ([command])", "doc": "Runs a shell command."},
+	"exit": {"kind": "function", "signature": "os.exit ([code [, close]])", "doc": "Exits the program."},
+	"getenv": {"kind": "function", "signature": "os.getenv (varname)", "doc": "Returns the value of an environment variable."},
+	"remove": {"kind": "function", "signature": "os.remove (filename)", "doc": "Removes a file."},
+	"rename": {"kind": "function", "signature": "os.rename (oldname, newname)", "doc": "Renames a file."},
+	"setlocale": {"kind": "function", "signature": "os.setlocale (locale [, category])", "doc": "Sets the locale."},
+	"time": {"kind": "function", "signature": "os.time ([table])", "doc": "Returns the current time, or the time of a date."},
+	"tmpname": {"kind": "function", "signature": "os.tmpname ()", "doc": "Returns a name for a temporary file."}
+}},
+
+"package": {"kind": "table", "doc": "Modules.", "fields": {
+	"config": {"kind": "value", "doc": "The configuration of the packages."},
+	"cpath": {"kind": "value", "doc": "The path searched for C loaders."},
+	"loaded": {"kind": "value", "doc": "The modules already loaded."},
+	"path": {"kind": "value", "doc": "The path searched for Lua loaders."},
+	"preload": {"kind": "value", "doc": "The loaders of specific modules."},
+	"searchers": {"kind": "value", "doc": "The searchers used by require."},
+	"searchpath": {"kind": "function", "signature": "package.searchpath (name, path [, sep [, rep]])", "doc": "Searches name in path."},
+	"seeall": {"kind": "function", "signature": "package.seeall (module)", "version": 501}
+}},
+
+"string": {"kind": "table", "doc": "String manipulation.", "fields": {
+	"byte": {"kind": "function", "signature": "string.byte (s [, i [, j]])", "doc": "Returns the codes of the characters s[i] to s[j]."},
+	"char": {"kind": "function", "signature": "string.char (...)", "doc": "Returns the string of the given character codes."},
+	"dump": {"kind": "function", "signature": "string.dump (function [, strip])", "doc": "Returns the binary chunk of a function."},
+	"find": {"kind": "function", "signature": "string.find (s, pattern [, init [, plain]])", "doc": "Finds the first match of pattern in s."},
+	"format": {"kind": "function", "signature": "string.format (formatstring, ...)", "doc": "Formats its arguments."},
+	"gfind": {"kind": "function", "signature": "string.gfind (s, pattern)", "version": 501},
+	"gmatch": {"kind": "function", "signature": "string.gmatch (s, pattern)", "doc": "Returns an iterator over the matches of pattern in s."},
+	"gsub": {"kind": "function", "signature": "string.gsub (s, pattern, repl [, n])", "doc": "Replaces the matches of pattern in s."},
+	"len": {"kind": "function", "signature": "string.len (s)", "doc": "Returns the length of s."},
+	"lower": {"kind": "function", "signature": "string.lower (s)", "doc": "Converts s to lowercase."},
+	"match": {"kind": "function", "signature": "string.match (s, pattern [, init])", "doc": "Returns the captures of the first match of pattern in s."},
+	"pack": {"kind": "function", "signature": "string.pack (fmt, v1, v2, ...)", "doc": "Packs values into a binary string."},
+	"packsize": {"kind": "function", "signature": "string.packsize (fmt)", "doc": "Returns the size of a string packed with fmt."},
+	"rep": {"kind": "function", "signature": "string.rep (s, n [, sep])", "doc": "Returns n copies of s separated by sep."},
+	"reverse": {"kind": "function", "signature": "string.reverse (s)", "doc": "Reverses s."},
+	"sub": {"kind": "function", "signature": "string.sub (s, i [, j])", "doc": "Returns the substring of s from i to j."},
+	"unpack": {"kind": "function", "signature": "string.unpack (fmt, s [, pos])", "doc": "Unpacks the values of a binary string."},
+	"upper": {"kind": "function", "signature": "string.upper (s)", "doc": "Converts s to uppercase."}
+}},
+
+"table": {"kind": "table", "doc": "Table manipulation.", "fields": {
+	"concat": {"kind": "function", "signature": "table.concat (list [, sep [, i [, j]]])", "doc": "Concatenates the strings of list."},
+	"getn": {"kind": "function", "signature": "table.getn (list)", "version": 501},
+	"insert": {"kind": "function", "signature": "table.insert (list, [pos,] value)", "doc": "Inserts value in list."},
+	"maxn": {"kind": "function", "signature": "table.maxn (table)", "version": 501},
+	"move": {"kind": "function", "signature": "table.move (a1, f, e, t [, a2])", "doc": "Moves elements from a1 to a2."},
+	"pack": {"kind": "function", "signature": "table.pack (...)", "doc": "Returns a table of its arguments, with their number in n."},
+	"remove": {"kind": "function", "signature": "table.remove (list [, pos])", "doc": "Removes an element of list."},
+	"sort": {"kind": "function", "signature": "table.sort (list [, comp])", "doc": "Sorts list in place."},
+	"unpack": {"kind": "function", "signature": "table.unpack (list [, i [, j]])", "doc": "Returns the elements of list from i to j."}
+}},
+
+"utf8": {"kind": "table", "doc": "UTF-8 support.", "fields": {
+	"char": {"kind": "function", "signature": "utf8.char (...)", "doc": "Returns the UTF-8 encoding of the code points."},
+	"charpattern": {"kind": "value", "doc": "The pattern matching one UTF-8 byte sequence."},
+	"codepoint": {"kind": "function", "signature": "utf8.codepoint (s [, i [, j]])", "doc": "Returns the code points of the characters of s."},
+	"codes": {"kind": "function", "signature": "utf8.codes (s)", "doc": "Returns an iterator over the characters of s."},
+	"len": {"kind": "function", "signature": "utf8.len (s [, i [, j]])", "doc": "Returns the number of characters of s."},
+	"offset": {"kind": "function", "signature": "utf8.offset (s, n [, i])", "doc": "Returns the position of the n-th character of s."}
+}}
+}`
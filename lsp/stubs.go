@@ -0,0 +1,101 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/iglev/glua/api"
+)
+
+// Stub describes a global, or a field of a table, to the editor. The stubs
+// of the Go libraries registered by a host are read from a JSON object
+// mapping the names of their globals to their stubs:
+//
+//	{
+//		"http": {
+//			"kind": "table",
+//			"doc": "HTTP client.",
+//			"fields": {
+//				"get": {
+//					"kind": "function",
+//					"signature": "http.get (url [, headers])",
+//					"doc": "Returns the body and the status of the response."
+//				}
+//			}
+//		}
+//	}
+type Stub struct {
+	Kind      string           `json:"kind"`                // "function", "table" or "value"
+	Signature string           `json:"signature,omitempty"` // of a function, as "f (a [, b])"
+	Doc       string           `json:"doc,omitempty"`
+	Fields    map[string]*Stub `json:"fields,omitempty"`  // of a table
+	Version   int              `json:"version,omitempty"` // api.LUA_VERSION_51 or 54 if only in it
+}
+
+// LoadStubs reads stubs from r.
+func LoadStubs(r io.Reader) (map[string]*Stub, error) {
+	stubs := map[string]*Stub{}
+	if err := json.NewDecoder(r).Decode(&stubs); err != nil {
+		return nil, err
+	}
+	return stubs, nil
+}
+
+// stdlibStubsFor returns the stubs of the standard library of version.
+func stdlibStubsFor(version int) map[string]*Stub {
+	stubs, err := LoadStubs(strings.NewReader(stdlibStubs))
+	if err != nil {
+		panic(err)
+	}
+	filterStubs(stubs, version)
+	return stubs
+}
+
+// filterStubs removes the stubs of the other versions.
+func filterStubs(stubs map[string]*Stub, version int) {
+	for name, stub := range stubs {
+		v := stub.Version
+		if v != 0 && v != version && !(v == api.LUA_VERSION_54 && version > v) {
+			delete(stubs, name)
+		} else {
+			filterStubs(stub.Fields, version)
+		}
+	}
+}
+
+// lookupStub returns the stub of the field path of the globals, nil if
+// unknown.
+func lookupStub(stubs map[string]*Stub, path []string) *Stub {
+	var stub *Stub
+	for _, name := range path {
+		if stub = stubs[name]; stub == nil {
+			return nil
+		}
+		stubs = stub.Fields
+	}
+	return stub
+}
+
+// hoverText returns the markdown describing stub, named name.
+func (stub *Stub) hoverText(name string) string {
+	sig := stub.Signature
+	if sig == "" {
+		sig = stub.Kind + " " + name
+	}
+	text := "```lua\n" + sig + "\n```"
+	if stub.Doc != "" {
+		text += "\n\n" + stub.Doc
+	}
+	return text
+}
+
+func (stub *Stub) completionKind() int {
+	switch stub.Kind {
+	case "function":
+		return CompletionFunction
+	case "table":
+		return CompletionModule
+	}
+	return CompletionField
+}
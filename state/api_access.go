@@ -106,6 +106,17 @@ func (l *luaState) IsInteger(idx int) bool {
 	return ok
 }
 
+// IsUserdata - lua_isuserdata
+func (l *luaState) IsUserdata(idx int) bool {
+	t := l.Type(idx)
+	return t == api.LUA_TUSERDATA || t == api.LUA_TLIGHTUSERDATA
+}
+
+// IsLightUserdata - lua_islightuserdata
+func (l *luaState) IsLightUserdata(idx int) bool {
+	return l.Type(idx) == api.LUA_TLIGHTUSERDATA
+}
+
 // IsGoFunction - lua_iscfunction
 func (l *luaState) IsGoFunction(idx int) bool {
 	val := l.stack.get(idx)
@@ -197,6 +208,21 @@ func (l *luaState) ToThread(idx int) api.LuaState {
 
 // ToPointer - lua_topointer
 func (l *luaState) ToPointer(idx int) interface{} {
-	// todo
-	return l.stack.get(idx)
+	val := l.stack.get(idx)
+	if x, ok := val.(lightUserdata); ok {
+		return x.p
+	}
+	return val
+}
+
+// ToUserdata - lua_touserdata, it returns the value of a full userdata
+// (see NewUserdata) or of a light userdata, nil otherwise.
+func (l *luaState) ToUserdata(idx int) interface{} {
+	switch x := l.stack.get(idx).(type) {
+	case *userdata:
+		return x.value
+	case lightUserdata:
+		return x.p
+	}
+	return nil
 }
@@ -64,7 +64,9 @@ func (l *luaState) Arith(op api.ArithOp) {
 	}
 
 	operator := operators[op]
-	if result := _arith(a, b, operator); result != nil {
+	// Lua 5.4 leaves strings in arithmetic to the string metamethods
+	strings := l.global.version < api.LUA_VERSION_54 || operator.floatFunc == nil
+	if result := _arith(a, b, operator, strings); result != nil {
 		l.stack.push(result)
 		return
 	}
@@ -78,7 +80,10 @@ func (l *luaState) Arith(op api.ArithOp) {
 	panic("arithmetic error!")
 }
 
-func _arith(a, b luaValue, op operator) luaValue {
+func _arith(a, b luaValue, op operator, strings bool) luaValue {
+	if !strings && (isString(a) || isString(b)) {
+		return nil
+	}
 	if op.floatFunc == nil { // bitwise
 		if x, ok := convertToInteger(a); ok {
 			if y, ok := convertToInteger(b); ok {
@@ -101,3 +106,8 @@ func _arith(a, b luaValue, op operator) luaValue {
 	}
 	return nil
 }
+
+func isString(val luaValue) bool {
+	_, ok := val.(string)
+	return ok
+}
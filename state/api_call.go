@@ -1,28 +1,137 @@
 package state
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
 	"github.com/iglev/glua/api"
 	"github.com/iglev/glua/binchunk"
 	"github.com/iglev/glua/compiler"
+	"github.com/iglev/glua/compiler/lexer"
 	"github.com/iglev/glua/vm"
 )
 
 // Load - lua_load
 func (l *luaState) Load(chunk []byte, chunkName, mode string) int {
 	var proto *binchunk.ProtoType
-	if binchunk.IsBinaryChunk(chunk) {
-		proto = binchunk.Undump(chunk)
+	if len(chunk) > 0 && chunk[0] == binchunk.LuaSignature[0] {
+		if !l.checkMode(mode, "binary") {
+			return api.LUA_ERRSYNTAX
+		}
+		var err error
+		if proto, err = undump(chunk); err != nil {
+			l.stack.push(fmt.Sprintf("%s: bad binary format (%v)", chunkID(chunkName), err))
+			return api.LUA_ERRSYNTAX
+		}
 	} else {
-		proto = compiler.Compile(string(chunk), chunkName)
+		if !l.checkMode(mode, "text") {
+			return api.LUA_ERRSYNTAX
+		}
+		var err error
+		proto, err = compiler.CompileVersion(string(chunk), chunkName, l.global.version)
+		if err != nil {
+			return l.pushLoadError(err)
+		}
 	}
+	l.pushMainClosure(proto)
+	return api.LUA_OK
+}
+
+// LoadReader is Load reading the chunk from r, a text chunk being compiled
+// as it is read. A read error is pushed as a LUA_ERRRUN error with its
+// message.
+func (l *luaState) LoadReader(r io.Reader, chunkName, mode string) int {
+	br := bufio.NewReader(r)
+	c, err := br.Peek(1)
+	if err != nil && err != io.EOF {
+		return l.pushLoadError(&lexer.ReadError{Err: err})
+	}
+	var proto *binchunk.ProtoType
+	if len(c) > 0 && c[0] == binchunk.LuaSignature[0] {
+		if !l.checkMode(mode, "binary") {
+			return api.LUA_ERRSYNTAX
+		}
+		chunk, err := ioutil.ReadAll(br)
+		if err != nil {
+			return l.pushLoadError(&lexer.ReadError{Err: err})
+		}
+		if proto, err = undump(chunk); err != nil {
+			l.stack.push(fmt.Sprintf("%s: bad binary format (%v)", chunkID(chunkName), err))
+			return api.LUA_ERRSYNTAX
+		}
+	} else {
+		if !l.checkMode(mode, "text") {
+			return api.LUA_ERRSYNTAX
+		}
+		proto, err = compiler.CompileReader(br, chunkName, l.global.version)
+		if err != nil {
+			return l.pushLoadError(err)
+		}
+	}
+	l.pushMainClosure(proto)
+	return api.LUA_OK
+}
+
+// undump reads the binary chunk and verifies its code, which may come
+// from anywhere.
+func undump(chunk []byte) (*binchunk.ProtoType, error) {
+	proto, err := binchunk.Undump(chunk)
+	if err != nil {
+		return nil, err
+	}
+	if err := binchunk.Verify(proto); err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
+
+// checkMode pushes an error and returns false if mode does not allow the
+// kind of chunk, "binary" or "text". The empty mode allows both -
+// checkmode
+func (l *luaState) checkMode(mode, kind string) bool {
+	if mode != "" && !strings.Contains(mode, kind[:1]) {
+		l.stack.push(fmt.Sprintf("attempt to load a %s chunk (mode is '%s')", kind, mode))
+		return false
+	}
+	return true
+}
+
+// pushLoadError pushes the message of err, an error of the compiler, and
+// returns its status.
+func (l *luaState) pushLoadError(err error) int {
+	if re, ok := err.(*lexer.ReadError); ok {
+		l.stack.push(re.Error())
+		return api.LUA_ERRRUN
+	}
+	se := err.(*lexer.SyntaxError)
+	l.stack.push(fmt.Sprintf("%s:%d: %s", chunkID(se.Source), se.Line, se.Msg))
+	return api.LUA_ERRSYNTAX
+}
 
+// pushMainClosure pushes a closure of the main function proto, with the
+// globals as its first upvalue and nil in the others.
+func (l *luaState) pushMainClosure(proto *binchunk.ProtoType) {
 	c := newLuaClosure(proto)
 	l.stack.push(c)
 	if len(proto.Upvalues) > 0 {
 		env := l.registry.get(api.LUA_RIDX_GLOBALS)
 		c.upvals[0] = &upvalue{&env}
 	}
-	return api.LUA_OK
+	for i := 1; i < len(c.upvals); i++ {
+		c.upvals[i] = &upvalue{new(luaValue)}
+	}
+}
+
+// Dump - lua_dump, it returns the binary chunk of the Lua function at the
+// top of the stack, nil if it is not a Lua function.
+func (l *luaState) Dump(strip bool) []byte {
+	if c, ok := l.stack.get(-1).(*closure); ok && c.proto != nil {
+		return binchunk.Dump(c.proto, strip)
+	}
+	return nil
 }
 
 // Call - lua_call
@@ -39,40 +148,41 @@ func (l *luaState) Call(nArgs, nResults int) {
 		}
 	}
 
-	if ok {
-		if c.proto != nil {
-			l.callLuaClosure(nArgs, nResults, c)
-		} else {
-			l.callGoClosure(nArgs, nResults, c)
-		}
-	} else {
+	if !ok {
 		panic("not function")
 	}
+
+	if l.nCalls >= l.maxCalls {
+		l.stackOverflow()
+	}
+	l.checkGC()
+	l.nCalls++
+	if c.proto != nil {
+		l.callLuaClosure(nArgs, nResults, c)
+	} else {
+		if l.nCcalls >= api.LUAI_MAXCCALLS {
+			l.cStackOverflow()
+		}
+		l.nCcalls++
+		l.callGoClosure(nArgs, nResults, c)
+		l.nCcalls--
+	}
+	l.nCalls--
 }
 
 func (l *luaState) callGoClosure(nArgs, nResults int, c *closure) {
-	// create new lua stack
-	newStack := newLuaStack(nArgs+api.LUA_MINSTACK, l)
-	newStack.closure = c
+	stack := l.stack
+	fn := stack.top - nArgs - 1
 
-	// pass args, pop func
-	if nArgs > 0 {
-		args := l.stack.popN(nArgs)
-		newStack.pushN(args, nArgs)
-	}
-	l.stack.pop()
+	// enter new frame, args are already in place
+	stack.check(api.LUA_MINSTACK)
+	stack.pushCallInfo(c, fn, fn+1)
 
 	// run closure
-	l.pushLuaStack(newStack)
 	r := c.goFunc(l)
-	l.popLuaStack()
 
 	// return results
-	if nResults != 0 {
-		results := newStack.popN(r)
-		l.stack.check(len(results))
-		l.stack.pushN(results, nResults)
-	}
+	l.postCall(r, nResults)
 }
 
 func (l *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
@@ -80,29 +190,72 @@ func (l *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
 	nParams := int(c.proto.NumParams)
 	isVararg := c.proto.IsVararg == 1
 
-	// create new lua stack
-	newStack := newLuaStack(nRegs+api.LUA_MINSTACK, l)
-	newStack.closure = c
+	stack := l.stack
+	fn := stack.top - nArgs - 1
+	base := fn + 1
+
+	// keep extra args below the registers as varargs
+	nVarargs := 0
+	if isVararg && nArgs > nParams {
+		nVarargs = nArgs - nParams
+		base = stack.top
+		stack.check(nParams)
+		for i := 0; i < nParams; i++ {
+			stack.slots[base+i] = stack.slots[fn+1+i]
+			stack.slots[fn+1+i] = nil
+		}
+		stack.top = base + nParams
+		nArgs = nParams
+	}
 
-	// pass args, pop func
-	funcAndArgs := l.stack.popN(nArgs + 1)
-	newStack.pushN(funcAndArgs[1:], nParams)
-	newStack.top = nRegs
-	if nArgs > nParams && isVararg {
-		newStack.varargs = funcAndArgs[nParams+1:]
+	// enter new frame
+	stack.check(nRegs + api.LUA_MINSTACK)
+	if nArgs > nParams { // drop extra args
+		stack.settop(base + nParams)
 	}
+	stack.settop(base + nRegs)
+	ci := stack.pushCallInfo(c, fn, base)
+	ci.nVarargs = nVarargs
 
 	// run closure
-	l.pushLuaStack(newStack)
 	l.runLuaClosure()
-	l.popLuaStack()
 
 	// return results
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		l.stack.check(len(results))
-		l.stack.pushN(results, nResults)
+	l.postCall(stack.top-base-nRegs, nResults)
+}
+
+// postCall leaves the frame of the current function and moves its last
+// nRets values to the slot of the called function, adjusted to nResults.
+func (l *luaState) postCall(nRets, nResults int) {
+	stack := l.stack
+	ci := stack.ci
+	if stack.hasTBC(ci.base) {
+		l.closeTBC(ci.base, nil)
+	}
+	stack.closeUpvalues(ci.base)
+	stack.popCallInfo()
+
+	if nResults < 0 {
+		nResults = nRets
+	}
+	oldTop := stack.top
+	src := oldTop - nRets
+	dst := ci.fn
+	if newTop := dst + nResults; newTop > oldTop {
+		stack.check(newTop - oldTop)
 	}
+	slots := stack.slots
+	for i := 0; i < nResults; i++ {
+		if i < nRets {
+			slots[dst+i] = slots[src+i]
+		} else {
+			slots[dst+i] = nil
+		}
+	}
+	for i := dst + nResults; i < oldTop; i++ {
+		slots[i] = nil
+	}
+	stack.top = dst + nResults
 }
 
 func (l *luaState) runLuaClosure() {
@@ -117,19 +270,31 @@ func (l *luaState) runLuaClosure() {
 
 // PCall - lua_pcall
 func (l *luaState) PCall(nArgs, nResults, msgh int) (status int) {
-	caller := l.stack
+	stack := l.stack
+	caller := stack.ci
+	nCalls, nCcalls := l.nCalls, l.nCcalls
+	fn := stack.top - nArgs - 1
 	status = api.LUA_ERRRUN
 
-	// catch error
+	// catch error, the status tells errors apart even when the error
+	// value is nil
 	defer func() {
-		if err := recover(); err != nil {
+		if status != api.LUA_OK {
+			err := recover()
 			if msgh != 0 {
 				panic(err)
 			}
-			for l.stack != caller {
-				l.popLuaStack()
+			if e, ok := err.(error); ok { /* a Go runtime error */
+				err = e.Error()
+			}
+			stack.ci = caller
+			l.nCalls, l.nCcalls = nCalls, nCcalls
+			stack.closeUpvalues(fn)
+			if stack.hasTBC(fn) {
+				err = l.closeProtected(fn, err)
 			}
-			l.stack.push(err)
+			stack.settop(fn)
+			stack.push(err)
 		}
 	}()
 
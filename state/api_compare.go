@@ -70,6 +70,13 @@ func _eq(a, b luaValue, l *luaState) bool {
 			}
 		}
 		return a == b
+	case *userdata:
+		if y, ok := b.(*userdata); ok && x != y && l != nil {
+			if result, ok := callMetamethod(x, y, "__eq", l); ok {
+				return convertToBoolean(result)
+			}
+		}
+		return a == b
 	default:
 		return a == b
 	}
@@ -125,8 +132,11 @@ func _le(a, b luaValue, l *luaState) bool {
 	}
 	if result, ok := callMetamethod(a, b, "__le", l); ok {
 		return convertToBoolean(result)
-	} else if result, ok := callMetamethod(b, a, "__lt", l); ok {
-		return !convertToBoolean(result)
+	}
+	if l.global.version < api.LUA_VERSION_54 { // 5.4 no longer tries not (b < a)
+		if result, ok := callMetamethod(b, a, "__lt", l); ok {
+			return !convertToBoolean(result)
+		}
 	}
 	panic("comparison error!")
 }
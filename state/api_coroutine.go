@@ -1,11 +1,25 @@
 package state
 
-import "github.com/iglev/glua/api"
+import (
+	"runtime"
+
+	"github.com/iglev/glua/api"
+)
+
+// coClosing is the status a suspended coroutine wakes up with when
+// CloseThread closes it.
+const coClosing = -1
 
 // NewThread - lua_newthread
 func (self *luaState) NewThread() api.LuaState {
-	t := &luaState{registry: self.registry}
-	t.pushLuaStack(newLuaStack(api.LUA_MINSTACK, t))
+	t := &luaState{
+		registry: self.registry,
+		global:   self.global,
+		gc:       self.gc,
+		maxCalls: self.maxCalls,
+		maxStack: self.maxStack,
+	}
+	t.stack = newLuaStack(api.LUA_MINSTACK, t)
 	self.stack.push(t)
 	return t
 }
@@ -22,8 +36,11 @@ func (self *luaState) Resume(from api.LuaState, nArgs int) int {
 		self.coChan = make(chan int)
 		self.coCaller = lsFrom
 		go func() {
+			defer func() { self.coCaller.coChan <- 1 }()
 			self.coStatus = self.PCall(nArgs, -1, 0)
-			lsFrom.coChan <- 1
+			if self.coStatus != api.LUA_OK {
+				self.coErr = self.stack.get(-1)
+			}
 		}()
 	} else {
 		// resume coroutine
@@ -47,9 +64,41 @@ func (self *luaState) Yield(nResults int) int {
 	self.coStatus = api.LUA_YIELD
 	self.coCaller.coChan <- 1
 	<-self.coChan
+	if self.coStatus == coClosing {
+		runtime.Goexit() // PCall closes the variables as it unwinds
+	}
 	return self.GetTop()
 }
 
+// CloseThread resets a suspended or dead coroutine, closing its pending
+// to-be-closed variables. It returns LUA_OK, or the status of the error of
+// a coroutine that died by an error, or of a __close metamethod, and then
+// leaves the error object on the stack - lua_closethread
+func (self *luaState) CloseThread(from api.LuaState) int {
+	status, err := self.coStatus, self.coErr
+	if status == api.LUA_YIELD {
+		lsFrom := from.(*luaState)
+		if lsFrom.coChan == nil {
+			lsFrom.coChan = make(chan int)
+		}
+		self.coCaller = lsFrom
+		self.coStatus = coClosing
+		self.coChan <- 1
+		<-lsFrom.coChan // wait coroutine to unwind
+		status, err = api.LUA_OK, self.stack.get(-1)
+		if err != nil {
+			status = api.LUA_ERRRUN
+		}
+	}
+
+	self.stack = newLuaStack(api.LUA_MINSTACK, self)
+	self.coStatus, self.coCaller, self.coChan, self.coErr = api.LUA_OK, nil, nil, nil
+	if status != api.LUA_OK {
+		self.stack.push(err)
+	}
+	return status
+}
+
 // IsYieldable - lua_isyieldable
 func (self *luaState) IsYieldable() bool {
 	if self.isMainThread() {
@@ -65,5 +114,5 @@ func (self *luaState) Status() int {
 
 // debug
 func (self *luaState) GetStack() bool {
-	return self.stack.prev != nil
+	return self.stack.ci.prev != nil
 }
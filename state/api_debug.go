@@ -0,0 +1,73 @@
+package state
+
+// GetFunction pushes the function running at the given level of the call
+// stack, level 0 being the current running function and level n+1 the
+// function that called level n. It pushes nothing and returns false if
+// the level is greater than the stack depth - lua_getstack and
+// lua_getinfo with "f"
+func (l *luaState) GetFunction(level int) bool {
+	ci := l.stack.ci.at(level)
+	if ci == nil || ci.closure == nil {
+		return false
+	}
+	l.stack.push(ci.closure)
+	return true
+}
+
+// GetUpvalue pushes the value of the upvalue n of the function at funcIdx
+// and returns its name, "" for Go functions and stripped Lua functions. It
+// pushes nothing and returns false if there is no such upvalue -
+// lua_getupvalue
+func (l *luaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	c, uv := l.upvalueAt(funcIdx, n)
+	if uv == nil {
+		return "", false
+	}
+	l.stack.push(*uv.val)
+	return upvalueName(c, n), true
+}
+
+// SetUpvalue pops a value and sets it as the value of the upvalue n of the
+// function at funcIdx, it returns the name of the upvalue as GetUpvalue
+// does. It pops nothing and returns false if there is no such upvalue -
+// lua_setupvalue
+func (l *luaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	c, uv := l.upvalueAt(funcIdx, n)
+	if uv == nil {
+		return "", false
+	}
+	*uv.val = l.stack.pop()
+	return upvalueName(c, n), true
+}
+
+// UpvalueJoin makes the upvalue n1 of the function at funcIdx1 refer to
+// the upvalue n2 of the function at funcIdx2 - lua_upvaluejoin
+func (l *luaState) UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int) {
+	c1, uv1 := l.upvalueAt(funcIdx1, n1)
+	_, uv2 := l.upvalueAt(funcIdx2, n2)
+	if uv1 == nil || uv2 == nil {
+		panic("invalid upvalue index")
+	}
+	c1.upvals[n1-1] = uv2
+}
+
+func (l *luaState) upvalueAt(funcIdx, n int) (*closure, *upvalue) {
+	c, ok := l.stack.get(funcIdx).(*closure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return nil, nil
+	}
+	uv := c.upvals[n-1]
+	if uv == nil { /* not set by Load */
+		var val luaValue
+		uv = &upvalue{&val}
+		c.upvals[n-1] = uv
+	}
+	return c, uv
+}
+
+func upvalueName(c *closure, n int) string {
+	if c.proto != nil && n <= len(c.proto.UpvalueNames) {
+		return c.proto.UpvalueNames[n-1]
+	}
+	return ""
+}
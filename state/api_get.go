@@ -6,7 +6,8 @@ import (
 
 // CreateTable - lua_createtable
 func (l *luaState) CreateTable(nArr, nRec int) {
-	t := newLuaTable(nArr, nRec)
+	l.checkGC()
+	t := l.newTable(nArr, nRec)
 	l.stack.push(t)
 }
 
@@ -16,30 +17,34 @@ func (l *luaState) NewTable() {
 }
 
 func (l *luaState) getTable(t, k luaValue, raw bool) api.LuaType {
-	if tbl, ok := t.(*luaTable); ok {
-		v := tbl.get(k)
-		if raw || v != nil || !tbl.hasMetafield("__index") {
-			l.stack.push(v)
-			return typeOf(v)
+	for loop := 0; loop < MAXTAGLOOP; loop++ {
+		if tbl, ok := t.(*luaTable); ok {
+			v := tbl.get(k)
+			if raw || v != nil || !tbl.hasMetafield("__index") {
+				l.stack.push(v)
+				return typeOf(v)
+			}
 		}
-	}
 
-	if !raw {
-		if mf := getMetafield(t, "__index", l); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				return l.getTable(x, k, false)
-			case *closure:
-				l.stack.push(mf)
-				l.stack.push(t)
-				l.stack.push(k)
-				l.Call(2, 1)
-				v := l.stack.get(-1)
-				return typeOf(v)
+		if !raw {
+			if mf := getMetafield(t, "__index", l); mf != nil {
+				switch x := mf.(type) {
+				case *luaTable:
+					t = x
+					continue
+				case *closure:
+					l.stack.push(mf)
+					l.stack.push(t)
+					l.stack.push(k)
+					l.Call(2, 1)
+					v := l.stack.get(-1)
+					return typeOf(v)
+				}
 			}
 		}
+		panic("not a table") // todo
 	}
-	panic("not a table") // todo
+	panic("'__index' chain too long; possible loop")
 }
 
 // GetTable - lua_gettable
@@ -74,6 +79,12 @@ func (l *luaState) RawGetI(idx int, i int64) api.LuaType {
 	return l.getTable(t, i, true)
 }
 
+// RawGetP - lua_rawgetp
+func (l *luaState) RawGetP(idx int, p interface{}) api.LuaType {
+	t := l.stack.get(idx)
+	return l.getTable(t, lightUserdata{p}, true)
+}
+
 // GetGlobal - lua_getglobal
 func (l *luaState) GetGlobal(name string) api.LuaType {
 	t := l.registry.get(api.LUA_RIDX_GLOBALS)
@@ -89,3 +100,20 @@ func (l *luaState) GetMetatable(idx int) bool {
 	}
 	return false
 }
+
+// NewUserdata - lua_newuserdata, it pushes a full userdata holding v,
+// without metatable.
+func (l *luaState) NewUserdata(v interface{}) {
+	l.checkGC()
+	l.stack.push(newUserdata(v, nil))
+}
+
+// GetUserValue - lua_getuservalue
+func (l *luaState) GetUserValue(idx int) api.LuaType {
+	u, ok := l.stack.get(idx).(*userdata)
+	if !ok {
+		panic("full userdata expected")
+	}
+	l.stack.push(u.user)
+	return typeOf(u.user)
+}
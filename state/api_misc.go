@@ -1,6 +1,13 @@
 package state
 
-import "github.com/iglev/glua/number"
+import (
+	"io"
+	"runtime"
+	"strings"
+
+	"github.com/iglev/glua/api"
+	"github.com/iglev/glua/number"
+)
 
 // Len - lua_len
 func (l *luaState) Len(idx int) {
@@ -17,32 +24,44 @@ func (l *luaState) Len(idx int) {
 	}
 }
 
-// Concat - lua_concat
+// Concat - lua_concat, runs of strings and numbers are joined in a single
+// pass (as by luaV_concat) so that concatenating many values is linear.
 func (l *luaState) Concat(n int) {
 	if n == 0 {
 		l.stack.push("")
-	} else if n >= 2 {
-		for i := 1; i < n; i++ {
-			if l.IsString(-1) && l.IsString(-2) {
-				s2 := l.ToString(-1)
-				s1 := l.ToString(-2)
-				l.stack.pop()
-				l.stack.pop()
-				l.stack.push(s1 + s2)
-				continue
+		return
+	}
+	for n >= 2 { // n == 1, do nothing
+		if l.IsString(-1) && l.IsString(-2) {
+			/* at least two string values; get as many as possible */
+			k, size := 2, 0
+			for k < n && l.IsString(-(k + 1)) {
+				k++
 			}
-
-			b := l.stack.pop()
-			a := l.stack.pop()
-			if result, ok := callMetamethod(a, b, "__concat", l); ok {
-				l.stack.push(result)
-				continue
+			for i := k; i >= 1; i-- {
+				size += len(l.ToString(-i))
+			}
+			var sb strings.Builder
+			sb.Grow(size)
+			for i := k; i >= 1; i-- {
+				sb.WriteString(l.ToString(-i))
 			}
+			l.stack.settop(l.stack.top - k)
+			l.stack.push(sb.String())
+			n -= k - 1
+			continue
+		}
 
-			panic("concatenation error!")
+		b := l.stack.pop()
+		a := l.stack.pop()
+		if result, ok := callMetamethod(a, b, "__concat", l); ok {
+			l.stack.push(result)
+			n--
+			continue
 		}
+
+		panic("concatenation error!")
 	}
-	// n == 1, do nothing
 }
 
 // Next - lua_next
@@ -50,9 +69,9 @@ func (l *luaState) Next(idx int) bool {
 	val := l.stack.get(idx)
 	if t, ok := val.(*luaTable); ok {
 		key := l.stack.pop()
-		if nextKey := t.nextKey(key); nextKey != nil {
+		if nextKey, nextVal := t.next(key); nextKey != nil {
 			l.stack.push(nextKey)
-			l.stack.push(t.get(nextKey))
+			l.stack.push(nextVal)
 			return true
 		}
 		return false
@@ -78,3 +97,126 @@ func (l *luaState) StringToNumber(s string) bool {
 	}
 	return false
 }
+
+// GC - lua_gc
+// Memory is managed by the Go runtime: "collect" also runs the Go collector
+// and the counts report the heap of the whole process, while stopping only
+// stops the cycles of this state that clear weak tables and run finalizers.
+func (l *luaState) GC(what, data int) int {
+	g := l.gc
+	switch what {
+	case api.LUA_GCSTOP:
+		g.stopped = true
+	case api.LUA_GCRESTART:
+		g.stopped = false
+	case api.LUA_GCCOLLECT:
+		l.fullGC()
+		runtime.GC()
+	case api.LUA_GCCOUNT, api.LUA_GCCOUNTB:
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if what == api.LUA_GCCOUNT {
+			return int(stats.HeapAlloc >> 10)
+		}
+		return int(stats.HeapAlloc & 0x3ff)
+	case api.LUA_GCSTEP:
+		l.fullGC()
+		return 1 /* a step always ends a cycle */
+	case api.LUA_GCISRUNNING:
+		if g.stopped {
+			return 0
+		}
+		return 1
+	default:
+		return -1 /* invalid option */
+	}
+	return 0
+}
+
+// ToClose - lua_toclose, it marks the slot idx to be closed: its __close
+// metamethod is called with the value and an error object (or nil) when
+// the slot is removed by SetTop, by the return of the current function or
+// by an error.
+func (l *luaState) ToClose(idx int) {
+	stack := l.stack
+	slot := stack.slot(idx)
+	val := stack.slots[slot]
+	if val != nil && val != false && getMetafield(val, "__close", l) == nil {
+		l.Error2("variable '?' got a non-closable value")
+	}
+	if stack.hasTBC(slot) {
+		panic("slot already marked or below a marked slot")
+	}
+	stack.tbc = append(stack.tbc, slot)
+}
+
+// closeTBC calls the __close metamethods of the to-be-closed slots at or
+// above the absolute slot level, last marked first.
+func (l *luaState) closeTBC(level int, err luaValue) {
+	stack := l.stack
+	for stack.hasTBC(level) {
+		slot := stack.tbc[len(stack.tbc)-1]
+		stack.tbc = stack.tbc[:len(stack.tbc)-1]
+		val := stack.slots[slot]
+		if mm := getMetafield(val, "__close", l); mm != nil {
+			stack.push(mm)
+			stack.push(val)
+			stack.push(err)
+			l.Call(2, 0)
+		}
+	}
+}
+
+// closeProtected is closeTBC after the error err, an error raised by a
+// __close metamethod replaces err and the remaining slots are still
+// closed. It returns the final error.
+func (l *luaState) closeProtected(level int, err luaValue) luaValue {
+	for l.stack.hasTBC(level) {
+		l.stack.push(newGoClosure(func(api.LuaState) int {
+			l.closeTBC(level, err)
+			return 0
+		}, 0))
+		if l.PCall(0, 0, 0) == api.LUA_OK {
+			break
+		}
+		err = l.stack.pop()
+	}
+	return err
+}
+
+/* states of Warning */
+const (
+	warnOff = iota
+	warnOn
+	warnCont // continuing a message
+)
+
+// Warning emits the warning msg, continued by the next call if toCont -
+// lua_warning. As with the default of lauxlib, warnings are written to
+// Stderr once turned on by the control message "@on", "@off" turns them
+// off again.
+func (l *luaState) Warning(msg string, toCont bool) {
+	g := l.global
+	if g.warn != warnCont && !toCont && strings.HasPrefix(msg, "@") {
+		switch msg {
+		case "@on":
+			g.warn = warnOn
+		case "@off":
+			g.warn = warnOff
+		}
+		return
+	}
+	if g.warn == warnOff {
+		return
+	}
+	if g.warn == warnOn {
+		io.WriteString(g.stderr, "Lua warning: ")
+	}
+	io.WriteString(g.stderr, msg)
+	if toCont {
+		g.warn = warnCont
+	} else {
+		io.WriteString(g.stderr, "\n")
+		g.warn = warnOn
+	}
+}
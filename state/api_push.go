@@ -62,6 +62,11 @@ func (l *luaState) PushGlobalTable() {
 	l.stack.push(global)
 }
 
+// PushLightUserdata - lua_pushlightuserdata, p must be comparable.
+func (l *luaState) PushLightUserdata(p interface{}) {
+	l.stack.push(lightUserdata{p})
+}
+
 // PushThread - lua_pushthread
 func (l *luaState) PushThread() bool {
 	l.stack.push(l)
@@ -0,0 +1,566 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/iglev/glua/api"
+)
+
+/*
+Go values are pushed by value when Lua has a type for them: booleans,
+numbers of any Go numeric type, strings and GoFunctions. Structs, pointers,
+slices, arrays, maps, funcs and channels are pushed as proxies, userdata
+holding the Go value whose metatable (see go_proxy.go) gives access to it.
+Nil pointers, maps, funcs, channels and interfaces are pushed as nil.
+
+ToGoValue goes the other way, converting a Lua value to the type of its
+target: numbers and strings are coerced as Lua does, tables are copied into
+structs, slices, arrays and maps, functions become Go funcs calling them and
+proxies give back the Go value they hold.
+*/
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var interfaceSliceType = reflect.TypeOf([]interface{}(nil))
+
+// PushGoValue pushes the Go value v, a proxy if Lua has no type for it.
+func (l *luaState) PushGoValue(v interface{}) {
+	l.stack.push(l.goToLua(v))
+}
+
+// ToGoValue converts the value at idx and stores it in the value pointed to
+// by target.
+func (l *luaState) ToGoValue(idx int, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("ToGoValue: target must be a non-nil pointer")
+	}
+	e := rv.Elem()
+	v, err := l.luaToGo(l.stack.get(idx), e.Type())
+	if err != nil {
+		return err
+	}
+	e.Set(v)
+	return nil
+}
+
+func (l *luaState) goToLua(v interface{}) luaValue {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		return x
+	case int:
+		return int64(x)
+	case int64:
+		return x
+	case float64:
+		return x
+	case string:
+		return x
+	case api.GoFunction:
+		return newGoClosure(x, 0)
+	case func(api.LuaState) int:
+		return newGoClosure(x, 0)
+	}
+	return l.reflectToLua(reflect.ValueOf(v))
+}
+
+func (l *luaState) reflectToLua(rv reflect.Value) luaValue {
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return float64(u)
+		}
+		return int64(u)
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return l.reflectToLua(rv.Elem())
+	case reflect.Ptr, reflect.Map, reflect.Chan:
+		if rv.IsNil() {
+			return nil
+		}
+	case reflect.Func:
+		if rv.IsNil() {
+			return nil
+		}
+		if f, ok := rv.Interface().(func(api.LuaState) int); ok {
+			return newGoClosure(f, 0)
+		}
+		if rv.Type().ConvertibleTo(goFunctionType) {
+			return newGoClosure(rv.Convert(goFunctionType).Interface().(api.GoFunction), 0)
+		}
+	}
+	return newUserdata(rv.Interface(), l.goProxyMetatable())
+}
+
+var goFunctionType = reflect.TypeOf(api.GoFunction(nil))
+
+/* Lua -> Go */
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// luaToGo converts val to a Go value of type t.
+func (l *luaState) luaToGo(val luaValue, t reflect.Type) (reflect.Value, error) {
+	rv := reflect.New(t).Elem()
+	c := &goConverter{l: l}
+	return rv, c.assign(val, rv)
+}
+
+// ConversionError is the error for a Lua value that cannot be converted to
+// a Go value.
+type ConversionError struct {
+	Path     string // to the value, such as servers[2].port, "" for the root
+	Expected string // name of the expected Lua type, if the type was wrong
+	Got      string // type of the value
+	Msg      string // if the type was right
+}
+
+func (e *ConversionError) Error() string {
+	msg := e.Msg
+	if e.Expected != "" {
+		msg = fmt.Sprintf("expected %s, got %s", e.Expected, e.Got)
+	}
+	if e.Path == "" {
+		return msg
+	}
+	return e.Path + ": " + msg
+}
+
+// argMsg returns the message of e in the style of argument errors.
+func (e *ConversionError) argMsg() string {
+	if e.Expected == "" {
+		return e.Error()
+	}
+	msg := fmt.Sprintf("%s expected, got %s", e.Expected, e.Got)
+	if e.Path == "" {
+		return msg
+	}
+	return e.Path + ": " + msg
+}
+
+// within prefixes the path of err with seg, a field name or "[key]".
+func within(err error, seg string) error {
+	if e, ok := err.(*ConversionError); ok {
+		switch {
+		case e.Path == "":
+			e.Path = seg
+		case e.Path[0] == '[':
+			e.Path = seg + e.Path
+		default:
+			e.Path = seg + "." + e.Path
+		}
+	}
+	return err
+}
+
+type goConverter struct {
+	l        *luaState
+	visiting map[*luaTable]bool // tables being converted, to detect cycles
+}
+
+func (c *goConverter) fail(msg string, a ...interface{}) error {
+	return &ConversionError{Msg: fmt.Sprintf(msg, a...)}
+}
+
+// assign converts val and stores it in the settable rv. Structs, maps and
+// pointers already in rv are updated rather than replaced, as by
+// encoding/json.
+func (c *goConverter) assign(val luaValue, rv reflect.Value) error {
+	t := rv.Type()
+	if u, ok := val.(*userdata); ok {
+		if uv := reflect.ValueOf(u.value); uv.IsValid() {
+			if uv.Type().AssignableTo(t) {
+				rv.Set(uv)
+				return nil
+			}
+			if uv.Kind() == reflect.Ptr && uv.Type().Elem().AssignableTo(t) {
+				rv.Set(uv.Elem())
+				return nil
+			}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if val == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		if t.NumMethod() == 0 {
+			x, err := c.toInterface(val)
+			if err != nil {
+				return err
+			}
+			if x == nil {
+				rv.Set(reflect.Zero(t))
+			} else {
+				rv.Set(reflect.ValueOf(x))
+			}
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := val.(bool); ok {
+			rv.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if s, ok := val.(string); ok && t == durationType {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return c.fail("invalid duration %q", s)
+			}
+			rv.SetInt(int64(d))
+			return nil
+		}
+		if n, ok := c.toInteger(val); ok {
+			if rv.OverflowInt(n) {
+				return c.fail("number out of range for %s", t)
+			}
+			rv.SetInt(n)
+			return nil
+		} else if _, isNum := c.toFloat(val); isNum {
+			return c.fail("number has no integer representation")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := c.toInteger(val); ok {
+			if n < 0 || rv.OverflowUint(uint64(n)) {
+				return c.fail("number out of range for %s", t)
+			}
+			rv.SetUint(uint64(n))
+			return nil
+		} else if f, isNum := c.toFloat(val); isNum && f >= 0 && f < 1<<64 && f == math.Floor(f) {
+			if rv.OverflowUint(uint64(f)) {
+				return c.fail("number out of range for %s", t)
+			}
+			rv.SetUint(uint64(f))
+			return nil
+		} else if isNum {
+			return c.fail("number has no integer representation")
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := c.toFloat(val); ok {
+			rv.SetFloat(f)
+			return nil
+		}
+	case reflect.String:
+		switch x := val.(type) {
+		case string:
+			rv.SetString(x)
+			return nil
+		case int64, float64:
+			rv.SetString(fmt.Sprintf("%v", x))
+			return nil
+		}
+	case reflect.Slice:
+		if val == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		if s, ok := val.(string); ok && t.Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes([]byte(s))
+			return nil
+		}
+		if tbl, ok := val.(*luaTable); ok {
+			return c.tableToSlice(tbl, rv)
+		}
+	case reflect.Array:
+		if tbl, ok := val.(*luaTable); ok {
+			return c.tableToSlice(tbl, rv)
+		}
+	case reflect.Map:
+		if val == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		if tbl, ok := val.(*luaTable); ok {
+			return c.tableToMap(tbl, rv)
+		}
+	case reflect.Struct:
+		if tbl, ok := val.(*luaTable); ok {
+			return c.tableToStruct(tbl, rv)
+		}
+	case reflect.Ptr:
+		if val == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return c.assign(val, rv.Elem())
+	case reflect.Func:
+		if val == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		if f, ok := val.(*closure); ok {
+			rv.Set(c.l.luaFunc(f, t))
+			return nil
+		}
+	case reflect.Chan:
+		if val == nil {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+	}
+	return &ConversionError{Expected: luaTypeNameOf(t), Got: c.typeName(val)}
+}
+
+func (c *goConverter) typeName(val luaValue) string {
+	if u, ok := val.(*userdata); ok && u.value != nil {
+		return reflect.TypeOf(u.value).String()
+	}
+	return c.l.TypeName(typeOf(val))
+}
+
+// toInteger converts numbers and strings with an exact integer value.
+func (c *goConverter) toInteger(val luaValue) (int64, bool) {
+	switch val.(type) {
+	case int64, float64, string:
+		return convertToInteger(val)
+	}
+	return 0, false
+}
+
+func (c *goConverter) toFloat(val luaValue) (float64, bool) {
+	switch val.(type) {
+	case int64, float64, string:
+		return convertToFloat(val)
+	}
+	return 0, false
+}
+
+func (c *goConverter) enter(tbl *luaTable) error {
+	if c.visiting == nil {
+		c.visiting = map[*luaTable]bool{}
+	}
+	if c.visiting[tbl] {
+		return c.fail("cannot convert a table with cycles")
+	}
+	c.visiting[tbl] = true
+	return nil
+}
+
+func (c *goConverter) leave(tbl *luaTable) {
+	delete(c.visiting, tbl)
+}
+
+// tableToSlice fills the slice or array rv with the sequence t[1..#t].
+func (c *goConverter) tableToSlice(tbl *luaTable, rv reflect.Value) error {
+	if err := c.enter(tbl); err != nil {
+		return err
+	}
+	defer c.leave(tbl)
+
+	n := tbl.len()
+	if rv.Kind() == reflect.Slice {
+		rv.Set(reflect.MakeSlice(rv.Type(), n, n))
+	} else {
+		rv.Set(reflect.Zero(rv.Type()))
+		if n > rv.Len() {
+			n = rv.Len()
+		}
+	}
+	for i := 0; i < n; i++ {
+		if err := c.assign(tbl.getInt(int64(i+1)), rv.Index(i)); err != nil {
+			return within(err, fmt.Sprintf("[%d]", i+1))
+		}
+	}
+	return nil
+}
+
+func (c *goConverter) tableToMap(tbl *luaTable, rv reflect.Value) error {
+	if err := c.enter(tbl); err != nil {
+		return err
+	}
+	defer c.leave(tbl)
+
+	t := rv.Type()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(t))
+	}
+	for k, v := tbl.next(nil); k != nil; k, v = tbl.next(k) {
+		seg := fmt.Sprintf("[%v]", k)
+		if s, ok := k.(string); ok {
+			seg = fmt.Sprintf("[%q]", s)
+		}
+		gk := reflect.New(t.Key()).Elem()
+		if err := c.assign(k, gk); err != nil {
+			return within(err, seg)
+		}
+		gv := reflect.New(t.Elem()).Elem()
+		if err := c.assign(v, gv); err != nil {
+			return within(err, seg)
+		}
+		rv.SetMapIndex(gk, gv)
+	}
+	return nil
+}
+
+// tableToStruct sets the fields named by the string keys of tbl, other
+// keys are ignored.
+func (c *goConverter) tableToStruct(tbl *luaTable, rv reflect.Value) error {
+	if err := c.enter(tbl); err != nil {
+		return err
+	}
+	defer c.leave(tbl)
+
+	info := structInfoOf(rv.Type())
+	for k, v := tbl.next(nil); k != nil; k, v = tbl.next(k) {
+		name, ok := k.(string)
+		if !ok {
+			continue
+		}
+		if f, ok := info.field(rv, name, true); ok && f.CanSet() {
+			if err := c.assign(v, f); err != nil {
+				return within(err, name)
+			}
+		}
+	}
+	return nil
+}
+
+// toInterface converts val to its natural Go type: sequences become
+// []interface{}, tables with string keys map[string]interface{}, other
+// tables map[interface{}]interface{} and functions
+// func(...interface{}) []interface{}.
+func (c *goConverter) toInterface(val luaValue) (interface{}, error) {
+	switch x := val.(type) {
+	case nil, bool, int64, float64, string:
+		return x, nil
+	case *userdata:
+		return x.value, nil
+	case *closure:
+		f := c.l.luaFunc(x, reflect.TypeOf(func(...interface{}) []interface{} { return nil }))
+		return f.Interface(), nil
+	case *luaTable:
+		var t reflect.Type
+		switch tableKind(x) {
+		case 's':
+			t = interfaceSliceType
+		case 'm':
+			t = reflect.TypeOf(map[string]interface{}(nil))
+		default:
+			t = reflect.TypeOf(map[interface{}]interface{}(nil))
+		}
+		rv := reflect.New(t).Elem()
+		if err := c.assign(x, rv); err != nil {
+			return nil, err
+		}
+		return rv.Interface(), nil
+	}
+	return nil, c.fail("cannot convert %s", c.typeName(val))
+}
+
+// tableKind returns 's' for non-empty sequences, 'm' for tables whose keys
+// are all strings and 'x' for other tables.
+func tableKind(t *luaTable) byte {
+	n := int64(t.len())
+	count, strKeys := int64(0), true
+	for k, _ := t.next(nil); k != nil; k, _ = t.next(k) {
+		count++
+		if _, ok := k.(string); !ok {
+			strKeys = false
+		}
+	}
+	if n > 0 && count == n {
+		return 's'
+	}
+	if strKeys {
+		return 'm'
+	}
+	return 'x'
+}
+
+// luaFunc returns a Go func of type t calling the Lua function f. The func
+// runs on the thread l, so it may only be called while l is running Go code
+// on behalf of Lua (or is idle), from the goroutine running l. If the last
+// result of t is an error, Lua errors are returned instead of raised.
+func (l *luaState) luaFunc(f *closure, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(args []reflect.Value) (results []reflect.Value) {
+		if t.IsVariadic() && len(args) > 0 {
+			last := args[len(args)-1]
+			args = args[:len(args)-1]
+			for i := 0; i < last.Len(); i++ {
+				args = append(args, last.Index(i))
+			}
+		}
+
+		nOut := t.NumOut()
+		withErr := nOut > 0 && t.Out(nOut-1) == errorType
+		multRet := nOut == 1 && t.Out(0) == interfaceSliceType
+		nResults := nOut
+		if withErr {
+			nResults--
+		}
+		if multRet {
+			nResults = api.LUA_MULTRET
+		}
+
+		results = make([]reflect.Value, nOut)
+		for i := range results {
+			results[i] = reflect.Zero(t.Out(i))
+		}
+		fail := func(err error) []reflect.Value {
+			if !withErr {
+				panic(err.Error())
+			}
+			results[nOut-1] = reflect.ValueOf(&err).Elem()
+			return results
+		}
+
+		base := l.stack.top
+		l.stack.check(len(args) + 1)
+		l.stack.push(f)
+		for _, a := range args {
+			l.stack.push(l.reflectToLua(a))
+		}
+		if withErr {
+			if l.PCall(len(args), nResults, 0) != api.LUA_OK {
+				err := l.stack.pop()
+				return fail(fmt.Errorf("%v", err))
+			}
+		} else {
+			l.Call(len(args), nResults)
+		}
+		defer l.stack.settop(base)
+
+		if multRet {
+			all := make([]interface{}, 0, l.stack.top-base)
+			for i := base; i < l.stack.top; i++ {
+				x, err := (&goConverter{l: l}).toInterface(l.stack.slots[i])
+				if err != nil {
+					return fail(err)
+				}
+				all = append(all, x)
+			}
+			results[0] = reflect.ValueOf(all)
+			return results
+		}
+		for i := 0; i < nResults; i++ {
+			v, err := l.luaToGo(l.stack.slots[base+i], t.Out(i))
+			if err != nil {
+				return fail(fmt.Errorf("result #%d: %s", i+1, err))
+			}
+			results[i] = v
+		}
+		return results
+	})
+}
@@ -39,33 +39,43 @@ func (l *luaState) RawSetI(idx int, i int64) {
 	l.setTable(t, i, v, true)
 }
 
+// RawSetP - lua_rawsetp
+func (l *luaState) RawSetP(idx int, p interface{}) {
+	t := l.stack.get(idx)
+	v := l.stack.pop()
+	l.setTable(t, lightUserdata{p}, v, true)
+}
+
 // t[k]=v
 func (l *luaState) setTable(t, k, v luaValue, raw bool) {
-	if tbl, ok := t.(*luaTable); ok {
-		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
-			tbl.put(k, v)
-			return
+	for loop := 0; loop < MAXTAGLOOP; loop++ {
+		if tbl, ok := t.(*luaTable); ok {
+			if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+				tbl.put(k, v)
+				return
+			}
 		}
-	}
 
-	if !raw {
-		if mf := getMetafield(t, "__newindex", l); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				l.setTable(x, k, v, false)
-				return
-			case *closure:
-				l.stack.push(mf)
-				l.stack.push(t)
-				l.stack.push(k)
-				l.stack.push(v)
-				l.Call(3, 0)
-				return
+		if !raw {
+			if mf := getMetafield(t, "__newindex", l); mf != nil {
+				switch x := mf.(type) {
+				case *luaTable:
+					t = x
+					continue
+				case *closure:
+					l.stack.push(mf)
+					l.stack.push(t)
+					l.stack.push(k)
+					l.stack.push(v)
+					l.Call(3, 0)
+					return
+				}
 			}
 		}
-	}
 
-	panic("index error!")
+		panic("index error!")
+	}
+	panic("'__newindex' chain too long; possible loop")
 }
 
 // SetGlobal - lua_setglobal
@@ -94,3 +104,12 @@ func (l *luaState) SetMetatable(idx int) {
 		panic("table expected!") // todo
 	}
 }
+
+// SetUserValue - lua_setuservalue
+func (l *luaState) SetUserValue(idx int) {
+	u, ok := l.stack.get(idx).(*userdata)
+	if !ok {
+		panic("full userdata expected")
+	}
+	u.user = l.stack.pop()
+}
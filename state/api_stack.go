@@ -8,7 +8,7 @@ http://www.lua.org/manual/5.3/manual.html
 
 // GetTop - lua_gettop
 func (l *luaState) GetTop() int {
-	return l.stack.top
+	return l.stack.gettop()
 }
 
 // AbsIndex - lua_absindex
@@ -18,8 +18,11 @@ func (l *luaState) AbsIndex(idx int) int {
 
 // CheckStack - lua_checkstack
 func (l *luaState) CheckStack(n int) bool {
+	if l.stack.top+n > l.maxStack {
+		return false
+	}
 	l.stack.check(n)
-	return true // never fails
+	return true
 }
 
 // Pop - lua_pop
@@ -43,8 +46,8 @@ func (l *luaState) PushValue(idx int) {
 
 // Replace - lua_replace
 func (l *luaState) Replace(idx int) {
-	val := l.stack.pop()
-	l.stack.set(idx, val)
+	l.Copy(-1, idx)
+	l.stack.pop()
 }
 
 // Insert - lua_insert
@@ -66,7 +69,7 @@ func (l *luaState) Remove(idx int) {
 // Rotate - lua_rotate
 func (l *luaState) Rotate(idx, n int) {
 	t := l.stack.top - 1
-	p := l.stack.absIndex(idx) - 1
+	p := l.stack.slot(idx)
 	var m int
 	if n >= 0 {
 		m = t - n
@@ -84,20 +87,19 @@ func (l *luaState) SetTop(idx int) {
 	if newTop < 0 {
 		panic("stack underflow!")
 	}
-	n := l.stack.top - newTop
-	if n > 0 {
-		for i := 0; i < n; i++ {
-			l.stack.pop()
-		}
-	} else if n < 0 {
-		for i := 0; i > n; i-- {
-			l.stack.push(nil)
-		}
+	newTop += l.stack.ci.base
+	if l.stack.hasTBC(newTop) {
+		l.closeTBC(newTop, nil)
 	}
+	l.stack.settop(newTop)
 }
 
 // XMove - lua_xmove
 func (l *luaState) XMove(to api.LuaState, n int) {
-	vals := l.stack.popN(n)
-	to.(*luaState).stack.pushN(vals, n)
+	from, dst := l.stack, to.(*luaState).stack
+	dst.check(n)
+	for i := from.top - n; i < from.top; i++ {
+		dst.push(from.slots[i])
+	}
+	from.settop(from.top - n)
 }
@@ -2,24 +2,24 @@ package state
 
 // PC return pc
 func (l *luaState) PC() int {
-	return l.stack.pc
+	return l.stack.ci.pc
 }
 
 // AddPC add pc
 func (l *luaState) AddPC(n int) {
-	l.stack.pc += n
+	l.stack.ci.pc += n
 }
 
 // Fetch fetch
 func (l *luaState) Fetch() uint32 {
-	i := l.stack.closure.proto.Code[l.stack.pc]
-	l.stack.pc++
+	i := l.stack.ci.closure.proto.Code[l.stack.ci.pc]
+	l.stack.ci.pc++
 	return i
 }
 
 // GetConst get const
 func (l *luaState) GetConst(idx int) {
-	c := l.stack.closure.proto.Constants[idx]
+	c := l.stack.ci.closure.proto.Constants[idx]
 	l.stack.push(c)
 }
 
@@ -34,49 +34,48 @@ func (l *luaState) GetRK(rk int) {
 
 // RegisterCount register count
 func (l *luaState) RegisterCount() int {
-	return int(l.stack.closure.proto.MaxStackSize)
+	return int(l.stack.ci.closure.proto.MaxStackSize)
 }
 
 // LoadVararg load vararg
 func (l *luaState) LoadVararg(n int) {
+	stack := l.stack
+	ci := stack.ci
 	if n < 0 {
-		n = len(l.stack.varargs)
+		n = ci.nVarargs
 	}
 
-	l.stack.check(n)
-	l.stack.pushN(l.stack.varargs, n)
+	stack.check(n)
+	for i := 0; i < n; i++ {
+		if i < ci.nVarargs {
+			stack.push(stack.slots[ci.base-ci.nVarargs+i])
+		} else {
+			stack.push(nil)
+		}
+	}
 }
 
 // LoadProto load proto
 func (l *luaState) LoadProto(idx int) {
 	stack := l.stack
-	subProto := stack.closure.proto.Protos[idx]
+	ci := stack.ci
+	subProto := ci.closure.proto.Protos[idx]
 	closure := newLuaClosure(subProto)
 	stack.push(closure)
 	for i, uvInfo := range subProto.Upvalues {
 		uvIdx := int(uvInfo.Idx)
 		if uvInfo.Instack == 1 {
-			if stack.openuvs == nil {
-				stack.openuvs = make(map[int]*upvalue)
-			}
-			if openuv, found := stack.openuvs[uvIdx]; found {
-				closure.upvals[i] = openuv
-			} else {
-				closure.upvals[i] = &upvalue{&stack.slots[uvIdx]}
-				stack.openuvs[uvIdx] = closure.upvals[i]
-			}
+			closure.upvals[i] = stack.openUpvalue(ci.base + uvIdx)
 		} else {
-			closure.upvals[i] = stack.closure.upvals[uvIdx]
+			closure.upvals[i] = ci.closure.upvals[uvIdx]
 		}
 	}
 }
 
+// CloseUpvalues close upvalues, and the to-be-closed variables, from
+// register a-1 up
 func (l *luaState) CloseUpvalues(a int) {
-	for i, openuv := range l.stack.openuvs {
-		if i >= a-1 {
-			val := *openuv.val
-			openuv.val = &val
-			delete(l.stack.openuvs, i)
-		}
-	}
+	level := l.stack.ci.base + a - 1
+	l.stack.closeUpvalues(level)
+	l.closeTBC(level, nil)
 }
@@ -2,7 +2,8 @@ package state
 
 import (
 	"fmt"
-	"io/ioutil"
+	"os"
+	"strings"
 
 	"github.com/iglev/glua/api"
 	"github.com/iglev/glua/stdlib"
@@ -122,10 +123,12 @@ func (self *luaState) LoadFile(filename string) int {
 
 // LoadFileX - luaL_loadfilex
 func (self *luaState) LoadFileX(filename, mode string) int {
-	if data, err := ioutil.ReadFile(filename); err == nil {
-		return self.Load(data, "@"+filename, mode)
+	f, err := os.Open(filename)
+	if err != nil {
+		return api.LUA_ERRFILE
 	}
-	return api.LUA_ERRFILE
+	defer f.Close()
+	return self.LoadReader(f, "@"+filename, mode)
 }
 
 // LoadString - luaL_loadstring
@@ -234,21 +237,16 @@ func (self *luaState) CallMeta(obj int, event string) bool {
 
 // OpenLibs - luaL_openlibs
 func (self *luaState) OpenLibs() {
-	libs := map[string]api.GoFunction{
-		"_G":        stdlib.OpenBaseLib,
-		"math":      stdlib.OpenMathLib,
-		"table":     stdlib.OpenTableLib,
-		"string":    stdlib.OpenStringLib,
-		"utf8":      stdlib.OpenUTF8Lib,
-		"os":        stdlib.OpenOSLib,
-		"package":   stdlib.OpenPackageLib,
-		"coroutine": stdlib.OpenCoroutineLib,
-	}
-
-	for name, fun := range libs {
+	for name, fun := range stdlib.Libs {
 		self.RequireF(name, fun, true)
 		self.Pop(1)
 	}
+	if self.Version() == api.LUA_VERSION_51 {
+		for name, fun := range stdlib.Compat51Libs {
+			self.RequireF(name, fun, true)
+			self.Pop(1)
+		}
+	}
 }
 
 // RequireF - luaL_requiref
@@ -295,6 +293,152 @@ func (self *luaState) SetFuncs(l api.FuncReg, nup int) {
 	self.Pop(nup) /* remove upvalues */
 }
 
+/* index of free-list header in reference tables */
+const freelist = 0
+
+// Ref - luaL_ref
+func (self *luaState) Ref(t int) int {
+	if self.IsNil(-1) {
+		self.Pop(1)           /* remove it from stack */
+		return api.LUA_REFNIL /* 'nil' has a unique fixed reference */
+	}
+	t = self.AbsIndex(t)
+	self.RawGetI(t, freelist)      /* get first free element */
+	ref := int(self.ToInteger(-1)) /* ref = t[freelist] */
+	self.Pop(1)                    /* remove it from stack */
+	if ref != 0 {                  /* any free element? */
+		self.RawGetI(t, int64(ref)) /* remove it from list */
+		self.RawSetI(t, freelist)   /* (t[freelist] = t[ref]) */
+	} else { /* no free elements */
+		ref = int(self.RawLen(t)) + 1 /* get a new reference */
+	}
+	self.RawSetI(t, int64(ref))
+	return ref
+}
+
+// Unref - luaL_unref
+func (self *luaState) Unref(t, ref int) {
+	if ref >= 0 {
+		t = self.AbsIndex(t)
+		self.RawGetI(t, freelist)
+		self.RawSetI(t, int64(ref)) /* t[ref] = t[freelist] */
+		self.PushInteger(int64(ref))
+		self.RawSetI(t, freelist) /* t[freelist] = ref */
+	}
+}
+
+// NewMetatable - luaL_newmetatable
+func (self *luaState) NewMetatable(tname string) bool {
+	if self.GetMetatable2(tname) != api.LUA_TNIL { /* name already in use? */
+		return false /* leave previous value on top, but return false */
+	}
+	self.Pop(1)
+	self.CreateTable(0, 2) /* create metatable */
+	self.PushString(tname)
+	self.SetField(-2, "__name") /* metatable.__name = tname */
+	self.PushValue(-1)
+	self.SetField(api.LUA_REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+// SetMetatable2 - luaL_setmetatable
+func (self *luaState) SetMetatable2(tname string) {
+	self.GetMetatable2(tname)
+	self.SetMetatable(-2)
+}
+
+// GetMetatable2 - luaL_getmetatable
+func (self *luaState) GetMetatable2(tname string) api.LuaType {
+	return self.GetField(api.LUA_REGISTRYINDEX, tname)
+}
+
+// TestUdata - luaL_testudata, it returns the value of the full userdata at
+// ud if its metatable is that of tname, nil otherwise.
+func (self *luaState) TestUdata(ud int, tname string) interface{} {
+	if self.Type(ud) != api.LUA_TUSERDATA { /* value is not a userdata? */
+		return nil
+	}
+	if !self.GetMetatable(ud) { /* does it have a metatable? */
+		return nil
+	}
+	self.GetMetatable2(tname) /* get correct metatable */
+	ok := self.RawEqual(-1, -2)
+	self.Pop(2) /* remove both metatables */
+	if !ok {
+		return nil
+	}
+	return self.ToUserdata(ud)
+}
+
+// CheckUdata - luaL_checkudata
+func (self *luaState) CheckUdata(ud int, tname string) interface{} {
+	p := self.TestUdata(ud, tname)
+	if p == nil {
+		self.typeError(ud, tname)
+	}
+	return p
+}
+
+// CheckOption - luaL_checkoption, def "" makes the option mandatory.
+func (self *luaState) CheckOption(arg int, def string, lst []string) int {
+	var name string
+	if def != "" {
+		name = self.OptString(arg, def)
+	} else {
+		name = self.CheckString(arg)
+	}
+	for i, opt := range lst {
+		if opt == name {
+			return i
+		}
+	}
+	return self.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
+// Where - luaL_where, level 1 is the function that called the running Go
+// function.
+func (self *luaState) Where(level int) {
+	if ci := self.stack.ci.at(level); ci != nil {
+		self.PushString(where(ci))
+	} else {
+		self.PushString("") /* else, no information available... */
+	}
+}
+
+// Traceback - luaL_traceback, it pushes msg and a traceback of the stack
+// of l1 from level on.
+func (self *luaState) Traceback(l1 api.LuaState, msg string, level int) {
+	L1 := l1.(*luaState)
+	self.PushString(L1.traceback(msg, L1.stack.ci.at(level)))
+}
+
+// Gsub - luaL_gsub
+func (self *luaState) Gsub(s, p, r string) string {
+	s = strings.Replace(s, p, r, -1)
+	self.PushString(s)
+	return s
+}
+
+// PushGoFunc pushes a Lua function calling the ordinary Go func fn, its
+// arguments and results are converted by reflection (see go_func.go).
+func (self *luaState) PushGoFunc(fn interface{}) {
+	self.PushGoFunction(goFunc(fn, "?"))
+}
+
+// RegisterFunc sets the global name to a Lua function calling the Go func fn.
+func (self *luaState) RegisterFunc(name string, fn interface{}) {
+	self.PushGoFunction(goFunc(fn, name))
+	self.SetGlobal(name)
+}
+
+// SetGoFuncs is SetFuncs for ordinary Go funcs.
+func (self *luaState) SetGoFuncs(l api.GoFuncReg) {
+	for name, fn := range l {
+		self.PushGoFunction(goFunc(fn, name))
+		self.SetField(-2, name)
+	}
+}
+
 func (self *luaState) intError(arg int) {
 	if self.IsNumber(arg) {
 		self.ArgError(arg, "number has no integer representation")
@@ -13,6 +13,7 @@ type closure struct {
 	proto  *binchunk.ProtoType
 	goFunc api.GoFunction
 	upvals []*upvalue
+	gcMark uint32
 }
 
 func newLuaClosure(proto *binchunk.ProtoType) *closure {
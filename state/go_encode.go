@@ -0,0 +1,270 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+/*
+Encode and Decode copy values between Go and Lua in the spirit of
+encoding/json, where PushGoValue and ToGoValue share Go values through
+proxies:
+
+	Go                         Lua
+	bool, numbers, strings     booleans, numbers, strings
+	time.Duration              strings such as "1m30s" (or nanoseconds)
+	structs                    tables keyed by field name or `lua:"name"`
+	slices and arrays          sequences ([]byte: strings)
+	maps                       tables
+	pointers, interfaces       the value they point to, nil if nil
+	funcs                      functions, see PushGoFunc
+
+Fields of embedded structs are promoted and fields tagged `lua:",omitempty"`
+are not encoded when empty. Decode updates the structs and maps already in
+its target, ignores keys naming no field and reports the path to the value
+it could not convert, as in "servers[2].port: expected integer, got string".
+*/
+
+// Encode pushes a copy of the Go value v made of Lua values, it pushes
+// nothing if v cannot be encoded.
+func (l *luaState) Encode(v interface{}) error {
+	e := &goEncoder{l: l}
+	val, err := e.encode(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	l.stack.push(val)
+	return nil
+}
+
+// Decode stores the value at idx in the value pointed to by v.
+func (l *luaState) Decode(idx int, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Decode: v must be a non-nil pointer")
+	}
+	c := &goConverter{l: l}
+	return c.assign(l.stack.get(idx), rv.Elem())
+}
+
+type goEncoder struct {
+	l        *luaState
+	visiting map[goRef]bool // pointers, maps and slices being encoded
+}
+
+// goRef identifies the memory referred to by a pointer, map or slice.
+type goRef struct {
+	ptr uintptr
+	t   reflect.Type
+	n   int
+}
+
+func (e *goEncoder) enter(rv reflect.Value) (goRef, error) {
+	ref := goRef{rv.Pointer(), rv.Type(), 0}
+	if rv.Kind() == reflect.Slice {
+		ref.n = rv.Len()
+	}
+	if e.visiting == nil {
+		e.visiting = map[goRef]bool{}
+	}
+	if e.visiting[ref] {
+		return ref, &ConversionError{Msg: fmt.Sprintf("encountered a cycle via %s", rv.Type())}
+	}
+	e.visiting[ref] = true
+	return ref, nil
+}
+
+func (e *goEncoder) encode(rv reflect.Value) (luaValue, error) {
+	if rv.IsValid() && rv.Type() == durationType {
+		return time.Duration(rv.Int()).String(), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return e.l.reflectToLua(rv), nil
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return e.encode(rv.Elem())
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		ref, err := e.enter(rv)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(e.visiting, ref)
+		return e.encode(rv.Elem())
+	case reflect.Struct:
+		return e.encodeStruct(rv)
+	case reflect.Slice:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes()), nil
+		}
+		ref, err := e.enter(rv)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(e.visiting, ref)
+		return e.encodeSeq(rv)
+	case reflect.Array:
+		return e.encodeSeq(rv)
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		ref, err := e.enter(rv)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(e.visiting, ref)
+		return e.encodeMap(rv)
+	case reflect.Func:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return newGoClosure(goFunc(rv.Interface(), "?"), 0), nil
+	}
+	return nil, &ConversionError{Msg: fmt.Sprintf("unsupported type %s", rv.Type())}
+}
+
+func (e *goEncoder) encodeStruct(rv reflect.Value) (luaValue, error) {
+	info := structInfoOf(rv.Type())
+	t := e.l.newTable(0, len(info.fields))
+	for _, f := range info.fields {
+		if f.embedded {
+			continue
+		}
+		fv := fieldByIndex(rv, f.index, false)
+		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		val, err := e.encode(fv)
+		if err != nil {
+			return nil, within(err, f.name)
+		}
+		if val != nil {
+			t.put(f.name, val)
+		}
+	}
+	return t, nil
+}
+
+func (e *goEncoder) encodeSeq(rv reflect.Value) (luaValue, error) {
+	n := rv.Len()
+	t := e.l.newTable(n, 0)
+	for i := 0; i < n; i++ {
+		val, err := e.encode(rv.Index(i))
+		if err != nil {
+			return nil, within(err, fmt.Sprintf("[%d]", i+1))
+		}
+		if val != nil {
+			t.put(int64(i+1), val)
+		}
+	}
+	return t, nil
+}
+
+// encodeMap encodes the entries in the order of their keys, so that tables
+// made with SetDeterministic traverse the same way in every run.
+func (e *goEncoder) encodeMap(rv reflect.Value) (luaValue, error) {
+	type entry struct {
+		key luaValue
+		val reflect.Value
+	}
+	entries := make([]entry, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		key, err := e.encode(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := key.(float64); key == nil || ok && math.IsNaN(f) {
+			return nil, &ConversionError{Msg: fmt.Sprintf("invalid key %v", iter.Key())}
+		}
+		entries = append(entries, entry{key, iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return lessKey(entries[i].key, entries[j].key)
+	})
+
+	t := e.l.newTable(0, len(entries))
+	for _, ent := range entries {
+		val, err := e.encode(ent.val)
+		if err != nil {
+			seg := fmt.Sprintf("[%v]", ent.key)
+			if s, ok := ent.key.(string); ok {
+				seg = fmt.Sprintf("[%q]", s)
+			}
+			return nil, within(err, seg)
+		}
+		if val != nil {
+			t.put(ent.key, val)
+		}
+	}
+	return t, nil
+}
+
+// lessKey orders numbers before strings before other keys.
+func lessKey(a, b luaValue) bool {
+	fa, aNum := keyNumber(a)
+	fb, bNum := keyNumber(b)
+	switch {
+	case aNum && bNum:
+		return fa < fb
+	case aNum || bNum:
+		return aNum
+	}
+	sa, aStr := a.(string)
+	sb, bStr := b.(string)
+	switch {
+	case aStr && bStr:
+		return sa < sb
+	case aStr || bStr:
+		return aStr
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func keyNumber(val luaValue) (float64, bool) {
+	switch x := val.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// isEmptyValue is the test of encoding/json for omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
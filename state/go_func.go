@@ -0,0 +1,135 @@
+package state
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/iglev/glua/api"
+)
+
+/*
+Ordinary Go funcs become Lua functions through goFunc: the arguments are
+converted to the types of the parameters as by ToGoValue (missing ones are
+nil, so pointer parameters are optional), extra arguments are dropped and
+the results are pushed as by PushGoValue. A trailing error result is raised
+as a Lua error when not nil and dropped otherwise.
+*/
+
+// goFunc returns the GoFunction calling fn, name is used in error messages.
+func goFunc(fn interface{}, name string) api.GoFunction {
+	switch f := fn.(type) {
+	case api.GoFunction:
+		return f
+	case func(api.LuaState) int:
+		return f
+	}
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		panic(fmt.Sprintf("%s: func expected, got %T", name, fn))
+	}
+	return func(ls api.LuaState) int {
+		return ls.(*luaState).callGo(rv, 1, name)
+	}
+}
+
+// callGo calls the Go func fn with the arguments from the stack index first
+// up to the top, and pushes its results.
+func (l *luaState) callGo(fn reflect.Value, first int, name string) int {
+	ft := fn.Type()
+	nIn, nFixed := ft.NumIn(), ft.NumIn()
+	if ft.IsVariadic() {
+		nFixed--
+	}
+	top := l.GetTop()
+	n := top - first + 1
+	if n < nFixed {
+		n = nFixed /* missing arguments are nil */
+	} else if !ft.IsVariadic() && n > nIn {
+		n = nIn /* extra arguments are dropped */
+	}
+
+	args := make([]reflect.Value, n)
+	for i := range args {
+		var t reflect.Type
+		if i < nFixed {
+			t = ft.In(i)
+		} else {
+			t = ft.In(nIn - 1).Elem()
+		}
+		v, err := l.luaToGo(l.stack.get(first+i), t)
+		if err != nil {
+			msg := err.Error()
+			if e, ok := err.(*ConversionError); ok {
+				msg = e.argMsg()
+			}
+			if first+i > top {
+				msg = luaTypeNameOf(t) + " expected, got no value"
+			}
+			if name == "" {
+				return l.ArgError(i+1, msg)
+			}
+			return l.Error2("bad argument #%d to '%s' (%s)", i+1, name, msg)
+		}
+		args[i] = v
+	}
+
+	var results []reflect.Value
+	l.guard(func() { results = fn.Call(args) })
+	if n := len(results); n > 0 && ft.Out(n-1) == errorType {
+		if err := results[n-1]; !err.IsNil() {
+			return l.Error2("%s", err.Interface().(error).Error())
+		}
+		results = results[:n-1]
+	}
+	l.stack.check(len(results))
+	for _, r := range results {
+		l.stack.push(l.reflectToLua(r))
+	}
+	return len(results)
+}
+
+// guard runs f, raising Go panics with error values (such as runtime
+// errors) as Lua errors.
+func (l *luaState) guard(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(error); ok {
+				panic(err.Error())
+			}
+			panic(r)
+		}
+	}()
+	f()
+}
+
+// luaTypeNameOf returns the name of the Lua type converted to the Go type
+// t, for messages.
+func luaTypeNameOf(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return "table"
+	case reflect.Array, reflect.Map, reflect.Struct:
+		return "table"
+	case reflect.Func:
+		return "function"
+	case reflect.Ptr:
+		return luaTypeNameOf(t.Elem())
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return "value"
+		}
+	}
+	return t.String()
+}
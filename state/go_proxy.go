@@ -0,0 +1,457 @@
+package state
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/iglev/glua/api"
+)
+
+/*
+Proxies of Go values share one metatable, registered as "go.value":
+
+	p.Name, p.tag    fields by name or by `lua:"tag"`, promoted fields too
+	p:Method(...)    methods, called with the proxy as first argument
+	p[i]             elements of slices and arrays, from 1
+	p[k]             entries of maps, assigning nil deletes the entry
+	#p               length of slices, arrays, maps and channels
+	pairs(p)         fields, elements or entries
+	p(...)           calls a func
+	tostring(p)      String() or Error() if the value has them
+	p1 == p2         same pointer, or equal values
+
+Struct and array fields reached through a pointer are pushed as pointers,
+so that p.Inner.X = 1 changes the Go value. Struct values are copies whose
+fields cannot be assigned.
+
+Go funcs called from Lua get their arguments converted to the types of
+their parameters; a trailing error result is raised when not nil and
+dropped otherwise.
+*/
+
+const goProxyName = "go.value"
+
+// goProxyMetatable returns the metatable of proxies, creating it on first
+// use.
+func (l *luaState) goProxyMetatable() *luaTable {
+	if mt, ok := l.registry.get(goProxyName).(*luaTable); ok {
+		return mt
+	}
+	metamethods := []struct {
+		name string
+		f    api.GoFunction
+	}{
+		{"__index", goIndex},
+		{"__newindex", goNewIndex},
+		{"__len", goLen},
+		{"__pairs", goPairs},
+		{"__call", goCall},
+		{"__tostring", goToString},
+		{"__eq", goEq},
+	}
+	mt := l.newTable(0, len(metamethods)+1)
+	mt.put("__name", goProxyName)
+	for _, mm := range metamethods {
+		mt.put(mm.name, newGoClosure(mm.f, 0))
+	}
+	l.registry.put(goProxyName, mt)
+	return mt
+}
+
+// checkProxy returns the Go value of the proxy at arg.
+func (l *luaState) checkProxy(arg int) reflect.Value {
+	if u, ok := l.stack.get(arg).(*userdata); ok && u.metatable == l.goProxyMetatable() {
+		return reflect.ValueOf(u.value)
+	}
+	l.typeError(arg, goProxyName)
+	return reflect.Value{}
+}
+
+// proxied returns the value indexed through the proxy rv: the struct or
+// array pointed to by pointers, rv itself otherwise.
+func proxied(rv reflect.Value) reflect.Value {
+	if rv.Kind() == reflect.Ptr {
+		switch rv.Type().Elem().Kind() {
+		case reflect.Struct, reflect.Array:
+			return rv.Elem()
+		}
+	}
+	return rv
+}
+
+// proxyIndex converts the Lua index of an element to an int.
+func proxyIndex(key luaValue) (int, bool) {
+	switch key.(type) {
+	case int64, float64:
+		if i, ok := convertToInteger(key); ok && int64(int(i)) == i {
+			return int(i), true
+		}
+	}
+	return 0, false
+}
+
+// fieldToLua converts the field or element f, addressable structs and
+// arrays are pushed as pointers.
+func (l *luaState) fieldToLua(f reflect.Value) luaValue {
+	switch f.Kind() {
+	case reflect.Struct, reflect.Array:
+		if f.CanAddr() {
+			return l.reflectToLua(f.Addr())
+		}
+	}
+	return l.reflectToLua(f)
+}
+
+// setField converts val and assigns it to f, what names f in errors.
+func (l *luaState) setField(f reflect.Value, val luaValue, what string) {
+	v, err := l.luaToGo(val, f.Type())
+	if err != nil {
+		l.Error2("%s: %s", what, err)
+	}
+	f.Set(v)
+}
+
+func goIndex(ls api.LuaState) int {
+	l := ls.(*luaState)
+	rv := l.checkProxy(1)
+	key := l.stack.get(2)
+	v := proxied(rv)
+	switch v.Kind() {
+	case reflect.Struct:
+		if name, ok := key.(string); ok {
+			if f, ok := structInfoOf(v.Type()).field(v, name, false); ok {
+				l.stack.push(l.fieldToLua(f))
+				return 1
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if i, ok := proxyIndex(key); ok {
+			if i >= 1 && i <= v.Len() {
+				l.stack.push(l.fieldToLua(v.Index(i - 1)))
+			} else {
+				l.stack.push(nil)
+			}
+			return 1
+		}
+	case reflect.Map:
+		if k, err := l.luaToGo(key, v.Type().Key()); err == nil {
+			if e := v.MapIndex(k); e.IsValid() {
+				l.stack.push(l.reflectToLua(e))
+				return 1
+			}
+		}
+	}
+	if name, ok := key.(string); ok {
+		if _, ok := rv.Type().MethodByName(name); ok {
+			l.stack.push(newGoClosure(goMethod(name), 0))
+			return 1
+		}
+	}
+	if v.Kind() == reflect.Struct {
+		return l.Error2("'%v' is not a field or method of %s", key, rv.Type())
+	}
+	l.stack.push(nil)
+	return 1
+}
+
+// goMethod returns the Lua function calling the method name of its first
+// argument.
+func goMethod(name string) api.GoFunction {
+	return func(ls api.LuaState) int {
+		l := ls.(*luaState)
+		m := l.checkProxy(1).MethodByName(name)
+		if !m.IsValid() {
+			return l.ArgError(1, fmt.Sprintf("no method '%s'", name))
+		}
+		return l.callGo(m, 2, name)
+	}
+}
+
+func goNewIndex(ls api.LuaState) int {
+	l := ls.(*luaState)
+	rv := l.checkProxy(1)
+	key, val := l.stack.get(2), l.stack.get(3)
+	v := proxied(rv)
+	switch v.Kind() {
+	case reflect.Struct:
+		if name, ok := key.(string); ok {
+			if f, ok := structInfoOf(v.Type()).field(v, name, true); ok {
+				if !f.IsValid() || !f.CanSet() {
+					return l.Error2("cannot assign to field '%s' of a %s value", name, v.Type())
+				}
+				l.setField(f, val, "field '"+name+"'")
+				return 0
+			}
+		}
+		return l.Error2("'%v' is not a field of %s", key, v.Type())
+	case reflect.Slice, reflect.Array:
+		i, ok := proxyIndex(key)
+		if !ok || i < 1 || i > v.Len() {
+			return l.Error2("index %v out of range", key)
+		}
+		e := v.Index(i - 1)
+		if !e.CanSet() {
+			return l.Error2("cannot assign to an element of a %s value", v.Type())
+		}
+		l.setField(e, val, fmt.Sprintf("index %d", i))
+		return 0
+	case reflect.Map:
+		k, err := l.luaToGo(key, v.Type().Key())
+		if err != nil {
+			return l.Error2("invalid key: %s", err)
+		}
+		if val == nil {
+			v.SetMapIndex(k, reflect.Value{})
+			return 0
+		}
+		e, err := l.luaToGo(val, v.Type().Elem())
+		if err != nil {
+			return l.Error2("[%v]: %s", key, err)
+		}
+		v.SetMapIndex(k, e)
+		return 0
+	}
+	return l.Error2("cannot set '%v' in a %s value", key, rv.Type())
+}
+
+func goLen(ls api.LuaState) int {
+	l := ls.(*luaState)
+	rv := l.checkProxy(1)
+	switch v := proxied(rv); v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		l.stack.push(int64(v.Len()))
+		return 1
+	}
+	return l.Error2("attempt to get length of a %s value", rv.Type())
+}
+
+func goPairs(ls api.LuaState) int {
+	l := ls.(*luaState)
+	rv := l.checkProxy(1)
+	v := proxied(rv)
+	var iter api.GoFunction
+	switch v.Kind() {
+	case reflect.Struct:
+		info, i := structInfoOf(v.Type()), 0
+		iter = func(ls api.LuaState) int {
+			l := ls.(*luaState)
+			for i < len(info.fields) && info.fields[i].embedded {
+				i++
+			}
+			if i >= len(info.fields) {
+				l.stack.push(nil)
+				return 1
+			}
+			f := info.fields[i]
+			i++
+			l.stack.push(f.name)
+			l.stack.push(l.fieldToLua(fieldByIndex(v, f.index, false)))
+			return 2
+		}
+	case reflect.Slice, reflect.Array:
+		i := 0
+		iter = func(ls api.LuaState) int {
+			l := ls.(*luaState)
+			if i >= v.Len() {
+				l.stack.push(nil)
+				return 1
+			}
+			i++
+			l.stack.push(int64(i))
+			l.stack.push(l.fieldToLua(v.Index(i - 1)))
+			return 2
+		}
+	case reflect.Map:
+		keys, i := v.MapKeys(), 0
+		iter = func(ls api.LuaState) int {
+			l := ls.(*luaState)
+			for i < len(keys) {
+				k := keys[i]
+				i++
+				if e := v.MapIndex(k); e.IsValid() { /* not deleted */
+					l.stack.push(l.reflectToLua(k))
+					l.stack.push(l.reflectToLua(e))
+					return 2
+				}
+			}
+			l.stack.push(nil)
+			return 1
+		}
+	default:
+		return l.Error2("cannot iterate a %s value", rv.Type())
+	}
+	l.stack.push(newGoClosure(iter, 0))
+	l.PushValue(1)
+	l.stack.push(nil)
+	return 3
+}
+
+func goCall(ls api.LuaState) int {
+	l := ls.(*luaState)
+	rv := l.checkProxy(1)
+	if rv.Kind() != reflect.Func {
+		return l.Error2("attempt to call a %s value", rv.Type())
+	}
+	return l.callGo(rv, 2, "")
+}
+
+func goToString(ls api.LuaState) int {
+	l := ls.(*luaState)
+	rv := l.checkProxy(1)
+	var s string
+	l.guard(func() {
+		switch x := rv.Interface().(type) {
+		case fmt.Stringer:
+			s = x.String()
+		case error:
+			s = x.Error()
+		default:
+			switch rv.Kind() {
+			case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+				s = fmt.Sprintf("%s: %p", rv.Type(), x)
+			default:
+				s = fmt.Sprintf("%s: %v", rv.Type(), x)
+			}
+		}
+	})
+	l.stack.push(s)
+	return 1
+}
+
+func goEq(ls api.LuaState) int {
+	l := ls.(*luaState)
+	a, _ := l.stack.get(1).(*userdata)
+	b, _ := l.stack.get(2).(*userdata)
+	eq := false
+	if a != nil && b != nil && a.value != nil && b.value != nil {
+		va, vb := reflect.ValueOf(a.value), reflect.ValueOf(b.value)
+		if va.Type() == vb.Type() {
+			switch va.Kind() {
+			case reflect.Ptr, reflect.Map, reflect.Func, reflect.Chan:
+				eq = va.Pointer() == vb.Pointer()
+			case reflect.Slice:
+				eq = va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+			default:
+				if va.Type().Comparable() {
+					l.guard(func() { eq = a.value == b.value })
+				}
+			}
+		}
+	}
+	l.stack.push(eq)
+	return 1
+}
+
+/* struct fields */
+
+type goStructInfo struct {
+	fields []goField      // in order of declaration, promoted ones last
+	byName map[string]int // tags and names to fields
+}
+
+type goField struct {
+	name      string // the tag, or else the name of the field
+	index     []int  // see reflect.Value.FieldByIndex
+	omitEmpty bool   // `lua:",omitempty"`
+	embedded  bool   // its fields are promoted, pairs and Encode skip it
+}
+
+var goStructInfos sync.Map // reflect.Type -> *goStructInfo
+
+// structInfoOf returns the fields of the struct type t visible from Lua:
+// the exported ones, and those promoted from untagged embedded structs,
+// unless tagged with `lua:"-"`. Tags are a name and options, as in
+// `lua:"name,omitempty"`.
+func structInfoOf(t reflect.Type) *goStructInfo {
+	if info, ok := goStructInfos.Load(t); ok {
+		return info.(*goStructInfo)
+	}
+
+	info := &goStructInfo{byName: map[string]int{}}
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	level := []embedded{{t, nil}}
+	visited := map[reflect.Type]bool{}
+	for len(level) > 0 { /* shallower fields hide deeper ones */
+		var next []embedded
+		for _, e := range level {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+			for i := 0; i < e.t.NumField(); i++ {
+				sf := e.t.Field(i)
+				tag := sf.Tag.Get("lua")
+				if tag == "-" {
+					continue
+				}
+				f := goField{name: sf.Name, index: append(append([]int(nil), e.index...), i)}
+				opts := strings.Split(tag, ",")
+				for _, opt := range opts[1:] {
+					f.omitEmpty = f.omitEmpty || opt == "omitempty"
+				}
+				if opts[0] != "" {
+					f.name = opts[0]
+				} else if sf.Anonymous { /* promote its fields */
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Struct {
+						next = append(next, embedded{ft, f.index})
+						f.embedded = true
+					}
+				}
+				if sf.PkgPath == "" {
+					info.add(f, sf.Name)
+				}
+			}
+		}
+		level = next
+	}
+
+	actual, _ := goStructInfos.LoadOrStore(t, info)
+	return actual.(*goStructInfo)
+}
+
+func (info *goStructInfo) add(f goField, goName string) {
+	if _, found := info.byName[f.name]; found {
+		return
+	}
+	info.byName[f.name] = len(info.fields)
+	if _, found := info.byName[goName]; !found {
+		info.byName[goName] = len(info.fields)
+	}
+	info.fields = append(info.fields, f)
+}
+
+// field returns the field called name of the struct v. The field is not
+// valid if it is reached through a nil embedded pointer, unless alloc is
+// set and the pointer can be set.
+func (info *goStructInfo) field(v reflect.Value, name string, alloc bool) (reflect.Value, bool) {
+	i, ok := info.byName[name]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	return fieldByIndex(v, info.fields[i].index, alloc), true
+}
+
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
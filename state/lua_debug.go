@@ -0,0 +1,128 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+)
+
+const LUA_IDSIZE = 60   // size of chunk ids in messages
+const MAXTAGLOOP = 2000 // limit for table tag-method chains (to avoid loops)
+
+/* traceback levels shown at the top and at the bottom of the stack */
+const (
+	LEVELS1 = 10
+	LEVELS2 = 11
+)
+
+// stackOverflow raises the error for a thread running out of call depth or
+// stack slots, a traceback is attached since the error usually unwinds
+// the frames that would explain it.
+func (l *luaState) stackOverflow() {
+	ci := l.stack.ci
+	panic(l.traceback(where(ci)+"stack overflow", ci))
+}
+
+// cStackOverflow raises the error for a thread nesting too many calls of
+// Go functions, each of them taking Go stack for the Lua code it calls.
+func (l *luaState) cStackOverflow() {
+	ci := l.stack.ci
+	panic(l.traceback(where(ci)+"C stack overflow", ci))
+}
+
+// traceback appends to msg the frames from ci down to the bottom of the
+// stack, in the format of luaL_traceback.
+func (l *luaState) traceback(msg string, ci *callInfo) string {
+	frames := make([]*callInfo, 0, 8)
+	for ; ci != nil && ci.prev != nil; ci = ci.prev {
+		frames = append(frames, ci)
+	}
+
+	var sb strings.Builder
+	if msg != "" {
+		sb.WriteString(msg)
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("stack traceback:")
+	for i := 0; i < len(frames); i++ {
+		if i == LEVELS1 && len(frames) > LEVELS1+LEVELS2 {
+			sb.WriteString("\n\t...") /* too many levels, skip the middle */
+			i = len(frames) - LEVELS2
+		}
+		sb.WriteString("\n\t")
+		sb.WriteString(describe(frames[i]))
+	}
+	return sb.String()
+}
+
+// where returns the "chunkname:currentline: " prefix for messages raised
+// while ci is running, or "" for Go functions.
+func where(ci *callInfo) string {
+	c := ci.closure
+	if c == nil || c.proto == nil {
+		return ""
+	}
+	if line := currentLine(ci); line > 0 {
+		return fmt.Sprintf("%s:%d: ", chunkID(c.proto.Source), line)
+	}
+	return ""
+}
+
+// describe returns one traceback line for the frame ci.
+func describe(ci *callInfo) string {
+	c := ci.closure
+	if c.proto == nil {
+		return "[Go]: in ?"
+	}
+
+	proto := c.proto
+	source := chunkID(proto.Source)
+	pos := source + ":"
+	if line := currentLine(ci); line > 0 {
+		pos = fmt.Sprintf("%s:%d:", source, line)
+	}
+	if proto.LineDefined == 0 {
+		return pos + " in main chunk"
+	}
+	return fmt.Sprintf("%s in function <%s:%d>", pos, source, proto.LineDefined)
+}
+
+// currentLine returns the line of the instruction being run by ci, or -1
+// if there is no line information.
+func currentLine(ci *callInfo) int {
+	lineInfo := ci.closure.proto.LineInfo
+	if pc := ci.pc - 1; pc >= 0 && pc < len(lineInfo) {
+		return int(lineInfo[pc])
+	}
+	return -1
+}
+
+// chunkID - luaO_chunkid
+func chunkID(source string) string {
+	const bufflen = LUA_IDSIZE - 1
+	switch {
+	case strings.HasPrefix(source, "="): /* 'literal' source */
+		if len(source) > LUA_IDSIZE {
+			return source[1:LUA_IDSIZE]
+		}
+		return source[1:]
+	case strings.HasPrefix(source, "@"): /* file name */
+		if len(source) > LUA_IDSIZE {
+			return "..." + source[len(source)-bufflen+3:]
+		}
+		return source[1:]
+	default: /* string; format as [string "source"] */
+		const pre, rets, pos = `[string "`, "...", `"]`
+		max := bufflen - len(pre) - len(rets) - len(pos)
+		nl := strings.IndexByte(source, '\n')
+		if len(source) < max && nl < 0 {
+			return pre + source + pos
+		}
+		if nl >= 0 {
+			source = source[:nl]
+		}
+		if len(source) > max {
+			source = source[:max]
+		}
+		return pre + source + rets + pos
+	}
+}
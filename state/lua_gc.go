@@ -0,0 +1,348 @@
+package state
+
+import (
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+Memory is reclaimed by the Go collector, which knows nothing about weak
+tables or finalizers. For those, a state runs cycles of its own: a full
+mark of the objects reachable from its roots (the registry and the running
+threads), after which weak entries whose key or value was not marked are
+cleared, and unreachable objects with a __gc metamethod are resurrected
+and finalized.
+
+Cycles are atomic and run at safe points (calls and table creation) of the
+thread that happens to be running, once the Go collector has completed a
+cycle since the last one. States that never set a metatable with __mode or
+__gc never run them.
+
+As with the C API, values referenced only from Go code are not roots; keep
+them in the registry if they must not be cleared from weak tables.
+*/
+
+/* shared by all threads of a state */
+type gcState struct {
+	stopped  bool              // collectgarbage("stop")
+	running  bool              // a cycle or its finalizers are running
+	weak     bool              // a metatable with __mode was set
+	finobj   []luaValue        // objects to finalize, in order of marking
+	finset   map[luaValue]bool // members of finobj
+	lastGoGC uint32            // goGCCycles at the last cycle
+}
+
+var gcEpoch uint32    // identifies a cycle, see collector.epoch
+var goGCCycles uint32 // number of cycles completed by the Go collector
+
+type gcSentinel struct {
+	_ *int // not a tiny allocation, so the finalizer does run
+}
+
+func init() {
+	armGCNotifier()
+}
+
+// armGCNotifier counts the cycles of the Go collector.
+func armGCNotifier() {
+	runtime.SetFinalizer(&gcSentinel{}, func(*gcSentinel) {
+		atomic.AddUint32(&goGCCycles, 1)
+		armGCNotifier()
+	})
+}
+
+// checkFinalizer records val for finalization if mt has a __gc field,
+// and notes weak tables; called when mt becomes the metatable of val.
+func (l *luaState) checkFinalizer(val luaValue, mt *luaTable) {
+	if mt == nil {
+		return
+	}
+	g := l.gc
+	if mt.get("__mode") != nil {
+		g.weak = true
+	}
+	switch val.(type) {
+	case *luaTable, *userdata:
+	default:
+		return
+	}
+	if mt.get("__gc") == nil {
+		return
+	}
+	if g.finset == nil {
+		g.finset = map[luaValue]bool{}
+	}
+	if !g.finset[val] {
+		g.finset[val] = true
+		g.finobj = append(g.finobj, val)
+	}
+}
+
+// checkGC runs a cycle if the Go collector completed one since the last
+// cycle of this state - luaC_checkGC
+func (l *luaState) checkGC() {
+	g := l.gc
+	if !g.stopped && !g.running && (g.weak || len(g.finobj) > 0) &&
+		atomic.LoadUint32(&goGCCycles) != g.lastGoGC {
+		l.fullGC()
+	}
+}
+
+// fullGC runs a complete cycle and then the pending finalizers.
+func (l *luaState) fullGC() {
+	g := l.gc
+	if g.running {
+		return
+	}
+	g.running = true
+	defer func() { g.running = false }()
+
+	g.lastGoGC = atomic.LoadUint32(&goGCCycles)
+	c := &collector{epoch: atomic.AddUint32(&gcEpoch, 1)}
+	tobefnz := c.run(l)
+	for i := len(tobefnz) - 1; i >= 0; i-- {
+		l.callFinalizer(tobefnz[i])
+	}
+}
+
+// callFinalizer calls the __gc metamethod of obj in protected mode, errors
+// are ignored.
+func (l *luaState) callFinalizer(obj luaValue) {
+	if mm, ok := getMetafield(obj, "__gc", l).(*closure); ok {
+		l.stack.check(2)
+		l.stack.push(mm)
+		l.stack.push(obj)
+		if l.PCall(1, 0, 0) != 0 {
+			l.stack.pop()
+		}
+	}
+}
+
+/* collector */
+
+type collector struct {
+	epoch      uint32 // objects marked in this cycle hold it in gcMark
+	nMarked    int
+	gray       []luaValue
+	weakValues []*luaTable
+	ephemerons []*luaTable
+	allWeak    []*luaTable
+}
+
+// run marks everything reachable from the roots of l, clears the weak
+// tables and returns the objects to finalize.
+func (c *collector) run(l *luaState) []luaValue {
+	c.mark(l.registry)
+	c.mark(l)
+	c.propagate()
+	c.convergeEphemerons()
+	/* values of unreachable objects go before resurrection */
+	c.clearValues(c.weakValues)
+	c.clearValues(c.allWeak)
+
+	g := l.gc
+	var tobefnz []luaValue
+	live := g.finobj[:0]
+	for _, obj := range g.finobj {
+		if c.isMarked(obj) {
+			live = append(live, obj)
+		} else {
+			tobefnz = append(tobefnz, obj)
+			delete(g.finset, obj)
+		}
+	}
+	for i := len(live); i < len(g.finobj); i++ {
+		g.finobj[i] = nil
+	}
+	g.finobj = live
+
+	/* resurrect the objects to finalize */
+	for _, obj := range tobefnz {
+		c.mark(obj)
+	}
+	c.propagate()
+	c.convergeEphemerons()
+	c.clearKeys(c.ephemerons)
+	c.clearKeys(c.allWeak)
+	return tobefnz
+}
+
+func (c *collector) mark(val luaValue) {
+	switch x := val.(type) {
+	case *luaTable:
+		if x.gcMark == c.epoch {
+			return
+		}
+		x.gcMark = c.epoch
+	case *closure:
+		if x.gcMark == c.epoch {
+			return
+		}
+		x.gcMark = c.epoch
+	case *luaState:
+		if x.gcMark == c.epoch {
+			return
+		}
+		x.gcMark = c.epoch
+	case *userdata:
+		if x.gcMark == c.epoch {
+			return
+		}
+		x.gcMark = c.epoch
+	default:
+		return
+	}
+	c.nMarked++
+	c.gray = append(c.gray, val)
+}
+
+// isMarked reports whether val survives the cycle, values that are not
+// objects (numbers, strings...) are never removed from weak tables.
+func (c *collector) isMarked(val luaValue) bool {
+	switch x := val.(type) {
+	case *luaTable:
+		return x.gcMark == c.epoch
+	case *closure:
+		return x.gcMark == c.epoch
+	case *luaState:
+		return x.gcMark == c.epoch
+	case *userdata:
+		return x.gcMark == c.epoch
+	}
+	return true
+}
+
+func (c *collector) propagate() {
+	for len(c.gray) > 0 {
+		val := c.gray[len(c.gray)-1]
+		c.gray = c.gray[:len(c.gray)-1]
+		switch x := val.(type) {
+		case *luaTable:
+			c.traverseTable(x)
+		case *closure:
+			for _, uv := range x.upvals {
+				if uv != nil {
+					c.mark(*uv.val)
+				}
+			}
+		case *luaState:
+			c.traverseThread(x)
+		case *userdata:
+			if x.metatable != nil {
+				c.mark(x.metatable)
+			}
+			c.mark(x.user)
+		}
+	}
+}
+
+func (c *collector) traverseThread(ls *luaState) {
+	stack := ls.stack
+	for i := 0; i < stack.top; i++ {
+		c.mark(stack.slots[i])
+	}
+	for ci := stack.ci; ci != nil; ci = ci.prev {
+		if ci.closure != nil {
+			c.mark(ci.closure)
+		}
+	}
+	if ls.coCaller != nil {
+		c.mark(ls.coCaller)
+	}
+	c.mark(ls.coErr)
+}
+
+func (c *collector) traverseTable(t *luaTable) {
+	if t.metatable != nil {
+		c.mark(t.metatable)
+	}
+	weakKey, weakValue := t.weakMode()
+	switch {
+	case weakKey && weakValue:
+		c.allWeak = append(c.allWeak, t)
+	case weakValue:
+		for i := range t.node {
+			if n := &t.node[i]; n.val != nil {
+				c.mark(n.key)
+			}
+		}
+		c.weakValues = append(c.weakValues, t)
+	case weakKey:
+		c.ephemerons = append(c.ephemerons, t)
+		c.traverseEphemeron(t)
+	default:
+		for _, v := range t.arr {
+			c.mark(v)
+		}
+		for i := range t.node {
+			if n := &t.node[i]; n.val != nil {
+				c.mark(n.key)
+				c.mark(n.val)
+			}
+		}
+	}
+}
+
+// traverseEphemeron marks the values whose keys are marked, it reports
+// whether anything new was marked.
+func (c *collector) traverseEphemeron(t *luaTable) bool {
+	nMarked := c.nMarked
+	for _, v := range t.arr {
+		c.mark(v)
+	}
+	for i := range t.node {
+		if n := &t.node[i]; n.val != nil && c.isMarked(n.key) {
+			c.mark(n.val)
+		}
+	}
+	return c.nMarked != nMarked
+}
+
+func (c *collector) convergeEphemerons() {
+	for changed := true; changed; {
+		changed = false
+		for _, t := range c.ephemerons {
+			if c.traverseEphemeron(t) {
+				c.propagate()
+				changed = true
+			}
+		}
+	}
+}
+
+func (c *collector) clearValues(tables []*luaTable) {
+	for _, t := range tables {
+		for i, v := range t.arr {
+			if !c.isMarked(v) {
+				t.arr[i] = nil
+			}
+		}
+		for i := range t.node {
+			if n := &t.node[i]; n.val != nil && !c.isMarked(n.val) {
+				n.val = nil
+			}
+		}
+	}
+}
+
+func (c *collector) clearKeys(tables []*luaTable) {
+	for _, t := range tables {
+		for i := range t.node {
+			if n := &t.node[i]; n.val != nil && !c.isMarked(n.key) {
+				t.clearNode(i)
+			}
+		}
+	}
+}
+
+// weakMode returns the weakness of keys and values given by __mode.
+func (lt *luaTable) weakMode() (weakKey, weakValue bool) {
+	if lt.metatable == nil {
+		return false, false
+	}
+	if mode, ok := lt.metatable.get("__mode").(string); ok {
+		return strings.IndexByte(mode, 'k') >= 0, strings.IndexByte(mode, 'v') >= 0
+	}
+	return false, false
+}
@@ -2,18 +2,46 @@ package state
 
 import "github.com/iglev/glua/api"
 
+/*
+Every thread owns exactly one value stack. A call does not get a stack of
+its own; it gets a callInfo that marks where its frame lives inside the
+shared slots:
+
+	slots: [ ... | fn | varargs... | R0 R1 ... Rn | temporaries ... ]
+	                ^               ^                                ^
+	                ci.fn           ci.base                        top
+
+Stack indexes used by the API (1, 2, ..., -1, -2, ...) are relative to
+ci.base, so Go functions and the VM see the same "virtual stack" as
+before while nothing has to be copied between frames.
+*/
 type luaStack struct {
 	/* virtual stack */
 	slots []luaValue
-	top   int
+	top   int // first free slot (absolute)
 	/* call info */
 	state   *luaState
-	closure *closure
-	varargs []luaValue
-	openuvs map[int]*upvalue
-	pc      int
+	ci      *callInfo   // current frame
+	openuvs []openUpval // open upvalues, sorted by slot
+	tbc     []int       // to-be-closed slots (absolute), see ToClose
+}
+
+// openUpval is an upvalue still pointing at the absolute slot idx - an
+// entry of L->openupval.
+type openUpval struct {
+	idx int
+	uv  *upvalue
+}
+
+type callInfo struct {
+	closure  *closure
+	fn       int // slot of the called function (absolute)
+	base     int // slot of the first register (absolute)
+	nVarargs int // varargs live in slots [base-nVarargs, base)
+	pc       int
 	/* linked list */
-	prev *luaStack
+	prev *callInfo
+	next *callInfo // cached frame, reused by the next call
 }
 
 func newLuaStack(size int, state *luaState) *luaStack {
@@ -21,26 +49,53 @@ func newLuaStack(size int, state *luaState) *luaStack {
 		slots: make([]luaValue, size),
 		top:   0,
 		state: state,
+		ci:    &callInfo{},
 	}
 }
 
+// gettop returns the number of values in the current frame.
+func (l *luaStack) gettop() int {
+	return l.top - l.ci.base
+}
+
 func (l *luaStack) check(n int) {
-	free := len(l.slots) - l.top
-	for i := free; i < n; i++ {
-		l.slots = append(l.slots, nil)
+	if free := len(l.slots) - l.top; free < n {
+		l.grow(l.top + n)
+	}
+}
+
+// grow reallocates slots to hold at least size values and re-points every
+// open upvalue at its slot in the new array. Growing beyond the slot limit
+// of the thread raises a "stack overflow" error.
+func (l *luaStack) grow(size int) {
+	limit := l.state.maxStack
+	if size > limit {
+		l.state.stackOverflow()
+	}
+	newSize := 2 * len(l.slots)
+	if newSize < size {
+		newSize = size
+	} else if newSize > limit {
+		newSize = limit
+	}
+	slots := make([]luaValue, newSize)
+	copy(slots, l.slots[:l.top])
+	l.slots = slots
+	for _, open := range l.openuvs {
+		open.uv.val = &l.slots[open.idx]
 	}
 }
 
 func (l *luaStack) push(val luaValue) {
 	if l.top == len(l.slots) {
-		panic("stack overflow!")
+		l.grow(l.top + 1)
 	}
 	l.slots[l.top] = val
 	l.top++
 }
 
 func (l *luaStack) pop() luaValue {
-	if l.top < 1 {
+	if l.top <= l.ci.base {
 		panic("stack underflow!")
 	}
 	l.top--
@@ -49,53 +104,51 @@ func (l *luaStack) pop() luaValue {
 	return val
 }
 
-func (l *luaStack) pushN(vals []luaValue, n int) {
-	nVals := len(vals)
-	if n < 0 {
-		n = nVals
-	}
-
-	for i := 0; i < n; i++ {
-		if i < nVals {
-			l.push(vals[i])
-		} else {
-			l.push(nil)
+// settop moves the top to the absolute slot newTop, filling new slots with
+// nil and clearing dropped ones so that they can be collected.
+func (l *luaStack) settop(newTop int) {
+	if newTop > l.top {
+		l.check(newTop - l.top)
+		for i := l.top; i < newTop; i++ {
+			l.slots[i] = nil
+		}
+	} else {
+		for i := newTop; i < l.top; i++ {
+			l.slots[i] = nil
 		}
 	}
-}
-
-func (l *luaStack) popN(n int) []luaValue {
-	vals := make([]luaValue, n)
-	for i := n - 1; i >= 0; i-- {
-		vals[i] = l.pop()
-	}
-	return vals
+	l.top = newTop
 }
 
 func (l *luaStack) absIndex(idx int) int {
 	if idx >= 0 || idx <= api.LUA_REGISTRYINDEX {
 		return idx
 	}
-	return idx + l.top + 1
+	return idx + l.gettop() + 1
+}
+
+// slot converts a valid (non pseudo) stack index to an absolute slot.
+func (l *luaStack) slot(idx int) int {
+	return l.ci.base + l.absIndex(idx) - 1
 }
 
 func (l *luaStack) isValid(idx int) bool {
 	if idx < api.LUA_REGISTRYINDEX { /* upvalues */
 		uvIdx := api.LUA_REGISTRYINDEX - idx - 1
-		c := l.closure
+		c := l.ci.closure
 		return c != nil && uvIdx < len(c.upvals)
 	}
 	if idx == api.LUA_REGISTRYINDEX {
 		return true
 	}
 	absIdx := l.absIndex(idx)
-	return absIdx > 0 && absIdx <= l.top
+	return absIdx > 0 && absIdx <= l.gettop()
 }
 
 func (l *luaStack) get(idx int) luaValue {
 	if idx < api.LUA_REGISTRYINDEX { /* upvalues */
 		uvIdx := api.LUA_REGISTRYINDEX - idx - 1
-		c := l.closure
+		c := l.ci.closure
 		if c == nil || uvIdx >= len(c.upvals) {
 			return nil
 		}
@@ -107,8 +160,8 @@ func (l *luaStack) get(idx int) luaValue {
 	}
 
 	absIdx := l.absIndex(idx)
-	if absIdx > 0 && absIdx <= l.top {
-		return l.slots[absIdx-1]
+	if absIdx > 0 && absIdx <= l.gettop() {
+		return l.slots[l.ci.base+absIdx-1]
 	}
 	return nil
 }
@@ -116,7 +169,7 @@ func (l *luaStack) get(idx int) luaValue {
 func (l *luaStack) set(idx int, val luaValue) {
 	if idx < api.LUA_REGISTRYINDEX { /* upvalues */
 		uvIdx := api.LUA_REGISTRYINDEX - idx - 1
-		c := l.closure
+		c := l.ci.closure
 		if c != nil && uvIdx < len(c.upvals) {
 			*(c.upvals[uvIdx].val) = val
 		}
@@ -129,13 +182,14 @@ func (l *luaStack) set(idx int, val luaValue) {
 	}
 
 	absIdx := l.absIndex(idx)
-	if absIdx > 0 && absIdx <= l.top {
-		l.slots[absIdx-1] = val
+	if absIdx > 0 && absIdx <= l.gettop() {
+		l.slots[l.ci.base+absIdx-1] = val
 		return
 	}
 	panic("invalid index!")
 }
 
+// reverse reverses the absolute slots [from, to].
 func (l *luaStack) reverse(from, to int) {
 	slots := l.slots
 	for from < to {
@@ -144,3 +198,81 @@ func (l *luaStack) reverse(from, to int) {
 		to--
 	}
 }
+
+/* call frames */
+
+// pushCallInfo enters a new frame for the function in slot fn.
+func (l *luaStack) pushCallInfo(c *closure, fn, base int) *callInfo {
+	ci := l.ci.next
+	if ci == nil {
+		ci = &callInfo{prev: l.ci}
+		l.ci.next = ci
+	}
+	ci.closure = c
+	ci.fn = fn
+	ci.base = base
+	ci.nVarargs = 0
+	ci.pc = 0
+	l.ci = ci
+	return ci
+}
+
+// at returns the frame level levels below ci, nil if there is none.
+func (ci *callInfo) at(level int) *callInfo {
+	for ; level > 0 && ci != nil; level-- {
+		ci = ci.prev
+	}
+	if ci == nil || ci.prev == nil { /* the base frame is not a call */
+		return nil
+	}
+	return ci
+}
+
+func (l *luaStack) popCallInfo() {
+	ci := l.ci
+	ci.closure = nil
+	l.ci = ci.prev
+}
+
+/* to-be-closed slots */
+
+// hasTBC reports whether a slot at or above the absolute slot level is to
+// be closed.
+func (l *luaStack) hasTBC(level int) bool {
+	n := len(l.tbc)
+	return n > 0 && l.tbc[n-1] >= level
+}
+
+/* upvalues */
+
+// openUpvalue returns the open upvalue for the absolute slot idx,
+// creating it if necessary.
+func (l *luaStack) openUpvalue(idx int) *upvalue {
+	/* the upvalues of the running frame are usually at the end */
+	i := len(l.openuvs)
+	for i > 0 && l.openuvs[i-1].idx >= idx {
+		if l.openuvs[i-1].idx == idx {
+			return l.openuvs[i-1].uv
+		}
+		i--
+	}
+	uv := &upvalue{&l.slots[idx]}
+	l.openuvs = append(l.openuvs, openUpval{})
+	copy(l.openuvs[i+1:], l.openuvs[i:])
+	l.openuvs[i] = openUpval{idx, uv}
+	return uv
+}
+
+// closeUpvalues closes every open upvalue at or above the absolute slot
+// level, the ones at the end of openuvs.
+func (l *luaStack) closeUpvalues(level int) {
+	n := len(l.openuvs)
+	for n > 0 && l.openuvs[n-1].idx >= level {
+		n--
+		uv := l.openuvs[n].uv
+		val := *uv.val
+		uv.val = &val
+		l.openuvs[n] = openUpval{}
+	}
+	l.openuvs = l.openuvs[:n]
+}
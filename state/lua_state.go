@@ -1,43 +1,160 @@
 package state
 
 import (
+	"io"
+	"os"
+
 	"github.com/iglev/glua/api"
 )
 
 type luaState struct {
 	registry *luaTable
 	stack    *luaStack
+	global   *globalState // shared by all threads
+	gc       *gcState     // shared by all threads
+	gcMark   uint32
+
+	/* limits */
+	nCalls   int // number of nested calls
+	nCcalls  int // number of nested calls of Go functions
+	maxCalls int // limit of nested calls
+	maxStack int // limit of stack slots
 
 	/* coroutine */
 	coStatus int
 	coCaller *luaState
 	coChan   chan int
+	coErr    luaValue // the error the coroutine died by
+}
+
+/* shared by all threads of a state */
+type globalState struct {
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	version int  // see SetVersion
+	warn    int  // state of warn: off, on or continuing a message
+	ordered bool // see SetDeterministic
 }
 
 // New new luaState
 func New() *luaState {
-	ls := &luaState{}
+	ls := &luaState{
+		maxCalls: api.LUAI_MAXCALLS,
+		maxStack: api.LUAI_MAXSTACK,
+	}
 
 	registry := newLuaTable(8, 0)
 	registry.put(api.LUA_RIDX_MAINTHREAD, ls)
 	registry.put(api.LUA_RIDX_GLOBALS, newLuaTable(0, 20))
 
 	ls.registry = registry
-	ls.pushLuaStack(newLuaStack(api.LUA_MINSTACK, ls))
+	ls.stack = newLuaStack(api.LUA_MINSTACK, ls)
+	ls.global = &globalState{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr,
+		version: api.LUA_VERSION_53}
+	ls.gc = &gcState{}
 	return ls
 }
 
-func (l *luaState) isMainThread() bool {
-	return l.registry.get(api.LUA_RIDX_MAINTHREAD) == l
+// SetMaxCalls limits the depth of nested (Lua and Go) calls of this
+// thread and of the threads created by it afterwards. The calls of Go
+// functions, which may call back into Lua, nest up to api.LUAI_MAXCCALLS
+// deep whatever the limit.
+func (l *luaState) SetMaxCalls(n int) {
+	l.maxCalls = n
+}
+
+// SetMaxStack limits the number of stack slots of this thread and of the
+// threads created by it afterwards.
+func (l *luaState) SetMaxStack(n int) {
+	l.maxStack = n
+}
+
+// SetStdin sets the reader of io.read and io.stdin, for all threads of the
+// state.
+func (l *luaState) SetStdin(r io.Reader) {
+	l.global.stdin = r
+}
+
+// SetStdout sets the writer of print, io.write and io.stdout, for all
+// threads of the state.
+func (l *luaState) SetStdout(w io.Writer) {
+	l.global.stdout = w
+}
+
+// SetStderr sets the writer of io.stderr and of error reports, for all
+// threads of the state.
+func (l *luaState) SetStderr(w io.Writer) {
+	l.global.stderr = w
+}
+
+// Stdin returns the reader set by SetStdin, os.Stdin by default.
+func (l *luaState) Stdin() io.Reader {
+	return l.global.stdin
+}
+
+// Stdout returns the writer set by SetStdout, os.Stdout by default.
+func (l *luaState) Stdout() io.Writer {
+	return l.global.stdout
+}
+
+// Stderr returns the writer set by SetStderr, os.Stderr by default.
+func (l *luaState) Stderr() io.Writer {
+	return l.global.stderr
+}
+
+// SetVersion selects the language version of the chunks loaded afterwards
+// and of the libraries opened afterwards, api.LUA_VERSION_53 by default.
+// It applies to all threads of the state and must be called before the
+// libraries are opened.
+func (l *luaState) SetVersion(v int) {
+	l.global.version = v
 }
 
-func (l *luaState) pushLuaStack(stack *luaStack) {
-	stack.prev = l.stack
-	l.stack = stack
+// Version returns the version set by SetVersion - lua_version
+func (l *luaState) Version() int {
+	return l.global.version
 }
 
-func (l *luaState) popLuaStack() {
-	stack := l.stack
-	l.stack = stack.prev
-	stack.prev = nil
+// SetDeterministic makes the registry, the global table and the tables
+// created afterwards by any thread of the state traverse in a
+// deterministic order: the array part by index, then the other keys in
+// insertion order. Otherwise the order of keys such as tables and
+// functions depends on their addresses and may differ between runs. The
+// keys already in the registry and the global table keep their current
+// order, so the libraries should be opened afterwards.
+func (l *luaState) SetDeterministic(on bool) {
+	l.global.ordered = on
+	if on {
+		l.registry.setOrdered()
+		if g, ok := l.registry.get(api.LUA_RIDX_GLOBALS).(*luaTable); ok {
+			g.setOrdered()
+		}
+	}
+}
+
+// Close calls the finalizers of all the objects that have one and releases
+// the stack - lua_close
+func (l *luaState) Close() {
+	g := l.gc
+	l.stack.settop(l.stack.ci.base)
+	for len(g.finobj) > 0 { /* finalizers may create objects to finalize */
+		objs := g.finobj
+		g.finobj, g.finset = nil, nil
+		for i := len(objs) - 1; i >= 0; i-- {
+			l.callFinalizer(objs[i])
+		}
+	}
+	g.stopped = true
+	l.stack = newLuaStack(api.LUA_MINSTACK, l)
+}
+
+func (l *luaState) newTable(nArr, nRec int) *luaTable {
+	t := newLuaTable(nArr, nRec)
+	t.ordered = l.global.ordered
+	return t
+}
+
+func (l *luaState) isMainThread() bool {
+	return l.registry.get(api.LUA_RIDX_MAINTHREAD) == l
 }
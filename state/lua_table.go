@@ -2,26 +2,79 @@ package state
 
 import (
 	"math"
+	"math/bits"
+	"reflect"
+	"unsafe"
 
 	"github.com/iglev/glua/number"
 )
 
+/*
+Tables follow the layout of the reference implementation (ltable.c): an
+array part holding the values of the integer keys 1..len(arr), and a hash
+part made of nodes chained inside one slice (a scatter table with Brent's
+variation).
+
+The array part may contain nils. The sizes of both parts only change when
+a new key does not fit into the hash part, rehash then counts the keys and
+chooses the largest array size that would be more than half full.
+
+Setting a field to nil leaves its key in the node, so a traversal can
+continue from it; the node is reclaimed by the next rehash.
+
+Traversal visits the array part and then the nodes, so the order of the
+hash part depends on hashes, and on addresses for keys like tables and
+functions. An ordered table also records its hash keys in insertion order
+and traverses them in that order instead.
+*/
 type luaTable struct {
 	metatable *luaTable
 	arr       []luaValue
-	mp        map[luaValue]luaValue
-	keys      map[luaValue]luaValue // used by next()
-	lastKey   luaValue              // used by next()
-	changed   bool                  // used by next()
+	node      []node // len is 0 or a power of 2
+	lastFree  int    // all nodes at or above lastFree are in use
+	/* ordered tables */
+	ordered bool
+	keys    []luaValue // hash keys in insertion order, may hold stale keys
+	gcMark  uint32
+}
+
+type node struct {
+	key  luaValue // nil if the node was never used
+	val  luaValue
+	next int // offset to the next node of the chain, 0 ends it
+	ord  int // index of key in keys (ordered tables)
 }
 
+// deadKey replaces the keys removed from weak tables by the collector, so
+// that their nodes stay in their chains without keeping the keys alive.
+type deadKey struct{}
+
+/* max size of the array part is 2^MAXABITS */
+const MAXABITS = 31
+const MAXASIZE = 1 << MAXABITS
+
+/* max size of the hash part is 2^MAXHBITS */
+const MAXHBITS = 30
+
+/* the sizes given to newLuaTable are hints, preallocated up to maxPrealloc */
+const maxPrealloc = 1 << 16
+
 func newLuaTable(nArr, nRec int) *luaTable {
+	if nArr > MAXASIZE || nRec > 1<<MAXHBITS {
+		panic("table overflow")
+	}
+	if nArr > maxPrealloc {
+		nArr = maxPrealloc
+	}
+	if nRec > maxPrealloc {
+		nRec = maxPrealloc
+	}
 	t := &luaTable{}
 	if nArr > 0 {
-		t.arr = make([]luaValue, 0, nArr)
+		t.arr = make([]luaValue, nArr)
 	}
 	if nRec > 0 {
-		t.mp = make(map[luaValue]luaValue, nRec)
+		t.setNodeVector(nRec)
 	}
 	return t
 }
@@ -31,10 +84,6 @@ func (lt *luaTable) hasMetafield(fieldName string) bool {
 		lt.metatable.get(fieldName) != nil
 }
 
-func (lt *luaTable) len() int {
-	return len(lt.arr)
-}
-
 func _floatToInteger(key luaValue) luaValue {
 	if f, fok := key.(float64); fok {
 		if i, ok := number.FloatToInteger(f); ok {
@@ -44,101 +93,497 @@ func _floatToInteger(key luaValue) luaValue {
 	return key
 }
 
-func (lt *luaTable) _shrinkArray() {
-	for i := len(lt.arr) - 1; i >= 0; i-- {
-		if lt.arr[i] == nil {
-			lt.arr = lt.arr[0:i]
+/* hashing */
+
+const hashSeed = 0x2545f491
+
+// hashString - luaS_hash
+func hashString(s string) uint32 {
+	l := len(s)
+	h := hashSeed ^ uint32(l)
+	step := (l >> 5) + 1
+	for ; l >= step; l -= step {
+		h ^= (h << 5) + (h >> 2) + uint32(s[l-1])
+	}
+	return h
+}
+
+// hashObject hashes tables, functions, threads... by their identity.
+func hashObject(key luaValue) uintptr {
+	switch x := key.(type) {
+	case *luaTable:
+		return uintptr(unsafe.Pointer(x))
+	case *closure:
+		return uintptr(unsafe.Pointer(x))
+	case *luaState:
+		return uintptr(unsafe.Pointer(x))
+	case *userdata:
+		return uintptr(unsafe.Pointer(x))
+	case lightUserdata:
+		switch rv := reflect.ValueOf(x.p); rv.Kind() {
+		case reflect.Ptr, reflect.UnsafePointer, reflect.Chan, reflect.Map, reflect.Func:
+			return rv.Pointer()
 		}
+		return 0
 	}
+	return reflect.ValueOf(key).Pointer()
 }
 
-func (lt *luaTable) _expandArray() {
-	for idx := int64(len(lt.arr)) + 1; true; idx++ {
-		if val, found := lt.mp[idx]; found {
-			delete(lt.mp, idx)
-			lt.arr = append(lt.arr, val)
-		} else {
-			break
+// mainPosition returns the index of the node where key should be.
+func (lt *luaTable) mainPosition(key luaValue) int {
+	mask := len(lt.node) - 1
+	switch x := key.(type) {
+	case int64:
+		/* fold all the bits in, like hashint */
+		return int(uint64(x) % uint64(mask|1))
+	case string:
+		return int(hashString(x)) & mask
+	case bool:
+		if x {
+			return 1 & mask
 		}
+		return 0
+	case float64:
+		b := math.Float64bits(x)
+		return int(uint((b^b>>32)&math.MaxUint32) % uint(mask|1))
 	}
+	/* pointers, avoid the alignment zeros in the low bits */
+	return int(hashObject(key) % uintptr(mask|1))
 }
 
+/* get & put */
+
 func (lt *luaTable) get(key luaValue) luaValue {
-	key = _floatToInteger(key)
-	if idx, ok := key.(int64); ok {
-		if idx >= 1 && idx <= int64(len(lt.arr)) {
-			return lt.arr[idx-1]
+	switch x := key.(type) {
+	case nil:
+		return nil
+	case int64:
+		return lt.getInt(x)
+	case float64:
+		if i, ok := number.FloatToInteger(x); ok {
+			return lt.getInt(i)
+		}
+	}
+	if n := lt.findNode(key); n >= 0 {
+		return lt.node[n].val
+	}
+	return nil
+}
+
+func (lt *luaTable) getInt(key int64) luaValue {
+	if uint64(key)-1 < uint64(len(lt.arr)) {
+		return lt.arr[key-1]
+	}
+	if n := lt.findNode(key); n >= 0 {
+		return lt.node[n].val
+	}
+	return nil
+}
+
+// findNode returns the index of the node holding key, or -1.
+func (lt *luaTable) findNode(key luaValue) int {
+	if len(lt.node) == 0 {
+		return -1
+	}
+	for i := lt.mainPosition(key); ; {
+		n := &lt.node[i]
+		if n.key == key {
+			return i
+		}
+		if n.next == 0 {
+			return -1
 		}
+		i += n.next
 	}
-	return lt.mp[key]
 }
 
 func (lt *luaTable) put(key, val luaValue) {
-	if key == nil {
+	switch x := key.(type) {
+	case nil:
 		panic("table index is nil!")
+	case float64:
+		if i, ok := number.FloatToInteger(x); ok {
+			key = i
+		} else if math.IsNaN(x) {
+			panic("table index is NaN")
+		}
 	}
-	if f, ok := key.(float64); ok && math.IsNaN(f) {
-		panic("table index is NaN")
+
+	if idx, ok := key.(int64); ok && uint64(idx)-1 < uint64(len(lt.arr)) {
+		lt.arr[idx-1] = val
+		return
 	}
+	if n := lt.findNode(key); n >= 0 {
+		lt.node[n].val = val
+		return
+	}
+	if val != nil {
+		lt.newKey(key, val)
+	}
+}
 
-	lt.changed = true
-	key = _floatToInteger(key)
-	if idx, ok := key.(int64); ok && idx >= 1 {
-		arrLen := int64(len(lt.arr))
-		if idx <= arrLen {
-			lt.arr[idx-1] = val
-			if idx == arrLen && val == nil {
-				lt._shrinkArray()
-			}
+// newKey inserts a key that is not in the table yet - luaH_newkey
+func (lt *luaTable) newKey(key, val luaValue) {
+	if len(lt.node) == 0 {
+		lt.rehash(key)
+		lt.put(key, val)
+		return
+	}
+
+	mp := lt.mainPosition(key)
+	if lt.node[mp].val != nil { /* main position is taken? */
+		f := lt.getFreePos()
+		if f < 0 { /* cannot find a free place? */
+			lt.rehash(key)
+			lt.put(key, val)
 			return
 		}
-		if idx == arrLen+1 {
-			delete(lt.mp, key)
-			if val != nil {
-				lt.arr = append(lt.arr, val)
-				lt._expandArray()
+		other := lt.mainPosition(lt.node[mp].key)
+		if other != mp {
+			/* colliding node is out of its main position: move it into
+			the free position and put the new key in its place */
+			for other+lt.node[other].next != mp {
+				other += lt.node[other].next
 			}
-			return
+			lt.node[other].next = f - other
+			lt.node[f] = lt.node[mp]
+			if lt.node[mp].next != 0 {
+				lt.node[f].next += mp - f
+				lt.node[mp].next = 0
+			}
+			lt.node[mp].val = nil
+		} else {
+			/* colliding node is in its own main position: new key goes
+			into the free position */
+			if lt.node[mp].next != 0 {
+				lt.node[f].next = mp + lt.node[mp].next - f
+			}
+			lt.node[mp].next = f - mp
+			mp = f
 		}
 	}
-	if val != nil {
-		if lt.mp == nil {
-			lt.mp = make(map[luaValue]luaValue, 8)
+	lt.node[mp].key = key
+	lt.node[mp].val = val
+	if lt.ordered {
+		if len(lt.keys) >= 2*len(lt.node) {
+			lt.compactKeys()
+		}
+		lt.node[mp].ord = len(lt.keys)
+		lt.keys = append(lt.keys, key)
+	}
+}
+
+func (lt *luaTable) getFreePos() int {
+	for lt.lastFree > 0 {
+		lt.lastFree--
+		if lt.node[lt.lastFree].key == nil {
+			return lt.lastFree
 		}
-		lt.mp[key] = val
-	} else {
-		delete(lt.mp, key)
 	}
+	return -1
+}
+
+/* rehash */
+
+func (lt *luaTable) setNodeVector(size int) {
+	if size == 0 {
+		lt.node = nil
+		lt.lastFree = 0
+		return
+	}
+	lsize := ceilLog2(size)
+	if lsize > MAXHBITS {
+		panic("table overflow")
+	}
+	lt.node = make([]node, 1<<lsize)
+	lt.lastFree = len(lt.node)
+}
+
+// rehash resizes both parts for the keys in use plus extraKey.
+func (lt *luaTable) rehash(extraKey luaValue) {
+	var nums [MAXABITS + 1]int // nums[i] = number of keys k where 2^(i-1) < k <= 2^i
+	na := lt.numUseArray(&nums)
+	totalUse := na
+	totalUse += lt.numUseHash(&nums, &na)
+	na += countInt(extraKey, &nums)
+	totalUse++
+	asize := computeSizes(&nums, &na)
+	lt.resize(asize, totalUse-na)
 }
 
-func (lt *luaTable) initKeys() {
-	lt.keys = make(map[luaValue]luaValue)
-	var key luaValue = nil
-	for i, v := range lt.arr {
-		if v != nil {
-			lt.keys[key] = int64(i + 1)
-			key = int64(i + 1)
+func (lt *luaTable) resize(nasize, nhsize int) {
+	oldArr := lt.arr
+	oldNode := lt.node
+	if lt.ordered { /* re-insert in insertion order */
+		oldNode = lt.orderedNodes()
+		lt.keys = lt.keys[:0]
+	}
+	if nasize > len(oldArr) {
+		lt.arr = append(oldArr, make([]luaValue, nasize-len(oldArr))...)
+	}
+	lt.setNodeVector(nhsize)
+	if nasize < len(oldArr) { /* re-insert elements from vanishing slice */
+		lt.arr = make([]luaValue, nasize)
+		copy(lt.arr, oldArr)
+		for i := nasize; i < len(oldArr); i++ {
+			if oldArr[i] != nil {
+				lt.put(int64(i+1), oldArr[i])
+			}
+		}
+	}
+	if lt.ordered {
+		for j := range oldNode {
+			lt.put(oldNode[j].key, oldNode[j].val)
 		}
+		return
 	}
-	for k, v := range lt.mp {
-		if v != nil {
-			lt.keys[key] = k
-			key = k
+	for j := len(oldNode) - 1; j >= 0; j-- {
+		if old := &oldNode[j]; old.val != nil {
+			lt.put(old.key, old.val)
 		}
 	}
-	lt.lastKey = key
 }
 
-func (lt *luaTable) nextKey(key luaValue) luaValue {
-	if lt.keys == nil || (key == nil && lt.changed) {
-		lt.initKeys()
-		lt.changed = false
+func (lt *luaTable) numUseArray(nums *[MAXABITS + 1]int) int {
+	ause := 0
+	i := 1
+	for lg, ttlg := 0, 1; lg <= MAXABITS; lg, ttlg = lg+1, ttlg*2 {
+		lc := 0
+		lim := ttlg
+		if lim > len(lt.arr) {
+			lim = len(lt.arr)
+			if i > lim {
+				break /* no more elements to count */
+			}
+		}
+		/* count elements in range (2^(lg-1), 2^lg] */
+		for ; i <= lim; i++ {
+			if lt.arr[i-1] != nil {
+				lc++
+			}
+		}
+		nums[lg] += lc
+		ause += lc
 	}
+	return ause
+}
+
+func (lt *luaTable) numUseHash(nums *[MAXABITS + 1]int, na *int) int {
+	totalUse := 0
+	ause := 0
+	for i := len(lt.node) - 1; i >= 0; i-- {
+		if n := &lt.node[i]; n.val != nil {
+			ause += countInt(n.key, nums)
+			totalUse++
+		}
+	}
+	*na += ause
+	return totalUse
+}
+
+func countInt(key luaValue, nums *[MAXABITS + 1]int) int {
+	if k, ok := key.(int64); ok && k >= 1 && k <= MAXASIZE {
+		nums[ceilLog2(int(k))]++
+		return 1
+	}
+	return 0
+}
+
+// computeSizes returns the largest size n such that more than half of the
+// slots 1..n would be in use, na is updated to the number of keys going to
+// the array part.
+func computeSizes(nums *[MAXABITS + 1]int, na *int) int {
+	a := 0       /* number of elements smaller than 2^i */
+	nArr := 0    /* number of elements to go to array part */
+	optimal := 0 /* optimal size for array part */
+	for i, twotoi := 0, 1; twotoi > 0 && *na > twotoi/2; i, twotoi = i+1, twotoi*2 {
+		if i > MAXABITS {
+			break
+		}
+		if nums[i] > 0 {
+			a += nums[i]
+			if a > twotoi/2 { /* more than half elements present? */
+				optimal = twotoi
+				nArr = a
+			}
+		}
+	}
+	*na = nArr
+	return optimal
+}
+
+// ceilLog2 returns ceil(log2(x)) for x >= 1.
+func ceilLog2(x int) int {
+	return bits.Len(uint(x - 1))
+}
+
+/* length */
 
-	nextKey := lt.keys[key]
-	if nextKey == nil && key != nil && key != lt.lastKey {
-		panic("invalid key to 'next'")
+// len returns a border of the table - luaH_getn
+func (lt *luaTable) len() int {
+	j := len(lt.arr)
+	if j > 0 && lt.arr[j-1] == nil {
+		/* there is a border in the array part: binary search for it */
+		i := 0
+		for j-i > 1 {
+			m := (i + j) / 2
+			if lt.arr[m-1] == nil {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return i
+	}
+	if len(lt.node) == 0 {
+		return j
+	}
+	return lt.unboundSearch(j)
+}
+
+func (lt *luaTable) unboundSearch(j int) int {
+	i := j /* i is zero or a present index */
+	j++
+	/* find i and j such that i is present and j is not */
+	for lt.getInt(int64(j)) != nil {
+		i = j
+		if j > math.MaxInt64/2 { /* overflow? */
+			/* table was built with bad purposes: resort to linear search */
+			i = 1
+			for lt.getInt(int64(i)) != nil {
+				i++
+			}
+			return i - 1
+		}
+		j *= 2
 	}
+	/* now do a binary search between them */
+	for j-i > 1 {
+		m := (i + j) / 2
+		if lt.getInt(int64(m)) == nil {
+			j = m
+		} else {
+			i = m
+		}
+	}
+	return i
+}
+
+/* traversal */
 
-	return nextKey
+// next returns the field following key in a traversal of the table, the
+// first one for a nil key, and a nil key after the last one.
+func (lt *luaTable) next(key luaValue) (luaValue, luaValue) {
+	i := lt.findIndex(key)
+	for ; i < len(lt.arr); i++ {
+		if lt.arr[i] != nil {
+			return int64(i + 1), lt.arr[i]
+		}
+	}
+	if lt.ordered {
+		for i -= len(lt.arr); i < len(lt.keys); i++ {
+			if n := lt.liveNode(i); n != nil {
+				return n.key, n.val
+			}
+		}
+		return nil, nil
+	}
+	for i -= len(lt.arr); i < len(lt.node); i++ {
+		if n := &lt.node[i]; n.val != nil {
+			return n.key, n.val
+		}
+	}
+	return nil, nil
+}
+
+// findIndex returns the traversal position that follows key: array slots
+// come first, then the nodes.
+func (lt *luaTable) findIndex(key luaValue) int {
+	if key == nil {
+		return 0
+	}
+	key = _floatToInteger(key)
+	if idx, ok := key.(int64); ok && uint64(idx)-1 < uint64(len(lt.arr)) {
+		return int(idx)
+	}
+	if n := lt.findNode(key); n >= 0 {
+		if lt.ordered {
+			return len(lt.arr) + lt.node[n].ord + 1
+		}
+		return len(lt.arr) + n + 1
+	}
+	panic("invalid key to 'next'")
+}
+
+// clearNode removes the entry of node i together with its key.
+func (lt *luaTable) clearNode(i int) {
+	n := &lt.node[i]
+	if lt.ordered && lt.keys[n.ord] == n.key {
+		lt.keys[n.ord] = deadKey{}
+	}
+	n.key = deadKey{}
+	n.val = nil
+}
+
+/* ordered tables */
+
+// liveNode returns the node of keys[i], or nil if the key is stale or its
+// value is nil.
+func (lt *luaTable) liveNode(i int) *node {
+	if _, ok := lt.keys[i].(deadKey); ok {
+		return nil
+	}
+	if n := lt.findNode(lt.keys[i]); n >= 0 {
+		if node := &lt.node[n]; node.ord == i && node.val != nil {
+			return node
+		}
+	}
+	return nil
+}
+
+// orderedNodes returns copies of the live nodes in insertion order.
+func (lt *luaTable) orderedNodes() []node {
+	nodes := make([]node, 0, len(lt.keys))
+	for i := range lt.keys {
+		if n := lt.liveNode(i); n != nil {
+			nodes = append(nodes, *n)
+		}
+	}
+	return nodes
+}
+
+// setOrdered makes the table ordered, its hash keys taking the order of
+// their nodes.
+func (lt *luaTable) setOrdered() {
+	if lt.ordered {
+		return
+	}
+	lt.ordered = true
+	lt.keys = nil
+	for i := range lt.node {
+		n := &lt.node[i]
+		if _, ok := n.key.(deadKey); ok || n.key == nil {
+			continue
+		}
+		n.ord = len(lt.keys)
+		lt.keys = append(lt.keys, n.key)
+	}
+}
+
+// compactKeys drops the stale keys left behind by reused nodes.
+func (lt *luaTable) compactKeys() {
+	keys := lt.keys[:0]
+	for i, key := range lt.keys {
+		if _, ok := key.(deadKey); ok {
+			continue
+		}
+		if n := lt.findNode(key); n >= 0 && lt.node[n].ord == i {
+			lt.node[n].ord = len(keys)
+			keys = append(keys, key)
+		}
+	}
+	for i := len(keys); i < len(lt.keys); i++ {
+		lt.keys[i] = nil
+	}
+	lt.keys = keys
 }
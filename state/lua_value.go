@@ -25,6 +25,10 @@ func typeOf(val luaValue) api.LuaType {
 		return api.LUA_TTABLE
 	case *luaState:
 		return api.LUA_TTHREAD
+	case *userdata:
+		return api.LUA_TUSERDATA
+	case lightUserdata:
+		return api.LUA_TLIGHTUSERDATA
 	default:
 		panic("todo!")
 	}
@@ -80,8 +84,11 @@ func _stringToInteger(s string) (int64, bool) {
 /* metatable */
 
 func getMetatable(val luaValue, ls *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
+	switch x := val.(type) {
+	case *luaTable:
+		return x.metatable
+	case *userdata:
+		return x.metatable
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
 	if mt := ls.registry.get(key); mt != nil {
@@ -91,8 +98,14 @@ func getMetatable(val luaValue, ls *luaState) *luaTable {
 }
 
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
+	switch x := val.(type) {
+	case *luaTable:
+		x.metatable = mt
+		ls.checkFinalizer(x, mt)
+		return
+	case *userdata:
+		x.metatable = mt
+		ls.checkFinalizer(x, mt)
 		return
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
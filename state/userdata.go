@@ -0,0 +1,21 @@
+package state
+
+// userdata is a full userdata: a Go value with a metatable of its own and
+// a user value, see lua_newuserdata.
+type userdata struct {
+	metatable *luaTable
+	value     interface{}
+	user      luaValue // lua_setuservalue
+	gcMark    uint32
+}
+
+func newUserdata(value interface{}, mt *luaTable) *userdata {
+	return &userdata{metatable: mt, value: value}
+}
+
+// lightUserdata is a light userdata: a comparable Go value, usually a
+// pointer, equal to any other light userdata holding the same value, see
+// lua_pushlightuserdata.
+type lightUserdata struct {
+	p interface{}
+}
@@ -1,7 +1,9 @@
 package stdlib
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 
@@ -9,27 +11,28 @@ import (
 )
 
 var baseFuncs = map[string]api.GoFunction{
-	"print":        basePrint,
-	"assert":       baseAssert,
-	"error":        baseError,
-	"select":       baseSelect,
-	"ipairs":       baseIPairs,
-	"pairs":        basePairs,
-	"next":         baseNext,
-	"load":         baseLoad,
-	"loadfile":     baseLoadFile,
-	"dofile":       baseDoFile,
-	"pcall":        basePCall,
-	"xpcall":       baseXPCall,
-	"getmetatable": baseGetMetatable,
-	"setmetatable": baseSetMetatable,
-	"rawequal":     baseRawEqual,
-	"rawlen":       baseRawLen,
-	"rawget":       baseRawGet,
-	"rawset":       baseRawSet,
-	"type":         baseType,
-	"tostring":     baseToString,
-	"tonumber":     baseToNumber,
+	"print":          basePrint,
+	"assert":         baseAssert,
+	"error":          baseError,
+	"select":         baseSelect,
+	"ipairs":         baseIPairs,
+	"pairs":          basePairs,
+	"next":           baseNext,
+	"load":           baseLoad,
+	"loadfile":       baseLoadFile,
+	"dofile":         baseDoFile,
+	"pcall":          basePCall,
+	"xpcall":         baseXPCall,
+	"getmetatable":   baseGetMetatable,
+	"setmetatable":   baseSetMetatable,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"type":           baseType,
+	"tostring":       baseToString,
+	"tonumber":       baseToNumber,
+	"collectgarbage": baseCollectGarbage,
 	/* placeholders */
 	"_G":       nil,
 	"_VERSION": nil,
@@ -44,15 +47,41 @@ func OpenBaseLib(ls api.LuaState) int {
 	ls.PushValue(-1)
 	ls.SetField(-2, "_G")
 	/* set global _VERSION */
-	ls.PushString("Lua 5.3") // todo
-	ls.SetField(-2, "_VERSION")
+	if ls.Version() >= api.LUA_VERSION_54 {
+		ls.PushString("Lua 5.4")
+		ls.SetField(-2, "_VERSION")
+		ls.PushGoFunction(baseWarn)
+		ls.SetField(-2, "warn")
+	} else if ls.Version() == api.LUA_VERSION_51 {
+		ls.PushString("Lua 5.1")
+		ls.SetField(-2, "_VERSION")
+	} else {
+		ls.PushString("Lua 5.3")
+		ls.SetField(-2, "_VERSION")
+	}
 	return 1
 }
 
+// warn (msg1, ···)
+// http://www.lua.org/manual/5.4/manual.html#pdf-warn
+func baseWarn(ls api.LuaState) int {
+	n := ls.GetTop()  /* number of arguments */
+	ls.CheckString(1) /* at least one argument */
+	for i := 2; i <= n; i++ {
+		ls.CheckString(i) /* make sure all arguments are strings */
+	}
+	for i := 1; i < n; i++ { /* compose warning */
+		ls.Warning(ls.ToString(i), true)
+	}
+	ls.Warning(ls.ToString(n), false) /* close warning */
+	return 0
+}
+
 // basePrint - luaB_print
 func basePrint(ls api.LuaState) int {
 	n := ls.GetTop() /* number of arguments */
 	ls.GetGlobal("tostring")
+	var sb strings.Builder
 	for i := 1; i <= n; i++ {
 		ls.PushValue(-1) /* function to be called */
 		ls.PushValue(i)  /* value to print */
@@ -62,12 +91,13 @@ func basePrint(ls api.LuaState) int {
 			return ls.Error2("'tostring' must return a string to 'print'")
 		}
 		if i > 1 {
-			fmt.Print("\t")
+			sb.WriteByte('\t')
 		}
-		fmt.Print(s)
+		sb.WriteString(s)
 		ls.Pop(1) /* pop result */
 	}
-	fmt.Println()
+	sb.WriteByte('\n')
+	io.WriteString(ls.Stdout(), sb.String())
 	return 0
 }
 
@@ -174,11 +204,49 @@ func baseLoad(ls api.LuaState) int {
 		chunkname := ls.OptString(2, chunk)
 		status = ls.Load([]byte(chunk), chunkname, mode)
 	} else { /* loading from a reader function */
-		panic("loading from a reader function") // todo
+		chunkname := ls.OptString(2, "=(load)")
+		ls.CheckType(1, api.LUA_TFUNCTION)
+		status = ls.LoadReader(&funcReader{ls: ls}, chunkname, mode)
 	}
 	return loadAux(ls, status, env)
 }
 
+// funcReader reads the pieces returned by the function at index 1, until
+// it returns nil or an empty string - generic_reader
+type funcReader struct {
+	ls    api.LuaState
+	piece string
+	done  bool
+}
+
+func (r *funcReader) Read(p []byte) (int, error) {
+	ls := r.ls
+	for r.piece == "" {
+		if r.done {
+			return 0, io.EOF
+		}
+		ls.PushValue(1)
+		if ls.PCall(0, 1, 0) != api.LUA_OK {
+			err := errors.New(ls.ToString(-1))
+			ls.Pop(1)
+			return 0, err
+		}
+		if ls.IsNil(-1) {
+			r.done = true
+		} else if !ls.IsString(-1) {
+			ls.Pop(1)
+			return 0, errors.New("reader function must return a string")
+		} else {
+			r.piece = ls.ToString(-1)
+			r.done = r.piece == ""
+		}
+		ls.Pop(1)
+	}
+	n := copy(p, r.piece)
+	r.piece = r.piece[n:]
+	return n, nil
+}
+
 // loadAux - load_aux
 func loadAux(ls api.LuaState, status, envIdx int) int {
 	if status == api.LUA_OK {
@@ -196,7 +264,7 @@ func loadAux(ls api.LuaState, status, envIdx int) int {
 // baseLoadFile - luaB_loadfile
 func baseLoadFile(ls api.LuaState) int {
 	fname := ls.OptString(1, "")
-	mode := ls.OptString(1, "bt")
+	mode := ls.OptString(2, "bt")
 	env := 0 /* 'env' index or 0 if no 'env' */
 	if !ls.IsNone(3) {
 		env = 3
@@ -292,6 +360,36 @@ func baseRawSet(ls api.LuaState) int {
 	return 1
 }
 
+var gcOptions = map[string]int{
+	"stop":      api.LUA_GCSTOP,
+	"restart":   api.LUA_GCRESTART,
+	"collect":   api.LUA_GCCOLLECT,
+	"count":     api.LUA_GCCOUNT,
+	"step":      api.LUA_GCSTEP,
+	"isrunning": api.LUA_GCISRUNNING,
+}
+
+// baseCollectGarbage - luaB_collectgarbage
+func baseCollectGarbage(ls api.LuaState) int {
+	name := ls.OptString(1, "collect")
+	o, found := gcOptions[name]
+	if !found {
+		return ls.ArgError(1, fmt.Sprintf("invalid option '%s'", name))
+	}
+	ex := int(ls.OptInteger(2, 0))
+	res := ls.GC(o, ex)
+	switch o {
+	case api.LUA_GCCOUNT:
+		b := ls.GC(api.LUA_GCCOUNTB, 0)
+		ls.PushNumber(float64(res) + float64(b)/1024)
+	case api.LUA_GCSTEP, api.LUA_GCISRUNNING:
+		ls.PushBoolean(res != 0)
+	default:
+		ls.PushInteger(int64(res))
+	}
+	return 1
+}
+
 // baseType - luaB_type
 func baseType(ls api.LuaState) int {
 	t := ls.Type(1)
@@ -0,0 +1,138 @@
+package stdlib
+
+import (
+	"math"
+
+	"github.com/iglev/glua/api"
+)
+
+// bit library of LuaJIT (BitOp), on signed 32-bit integers
+
+var bitLib = map[string]api.GoFunction{
+	"tobit":   bitToBit,
+	"tohex":   bitToHex,
+	"bnot":    bitJitNot,
+	"band":    bitJitAnd,
+	"bor":     bitJitOr,
+	"bxor":    bitJitXor,
+	"lshift":  bitJitLshift,
+	"rshift":  bitJitRshift,
+	"arshift": bitJitArshift,
+	"rol":     bitJitRol,
+	"ror":     bitJitRor,
+	"bswap":   bitJitBswap,
+}
+
+func OpenBitLib(ls api.LuaState) int {
+	ls.NewLib(bitLib)
+	return 1
+}
+
+// _toBit converts the number at arg to 32 bits with modular arithmetic,
+// dropping the fractional part of floats.
+func _toBit(ls api.LuaState, arg int) uint32 {
+	if ls.IsInteger(arg) {
+		return uint32(ls.ToInteger(arg))
+	}
+	f := ls.CheckNumber(arg)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0
+	}
+	return uint32(int64(math.Mod(math.Trunc(f), 1<<32)))
+}
+
+func _pushBit(ls api.LuaState, b uint32) int {
+	ls.PushInteger(int64(int32(b)))
+	return 1
+}
+
+// bit.tobit (x)
+func bitToBit(ls api.LuaState) int {
+	return _pushBit(ls, _toBit(ls, 1))
+}
+
+// bit.tohex (x [, n])
+func bitToHex(ls api.LuaState) int {
+	b := _toBit(ls, 1)
+	n := ls.OptInteger(2, 8)
+	digits := "0123456789abcdef"
+	if n < 0 {
+		n = -n
+		digits = "0123456789ABCDEF"
+	}
+	if n > 8 {
+		n = 8
+	}
+	buf := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		buf[i] = digits[b&15]
+		b >>= 4
+	}
+	ls.PushString(string(buf))
+	return 1
+}
+
+// bit.bnot (x)
+func bitJitNot(ls api.LuaState) int {
+	return _pushBit(ls, ^_toBit(ls, 1))
+}
+
+// bit.band (x1 [, x2...])
+func bitJitAnd(ls api.LuaState) int {
+	b := _toBit(ls, 1)
+	for i := 2; i <= ls.GetTop(); i++ {
+		b &= _toBit(ls, i)
+	}
+	return _pushBit(ls, b)
+}
+
+// bit.bor (x1 [, x2...])
+func bitJitOr(ls api.LuaState) int {
+	b := _toBit(ls, 1)
+	for i := 2; i <= ls.GetTop(); i++ {
+		b |= _toBit(ls, i)
+	}
+	return _pushBit(ls, b)
+}
+
+// bit.bxor (x1 [, x2...])
+func bitJitXor(ls api.LuaState) int {
+	b := _toBit(ls, 1)
+	for i := 2; i <= ls.GetTop(); i++ {
+		b ^= _toBit(ls, i)
+	}
+	return _pushBit(ls, b)
+}
+
+// bit.lshift (x, n)
+func bitJitLshift(ls api.LuaState) int {
+	return _pushBit(ls, _toBit(ls, 1)<<(_toBit(ls, 2)&31))
+}
+
+// bit.rshift (x, n)
+func bitJitRshift(ls api.LuaState) int {
+	return _pushBit(ls, _toBit(ls, 1)>>(_toBit(ls, 2)&31))
+}
+
+// bit.arshift (x, n)
+func bitJitArshift(ls api.LuaState) int {
+	return _pushBit(ls, uint32(int32(_toBit(ls, 1))>>(_toBit(ls, 2)&31)))
+}
+
+// bit.rol (x, n)
+func bitJitRol(ls api.LuaState) int {
+	b, n := _toBit(ls, 1), _toBit(ls, 2)&31
+	return _pushBit(ls, b<<n|b>>(32-n))
+}
+
+// bit.ror (x, n)
+func bitJitRor(ls api.LuaState) int {
+	b, n := _toBit(ls, 1), _toBit(ls, 2)&31
+	return _pushBit(ls, b>>n|b<<(32-n))
+}
+
+// bit.bswap (x)
+func bitJitBswap(ls api.LuaState) int {
+	b := _toBit(ls, 1)
+	return _pushBit(ls, b>>24|b>>8&0xff00|b<<8&0xff0000|b<<24)
+}
@@ -0,0 +1,175 @@
+package stdlib
+
+import "github.com/iglev/glua/api"
+
+// bit32 library of Lua 5.2, on unsigned 32-bit integers
+
+const LUA_NBITS = 32
+
+var bit32Lib = map[string]api.GoFunction{
+	"arshift": bitArshift,
+	"band":    bitAnd,
+	"bnot":    bitNot,
+	"bor":     bitOr,
+	"btest":   bitTest,
+	"bxor":    bitXor,
+	"extract": bitExtract,
+	"replace": bitReplace,
+	"lrotate": bitLrotate,
+	"lshift":  bitLshift,
+	"rrotate": bitRrotate,
+	"rshift":  bitRshift,
+}
+
+func OpenBit32Lib(ls api.LuaState) int {
+	ls.NewLib(bit32Lib)
+	return 1
+}
+
+func _checkUnsigned(ls api.LuaState, arg int) uint32 {
+	return uint32(ls.CheckInteger(arg))
+}
+
+func _pushUnsigned(ls api.LuaState, r uint32) int {
+	ls.PushInteger(int64(r))
+	return 1
+}
+
+func _andAux(ls api.LuaState) uint32 {
+	n := ls.GetTop()
+	r := ^uint32(0)
+	for i := 1; i <= n; i++ {
+		r &= _checkUnsigned(ls, i)
+	}
+	return r
+}
+
+// bit32.band (···)
+func bitAnd(ls api.LuaState) int {
+	return _pushUnsigned(ls, _andAux(ls))
+}
+
+// bit32.btest (···)
+func bitTest(ls api.LuaState) int {
+	ls.PushBoolean(_andAux(ls) != 0)
+	return 1
+}
+
+// bit32.bor (···)
+func bitOr(ls api.LuaState) int {
+	n := ls.GetTop()
+	r := uint32(0)
+	for i := 1; i <= n; i++ {
+		r |= _checkUnsigned(ls, i)
+	}
+	return _pushUnsigned(ls, r)
+}
+
+// bit32.bxor (···)
+func bitXor(ls api.LuaState) int {
+	n := ls.GetTop()
+	r := uint32(0)
+	for i := 1; i <= n; i++ {
+		r ^= _checkUnsigned(ls, i)
+	}
+	return _pushUnsigned(ls, r)
+}
+
+// bit32.bnot (x)
+func bitNot(ls api.LuaState) int {
+	return _pushUnsigned(ls, ^_checkUnsigned(ls, 1))
+}
+
+func _shift(ls api.LuaState, r uint32, i int64) int {
+	if i < 0 { /* shift right? */
+		if i <= -LUA_NBITS {
+			r = 0
+		} else {
+			r >>= uint(-i)
+		}
+	} else { /* shift left */
+		if i >= LUA_NBITS {
+			r = 0
+		} else {
+			r <<= uint(i)
+		}
+	}
+	return _pushUnsigned(ls, r)
+}
+
+// bit32.lshift (x, disp)
+func bitLshift(ls api.LuaState) int {
+	return _shift(ls, _checkUnsigned(ls, 1), ls.CheckInteger(2))
+}
+
+// bit32.rshift (x, disp)
+func bitRshift(ls api.LuaState) int {
+	return _shift(ls, _checkUnsigned(ls, 1), -ls.CheckInteger(2))
+}
+
+// bit32.arshift (x, disp)
+func bitArshift(ls api.LuaState) int {
+	r := _checkUnsigned(ls, 1)
+	i := ls.CheckInteger(2)
+	if i < 0 || r&(1<<(LUA_NBITS-1)) == 0 {
+		return _shift(ls, r, -i)
+	}
+	/* arithmetic shift for 'negative' number */
+	if i >= LUA_NBITS {
+		r = ^uint32(0)
+	} else {
+		r = uint32(int32(r) >> uint(i)) /* add signal bit */
+	}
+	return _pushUnsigned(ls, r)
+}
+
+func _rotate(ls api.LuaState, d int64) int {
+	r := _checkUnsigned(ls, 1)
+	i := uint(d & (LUA_NBITS - 1)) /* i = d % NBITS */
+	if i != 0 {
+		r = r<<i | r>>(LUA_NBITS-i)
+	}
+	return _pushUnsigned(ls, r)
+}
+
+// bit32.lrotate (x, disp)
+func bitLrotate(ls api.LuaState) int {
+	return _rotate(ls, ls.CheckInteger(2))
+}
+
+// bit32.rrotate (x, disp)
+func bitRrotate(ls api.LuaState) int {
+	return _rotate(ls, -ls.CheckInteger(2))
+}
+
+// _fieldArgs gets the field and width arguments of extract and replace.
+func _fieldArgs(ls api.LuaState, farg int) (uint, uint) {
+	f := ls.CheckInteger(farg)
+	w := ls.OptInteger(farg+1, 1)
+	ls.ArgCheck(0 <= f, farg, "field cannot be negative")
+	ls.ArgCheck(0 < w, farg+1, "width must be positive")
+	if f+w > LUA_NBITS {
+		ls.Error2("trying to access non-existent bits")
+	}
+	return uint(f), uint(w)
+}
+
+func _mask(w uint) uint32 {
+	return ^uint32(0) >> (LUA_NBITS - w)
+}
+
+// bit32.extract (n, field [, width])
+func bitExtract(ls api.LuaState) int {
+	r := _checkUnsigned(ls, 1)
+	f, w := _fieldArgs(ls, 2)
+	return _pushUnsigned(ls, r>>f&_mask(w))
+}
+
+// bit32.replace (n, v, field [, width])
+func bitReplace(ls api.LuaState) int {
+	r := _checkUnsigned(ls, 1)
+	v := _checkUnsigned(ls, 2)
+	f, w := _fieldArgs(ls, 3)
+	m := _mask(w)
+	return _pushUnsigned(ls, r&^(m<<f)|(v&m)<<f)
+}
@@ -0,0 +1,285 @@
+package stdlib
+
+import (
+	"math"
+	"strings"
+
+	"github.com/iglev/glua/api"
+)
+
+// compat51 library: the functions of Lua 5.1 that Lua 5.3 removed, so
+// that legacy scripts run unchanged. Environments of functions are
+// emulated over their _ENV upvalues. The library adds functions to the
+// table, math, string and package libraries, and opens them first if they
+// are not open yet.
+
+var compat51Funcs = map[string]api.GoFunction{
+	"loadstring": baseLoad,
+	"getfenv":    compatGetfenv,
+	"setfenv":    compatSetfenv,
+	"module":     compatModule,
+}
+
+var compat51TabFuncs = map[string]api.GoFunction{
+	"getn": tabGetn,
+	"maxn": tabMaxn,
+}
+
+var compat51MathFuncs = map[string]api.GoFunction{
+	"pow":   mathPow,
+	"ldexp": mathLdexp,
+	"frexp": mathFrexp,
+}
+
+var compat51StrFuncs = map[string]api.GoFunction{
+	"gfind": strGmatch,
+}
+
+var compat51PkgFuncs = map[string]api.GoFunction{
+	"seeall": pkgSeeall,
+}
+
+func OpenCompat51Lib(ls api.LuaState) int {
+	_extendLib(ls, "table", OpenTableLib, compat51TabFuncs)
+	ls.GetGlobal("table")
+	ls.GetField(-1, "unpack")
+	ls.SetGlobal("unpack") /* unpack is table.unpack */
+	ls.Pop(1)
+	_extendLib(ls, "math", OpenMathLib, compat51MathFuncs)
+	_extendLib(ls, "string", OpenStringLib, compat51StrFuncs)
+	_extendLib(ls, "package", OpenPackageLib, compat51PkgFuncs)
+	ls.PushGlobalTable()
+	ls.SetFuncs(compat51Funcs, 0) /* open lib into global table */
+	ls.Pop(1)
+	ls.NewLib(compat51Funcs)
+	return 1
+}
+
+// _extendLib adds funcs to the library modname, opened by openf if needed.
+func _extendLib(ls api.LuaState, modname string, openf api.GoFunction, funcs map[string]api.GoFunction) {
+	ls.RequireF(modname, openf, true)
+	ls.SetFuncs(funcs, 0)
+	ls.Pop(1)
+}
+
+/* environments */
+
+// _pushEnvFunction pushes the function whose environment getfenv and
+// setfenv get or set: the function at 1, or the function running at the
+// level at 1 (1 by default). It pushes nothing for level 0, the global
+// environment.
+func _pushEnvFunction(ls api.LuaState) bool {
+	if ls.Type(1) == api.LUA_TFUNCTION {
+		ls.PushValue(1)
+		return true
+	}
+	level := ls.OptInteger(1, 1)
+	ls.ArgCheck(level >= 0, 1, "level must be non-negative")
+	if level == 0 {
+		return false
+	}
+	if !ls.GetFunction(int(level)) {
+		ls.ArgError(1, "invalid level")
+	}
+	return true
+}
+
+// _envUpvalue returns the index of the _ENV upvalue of the function at
+// idx, 0 if it has none.
+func _envUpvalue(ls api.LuaState, idx int) int {
+	for n := 1; ; n++ {
+		name, ok := ls.GetUpvalue(idx, n)
+		if !ok {
+			return 0
+		}
+		ls.Pop(1)
+		if name == "_ENV" {
+			return n
+		}
+	}
+}
+
+// _setEnv pops a table and makes it the environment of the function at
+// idx, in an _ENV upvalue of its own so that the functions sharing the
+// previous one keep their environment. It returns false if the function
+// has no _ENV upvalue.
+func _setEnv(ls api.LuaState, idx int) bool {
+	idx = ls.AbsIndex(idx)
+	n := _envUpvalue(ls, idx)
+	if n == 0 {
+		ls.Pop(1)
+		return false
+	}
+	ls.PushNil()
+	ls.PushGoClosure(func(api.LuaState) int { return 0 }, 1) /* holder of a new upvalue */
+	ls.UpvalueJoin(idx, n, -1, 1)
+	ls.Pop(1)
+	ls.SetUpvalue(idx, n)
+	return true
+}
+
+// getfenv ([f])
+func compatGetfenv(ls api.LuaState) int {
+	if _pushEnvFunction(ls) {
+		if n := _envUpvalue(ls, -1); n > 0 {
+			ls.GetUpvalue(-1, n)
+			return 1
+		}
+	}
+	ls.PushGlobalTable() /* level 0 and functions without _ENV */
+	return 1
+}
+
+// setfenv (f, table)
+func compatSetfenv(ls api.LuaState) int {
+	ls.CheckType(2, api.LUA_TTABLE)
+	if !_pushEnvFunction(ls) { /* level 0: the environment of new chunks */
+		ls.PushValue(2)
+		ls.RawSetI(api.LUA_REGISTRYINDEX, api.LUA_RIDX_GLOBALS)
+		return 0
+	}
+	ls.PushValue(2)
+	if ls.IsGoFunction(-2) || !_setEnv(ls, -2) {
+		return ls.Error2("'setfenv' cannot change environment of given object")
+	}
+	return 1
+}
+
+/* modules */
+
+// module (name [, ···])
+func compatModule(ls api.LuaState) int {
+	modname := ls.CheckString(1)
+	lastarg := ls.GetTop()   /* last parameter */
+	_pushModule(ls, modname) /* get/create module table */
+	/* check whether table already has a _NAME field */
+	if ls.GetField(-1, "_NAME") != api.LUA_TNIL {
+		ls.Pop(1) /* table is an initialized module */
+	} else { /* no; initialize it */
+		ls.Pop(1)
+		_modInit(ls, modname)
+	}
+	if !ls.GetFunction(1) || ls.IsGoFunction(-1) { /* get calling function */
+		return ls.Error2("'module' not called from a Lua function")
+	}
+	ls.PushValue(-2) /* module as the new environment */
+	_setEnv(ls, -2)
+	ls.Pop(1)                       /* remove function */
+	for i := 2; i <= lastarg; i++ { /* options */
+		if ls.IsFunction(i) {
+			ls.PushValue(i)  /* get option (a function) */
+			ls.PushValue(-2) /* module */
+			ls.Call(1, 0)
+		}
+	}
+	return 1
+}
+
+// _pushModule pushes package.loaded[modname], or else the global table
+// named modname, created if needed - luaL_pushmodule
+func _pushModule(ls api.LuaState, modname string) {
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	if ls.GetField(-1, modname) != api.LUA_TTABLE { /* no LOADED[modname]? */
+		ls.Pop(1) /* remove previous result */
+		/* try global variable (and create one if it does not exist) */
+		ls.PushGlobalTable()
+		if !_findTable(ls, modname) {
+			ls.Error2("name conflict for module '%s'", modname)
+		}
+		ls.PushValue(-1)
+		ls.SetField(-3, modname) /* LOADED[modname] = new table */
+	}
+	ls.Remove(-2) /* remove LOADED table */
+}
+
+// _findTable replaces the table at the top by its field fname, a dotted
+// name such as "a.b.c", creating the missing tables. It returns false if
+// a part of the name is not a table - luaL_findtable
+func _findTable(ls api.LuaState, fname string) bool {
+	for _, part := range strings.Split(fname, ".") {
+		switch ls.GetField(-1, part) {
+		case api.LUA_TTABLE:
+		case api.LUA_TNIL: /* no such field? */
+			ls.Pop(1)
+			ls.NewTable() /* new table for field */
+			ls.PushValue(-1)
+			ls.SetField(-3, part)
+		default: /* field has a non-table value */
+			ls.Pop(2)
+			return false
+		}
+		ls.Remove(-2) /* remove previous table */
+	}
+	return true
+}
+
+func _modInit(ls api.LuaState, modname string) {
+	ls.PushValue(-1)
+	ls.SetField(-2, "_M") /* module._M = module */
+	ls.PushString(modname)
+	ls.SetField(-2, "_NAME")
+	/* set _PACKAGE as package name (full module name minus last part) */
+	ls.PushString(modname[:strings.LastIndexByte(modname, '.')+1])
+	ls.SetField(-2, "_PACKAGE")
+}
+
+// package.seeall (module)
+func pkgSeeall(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	if !ls.GetMetatable(1) {
+		ls.CreateTable(0, 1) /* create new metatable */
+		ls.PushValue(-1)
+		ls.SetMetatable(1)
+	}
+	ls.PushGlobalTable()
+	ls.SetField(-2, "__index") /* mt.__index = _G */
+	return 0
+}
+
+/* table, math */
+
+// table.getn (table)
+func tabGetn(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.PushInteger(int64(ls.RawLen(1)))
+	return 1
+}
+
+// table.maxn (table)
+func tabMaxn(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.PushInteger(0) /* maximum so far */
+	ls.PushNil()      /* first key */
+	for ls.Next(1) {
+		ls.Pop(1) /* remove value */
+		if ls.Type(-1) == api.LUA_TNUMBER && ls.ToNumber(-1) > ls.ToNumber(-2) {
+			ls.PushValue(-1)
+			ls.Replace(-3)
+		}
+	}
+	return 1
+}
+
+// math.pow (x, y)
+func mathPow(ls api.LuaState) int {
+	x := ls.CheckNumber(1)
+	y := ls.CheckNumber(2)
+	ls.PushNumber(math.Pow(x, y))
+	return 1
+}
+
+// math.ldexp (m, e)
+func mathLdexp(ls api.LuaState) int {
+	m := ls.CheckNumber(1)
+	e := ls.CheckInteger(2)
+	ls.PushNumber(math.Ldexp(m, int(e)))
+	return 1
+}
+
+// math.frexp (x)
+func mathFrexp(ls api.LuaState) int {
+	m, e := math.Frexp(ls.CheckNumber(1))
+	ls.PushNumber(m)
+	ls.PushInteger(int64(e))
+	return 2
+}
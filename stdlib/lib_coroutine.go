@@ -14,6 +14,10 @@ var coFuncs = map[string]api.GoFunction{
 
 func OpenCoroutineLib(ls api.LuaState) int {
 	ls.NewLib(coFuncs)
+	if ls.Version() >= api.LUA_VERSION_54 {
+		ls.PushGoFunction(coClose)
+		ls.SetField(-2, "close")
+	}
 	return 1
 }
 
@@ -77,26 +81,47 @@ func coYield(ls api.LuaState) int {
 func coStatus(ls api.LuaState) int {
 	co := ls.ToThread(1)
 	ls.ArgCheck(co != nil, 1, "thread expected")
+	ls.PushString(_auxStatus(ls, co))
+	return 1
+}
+
+func _auxStatus(ls, co api.LuaState) string {
 	if ls == co {
-		ls.PushString("running")
-	} else {
-		switch co.Status() {
-		case api.LUA_YIELD:
-			ls.PushString("suspended")
-		case api.LUA_OK:
-			if co.GetStack() { /* does it have frames? */
-				ls.PushString("normal") /* it is running */
-			} else if co.GetTop() == 0 {
-				ls.PushString("dead")
-			} else {
-				ls.PushString("suspended")
-			}
-		default: /* some error occurred */
-			ls.PushString("dead")
+		return "running"
+	}
+	switch co.Status() {
+	case api.LUA_YIELD:
+		return "suspended"
+	case api.LUA_OK:
+		if co.GetStack() { /* does it have frames? */
+			return "normal" /* it is running */
+		} else if co.GetTop() == 0 {
+			return "dead"
+		} else {
+			return "suspended" /* initial state */
 		}
+	default: /* some error occurred */
+		return "dead"
 	}
+}
 
-	return 1
+// coroutine.close (co)
+// http://www.lua.org/manual/5.4/manual.html#pdf-coroutine.close
+func coClose(ls api.LuaState) int {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "thread expected")
+	switch status := _auxStatus(ls, co); status {
+	case "dead", "suspended":
+		if co.CloseThread(ls) == api.LUA_OK {
+			ls.PushBoolean(true)
+			return 1
+		}
+		ls.PushBoolean(false)
+		co.XMove(ls, 1) /* move error message */
+		return 2
+	default: /* normal or running coroutine */
+		return ls.Error2("cannot close a %s coroutine", status)
+	}
 }
 
 // coroutine.isyieldable ()
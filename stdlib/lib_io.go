@@ -0,0 +1,640 @@
+package stdlib
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/iglev/glua/api"
+)
+
+/*
+Files are userdata holding a *luaFile, with the metatable LUA_FILEHANDLE.
+
+io.stdin, io.stdout and io.stderr are bound to the streams of the state
+(see api.BasicAPI.SetStdout) each time they are used, so that they follow
+later calls to SetStdin, SetStdout and SetStderr.
+*/
+
+const LUA_FILEHANDLE = "FILE*"
+
+/* keys, in the registry, for the default input and output files */
+const (
+	IO_INPUT  = "_IO_input"
+	IO_OUTPUT = "_IO_output"
+)
+
+const MAXARGLINE = 250 // maximum number of arguments to 'lines'
+
+type luaFile struct {
+	f      *os.File // nil for the standard streams
+	std    int      // 0, 1 or 2 for stdin, stdout and stderr
+	tmp    string   // name of the file to remove on close (io.tmpfile)
+	closed bool
+	r      *bufio.Reader
+	src    io.Reader // what r reads from
+}
+
+var ioLib = map[string]api.GoFunction{
+	"close":   ioClose,
+	"flush":   ioFlush,
+	"input":   ioInput,
+	"lines":   ioLines,
+	"open":    ioOpen,
+	"output":  ioOutput,
+	"popen":   ioPopen,
+	"read":    ioRead,
+	"tmpfile": ioTmpFile,
+	"type":    ioType,
+	"write":   ioWrite,
+}
+
+var fileMethods = map[string]api.GoFunction{
+	"close":   ioClose,
+	"flush":   fileFlush,
+	"lines":   fileLines,
+	"read":    fileRead,
+	"seek":    fileSeek,
+	"setvbuf": fileSetVBuf,
+	"write":   fileWrite,
+}
+
+var fileMeta = map[string]api.GoFunction{
+	"__gc":       fileGC,
+	"__tostring": fileToString,
+}
+
+// OpenIOLib - luaopen_io
+func OpenIOLib(ls api.LuaState) int {
+	ls.NewLib(ioLib) /* new module */
+	createMeta(ls)
+	/* create (and set) default files */
+	createStdFile(ls, 0, IO_INPUT, "stdin")
+	createStdFile(ls, 1, IO_OUTPUT, "stdout")
+	createStdFile(ls, 2, "", "stderr")
+	return 1
+}
+
+func createMeta(ls api.LuaState) {
+	ls.NewMetatable(LUA_FILEHANDLE) /* metatable for file handles */
+	ls.SetFuncs(fileMeta, 0)        /* add metamethods to new metatable */
+	ls.NewLib(fileMethods)          /* create method table */
+	ls.SetField(-2, "__index")      /* metatable.__index = method table */
+	ls.Pop(1)                       /* pop metatable */
+}
+
+func createStdFile(ls api.LuaState, std int, regKey, fname string) {
+	newFile(ls, &luaFile{std: std})
+	if regKey != "" {
+		ls.PushValue(-1)
+		ls.SetField(api.LUA_REGISTRYINDEX, regKey) /* add file to registry */
+	}
+	ls.SetField(-2, fname) /* add file to module */
+}
+
+// newFile pushes a file handle for lf.
+func newFile(ls api.LuaState, lf *luaFile) {
+	ls.NewUserdata(lf)
+	ls.SetMetatable2(LUA_FILEHANDLE)
+}
+
+// testFile returns the file at arg, or nil if it is not a file handle.
+func testFile(ls api.LuaState, arg int) *luaFile {
+	lf, _ := ls.TestUdata(arg, LUA_FILEHANDLE).(*luaFile)
+	return lf
+}
+
+func checkFile(ls api.LuaState, arg int) *luaFile {
+	return ls.CheckUdata(arg, LUA_FILEHANDLE).(*luaFile)
+}
+
+// toFile checks that the file at 1 is open.
+func toFile(ls api.LuaState) *luaFile {
+	lf := checkFile(ls, 1)
+	if lf.closed {
+		ls.Error2("attempt to use a closed file")
+	}
+	return lf
+}
+
+/* streams */
+
+func (lf *luaFile) reader(ls api.LuaState) *bufio.Reader {
+	var src io.Reader = lf.f
+	if lf.f == nil {
+		src = ls.Stdin()
+	}
+	if lf.r == nil || lf.src != src {
+		lf.r, lf.src = bufio.NewReader(src), src
+	}
+	return lf.r
+}
+
+func (lf *luaFile) writer(ls api.LuaState) io.Writer {
+	if lf.f != nil {
+		lf.unread()
+		return lf.f
+	}
+	switch lf.std {
+	case 1:
+		return ls.Stdout()
+	case 2:
+		return ls.Stderr()
+	}
+	return nil
+}
+
+// unread drops the data read ahead, moving the file back to the position
+// seen by Lua.
+func (lf *luaFile) unread() {
+	if lf.r != nil && lf.f != nil {
+		if n := lf.r.Buffered(); n > 0 {
+			lf.f.Seek(int64(-n), io.SeekCurrent)
+		}
+		lf.r.Reset(lf.f)
+	}
+}
+
+func (lf *luaFile) close() error {
+	lf.closed = true
+	err := lf.f.Close()
+	if lf.tmp != "" {
+		os.Remove(lf.tmp)
+	}
+	return err
+}
+
+// fileResult - luaL_fileresult
+func fileResult(ls api.LuaState, err error, fname string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+	errno := 0
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	if en, ok := err.(syscall.Errno); ok {
+		errno = int(en)
+	}
+	ls.PushNil()
+	if fname != "" {
+		ls.PushFString("%s: %s", fname, err)
+	} else {
+		ls.PushString(err.Error())
+	}
+	ls.PushInteger(int64(errno))
+	return 3
+}
+
+/* io functions */
+
+// io.close ([file])
+func ioClose(ls api.LuaState) int {
+	if ls.IsNone(1) { /* no argument? */
+		ls.GetField(api.LUA_REGISTRYINDEX, IO_OUTPUT) /* use standard output */
+	}
+	lf := toFile(ls)
+	if lf.f == nil {
+		ls.PushNil()
+		ls.PushString("cannot close standard file")
+		return 2
+	}
+	return fileResult(ls, lf.close(), "")
+}
+
+// io.flush ()
+func ioFlush(ls api.LuaState) int {
+	return flush(ls, getIOFile(ls, IO_OUTPUT))
+}
+
+// io.input ([file])
+func ioInput(ls api.LuaState) int {
+	return gIOFile(ls, IO_INPUT, "r")
+}
+
+// io.output ([file])
+func ioOutput(ls api.LuaState) int {
+	return gIOFile(ls, IO_OUTPUT, "w")
+}
+
+func gIOFile(ls api.LuaState, regKey, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if fname, ok := ls.ToStringX(1); ok && ls.Type(1) == api.LUA_TSTRING {
+			openCheckFile(ls, fname, mode)
+		} else {
+			toFile(ls) /* check that it's a valid file handle */
+			ls.PushValue(1)
+		}
+		ls.SetField(api.LUA_REGISTRYINDEX, regKey)
+	}
+	/* return current value */
+	ls.GetField(api.LUA_REGISTRYINDEX, regKey)
+	return 1
+}
+
+func getIOFile(ls api.LuaState, regKey string) *luaFile {
+	ls.GetField(api.LUA_REGISTRYINDEX, regKey)
+	lf := testFile(ls, -1)
+	if lf.closed {
+		ls.Error2("standard %s file is closed", regKey[len("_IO_"):])
+	}
+	return lf
+}
+
+// io.lines ([filename, ...])
+func ioLines(ls api.LuaState) int {
+	if ls.IsNone(1) {
+		ls.PushNil() /* at least one argument */
+	}
+	toClose := false
+	if ls.IsNil(1) { /* no file name? */
+		ls.GetField(api.LUA_REGISTRYINDEX, IO_INPUT) /* get default input */
+		ls.Replace(1)                                /* put it at index 1 */
+		toFile(ls)                                   /* check that it's a valid file handle */
+	} else { /* open a new file */
+		openCheckFile(ls, ls.CheckString(1), "r")
+		ls.Replace(1) /* put file at index 1 */
+		toClose = true
+	}
+	auxLines(ls, toClose)
+	return 1
+}
+
+func openCheckFile(ls api.LuaState, fname, mode string) {
+	f, err := openFile(fname, mode)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+		}
+		ls.Error2("cannot open file '%s' (%s)", fname, err)
+	}
+	newFile(ls, &luaFile{f: f})
+}
+
+// io.open (filename [, mode])
+func ioOpen(ls api.LuaState) int {
+	fname := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	ls.ArgCheck(checkMode(mode), 2, "invalid mode")
+	f, err := openFile(fname, mode)
+	if err != nil {
+		return fileResult(ls, err, fname)
+	}
+	newFile(ls, &luaFile{f: f})
+	return 1
+}
+
+// checkMode checks whether mode matches '[rwa]%+?b*' - l_checkmode
+func checkMode(mode string) bool {
+	if mode == "" || strings.IndexByte("rwa", mode[0]) < 0 {
+		return false
+	}
+	mode = mode[1:]
+	if strings.HasPrefix(mode, "+") {
+		mode = mode[1:]
+	}
+	return strings.Trim(mode, "b") == ""
+}
+
+func openFile(fname, mode string) (*os.File, error) {
+	plus := strings.IndexByte(mode, '+') >= 0
+	var flag int
+	switch mode[0] {
+	case 'r':
+		flag = os.O_RDONLY
+		if plus {
+			flag = os.O_RDWR
+		}
+	case 'w':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if plus {
+			flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		}
+	default: /* 'a' */
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if plus {
+			flag = os.O_RDWR | os.O_CREATE | os.O_APPEND
+		}
+	}
+	return os.OpenFile(fname, flag, 0666)
+}
+
+// io.popen (prog [, mode])
+func ioPopen(ls api.LuaState) int {
+	return ls.Error2("'popen' not supported")
+}
+
+// io.read (···)
+func ioRead(ls api.LuaState) int {
+	return gRead(ls, getIOFile(ls, IO_INPUT), 1)
+}
+
+// io.tmpfile ()
+func ioTmpFile(ls api.LuaState) int {
+	f, err := ioutil.TempFile("", "lua_")
+	if err != nil {
+		return fileResult(ls, err, "")
+	}
+	newFile(ls, &luaFile{f: f, tmp: f.Name()})
+	return 1
+}
+
+// io.type (obj)
+func ioType(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if lf := testFile(ls, 1); lf == nil {
+		ls.PushNil() /* not a file */
+	} else if lf.closed {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+// io.write (···)
+func ioWrite(ls api.LuaState) int {
+	return gWrite(ls, getIOFile(ls, IO_OUTPUT), 1)
+}
+
+/* file methods */
+
+// file:flush ()
+func fileFlush(ls api.LuaState) int {
+	return flush(ls, toFile(ls))
+}
+
+func flush(ls api.LuaState, lf *luaFile) int {
+	var err error
+	if f, ok := lf.writer(ls).(interface{ Flush() error }); ok {
+		err = f.Flush()
+	}
+	return fileResult(ls, err, "")
+}
+
+// file:lines (···)
+func fileLines(ls api.LuaState) int {
+	toFile(ls) /* check that it's a valid file handle */
+	auxLines(ls, false)
+	return 1
+}
+
+// auxLines pushes the iterator of lines, with the file at 1 and the
+// formats after it as upvalues.
+func auxLines(ls api.LuaState, toClose bool) {
+	n := ls.GetTop() - 1 /* number of arguments to read */
+	ls.ArgCheck(n <= MAXARGLINE, MAXARGLINE+2, "too many arguments")
+	ls.PushValue(1) /* file */
+	ls.PushInteger(int64(n))
+	ls.PushBoolean(toClose)
+	ls.Rotate(2, 3) /* move the three values to their positions */
+	ls.PushGoClosure(ioReadLine, 3+n)
+}
+
+func ioReadLine(ls api.LuaState) int {
+	lf := checkFile(ls, api.LuaUpvalueIndex(1))
+	n := int(ls.ToInteger(api.LuaUpvalueIndex(2)))
+	if lf.closed { /* file is already closed? */
+		return ls.Error2("file is already closed")
+	}
+	ls.SetTop(1)
+	ls.CheckStack2(n, "too many arguments")
+	for i := 1; i <= n; i++ { /* push arguments to 'gRead' */
+		ls.PushValue(api.LuaUpvalueIndex(3 + i))
+	}
+	n = gRead(ls, lf, 2)  /* 'n' is number of results */
+	if ls.ToBoolean(-n) { /* read at least one value? */
+		return n /* return them */
+	}
+	/* first result is nil: EOF or error */
+	if n > 1 { /* is there error information? */
+		return ls.Error2("%s", ls.ToString(-n+1))
+	}
+	if ls.ToBoolean(api.LuaUpvalueIndex(3)) { /* close file? */
+		lf.close()
+	}
+	return 0
+}
+
+// file:read (···)
+func fileRead(ls api.LuaState) int {
+	return gRead(ls, toFile(ls), 2)
+}
+
+// file:seek ([whence [, offset]])
+func fileSeek(ls api.LuaState) int {
+	lf := toFile(ls)
+	modes := []int{io.SeekStart, io.SeekCurrent, io.SeekEnd}
+	whence := modes[ls.CheckOption(2, "cur", []string{"set", "cur", "end"})]
+	offset := ls.OptInteger(3, 0)
+	if lf.f == nil {
+		return fileResult(ls, syscall.ESPIPE, "")
+	}
+	lf.unread()
+	pos, err := lf.f.Seek(offset, whence)
+	if err != nil {
+		return fileResult(ls, err, "")
+	}
+	ls.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf (mode [, size])
+func fileSetVBuf(ls api.LuaState) int {
+	toFile(ls)
+	ls.CheckOption(2, "", []string{"no", "full", "line"})
+	ls.PushBoolean(true) /* writes are not buffered */
+	return 1
+}
+
+// file:write (···)
+func fileWrite(ls api.LuaState) int {
+	lf := toFile(ls)
+	ls.PushValue(1) /* push file at the stack top (to be returned) */
+	return gWrite(ls, lf, 2)
+}
+
+func fileGC(ls api.LuaState) int {
+	if lf := checkFile(ls, 1); !lf.closed && lf.f != nil {
+		lf.close() /* ignore closed and incompletely open files */
+	}
+	return 0
+}
+
+func fileToString(ls api.LuaState) int {
+	if lf := checkFile(ls, 1); lf.closed {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushFString("file (%p)", lf)
+	}
+	return 1
+}
+
+/* reading and writing */
+
+// gRead reads with the formats from first up to the file at the top -
+// g_read
+func gRead(ls api.LuaState, lf *luaFile, first int) int {
+	r := lf.reader(ls)
+	nArgs := ls.GetTop() - 1
+	success := true
+	var err error
+	n := first
+	if nArgs == 0 { /* no arguments? */
+		success, err = readLine(ls, r, true)
+		n = first + 1 /* to return 1 result */
+	} else {
+		ls.CheckStack2(nArgs+api.LUA_MINSTACK, "too many arguments")
+		for ; nArgs > 0 && success && err == nil; n, nArgs = n+1, nArgs-1 {
+			if ls.Type(n) == api.LUA_TNUMBER {
+				l := ls.CheckInteger(n)
+				if l == 0 {
+					success = testEOF(ls, r)
+				} else {
+					success, err = readChars(ls, r, l)
+				}
+				continue
+			}
+			p := strings.TrimPrefix(ls.CheckString(n), "*") /* skip optional '*' (for compatibility) */
+			switch {
+			case strings.HasPrefix(p, "n"): /* number */
+				success = readNumber(ls, r)
+			case strings.HasPrefix(p, "l"): /* line */
+				success, err = readLine(ls, r, true)
+			case strings.HasPrefix(p, "L"): /* line with end-of-line */
+				success, err = readLine(ls, r, false)
+			case strings.HasPrefix(p, "a"): /* file */
+				err = readAll(ls, r)
+			default:
+				return ls.ArgError(n, "invalid format")
+			}
+		}
+	}
+	if err != nil {
+		return fileResult(ls, err, "")
+	}
+	if !success {
+		ls.Pop(1)    /* remove last result */
+		ls.PushNil() /* push nil instead */
+	}
+	return n - first
+}
+
+func readLine(ls api.LuaState, r *bufio.Reader, chop bool) (bool, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF {
+		err = nil
+	}
+	/* success if read something, even if only an empty line */
+	success := line != ""
+	if chop {
+		line = strings.TrimSuffix(line, "\n")
+	}
+	ls.PushString(line)
+	return success, err
+}
+
+func readAll(ls api.LuaState, r *bufio.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	ls.PushString(string(data))
+	return err
+}
+
+func readChars(ls api.LuaState, r *bufio.Reader, n int64) (bool, error) {
+	buf := make([]byte, n)
+	nr, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	ls.PushString(string(buf[:nr]))
+	return nr > 0, err
+}
+
+func testEOF(ls api.LuaState, r *bufio.Reader) bool {
+	_, err := r.Peek(1)
+	ls.PushString("")
+	return err == nil
+}
+
+// readNumber reads a numeral and converts it as by tonumber - read_number
+func readNumber(ls api.LuaState, r *bufio.Reader) bool {
+	const maxLen = 200
+	var sb strings.Builder
+	c, err := r.ReadByte()
+	for err == nil && isSpace(c) { /* skip spaces */
+		c, err = r.ReadByte()
+	}
+	accept := func(set string) bool {
+		if err == nil && sb.Len() < maxLen && strings.IndexByte(set, c) >= 0 {
+			sb.WriteByte(c)
+			c, err = r.ReadByte()
+			return true
+		}
+		return false
+	}
+	digits := func(hex bool) int {
+		set := "0123456789"
+		if hex {
+			set += "abcdefABCDEF"
+		}
+		count := 0
+		for accept(set) {
+			count++
+		}
+		return count
+	}
+
+	accept("-+") /* optional sign */
+	count, hex := 0, false
+	if accept("0") {
+		if accept("xX") {
+			hex = true /* numeral is hexadecimal */
+		} else {
+			count = 1 /* count initial '0' as a valid digit */
+		}
+	}
+	count += digits(hex)
+	if accept(".") {
+		count += digits(hex)
+	}
+	exp := "eE"
+	if hex {
+		exp = "pP"
+	}
+	if count > 0 && accept(exp) { /* exponent mark? */
+		accept("-+")
+		digits(false)
+	}
+	if err == nil {
+		r.UnreadByte() /* unread look-ahead char */
+	}
+	if ls.StringToNumber(sb.String()) {
+		return true /* ok */
+	}
+	ls.PushNil() /* "result" to be removed */
+	return false /* read fails */
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c >= '\t' && c <= '\r'
+}
+
+// gWrite writes the arguments from arg up to the file at the top - g_write
+func gWrite(ls api.LuaState, lf *luaFile, arg int) int {
+	w := lf.writer(ls)
+	if w == nil {
+		return fileResult(ls, syscall.EBADF, "")
+	}
+	var sb strings.Builder
+	for ; arg < ls.GetTop(); arg++ {
+		sb.WriteString(ls.CheckString(arg))
+	}
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fileResult(ls, err, "")
+	}
+	return 1 /* file handle already on stack top */
+}
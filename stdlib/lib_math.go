@@ -2,7 +2,9 @@ package stdlib
 
 import (
 	"math"
+	"math/bits"
 	"math/rand"
+	"time"
 
 	"github.com/iglev/glua/api"
 	"github.com/iglev/glua/number"
@@ -49,6 +51,9 @@ func OpenMathLib(ls api.LuaState) int {
 	ls.SetField(-2, "maxinteger")
 	ls.PushInteger(math.MinInt64)
 	ls.SetField(-2, "mininteger")
+	if ls.Version() >= api.LUA_VERSION_54 {
+		setRandFuncs(ls)
+	}
 	return 1
 }
 
@@ -90,6 +95,104 @@ func mathRandomSeed(ls api.LuaState) int {
 	return 0
 }
 
+/* pseudo-random numbers of Lua 5.4: xoshiro256** */
+
+// ranState is the state of the generator of one Lua state.
+type ranState [4]uint64
+
+func (s *ranState) next() uint64 {
+	res := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return res
+}
+
+func (s *ranState) seed(n1, n2 uint64) {
+	*s = ranState{n1, 0xff, n2, 0} /* avoid a zero state */
+	for i := 0; i < 16; i++ {
+		s.next() /* discard initial values to "spread" seed */
+	}
+}
+
+// project projects the random integer ran into the interval [0, n].
+func (s *ranState) project(ran, n uint64) uint64 {
+	if n&(n+1) == 0 { /* is 'n + 1' a power of 2? */
+		return ran & n
+	}
+	lim := n /* compute the smallest (2^b - 1) not smaller than n */
+	lim |= lim >> 1
+	lim |= lim >> 2
+	lim |= lim >> 4
+	lim |= lim >> 8
+	lim |= lim >> 16
+	lim |= lim >> 32
+	for ran &= lim; ran > n; ran &= lim { /* not inside [0, n]? Try again */
+		ran = s.next()
+	}
+	return ran
+}
+
+// setRandFuncs replaces math.random and math.randomseed by the functions
+// of Lua 5.4, sharing a randomly seeded generator.
+func setRandFuncs(ls api.LuaState) {
+	g := &ranState{}
+	g.seed(uint64(time.Now().UnixNano()), uint64(rand.Int63()))
+
+	// math.random ([m [, n]])
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		var low, up int64
+		rv := g.next()
+		switch ls.GetTop() { /* check number of arguments */
+		case 0: /* no arguments */
+			ls.PushNumber(float64(rv>>11) * (0.5 / (1 << 52))) /* float between 0 and 1 */
+			return 1
+		case 1: /* only upper limit */
+			low = 1
+			up = ls.CheckInteger(1)
+			if up == 0 { /* single 0 as argument? */
+				ls.PushInteger(int64(rv)) /* full random integer */
+				return 1
+			}
+		case 2: /* lower and upper limits */
+			low = ls.CheckInteger(1)
+			up = ls.CheckInteger(2)
+		default:
+			return ls.Error2("wrong number of arguments")
+		}
+
+		/* random integer in the interval [low, up] */
+		ls.ArgCheck(low <= up, 1, "interval is empty")
+		ls.PushInteger(int64(g.project(rv, uint64(up)-uint64(low)) + uint64(low)))
+		return 1
+	})
+	ls.SetField(-2, "random")
+
+	// math.randomseed ([x [, y]])
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		var n1, n2 int64
+		if ls.IsNone(1) {
+			n1, n2 = time.Now().UnixNano(), rand.Int63()
+		} else {
+			if ls.IsInteger(1) {
+				n1 = ls.ToInteger(1)
+			} else {
+				n1 = int64(ls.CheckNumber(1))
+			}
+			n2 = ls.OptInteger(2, 0)
+		}
+		g.seed(uint64(n1), uint64(n2))
+		ls.PushInteger(n1)
+		ls.PushInteger(n2)
+		return 2
+	})
+	ls.SetField(-2, "randomseed")
+}
+
 /* max & min */
 
 // math.max (x, ···)
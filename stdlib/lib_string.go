@@ -8,15 +8,15 @@ import (
 )
 
 var strLib = map[string]api.GoFunction{
-	"len":     strLen,
-	"rep":     strRep,
-	"reverse": strReverse,
-	"lower":   strLower,
-	"upper":   strUpper,
-	"sub":     strSub,
-	"byte":    strByte,
-	"char":    strChar,
-	// "dump":     strDump,
+	"len":      strLen,
+	"rep":      strRep,
+	"reverse":  strReverse,
+	"lower":    strLower,
+	"upper":    strUpper,
+	"sub":      strSub,
+	"byte":     strByte,
+	"char":     strChar,
+	"dump":     strDump,
 	"format":   strFormat,
 	"packsize": strPackSize,
 	"pack":     strPack,
@@ -27,6 +27,9 @@ var strLib = map[string]api.GoFunction{
 	"gmatch":   strGmatch,
 }
 
+// MAXSIZE is the size limit of the strings built by the library
+const MAXSIZE = 1<<31 - 1
+
 func OpenStringLib(ls api.LuaState) int {
 	ls.NewLib(strLib)
 	createMetatable(ls)
@@ -41,7 +44,48 @@ func createMetatable(ls api.LuaState) {
 	ls.Pop(1)                  /* pop dummy string */
 	ls.PushValue(-2)           /* get string library */
 	ls.SetField(-2, "__index") /* metatable.__index = string */
-	ls.Pop(1)                  /* pop metatable */
+	if ls.Version() >= api.LUA_VERSION_54 {
+		ls.SetFuncs(strMetamethods, 0) /* arithmetic on numerical strings */
+	}
+	ls.Pop(1) /* pop metatable */
+}
+
+/* Arithmetic Metamethods (Lua 5.4) */
+
+var strMetamethods = map[string]api.GoFunction{
+	"__add":  func(ls api.LuaState) int { return _strArith(ls, api.LUA_OPADD, "__add") },
+	"__sub":  func(ls api.LuaState) int { return _strArith(ls, api.LUA_OPSUB, "__sub") },
+	"__mul":  func(ls api.LuaState) int { return _strArith(ls, api.LUA_OPMUL, "__mul") },
+	"__mod":  func(ls api.LuaState) int { return _strArith(ls, api.LUA_OPMOD, "__mod") },
+	"__pow":  func(ls api.LuaState) int { return _strArith(ls, api.LUA_OPPOW, "__pow") },
+	"__div":  func(ls api.LuaState) int { return _strArith(ls, api.LUA_OPDIV, "__div") },
+	"__idiv": func(ls api.LuaState) int { return _strArith(ls, api.LUA_OPIDIV, "__idiv") },
+	"__unm":  func(ls api.LuaState) int { return _strArith(ls, api.LUA_OPUNM, "__unm") },
+}
+
+// _strArith does the arithmetic on numerical strings that Lua 5.4 no
+// longer does by itself, or tries the metamethod of the second operand.
+func _strArith(ls api.LuaState, op api.ArithOp, mtName string) int {
+	if _toNum(ls, 1) && _toNum(ls, 2) {
+		ls.Arith(op) /* result will be on the top */
+		return 1
+	}
+	ls.SetTop(2) /* back to the original arguments */
+	if ls.Type(2) == api.LUA_TSTRING || ls.GetMetafield(2, mtName) == api.LUA_TNIL {
+		return ls.Error2("attempt to perform arithmetic on a %s value", ls.TypeName2(-2))
+	}
+	ls.Insert(-3) /* put metamethod before arguments */
+	ls.Call(2, 1) /* call metamethod */
+	return 1
+}
+
+// _toNum pushes the number, or numerical string, at arg as a number.
+func _toNum(ls api.LuaState, arg int) bool {
+	if ls.Type(arg) == api.LUA_TNUMBER {
+		ls.PushValue(arg)
+		return true
+	}
+	return ls.IsString(arg) && ls.StringToNumber(ls.ToString(arg))
 }
 
 /* Basic String Functions */
@@ -61,14 +105,16 @@ func strRep(ls api.LuaState) int {
 
 	if n <= 0 {
 		ls.PushString("")
-	} else if n == 1 {
-		ls.PushString(s)
+	} else if l := int64(len(s) + len(sep)); l > 0 && n > MAXSIZE/l {
+		return ls.Error2("resulting string too large")
 	} else {
-		a := make([]string, n)
-		for i := 0; i < int(n); i++ {
-			a[i] = s
+		b := api.NewBufferSize(ls, int(n*l))
+		for ; n > 1; n-- { /* first n-1 copies (followed by separator) */
+			b.AddString(s)
+			b.AddString(sep)
 		}
-		ls.PushString(strings.Join(a, sep))
+		b.AddString(s) /* last copy (not followed by separator) */
+		b.PushResult()
 	}
 
 	return 1
@@ -171,15 +217,18 @@ func strChar(ls api.LuaState) int {
 	return 1
 }
 
-// // string.dump (function [, strip])
-// func strDump(ls api.LuaState) int {
-// 	// strip := ls.ToBoolean(2)
-// 	// ls.CheckType(1, LUA_TFUNCTION)
-// 	// ls.SetTop(1)
-// 	// ls.PushString(string(ls.Dump(strip)))
-// 	// return 1
-// 	panic("todo: strDump!")
-// }
+// string.dump (function [, strip])
+func strDump(ls api.LuaState) int {
+	strip := ls.ToBoolean(2)
+	ls.CheckType(1, api.LUA_TFUNCTION)
+	ls.SetTop(1)
+	chunk := ls.Dump(strip)
+	if chunk == nil {
+		return ls.Error2("unable to dump given function")
+	}
+	ls.PushString(string(chunk))
+	return 1
+}
 
 /* PACK/UNPACK */
 
@@ -215,19 +264,18 @@ func strFormat(ls api.LuaState) int {
 	}
 
 	argIdx := 1
-	arr := parseFmtStr(fmtStr)
-	for i, s := range arr {
-		if s[0] == '%' {
-			if s == "%%" {
-				arr[i] = "%"
-			} else {
-				argIdx += 1
-				arr[i] = _fmtArg(s, ls, argIdx)
-			}
+	b := api.NewBufferSize(ls, len(fmtStr))
+	for _, s := range parseFmtStr(fmtStr) {
+		if s[0] != '%' {
+			b.AddString(s)
+		} else if s == "%%" {
+			b.AddChar('%')
+		} else {
+			argIdx += 1
+			b.AddString(_fmtArg(s, ls, argIdx))
 		}
 	}
-
-	ls.PushString(strings.Join(arr, ""))
+	b.PushResult()
 	return 1
 }
 
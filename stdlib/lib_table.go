@@ -2,7 +2,6 @@ package stdlib
 
 import (
 	"sort"
-	"strings"
 
 	"github.com/iglev/glua/api"
 )
@@ -114,26 +113,27 @@ func tabConcat(ls api.LuaState) int {
 	i := ls.OptInteger(3, 1)
 	j := ls.OptInteger(4, tabLen)
 
-	if i > j {
-		ls.PushString("")
-		return 1
+	b := api.NewBuffer(ls)
+	for ; i < j; i++ {
+		_addField(ls, b, i)
+		b.AddString(sep)
 	}
-
-	buf := make([]string, j-i+1)
-	for k := i; k > 0 && k <= j; k++ {
-		ls.GetI(1, k)
-		if !ls.IsString(-1) {
-			ls.Error2("invalid value (%s) at index %d in table for 'concat'",
-				ls.TypeName2(-1), i)
-		}
-		buf[k-i] = ls.ToString(-1)
-		ls.Pop(1)
+	if i == j { /* add last value (if interval was not empty) */
+		_addField(ls, b, i)
 	}
-	ls.PushString(strings.Join(buf, sep))
+	b.PushResult()
 
 	return 1
 }
 
+func _addField(ls api.LuaState, b *api.Buffer, i int64) {
+	ls.GetI(1, i)
+	if !ls.IsString(-1) {
+		ls.Error2("invalid value (at index %d) in table for 'concat'", i)
+	}
+	b.AddValue()
+}
+
 func _auxGetN(ls api.LuaState, n, w int) int64 {
 	_checkTab(ls, n, w|TAB_L)
 	return ls.Len2(n)
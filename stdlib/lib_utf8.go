@@ -1,15 +1,15 @@
 package stdlib
 
 import (
-	"unicode/utf8"
-
 	"github.com/iglev/glua/api"
 )
 
 /* pattern to match a single UTF-8 character */
 const UTF8PATT = "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*"
+const UTF8PATT54 = "[\x00-\x7F\xC2-\xFD][\x80-\xBF]*"
 
 const MAX_UNICODE = 0x10FFFF
+const MAX_UTF = 0x7FFFFFFF /* Lua 5.4 lax mode */
 
 var utf8Lib = map[string]api.GoFunction{
 	"len":       utfLen,
@@ -23,29 +23,73 @@ var utf8Lib = map[string]api.GoFunction{
 
 func OpenUTF8Lib(ls api.LuaState) int {
 	ls.NewLib(utf8Lib)
-	ls.PushString(UTF8PATT)
+	if ls.Version() >= api.LUA_VERSION_54 {
+		ls.PushString(UTF8PATT54)
+	} else {
+		ls.PushString(UTF8PATT)
+	}
 	ls.SetField(-2, "charpattern")
 	return 1
 }
 
-// utf8.len (s [, i [, j]])
+// _utf8Decode decodes the UTF-8 sequence at the start of s, which must not
+// be empty. Unless strict, it accepts surrogates and values up to
+// MAX_UTF (6 bytes), as Lua 5.4 does in lax mode. The size is 0 if the
+// sequence is invalid.
+func _utf8Decode(s string, strict bool) (code int64, size int) {
+	limits := [...]uint32{^uint32(0), 0x80, 0x800, 0x10000, 0x200000, 0x4000000}
+	c := uint32(s[0])
+	if c < 0x80 { /* ascii? */
+		return int64(c), 1
+	}
+	res := uint32(0)             /* final result */
+	count := 0                   /* to count number of continuation bytes */
+	for ; c&0x40 != 0; c <<= 1 { /* while it needs continuation bytes... */
+		count++
+		if count >= len(s) || !_isCont(s[count]) {
+			return 0, 0 /* invalid byte sequence */
+		}
+		res = res<<6 | uint32(s[count])&0x3F /* add lower 6 bits from cont. byte */
+	}
+	res |= (c & 0x7F) << (count * 5) /* add first byte */
+	if count > 5 || res > MAX_UTF || res < limits[count] {
+		return 0, 0 /* invalid byte sequence */
+	}
+	if strict && (res > MAX_UNICODE || 0xD800 <= res && res <= 0xDFFF) {
+		return 0, 0 /* surrogates and values above MAX_UNICODE are invalid */
+	}
+	return int64(res), count + 1
+}
+
+// _isLax reads the lax argument of Lua 5.4 at arg.
+func _isLax(ls api.LuaState, arg int) bool {
+	return ls.Version() >= api.LUA_VERSION_54 && ls.ToBoolean(arg)
+}
+
+// utf8.len (s [, i [, j [, lax]]])
 func utfLen(ls api.LuaState) int {
 	s := ls.CheckString(1)
 	sLen := len(s)
 	i := posRelat(ls.OptInteger(2, 1), sLen)
 	j := posRelat(ls.OptInteger(3, -1), sLen)
+	lax := _isLax(ls, 4)
 	ls.ArgCheck(1 <= i && i <= sLen+1, 2,
 		"initial position out of string")
 	ls.ArgCheck(j <= sLen, 3,
 		"final position out of string")
 
-	if i > j {
-		ls.PushInteger(0)
-	} else {
-		n := utf8.RuneCountInString(s[i-1 : j])
-		ls.PushInteger(int64(n))
+	n := int64(0)
+	for i <= j {
+		_, size := _utf8Decode(s[i-1:], !lax)
+		if size == 0 { /* conversion error? */
+			ls.PushNil()             /* return fail ... */
+			ls.PushInteger(int64(i)) /* ... and current position */
+			return 2
+		}
+		i += size
+		n++
 	}
-
+	ls.PushInteger(n)
 	return 1
 }
 
@@ -102,12 +146,13 @@ func utfByteOffset(ls api.LuaState) int {
 	return 1
 }
 
-// utf8.codepoint (s [, i [, j]])
+// utf8.codepoint (s [, i [, j [, lax]]])
 func utfCodePoint(ls api.LuaState) int {
 	s := ls.CheckString(1)
 	sLen := len(s)
 	i := posRelat(ls.OptInteger(2, 1), sLen)
 	j := posRelat(ls.OptInteger(3, int64(i)), sLen)
+	lax := _isLax(ls, 4)
 
 	ls.ArgCheck(i >= 1, 2, "out of range")
 	ls.ArgCheck(int(j) <= sLen, 3, "out of range")
@@ -123,11 +168,11 @@ func utfCodePoint(ls api.LuaState) int {
 	n = 0
 	s = s[i-1:]
 	for i <= j {
-		code, size := utf8.DecodeRuneInString(s)
-		if code == utf8.RuneError {
+		code, size := _utf8Decode(s, !lax)
+		if size == 0 {
 			return ls.Error2("invalid UTF-8 code")
 		}
-		ls.PushInteger(int64(code))
+		ls.PushInteger(code)
 		n++
 		i += size
 		s = s[size:]
@@ -138,40 +183,67 @@ func utfCodePoint(ls api.LuaState) int {
 // utf8.char (···)
 func utfChar(ls api.LuaState) int {
 	n := ls.GetTop() /* number of arguments */
-	codePoints := make([]rune, n)
+	max := int64(MAX_UNICODE)
+	if ls.Version() >= api.LUA_VERSION_54 {
+		max = MAX_UTF
+	}
+	str := make([]byte, 0, n)
 
 	for i := 1; i <= n; i++ {
 		cp := ls.CheckInteger(i)
-		ls.ArgCheck(0 <= cp && cp <= MAX_UNICODE, i, "value out of range")
-		codePoints[i-1] = rune(cp)
+		ls.ArgCheck(0 <= cp && cp <= max, i, "value out of range")
+		str = _appendUtf8(str, uint32(cp))
 	}
 
-	ls.PushString(_encodeUtf8(codePoints))
+	ls.PushString(string(str))
 	return 1
 }
 
-func _encodeUtf8(codePoints []rune) string {
-	buf := make([]byte, 6)
-	str := make([]byte, 0, len(codePoints))
-
-	for _, cp := range codePoints {
-		n := utf8.EncodeRune(buf, cp)
-		str = append(str, buf[0:n]...)
+// _appendUtf8 appends the UTF-8 sequence of x, surrogates and values up to
+// MAX_UTF included - luaO_utf8esc
+func _appendUtf8(str []byte, x uint32) []byte {
+	if x < 0x80 {
+		return append(str, byte(x))
 	}
-
-	return string(str)
+	var seq [6]byte
+	n := len(seq)
+	mfb := uint32(0x3f) /* maximum that fits in first byte */
+	for {
+		n--
+		seq[n] = byte(0x80 | x&0x3f)
+		x >>= 6
+		mfb >>= 1
+		if x <= mfb {
+			break
+		}
+	}
+	n--
+	seq[n] = byte(^mfb<<1 | x)
+	return append(str, seq[n:]...)
 }
 
-// utf8.codes (s)
+// utf8.codes (s [, lax])
 func utfIterCodes(ls api.LuaState) int {
 	ls.CheckString(1)
-	ls.PushGoFunction(_iterAux)
+	if _isLax(ls, 2) {
+		ls.PushGoFunction(_iterAuxLax)
+	} else {
+		ls.PushGoFunction(_iterAuxStrict)
+	}
 	ls.PushValue(1)
 	ls.PushInteger(0)
 	return 3
 }
 
-func _iterAux(ls api.LuaState) int {
+func _iterAuxStrict(ls api.LuaState) int {
+	return _iterAux(ls, true)
+}
+
+func _iterAuxLax(ls api.LuaState) int {
+	return _iterAux(ls, false)
+}
+
+func _iterAux(ls api.LuaState, strict bool) int {
 	s := ls.CheckString(1)
 	sLen := int64(len(s))
 	n := ls.ToInteger(2) - 1
@@ -186,12 +258,12 @@ func _iterAux(ls api.LuaState) int {
 	if n >= sLen {
 		return 0 /* no more codepoints */
 	} else {
-		code, _ := utf8.DecodeRuneInString(s[n:])
-		if code == utf8.RuneError {
+		code, size := _utf8Decode(s[n:], strict)
+		if size == 0 {
 			return ls.Error2("invalid UTF-8 code")
 		}
 		ls.PushInteger(n + 1)
-		ls.PushInteger(int64(code))
+		ls.PushInteger(code)
 		return 2
 	}
 }
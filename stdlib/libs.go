@@ -0,0 +1,26 @@
+package stdlib
+
+import "github.com/iglev/glua/api"
+
+// Libs maps the names of the standard libraries to their open functions,
+// as opened by luaL_openlibs.
+var Libs = map[string]api.GoFunction{
+	"_G":        OpenBaseLib,
+	"math":      OpenMathLib,
+	"table":     OpenTableLib,
+	"string":    OpenStringLib,
+	"utf8":      OpenUTF8Lib,
+	"os":        OpenOSLib,
+	"io":        OpenIOLib,
+	"package":   OpenPackageLib,
+	"coroutine": OpenCoroutineLib,
+}
+
+// Compat51Libs maps the names of the libraries for scripts written for
+// Lua 5.1 and LuaJIT to their open functions, as opened after Libs for
+// api.LUA_VERSION_51.
+var Compat51Libs = map[string]api.GoFunction{
+	"bit":      OpenBitLib,
+	"bit32":    OpenBit32Lib,
+	"compat51": OpenCompat51Lib,
+}
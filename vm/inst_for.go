@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"math"
+
 	"github.com/iglev/glua/api"
 )
 
@@ -27,6 +29,11 @@ func forPrep(i Instruction, vm api.LuaVM) {
 	a, sBx := i.AsBx()
 	a += 1
 
+	if vm.Version() >= api.LUA_VERSION_54 {
+		forPrep54(a, sBx, vm)
+		return
+	}
+
 	if vm.Type(a) == api.LUA_TSTRING {
 		vm.PushNumber(vm.ToNumber(a))
 		vm.Replace(a)
@@ -55,6 +62,11 @@ func forLoop(i Instruction, vm api.LuaVM) {
 	a, sBx := i.AsBx()
 	a += 1
 
+	if vm.Version() >= api.LUA_VERSION_54 {
+		forLoop54(a, sBx, vm)
+		return
+	}
+
 	// R(A)+=R(A+2);
 	vm.PushValue(a + 2)
 	vm.PushValue(a)
@@ -82,3 +94,115 @@ func tForLoop(i Instruction, vm api.LuaVM) {
 		vm.AddPC(sBx)
 	}
 }
+
+/* Lua 5.4 */
+
+// forPrep54 prepares the loop as Lua 5.4 does: an integer loop keeps its
+// iteration count in R(A+1) instead of the limit, so that it never
+// overflows. It falls into the body, or jumps past the FORLOOP if the loop
+// must not run.
+func forPrep54(a, sBx int, vm api.LuaVM) {
+	init, initIsInt := vm.ToIntegerX(a)
+	step, stepIsInt := vm.ToIntegerX(a + 2)
+	if vm.IsInteger(a) && vm.IsInteger(a+2) && initIsInt && stepIsInt {
+		if step == 0 {
+			vm.Error2("'for' step is zero")
+		}
+		limit, skip := forLimit(a+1, init, step, vm)
+		if skip {
+			vm.AddPC(sBx + 1)
+			return
+		}
+		var count uint64
+		if step > 0 {
+			count = (uint64(limit) - uint64(init)) / uint64(step)
+		} else {
+			count = (uint64(init) - uint64(limit)) / (uint64(-(step + 1)) + 1)
+		}
+		vm.PushInteger(int64(count))
+		vm.Replace(a + 1)
+		vm.Copy(a, a+3)
+		return
+	}
+
+	fInit := forNumber(a, "initial", vm)
+	fLimit := forNumber(a+1, "limit", vm)
+	fStep := forNumber(a+2, "step", vm)
+	if fStep == 0 {
+		vm.Error2("'for' step is zero")
+	}
+	if fStep > 0 && fLimit < fInit || fStep < 0 && fInit < fLimit {
+		vm.AddPC(sBx + 1)
+		return
+	}
+	for i, n := range []float64{fInit, fLimit, fStep, fInit} {
+		vm.PushNumber(n)
+		vm.Replace(a + i)
+	}
+}
+
+func forNumber(idx int, what string, vm api.LuaVM) float64 {
+	n, ok := vm.ToNumberX(idx)
+	if !ok {
+		vm.Error2("'for' %s value must be a number", what)
+	}
+	return n
+}
+
+// forLimit converts the limit of an integer loop to an integer, clipping
+// float limits, and tells whether the loop must not run.
+func forLimit(idx int, init, step int64, vm api.LuaVM) (limit int64, skip bool) {
+	if vm.IsInteger(idx) {
+		limit = vm.ToInteger(idx)
+	} else {
+		f := forNumber(idx, "limit", vm)
+		if step < 0 {
+			f = math.Ceil(f)
+		} else {
+			f = math.Floor(f)
+		}
+		switch {
+		case f >= 1<<63: // includes +inf
+			if step < 0 {
+				return 0, true
+			}
+			limit = math.MaxInt64
+		case f < -(1<<63) || math.IsNaN(f): // includes -inf
+			if step > 0 {
+				return 0, true
+			}
+			limit = math.MinInt64
+		default:
+			limit = int64(f)
+		}
+	}
+	if step > 0 {
+		return limit, init > limit
+	}
+	return limit, init < limit
+}
+
+// forLoop54 runs the next iteration of a loop prepared by forPrep54.
+func forLoop54(a, sBx int, vm api.LuaVM) {
+	if vm.IsInteger(a + 2) {
+		count := uint64(vm.ToInteger(a + 1))
+		if count > 0 {
+			vm.PushInteger(int64(count - 1))
+			vm.Replace(a + 1)
+			vm.PushInteger(vm.ToInteger(a) + vm.ToInteger(a+2))
+			vm.Replace(a)
+			vm.Copy(a, a+3)
+			vm.AddPC(sBx)
+		}
+		return
+	}
+
+	idx := vm.ToNumber(a) + vm.ToNumber(a+2)
+	limit, step := vm.ToNumber(a+1), vm.ToNumber(a+2)
+	if step > 0 && idx <= limit || step < 0 && limit <= idx {
+		vm.PushNumber(idx)
+		vm.Replace(a)
+		vm.Copy(a, a+3)
+		vm.AddPC(sBx)
+	}
+}
@@ -20,3 +20,11 @@ func jmp(i Instruction, vm api.LuaVM) {
 		vm.CloseUpvalues(a)
 	}
 }
+
+// mark R(A) "to be closed"
+func tbc(i Instruction, vm api.LuaVM) {
+	a, _, _ := i.ABC()
+	a += 1
+
+	vm.ToClose(a)
+}
@@ -42,7 +42,7 @@ func setList(i Instruction, vm api.LuaVM) {
 	if c > 0 {
 		c = c - 1
 	} else {
-		c = Instruction(vm.Fetch()).Ax()
+		c = Instruction(vm.Fetch()).Ax() - 1
 	}
 
 	bIsZero := b == 0
@@ -6,6 +6,8 @@ import (
 
 const MAXARG_Bx = 1<<18 - 1       // 262143
 const MAXARG_sBx = MAXARG_Bx >> 1 // 131071
+const MAXARG_C = 1<<9 - 1         // 511
+const MAXINDEXRK = 1<<8 - 1       // 255, largest index of a constant in an RK operand
 
 /*
  31       22       13       5    0